@@ -0,0 +1,23 @@
+# frozen_string_literal: true
+
+module Ci
+  # Mirrors the keys that Workhorse watches, for Workhorse instances that use
+  # the PostgreSQL notifications backend. A trigger on the table notifies them
+  # of every change.
+  class WorkhorseNotification < Ci::ApplicationRecord
+    self.table_name = 'workhorse_notifications'
+    self.primary_key = :key
+
+    validates :key, :value, presence: true, length: { maximum: 255 }
+
+    def self.store(key, value)
+      upsert({ key: key, value: value }, unique_by: :key)
+    end
+
+    # Adds the row when it's missing, without notifying anyone about a value
+    # that did not change
+    def self.store_missing(key, value)
+      insert({ key: key, value: value }, unique_by: :key)
+    end
+  end
+end
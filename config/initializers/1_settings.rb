@@ -969,6 +969,7 @@
 #
 Settings['workhorse'] ||= {}
 Settings.workhorse['secret_file'] ||= Rails.root.join('.gitlab_workhorse_secret')
+Settings.workhorse['notifications_backend'] ||= 'redis'
 
 #
 # Topology Service
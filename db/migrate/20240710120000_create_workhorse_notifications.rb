@@ -0,0 +1,37 @@
+# frozen_string_literal: true
+
+class CreateWorkhorseNotifications < Gitlab::Database::Migration[2.2]
+  milestone '17.2'
+
+  include Gitlab::Database::SchemaHelpers
+
+  TABLE_NAME = :workhorse_notifications
+  TRIGGER_FUNCTION_NAME = 'notify_workhorse_notifications'
+  TRIGGER_NAME = "trigger_#{TRIGGER_FUNCTION_NAME}"
+
+  def up
+    # rubocop:disable Migration/EnsureFactoryForTable -- rows are only written by Gitlab::Workhorse.set_key_and_notify
+    create_table TABLE_NAME, id: false do |t|
+      t.text :key, null: false, limit: 255, primary_key: true
+      t.text :value, null: false, limit: 255
+    end
+    # rubocop:enable Migration/EnsureFactoryForTable
+
+    # Workhorse LISTENs on this channel when its notifications backend is postgres
+    create_trigger_function(TRIGGER_FUNCTION_NAME, replace: true) do
+      <<~SQL
+        PERFORM pg_notify('workhorse_notifications', json_build_object('key', NEW.key, 'value', NEW.value)::text);
+
+        RETURN NULL;
+      SQL
+    end
+
+    create_trigger(TABLE_NAME, TRIGGER_NAME, TRIGGER_FUNCTION_NAME, fires: 'AFTER INSERT OR UPDATE')
+  end
+
+  def down
+    drop_trigger(TABLE_NAME, TRIGGER_NAME)
+    drop_function(TRIGGER_FUNCTION_NAME)
+    drop_table TABLE_NAME
+  end
+end
@@ -516,6 +516,17 @@ BEGIN
 END;
 $$;
 
+CREATE FUNCTION notify_workhorse_notifications() RETURNS trigger
+    LANGUAGE plpgsql
+    AS $$
+BEGIN
+PERFORM pg_notify('workhorse_notifications', json_build_object('key', NEW.key, 'value', NEW.value)::text);
+
+RETURN NULL;
+
+END
+$$;
+
 CREATE FUNCTION nullify_merge_request_metrics_build_data() RETURNS trigger
     LANGUAGE plpgsql
     AS $$
@@ -19709,6 +19720,13 @@ CREATE SEQUENCE work_item_widget_definitions_id_seq
 
 ALTER SEQUENCE work_item_widget_definitions_id_seq OWNED BY work_item_widget_definitions.id;
 
+CREATE TABLE workhorse_notifications (
+    key text NOT NULL,
+    value text NOT NULL,
+    CONSTRAINT check_15dfac033a CHECK ((char_length(value) <= 255)),
+    CONSTRAINT check_7a643c41ca CHECK ((char_length(key) <= 255))
+);
+
 CREATE TABLE workspace_variables (
     id bigint NOT NULL,
     workspace_id bigint NOT NULL,
@@ -24337,6 +24355,9 @@ ALTER TABLE ONLY work_item_types
 ALTER TABLE ONLY work_item_widget_definitions
     ADD CONSTRAINT work_item_widget_definitions_pkey PRIMARY KEY (id);
 
+ALTER TABLE ONLY workhorse_notifications
+    ADD CONSTRAINT workhorse_notifications_pkey PRIMARY KEY (key);
+
 ALTER TABLE ONLY workspace_variables
     ADD CONSTRAINT workspace_variables_pkey PRIMARY KEY (id);
 
@@ -31800,6 +31821,8 @@ CREATE TRIGGER trigger_insert_vulnerability_reads_from_vulnerability AFTER UPDAT
 
 CREATE TRIGGER trigger_namespaces_traversal_ids_on_update AFTER UPDATE ON namespaces FOR EACH ROW WHEN ((old.traversal_ids IS DISTINCT FROM new.traversal_ids)) EXECUTE FUNCTION insert_namespaces_sync_event();
 
+CREATE TRIGGER trigger_notify_workhorse_notifications AFTER INSERT OR UPDATE ON workhorse_notifications FOR EACH ROW EXECUTE FUNCTION notify_workhorse_notifications();
+
 CREATE TRIGGER trigger_projects_parent_id_on_insert AFTER INSERT ON projects FOR EACH ROW EXECUTE FUNCTION insert_projects_sync_event();
 
 CREATE TRIGGER trigger_projects_parent_id_on_update AFTER UPDATE ON projects FOR EACH ROW WHEN ((old.namespace_id IS DISTINCT FROM new.namespace_id)) EXECUTE FUNCTION insert_projects_sync_event();
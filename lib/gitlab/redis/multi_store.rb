@@ -111,6 +111,7 @@ def message
         setex
         setnx
         spop
+        spublish
         srem
         srem?
         unlink
@@ -265,11 +265,13 @@ def set_key_and_notify(key, value, expire: nil, overwrite: true)
         with_redis do |redis|
           result = redis.set(key, value, ex: expire, nx: !overwrite)
           if result
-            redis.publish(NOTIFICATION_PREFIX + key, value)
+            notify(redis, key, value)
 
             value
           else
-            redis.get(key)
+            redis.get(key).tap do |current|
+              Ci::WorkhorseNotification.store_missing(key, current) if current && postgres_notifications?
+            end
           end
         end
       end
@@ -287,6 +289,24 @@ def with_redis(&blk)
         Gitlab::Redis::Workhorse.with(&blk) # rubocop:disable CodeReuse/ActiveRecord
       end
 
+      # Workhorse watches keys with sharded Pub/Sub (SSUBSCRIBE) on Redis
+      # Cluster, which only receives messages sent with SPUBLISH.
+      def notify(redis, key, value)
+        if Gitlab::Redis::ClusterUtil.cluster?(redis)
+          redis.spublish(NOTIFICATION_PREFIX + key, value)
+        else
+          redis.publish(NOTIFICATION_PREFIX + key, value)
+        end
+
+        Ci::WorkhorseNotification.store(key, value) if postgres_notifications?
+      end
+
+      # Workhorse instances with the postgres notifications backend read keys
+      # from the workhorse_notifications table instead of Redis
+      def postgres_notifications?
+        Gitlab.config.workhorse.notifications_backend == 'postgres'
+      end
+
       # This is the outermost encoding of a senddata: header. It is safe for
       # inclusion in HTTP response headers
       def encode(hash)
@@ -414,6 +414,36 @@ def call_verify(headers)
 
         subject
       end
+
+      context 'when Redis is a cluster' do
+        before do
+          allow(Gitlab::Redis::ClusterUtil).to receive(:cluster?).and_return(true)
+        end
+
+        it 'notifies with sharded pub/sub' do
+          expect_any_instance_of(::Redis).to receive(:spublish)
+            .with(described_class::NOTIFICATION_PREFIX + 'test-key', "test-value")
+          expect_any_instance_of(::Redis).not_to receive(:publish)
+
+          subject
+        end
+      end
+
+      it 'does not write the notifications table' do
+        expect { subject }.not_to change { Ci::WorkhorseNotification.count }
+      end
+
+      context 'when Workhorse uses the postgres notifications backend' do
+        before do
+          allow(Gitlab.config.workhorse).to receive(:notifications_backend).and_return('postgres')
+        end
+
+        it 'stores the value in the notifications table' do
+          subject
+
+          expect(Ci::WorkhorseNotification.find(key).value).to eq(value)
+        end
+      end
     end
 
     context 'when we set a new key' do
@@ -447,6 +477,28 @@ def call_verify(headers)
 
           subject
         end
+
+        context 'when Workhorse uses the postgres notifications backend' do
+          before do
+            allow(Gitlab.config.workhorse).to receive(:notifications_backend).and_return('postgres')
+          end
+
+          it 'stores the previous value when the table does not have it' do
+            Ci::WorkhorseNotification.delete_all
+
+            subject
+
+            expect(Ci::WorkhorseNotification.find(key).value).to eq(old_value)
+          end
+
+          it 'does not change a stored value' do
+            Ci::WorkhorseNotification.store(key, 'stored-value')
+
+            subject
+
+            expect(Ci::WorkhorseNotification.find(key).value).to eq('stored-value')
+          end
+        end
       end
     end
   end
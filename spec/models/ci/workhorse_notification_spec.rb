@@ -0,0 +1,30 @@
+# frozen_string_literal: true
+
+require 'spec_helper'
+
+RSpec.describe Ci::WorkhorseNotification, feature_category: :continuous_integration do
+  describe 'validations' do
+    it { is_expected.to validate_presence_of(:key) }
+    it { is_expected.to validate_presence_of(:value) }
+    it { is_expected.to validate_length_of(:key).is_at_most(255) }
+    it { is_expected.to validate_length_of(:value).is_at_most(255) }
+  end
+
+  describe '.store' do
+    it 'inserts and updates the value of a key' do
+      described_class.store('key', 'first')
+      described_class.store('key', 'second')
+
+      expect(described_class.find('key').value).to eq('second')
+    end
+  end
+
+  describe '.store_missing' do
+    it 'only inserts keys that are missing' do
+      described_class.store_missing('key', 'first')
+      described_class.store_missing('key', 'second')
+
+      expect(described_class.find('key').value).to eq('first')
+    end
+  end
+end
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gorilla/websocket"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/portforward"
+)
+
+const progName = "gitlab-port-forward"
+
+var Version = "unknown"
+
+var (
+	printVersion = flag.Bool("version", false, "Print version and exit")
+	listenHost   = flag.String("listen", "127.0.0.1", "Host to listen on for local connections")
+)
+
+type headerFlags []string
+
+func (h *headerFlags) String() string { return strings.Join(*h, ", ") }
+
+func (h *headerFlags) Set(value string) error {
+	if !strings.Contains(value, ":") {
+		return fmt.Errorf("header %q is not in the form 'Name: value'", value)
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+// forward maps a local port to a target of the channel
+type forward struct {
+	localPort string
+	target    string
+}
+
+func parseForward(arg string) (forward, error) {
+	localPort, target, ok := strings.Cut(arg, ":")
+	if !ok {
+		return forward{}, fmt.Errorf("forward %q is not in the form LOCAL_PORT:HOST:PORT", arg)
+	}
+
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		return forward{}, fmt.Errorf("forward %q: invalid target: %v", arg, err)
+	}
+
+	return forward{localPort: localPort, target: target}, nil
+}
+
+func main() {
+	var headers headerFlags
+	flag.Var(&headers, "header", "Header to send with the websocket request, like 'PRIVATE-TOKEN: xxx'. Can be repeated.")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] URL LOCAL_PORT:HOST:PORT...\n", progName)
+		fmt.Fprintf(os.Stderr, "Example: %s -header 'PRIVATE-TOKEN: xxx' wss://gitlab.example.com/group/project/-/jobs/1/proxy.ws 5432:postgres:5432\n", progName)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *printVersion {
+		fmt.Printf("%s %s\n", progName, Version)
+		os.Exit(0)
+	}
+
+	if flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	var forwards []forward
+	for _, arg := range flag.Args()[1:] {
+		f, err := parseForward(arg)
+		if err != nil {
+			fatalError(err)
+		}
+		forwards = append(forwards, f)
+	}
+
+	header := http.Header{}
+	for _, h := range headers {
+		name, value, _ := strings.Cut(h, ":")
+		header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	dialer := &websocket.Dialer{Subprotocols: []string{portforward.Subprotocol}}
+	conn, resp, err := dialer.Dial(flag.Arg(0), header)
+	if err != nil {
+		if resp != nil {
+			err = fmt.Errorf("%v: %s", err, resp.Status)
+		}
+		fatalError(fmt.Errorf("connect: %v", err))
+	}
+	if resp != nil {
+		resp.Body.Close()
+	}
+	defer conn.Close()
+
+	client := portforward.NewClient(conn)
+	for _, f := range forwards {
+		ln, err := net.Listen("tcp", net.JoinHostPort(*listenHost, f.localPort))
+		if err != nil {
+			fatalError(fmt.Errorf("listen: %v", err))
+		}
+		fmt.Fprintf(os.Stderr, "Forwarding %s to %s\n", ln.Addr(), f.target)
+
+		go serve(ln, client, f.target)
+	}
+
+	<-client.Done()
+	fatalError(fmt.Errorf("connection closed: %v", client.Err()))
+}
+
+func serve(ln net.Listener, client *portforward.Client, target string) {
+	for {
+		local, err := ln.Accept()
+		if err != nil {
+			fatalError(fmt.Errorf("accept: %v", err))
+		}
+
+		go func() {
+			if err := client.Forward(local, target); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", progName, err)
+			}
+		}()
+	}
+}
+
+func fatalError(err error) {
+	fmt.Fprintf(os.Stderr, "%s error: %v\n", progName, err)
+	os.Exit(1)
+}
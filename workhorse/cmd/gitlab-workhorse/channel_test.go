@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/pem"
 	"fmt"
@@ -18,6 +19,7 @@ import (
 	"gitlab.com/gitlab-org/labkit/log"
 
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/portforward"
 )
 
 var (
@@ -69,6 +71,94 @@ func TestChannelHappyPath(t *testing.T) {
 	}
 }
 
+func TestChannelResizeAndCloseInput(t *testing.T) {
+	serverConns, clientURL := wireupChannel(t, envTerminalPath, nil, "v5.channel.k8s.io")
+
+	client, http, err := dialWebsocket(clientURL, nil, "v2.terminal.gitlab.com", "terminal.gitlab.com")
+	defer http.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, "v2.terminal.gitlab.com", client.Subprotocol())
+
+	server := (<-serverConns).conn
+	defer server.Close()
+
+	// v2.terminal.gitlab.com: text messages are resize messages
+	require.NoError(t, client.WriteMessage(websocket.TextMessage, []byte(`{"cols":120,"rows":40}`)))
+	requireReadMessage(t, server, websocket.BinaryMessage, "\x04"+`{"Width":120,"Height":40}`)
+
+	// v5.channel.k8s.io: the error stream reports the exit status
+	require.NoError(t, server.WriteMessage(websocket.BinaryMessage, []byte("\x03"+`{"status":"Success"}`)))
+	require.NoError(t, server.WriteMessage(websocket.BinaryMessage, []byte("\x01done")))
+	requireReadMessage(t, client, websocket.BinaryMessage, "done")
+
+	// Closing the client sends an EOT signal and closes the server's STDIN
+	client.Close()
+	requireReadMessage(t, server, websocket.BinaryMessage, "\x00\x04")
+	requireReadMessage(t, server, websocket.BinaryMessage, "\xff\x00")
+}
+
+func TestChannelPortForward(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write([]byte("hello from the job\n"))
+	}()
+	target := ln.Addr().String()
+
+	_, clientURL := wireupChannel(t, servicesProxyWSPath, func(authResponse *api.Response) {
+		authResponse.Channel.DialTargets = []string{target}
+	}, "channel.k8s.io")
+
+	conn, http, err := dialWebsocket(clientURL, nil, portforward.Subprotocol)
+	defer http.Body.Close()
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, portforward.Subprotocol, conn.Subprotocol())
+
+	client := portforward.NewClient(conn)
+	local, remote := net.Pipe()
+	go func() {
+		_ = client.Forward(remote, target)
+	}()
+
+	line, err := bufio.NewReader(local).ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "hello from the job\n", line)
+}
+
+func TestChannelPortForwardIdleTimeout(t *testing.T) {
+	_, clientURL := wireupChannel(t, servicesProxyWSPath, func(authResponse *api.Response) {
+		authResponse.Channel.DialTargets = []string{"127.0.0.1:1"}
+		authResponse.Channel.MaxIdleTime = 1
+	}, "channel.k8s.io")
+
+	conn, http, err := dialWebsocket(clientURL, nil, portforward.Subprotocol)
+	defer http.Body.Close()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = conn.ReadMessage()
+
+	require.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation), "Client connection was not closed, got %v", err)
+	require.Contains(t, err.Error(), "idle for more than 1s")
+}
+
+func TestChannelPortForwardWithoutDialTargets(t *testing.T) {
+	_, clientURL := wireupChannel(t, servicesProxyWSPath, nil, "channel.k8s.io")
+
+	_, http, err := dialWebsocket(clientURL, nil, portforward.Subprotocol)
+	defer http.Body.Close()
+	require.Equal(t, websocket.ErrBadHandshake, err, "unexpected error %v", err)
+	require.Equal(t, 500, http.StatusCode)
+}
+
 func TestChannelBadTLS(t *testing.T) {
 	_, clientURL := wireupChannel(t, envTerminalPath, badCA, "channel.k8s.io")
 
@@ -93,6 +183,72 @@ func TestChannelSessionTimeout(t *testing.T) {
 	require.True(t, websocket.IsCloseError(err, websocket.CloseAbnormalClosure), "Client connection was not closed, got %v", err)
 }
 
+func TestChannelIdleTimeout(t *testing.T) {
+	serverConns, clientURL := wireupChannel(t, envTerminalPath, func(authResponse *api.Response) {
+		authResponse.Channel.MaxIdleTime = 1
+	}, "channel.k8s.io")
+
+	client, http, err := dialWebsocket(clientURL, nil, "terminal.gitlab.com")
+	defer http.Body.Close()
+	require.NoError(t, err)
+
+	sc := <-serverConns
+	defer sc.conn.Close()
+
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = client.ReadMessage()
+
+	require.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation), "Client connection was not closed, got %v", err)
+	require.Contains(t, err.Error(), "idle for more than 1s")
+}
+
+func TestChannelByteLimits(t *testing.T) {
+	tests := []struct {
+		desc     string
+		modifier func(*api.Response)
+		reason   string
+		send     func(client, server *websocket.Conn) error
+	}{
+		{
+			desc:     "input",
+			modifier: func(authResponse *api.Response) { authResponse.Channel.MaxInputBytes = 10 },
+			reason:   "input limit of 10 bytes exceeded",
+			send: func(client, _ *websocket.Conn) error {
+				return say(client, "more than ten bytes")
+			},
+		},
+		{
+			desc:     "output",
+			modifier: func(authResponse *api.Response) { authResponse.Channel.MaxOutputBytes = 10 },
+			reason:   "output limit of 10 bytes exceeded",
+			send: func(_, server *websocket.Conn) error {
+				return say(server, "\x01more than ten bytes")
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			serverConns, clientURL := wireupChannel(t, envTerminalPath, tc.modifier, "channel.k8s.io")
+
+			client, http, err := dialWebsocket(clientURL, nil, "terminal.gitlab.com")
+			defer http.Body.Close()
+			require.NoError(t, err)
+
+			sc := <-serverConns
+			defer sc.conn.Close()
+
+			require.NoError(t, tc.send(client, sc.conn))
+
+			client.SetReadDeadline(time.Now().Add(5 * time.Second))
+			_, _, err = client.ReadMessage()
+
+			require.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation), "Client connection was not closed, got %v", err)
+			require.Contains(t, err.Error(), tc.reason)
+		})
+	}
+}
+
 func TestChannelProxyForwardsHeadersFromUpstream(t *testing.T) {
 	hdr := make(http.Header)
 	hdr.Set("Random-Header", "Value")
@@ -38,13 +38,40 @@ trusted_cidrs_for_x_forwarded_for = ["127.0.0.1/8", "192.168.0.1/8"]
 trusted_cidrs_for_propagation = ["10.0.0.1/8"]
 
 [redis]
+Cluster = ["redis://node1:7000", "rediss://node2:7000"]
 Password = "redis password"
 SentinelUsername = "sentinel-user"
 SentinelPassword = "sentinel password"
+[notifications]
+backend = "postgres"
+[notifications.postgres]
+url = "postgres://gitlab@localhost/gitlabhq_production"
 [object_storage]
 provider = "test provider"
 [image_resizer]
 max_scaler_procs = 123
+[image_upload]
+enabled = true
+[lsif]
+max_documents = 42
+[upload_pack_cache]
+enabled = true
+ttl = "10m"
+[upload_pack_policy]
+require_filter_above_bytes = 1073741824
+enforce = true
+[lfs]
+download_acceleration = true
+batch_cache_ttl = "30s"
+[geo_proxy]
+polling_interval = "1m"
+health_check_path = "/-/readiness"
+[git_audit]
+spool_dir = "/var/spool/workhorse"
+file_sink = "/var/log/gitlab/git-audit.jsonl"
+[git_audit.syslog]
+network = "udp"
+address = "localhost:514"
 [[listeners]]
 network = "tcp"
 addr = "localhost:3443"
@@ -74,11 +101,32 @@ key = "/path/to/private/key"
 	// config file ends up in the config struct. We do not test all the
 	// fields in each section; that should happen in the tests of the
 	// internal/config package.
+	require.Len(t, cfg.Redis.Cluster, 2)
+	require.Equal(t, "rediss://node2:7000", cfg.Redis.Cluster[1].String())
 	require.Equal(t, "redis password", cfg.Redis.Password)
 	require.Equal(t, "sentinel-user", cfg.Redis.SentinelUsername)
 	require.Equal(t, "sentinel password", cfg.Redis.SentinelPassword)
+	require.Equal(t, "postgres", cfg.NotificationsConfig.Backend, "notifications backend")
+	require.Equal(t, "postgres://gitlab@localhost/gitlabhq_production", cfg.NotificationsConfig.Postgres.URL, "notifications postgres url")
+	require.Equal(t, "workhorse_notifications", cfg.NotificationsConfig.Postgres.Channel, "notifications default postgres channel")
 	require.Equal(t, "test provider", cfg.ObjectStorageCredentials.Provider)
 	require.Equal(t, uint32(123), cfg.ImageResizerConfig.MaxScalerProcs, "image resizer max_scaler_procs")
+	require.True(t, cfg.ImageUploadConfig.Enabled, "image upload enabled")
+	require.Equal(t, 42, cfg.LsifConfig.MaxDocuments, "lsif max_documents")
+	require.True(t, cfg.UploadPackCacheConfig.Enabled, "upload pack cache enabled")
+	require.Equal(t, 10*time.Minute, cfg.UploadPackCacheConfig.TTL.Duration, "upload pack cache ttl")
+	require.Equal(t, int64(1073741824), cfg.UploadPackPolicyConfig.RequireFilterAboveBytes, "upload pack policy require_filter_above_bytes")
+	require.True(t, cfg.UploadPackPolicyConfig.Enforce, "upload pack policy enforce")
+	require.True(t, cfg.LfsConfig.DownloadAcceleration, "lfs download_acceleration")
+	require.Equal(t, 30*time.Second, cfg.LfsConfig.BatchCacheTTL.Duration, "lfs batch_cache_ttl")
+	require.Equal(t, 10000, cfg.LfsConfig.BatchCacheMaxEntries, "lfs default batch_cache_max_entries")
+	require.Equal(t, time.Minute, cfg.GeoProxyConfig.PollingInterval.Duration, "geo proxy polling_interval")
+	require.Equal(t, "/-/readiness", cfg.GeoProxyConfig.HealthCheckPath, "geo proxy health_check_path")
+	require.Equal(t, "workhorse:geo_proxy:last_update", cfg.GeoProxyConfig.WatchKey, "geo proxy default watch_key")
+	require.Equal(t, "/var/spool/workhorse", cfg.GitAuditConfig.SpoolDir, "git audit spool_dir")
+	require.Equal(t, 100, cfg.GitAuditConfig.BatchSize, "git audit default batch_size")
+	require.Equal(t, "/var/log/gitlab/git-audit.jsonl", cfg.GitAuditConfig.FileSink, "git audit file_sink")
+	require.Equal(t, "localhost:514", cfg.GitAuditConfig.Syslog.Address, "git audit syslog address")
 	require.Equal(t, []string{"127.0.0.1/8", "192.168.0.1/8"}, cfg.TrustedCIDRsForXForwardedFor)
 	require.Equal(t, []string{"10.0.0.1/8"}, cfg.TrustedCIDRsForPropagation)
 	require.Equal(t, 60*time.Second, cfg.ShutdownTimeout.Duration)
@@ -190,7 +238,14 @@ func TestConfigDefaults(t *testing.T) {
 		APIQueueTimeout:          queueing.DefaultTimeout,
 		APICILongPollingDuration: 50 * time.Nanosecond, // TODO this is meant to be 50*time.Second but it has been wrong for ages
 		ImageResizerConfig:       config.DefaultImageResizerConfig,
+		ImageUploadConfig:        config.DefaultImageUploadConfig,
 		MetadataConfig:           config.DefaultMetadataConfig,
+		LsifConfig:               config.DefaultLsifConfig,
+		UploadPackCacheConfig:    config.DefaultUploadPackCacheConfig,
+		GitAuditConfig:           config.DefaultGitAuditConfig,
+		NotificationsConfig:      config.DefaultNotificationsConfig,
+		LfsConfig:                config.DefaultLfsConfig,
+		GeoProxyConfig:           config.DefaultGeoProxyConfig,
 	}
 
 	require.Equal(t, expectedCfg, cfg)
@@ -224,7 +279,14 @@ func TestCableConfigDefault(t *testing.T) {
 		APIQueueTimeout:          queueing.DefaultTimeout,
 		APICILongPollingDuration: 50 * time.Nanosecond,
 		ImageResizerConfig:       config.DefaultImageResizerConfig,
+		ImageUploadConfig:        config.DefaultImageUploadConfig,
 		MetadataConfig:           config.DefaultMetadataConfig,
+		LsifConfig:               config.DefaultLsifConfig,
+		UploadPackCacheConfig:    config.DefaultUploadPackCacheConfig,
+		GitAuditConfig:           config.DefaultGitAuditConfig,
+		NotificationsConfig:      config.DefaultNotificationsConfig,
+		LfsConfig:                config.DefaultLfsConfig,
+		GeoProxyConfig:           config.DefaultGeoProxyConfig,
 	}
 	require.Equal(t, expectedCfg, cfg)
 }
@@ -289,7 +351,14 @@ func TestConfigFlagParsing(t *testing.T) {
 		APICILongPollingDuration: 234 * time.Second,
 		PropagateCorrelationID:   true,
 		ImageResizerConfig:       config.DefaultImageResizerConfig,
+		ImageUploadConfig:        config.DefaultImageUploadConfig,
 		MetadataConfig:           config.DefaultMetadataConfig,
+		LsifConfig:               config.DefaultLsifConfig,
+		UploadPackCacheConfig:    config.DefaultUploadPackCacheConfig,
+		GitAuditConfig:           config.DefaultGitAuditConfig,
+		NotificationsConfig:      config.DefaultNotificationsConfig,
+		LfsConfig:                config.DefaultLfsConfig,
+		GeoProxyConfig:           config.DefaultGeoProxyConfig,
 		MetricsListener:          &config.ListenerConfig{Network: "tcp", Addr: "prometheus listen addr"},
 	}
 	require.Equal(t, expectedCfg, cfg)
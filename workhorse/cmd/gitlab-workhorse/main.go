@@ -19,6 +19,7 @@ import (
 
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/config"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/gitaly"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/notification"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/queueing"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/redis"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/secret"
@@ -152,8 +153,16 @@ func buildConfig(arg0 string, args []string) (*bootConfig, *config.Config, error
 	}
 
 	cfg.Redis = cfgFromFile.Redis
+	cfg.NotificationsConfig = cfgFromFile.NotificationsConfig
 	cfg.ObjectStorageCredentials = cfgFromFile.ObjectStorageCredentials
 	cfg.ImageResizerConfig = cfgFromFile.ImageResizerConfig
+	cfg.ImageUploadConfig = cfgFromFile.ImageUploadConfig
+	cfg.LsifConfig = cfgFromFile.LsifConfig
+	cfg.UploadPackCacheConfig = cfgFromFile.UploadPackCacheConfig
+	cfg.UploadPackPolicyConfig = cfgFromFile.UploadPackPolicyConfig
+	cfg.GitAuditConfig = cfgFromFile.GitAuditConfig
+	cfg.LfsConfig = cfgFromFile.LfsConfig
+	cfg.GeoProxyConfig = cfgFromFile.GeoProxyConfig
 	cfg.AltDocumentRoot = cfgFromFile.AltDocumentRoot
 	cfg.ShutdownTimeout = cfgFromFile.ShutdownTimeout
 	cfg.TrustedCIDRsForXForwardedFor = cfgFromFile.TrustedCIDRsForXForwardedFor
@@ -215,19 +224,12 @@ func run(boot bootConfig, cfg config.Config) error {
 
 	secret.SetPath(boot.secretPath)
 
-	log.Info("Using redis/go-redis")
-
-	rdb, err := redis.Configure(cfg.Redis)
+	notifications, err := configureNotifications(&cfg)
 	if err != nil {
-		log.WithError(err).Error("unable to configure redis client")
-	}
-	redisKeyWatcher := redis.NewKeyWatcher(rdb)
-
-	if rdb != nil {
-		go redisKeyWatcher.Process()
+		return fmt.Errorf("configure notifications: %v", err)
 	}
 
-	watchKeyFn := redisKeyWatcher.WatchKey
+	watchKeyFn := notifications.WatchKey
 
 	if err := cfg.RegisterGoCloudURLOpeners(); err != nil {
 		return fmt.Errorf("register cloud credentials: %v", err)
@@ -241,7 +243,8 @@ func run(boot bootConfig, cfg config.Config) error {
 
 	gitaly.InitializeSidechannelRegistry(accessLogger)
 
-	up := wrapRaven(upstream.NewUpstream(cfg, accessLogger, watchKeyFn))
+	upHandler, upCloser := upstream.NewUpstream(cfg, accessLogger, watchKeyFn)
+	up := wrapRaven(upHandler)
 
 	done := make(chan os.Signal, 1)
 	signal.Notify(done, syscall.SIGINT, syscall.SIGTERM)
@@ -275,8 +278,53 @@ func run(boot bootConfig, cfg config.Config) error {
 		ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout.Duration) // lint:allow context.Background
 		defer cancel()
 
-		redisKeyWatcher.Shutdown()
+		notifications.Shutdown()
+
+		err := srv.Shutdown(ctx)
+
+		// Requests are done, so no events are recorded after this
+		if closeErr := upCloser.Close(); closeErr != nil {
+			log.WithError(closeErr).Error("failed to close upstream")
+		}
+
+		return err
+	}
+}
+
+// configureNotifications starts the notification backend for runner long
+// polling. Without a Redis configuration, the Redis backend fails all watches
+// so that requests are proxied.
+func configureNotifications(cfg *config.Config) (notification.Backend, error) {
+	switch cfg.NotificationsConfig.Backend {
+	case "redis":
+		log.Info("Using redis/go-redis")
+
+		rdb, err := redis.Configure(cfg.Redis)
+		if err != nil {
+			log.WithError(err).Error("unable to configure redis client")
+		}
+		redisKeyWatcher := redis.NewKeyWatcher(rdb)
+
+		if rdb != nil {
+			go redisKeyWatcher.Process()
+		}
+
+		return redisKeyWatcher, nil
+	case "postgres":
+		log.Info("Using PostgreSQL LISTEN/NOTIFY")
+
+		pg, err := notification.NewPostgres(cfg.NotificationsConfig.Postgres)
+		if err != nil {
+			return nil, err
+		}
+		go pg.Process()
+
+		return pg, nil
+	case "memory":
+		log.Info("Using in-memory notifications")
 
-		return srv.Shutdown(ctx)
+		return notification.NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("unknown backend %q", cfg.NotificationsConfig.Backend)
 	}
 }
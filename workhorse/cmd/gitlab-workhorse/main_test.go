@@ -775,10 +775,11 @@ func startWorkhorseServer(t *testing.T, authBackend string) *httptest.Server {
 
 func startWorkhorseServerWithConfig(t *testing.T, cfg *config.Config) *httptest.Server {
 	testhelper.ConfigureSecret()
-	u := upstream.NewUpstream(*cfg, logrus.StandardLogger(), nil)
+	u, closer := upstream.NewUpstream(*cfg, logrus.StandardLogger(), nil)
 	newServer := httptest.NewServer(u)
 	t.Cleanup(func() {
 		newServer.Close()
+		closer.Close()
 	})
 	return newServer
 }
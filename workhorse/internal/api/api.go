@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,6 +13,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -30,7 +32,8 @@ const (
 
 	failureResponseLimit = 32768
 
-	geoProxyEndpointPath = "/api/v4/geo/proxy"
+	geoProxyEndpointPath             = "/api/v4/geo/proxy"
+	geoReplicationStatusEndpointPath = "/api/v4/geo/proxy/replication_status"
 )
 
 // API represents a client for interacting with an external API.
@@ -40,6 +43,10 @@ type API struct {
 	Version string
 }
 
+// ErrGeoReplicationStatusNotFound is returned by GetGeoReplicationStatus
+// when the GitLab version does not provide the replication status endpoint.
+var ErrGeoReplicationStatusNotFound = errors.New("GetGeoReplicationStatus: endpoint not found")
+
 // PreAuthorizeFixedPathError represents an error returned when authorization fails due to fixed path.
 type PreAuthorizeFixedPathError struct {
 	StatusCode int
@@ -77,9 +84,15 @@ func NewAPI(myURL *url.URL, version string, roundTripper http.RoundTripper) *API
 
 // GeoProxyEndpointResponse represents the response structure for geo-proxy endpoint data.
 type GeoProxyEndpointResponse struct {
-	GeoProxyURL       string `json:"geo_proxy_url"`
-	GeoProxyExtraData string `json:"geo_proxy_extra_data"`
-	GeoEnabled        bool   `json:"geo_enabled"`
+	GeoProxyURL        string `json:"geo_proxy_url"`
+	GeoProxyExtraData  string `json:"geo_proxy_extra_data"`
+	GeoEnabled         bool   `json:"geo_enabled"`
+	GeoProxyLastUpdate string `json:"geo_proxy_last_update"`
+}
+
+// GeoReplicationStatusResponse represents the response structure for the Geo replication status endpoint.
+type GeoReplicationStatusResponse struct {
+	Replicated bool `json:"replicated"`
 }
 
 // GeoProxyData represents data url, extra data and enabled or not.
@@ -87,6 +100,9 @@ type GeoProxyData struct {
 	GeoProxyURL       *url.URL
 	GeoProxyExtraData string
 	GeoEnabled        bool
+	// GeoProxyLastUpdate is the value of the key that Rails changes when
+	// the Geo proxy data changes
+	GeoProxyLastUpdate string
 }
 
 // HandleFunc defines the signature of functions used to handle HTTP requests.
@@ -161,6 +177,8 @@ type Response struct {
 	LfsOid string
 	// LFS object size
 	LfsSize int64
+	// LfsObject tells workhorse where to find the LFS object of a download
+	LfsObject *LfsObject
 	// TmpPath is the path where we should store temporary files
 	// This is set by authorization middleware
 	TempPath string
@@ -173,6 +191,12 @@ type Response struct {
 	Entry string `json:"entry"`
 	// Used to communicate channel session details
 	Channel *ChannelSettings
+	// RecordingPath is the directory where channel session recordings are
+	// stored on local disk. Recordings are uploaded to RemoteObject when it
+	// is empty.
+	RecordingPath string
+	// JobLog tells workhorse how to stream the log of a CI job
+	JobLog *JobLogSettings
 	// GitalyServer specifies an address and authentication token for a gitaly server we should connect to.
 	GitalyServer GitalyServer
 	// Repository object for making gRPC requests to Gitaly.
@@ -187,6 +211,50 @@ type Response struct {
 	UploadHashFunctions []string
 	// NeedAudit indicates whether git events should be audited to rails.
 	NeedAudit bool `json:"NeedAudit"`
+	// MaxPushSize is the maximum size in bytes of the request body of a git
+	// push. Zero means no limit.
+	MaxPushSize int64
+	// RepositorySize is the size of the repository in bytes, used by
+	// upload-pack policies
+	RepositorySize int64
+	// BundleURIs lists pre-generated bundles that Git protocol v2 clients
+	// are told to download before fetching the remaining objects
+	BundleURIs []BundleURI
+}
+
+// BundleURI describes a Git bundle that clients can download instead of
+// receiving its objects from Gitaly.
+type BundleURI struct {
+	// ID uniquely identifies the bundle in the bundle list
+	ID string `json:"id"`
+	// URI is where the bundle can be downloaded from, for example an object
+	// storage URL or a GitLab URL answered with sendurl or sendfile
+	URI string `json:"uri"`
+	// CreationToken orders bundles so that clients only download bundles
+	// newer than the ones they already have
+	CreationToken uint64 `json:"creation_token,omitempty"`
+}
+
+// JobLogSettings describes how to stream the log of a CI job as
+// Server-Sent Events.
+type JobLogSettings struct {
+	// LogPath is requested from GitLab, with the same credentials and a
+	// Range header, for the log after the last sent byte. GitLab may answer
+	// with sendfile or senddata, for example for archived logs.
+	LogPath string
+	// WatchKey is set by GitLab to the size of the log in bytes whenever
+	// the log grows
+	WatchKey string
+}
+
+// LfsObject describes the location of a stored LFS object.
+type LfsObject struct {
+	// Path is the location of the object on local disk
+	Path string
+	// URL is the location of the object in object storage
+	URL string
+	// Redirect clients to URL instead of proxying the object through workhorse
+	Redirect bool
 }
 
 // GitalyServer represents configuration parameters for a Gitaly server,
@@ -297,6 +365,23 @@ type GitAuditEventRequest struct {
 	Repo          string                                  `json:"gl_repository"`
 	Username      string                                  `json:"username"`
 	PackfileStats *gitalypb.PackfileNegotiationStatistics `json:"packfile_stats,omitempty"`
+	Time          time.Time                               `json:"time"`
+	ClientIP      string                                  `json:"client_ip,omitempty"`
+	Outcome       string                                  `json:"outcome,omitempty"`
+	BytesIn       int64                                   `json:"bytes_in"`
+	BytesOut      int64                                   `json:"bytes_out"`
+	DurationS     float64                                 `json:"duration_s"`
+}
+
+// GitAuditEventError is returned by SendGitAuditEvent when Rails does not
+// accept the event.
+type GitAuditEventError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *GitAuditEventError) Error() string {
+	return fmt.Sprintf("SendGitAuditEvent: response status: %s", e.Status)
 }
 
 // SendGitAuditEvent sends a Git audit event using the API client.
@@ -322,7 +407,7 @@ func (api *API) SendGitAuditEvent(ctx context.Context, body GitAuditEventRequest
 	defer func() { _ = httpResponse.Body.Close() }()
 
 	if httpResponse.StatusCode != http.StatusOK {
-		return fmt.Errorf("SendGitAuditEvent: response status: %s", httpResponse.Status)
+		return &GitAuditEventError{StatusCode: httpResponse.StatusCode, Status: httpResponse.Status}
 	}
 
 	return nil
@@ -531,8 +616,42 @@ func (api *API) GetGeoProxyData() (*GeoProxyData, error) {
 	}
 
 	return &GeoProxyData{
-		GeoProxyURL:       geoProxyURL,
-		GeoProxyExtraData: response.GeoProxyExtraData,
-		GeoEnabled:        response.GeoEnabled,
+		GeoProxyURL:        geoProxyURL,
+		GeoProxyExtraData:  response.GeoProxyExtraData,
+		GeoEnabled:         response.GeoEnabled,
+		GeoProxyLastUpdate: response.GeoProxyLastUpdate,
 	}, nil
 }
+
+// GetGeoReplicationStatus asks Rails whether the data downloaded from path is
+// replicated to this Geo secondary, so that it can be served locally.
+func (api *API) GetGeoReplicationStatus(ctx context.Context, path string) (bool, error) {
+	statusURL := *api.URL
+	statusURL.Path, statusURL.RawPath = joinURLPath(api.URL, geoReplicationStatusEndpointPath)
+	statusURL.RawQuery = url.Values{"path": {path}}.Encode()
+
+	statusReq, err := http.NewRequestWithContext(ctx, "GET", statusURL.String(), nil)
+	if err != nil {
+		return false, fmt.Errorf("GetGeoReplicationStatus: new request: %v", err)
+	}
+
+	httpResponse, err := api.doRequestWithoutRedirects(statusReq)
+	if err != nil {
+		return false, fmt.Errorf("GetGeoReplicationStatus: do request: %v", err)
+	}
+	defer func() { _ = httpResponse.Body.Close() }()
+
+	if httpResponse.StatusCode == http.StatusNotFound {
+		return false, ErrGeoReplicationStatusNotFound
+	}
+	if httpResponse.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("GetGeoReplicationStatus: Received HTTP status code: %v", httpResponse.StatusCode)
+	}
+
+	response := &GeoReplicationStatusResponse{}
+	if err = json.NewDecoder(httpResponse.Body).Decode(response); err != nil {
+		return false, fmt.Errorf("GetGeoReplicationStatus: decode response: %v", err)
+	}
+
+	return response.Replicated, nil
+}
@@ -3,6 +3,7 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -55,6 +56,51 @@ func TestGetGeoProxyDataForResponses(t *testing.T) {
 	}
 }
 
+func TestGetGeoReplicationStatus(t *testing.T) {
+	testCases := []struct {
+		desc               string
+		code               int
+		json               string
+		expectedError      bool
+		expectedReplicated bool
+	}{
+		{"when replicated", 200, `{"replicated":true}`, false, true},
+		{"when not replicated", 200, `{"replicated":false}`, false, false},
+		{"when unknown", 200, `{}`, false, false},
+		{"for malformed response", 200, `non-json`, true, false},
+		{"for error response", 500, `{"replicated":true}`, true, false},
+		{"for missing endpoint", 404, `{"message":"404 Not Found"}`, true, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ts := testhelper.TestServerWithHandlerWithGeoPolling(regexp.MustCompile(`/api/v4/geo/proxy/replication_status\z`), func(w http.ResponseWriter, r *http.Request) {
+				assert.NotEmpty(t, r.Header.Get(secret.RequestHeader))
+				assert.Equal(t, "/group/project/-/archive/main/project-main.zip", r.URL.Query().Get("path"))
+
+				w.Header().Set("Content-Type", ResponseContentType)
+				w.WriteHeader(tc.code)
+				io.WriteString(w, tc.json)
+			})
+			defer ts.Close()
+
+			backend := helper.URLMustParse(ts.URL)
+			testhelper.ConfigureSecret()
+			apiClient := NewAPI(backend, "123", roundtripper.NewTestBackendRoundTripper(backend))
+
+			replicated, err := apiClient.GetGeoReplicationStatus(context.Background(), "/group/project/-/archive/main/project-main.zip")
+
+			if tc.expectedError {
+				require.Error(t, err)
+				require.Equal(t, tc.code == http.StatusNotFound, errors.Is(err, ErrGeoReplicationStatusNotFound))
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tc.expectedReplicated, replicated)
+			}
+		})
+	}
+}
+
 func TestPreAuthorizeFixedPath_OK(t *testing.T) {
 	var (
 		upstreamHeaders http.Header
@@ -5,8 +5,10 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
+	"slices"
 
 	"github.com/gorilla/websocket"
 	"gitlab.com/gitlab-org/labkit/log"
@@ -31,6 +33,25 @@ type ChannelSettings struct {
 	// The value is specified in seconds. It is converted to time.Duration
 	// later.
 	MaxSessionTime int
+
+	// MaxIdleTime stops the session when no data is sent in either
+	// direction for that many seconds. Zero means no limit.
+	MaxIdleTime int
+
+	// MaxInputBytes and MaxOutputBytes stop the session when more data is
+	// sent from the client or to the client respectively. Zero means no
+	// limit.
+	MaxInputBytes  int64
+	MaxOutputBytes int64
+
+	// DialTargets lists the TCP addresses, as host:port, that clients of
+	// the port-forward subprotocol may connect to. Url is not used for
+	// port forwarding.
+	DialTargets []string
+
+	// RecordSession enables recording of the session in asciicast v2
+	// format. See Response.RecordingPath for where recordings are stored.
+	RecordSession bool
 }
 
 // URL parses the websocket URL in the ChannelSettings and returns a *url.URL.
@@ -97,6 +118,26 @@ func (t *ChannelSettings) Validate() error {
 	return nil
 }
 
+// ValidatePortForward checks if the ChannelSettings instance is valid for
+// port forwarding.
+func (t *ChannelSettings) ValidatePortForward() error {
+	if t == nil {
+		return fmt.Errorf("channel details not specified")
+	}
+
+	if len(t.DialTargets) == 0 {
+		return fmt.Errorf("no dial targets specified")
+	}
+
+	for _, target := range t.DialTargets {
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			return fmt.Errorf("invalid dial target: %q", target)
+		}
+	}
+
+	return nil
+}
+
 // IsEqual compares the current ChannelSettings with another ChannelSettings instance.
 // It returns true if both instances are equal (or both nil), otherwise false.
 func (t *ChannelSettings) IsEqual(other *ChannelSettings) bool {
@@ -122,6 +163,10 @@ func (t *ChannelSettings) IsEqual(other *ChannelSettings) bool {
 		return false
 	}
 
+	if !slices.Equal(t.DialTargets, other.DialTargets) {
+		return false
+	}
+
 	for header, values := range t.Header {
 		if len(values) != len(other.Header[header]) {
 			return false
@@ -135,5 +180,9 @@ func (t *ChannelSettings) IsEqual(other *ChannelSettings) bool {
 
 	return t.Url == other.Url &&
 		t.CAPem == other.CAPem &&
-		t.MaxSessionTime == other.MaxSessionTime
+		t.MaxSessionTime == other.MaxSessionTime &&
+		t.MaxIdleTime == other.MaxIdleTime &&
+		t.MaxInputBytes == other.MaxInputBytes &&
+		t.MaxOutputBytes == other.MaxOutputBytes &&
+		t.RecordSession == other.RecordSession
 }
@@ -48,6 +48,20 @@ func timeout(channel *ChannelSettings) *ChannelSettings {
 	return channel
 }
 
+func limited(channel *ChannelSettings, modifier func(*ChannelSettings)) *ChannelSettings {
+	channel = channel.Clone()
+	modifier(channel)
+
+	return channel
+}
+
+func recorded(channel *ChannelSettings) *ChannelSettings {
+	channel = channel.Clone()
+	channel.RecordSession = true
+
+	return channel
+}
+
 func header(channel *ChannelSettings, values ...string) *ChannelSettings {
 	if len(values) == 0 {
 		values = []string{"Dummy Value"}
@@ -103,6 +117,24 @@ func TestValidate(t *testing.T) {
 	}
 }
 
+func TestValidatePortForward(t *testing.T) {
+	for i, tc := range []struct {
+		channel *ChannelSettings
+		valid   bool
+		msg     string
+	}{
+		{nil, false, "nil channel"},
+		{&ChannelSettings{}, false, "no dial targets"},
+		{&ChannelSettings{DialTargets: []string{"localhost:5432"}}, true, "dial target"},
+		{&ChannelSettings{DialTargets: []string{"localhost:5432", "[::1]:9229"}}, true, "multiple dial targets"},
+		{&ChannelSettings{DialTargets: []string{"localhost"}}, false, "dial target without port"},
+	} {
+		if err := tc.channel.ValidatePortForward(); (err != nil) == tc.valid {
+			t.Fatalf("test case %d: "+tc.msg+": valid=%v: %s: %+v", i, tc.valid, err, tc.channel)
+		}
+	}
+}
+
 func TestDialer(t *testing.T) {
 	channel := channel("ws:", "foo")
 	dialer := channel.Dialer()
@@ -160,6 +192,12 @@ func TestIsEqual(t *testing.T) {
 		{ca(header(chann)), ca(header(chann)), true},
 		{channCa2, ca(chann), false},
 		{chann, timeout(chann), false},
+		{chann, recorded(chann), false},
+		{chann, limited(chann, func(c *ChannelSettings) { c.DialTargets = []string{"localhost:5432"} }), false},
+		{chann, limited(chann, func(c *ChannelSettings) { c.MaxIdleTime = 60 }), false},
+		{chann, limited(chann, func(c *ChannelSettings) { c.MaxInputBytes = 1024 }), false},
+		{chann, limited(chann, func(c *ChannelSettings) { c.MaxOutputBytes = 1024 }), false},
+		{recorded(chann), recorded(chann), true},
 	} {
 		if actual := tc.channelA.IsEqual(tc.channelB); tc.expected != actual {
 			t.Fatalf(
@@ -0,0 +1,51 @@
+package builds
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/helper"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/helper/ttlcache"
+)
+
+const (
+	runnerLastUpdateHeaderKey = "X-Gitlab-Last-Update"
+	noJobsCacheTTL            = 30 * time.Second
+	noJobsCacheMaxEntries     = 100000
+)
+
+var registerHandlerSavedRequests = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Name: "gitlab_workhorse_builds_register_handler_saved_requests_total",
+		Help: "How many runner requests with an outdated last_update were answered by watching the last value Rails answered no jobs for, instead of being proxied",
+	},
+)
+
+// noJobsCache remembers, per runner token, the queue value of the last
+// response in which Rails said there are no jobs for the runner. Requests of
+// the runner with an older last_update can then wait for that value to
+// change instead of asking Rails again. Fleets of runners sharing a token
+// often send outdated values.
+type noJobsCache struct {
+	*ttlcache.Cache[string]
+}
+
+func newNoJobsCache() *noJobsCache {
+	return &noJobsCache{ttlcache.New[string](noJobsCacheTTL, noJobsCacheMaxEntries)}
+}
+
+// proxy proxies the request of a runner to Rails, and remembers the
+// X-GitLab-Last-Update value of a no jobs response.
+func (c *noJobsCache) proxy(h http.Handler, w http.ResponseWriter, r *http.Request, token string) {
+	cw := helper.NewCountingResponseWriter(w)
+	proxyRegisterRequest(h, cw, r)
+
+	if lastUpdate := w.Header().Get(runnerLastUpdateHeaderKey); cw.Status() == http.StatusNoContent && lastUpdate != "" {
+		c.Put(token, lastUpdate)
+	} else {
+		c.Delete(token)
+	}
+}
@@ -16,7 +16,7 @@ import (
 
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/helper"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/helper/fail"
-	"gitlab.com/gitlab-org/gitlab/workhorse/internal/redis"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/notification"
 )
 
 const (
@@ -58,8 +58,8 @@ var (
 
 type largeBodyError struct{ error }
 
-// WatchKeyHandler is a function type for watching keys in Redis.
-type WatchKeyHandler func(ctx context.Context, key, value string, timeout time.Duration) (redis.WatchKeyStatus, error)
+// WatchKeyHandler is a function type for watching keys with a notification backend.
+type WatchKeyHandler func(ctx context.Context, key, value string, timeout time.Duration) (notification.WatchKeyStatus, error)
 
 type runnerRequest struct {
 	Token      string `json:"token,omitempty"`
@@ -110,7 +110,7 @@ func proxyRegisterRequest(h http.Handler, w http.ResponseWriter, r *http.Request
 	h.ServeHTTP(w, r)
 }
 
-func watchForRunnerChange(ctx context.Context, watchHandler WatchKeyHandler, token, lastUpdate string, duration time.Duration) (redis.WatchKeyStatus, error) {
+func watchForRunnerChange(ctx context.Context, watchHandler WatchKeyHandler, token, lastUpdate string, duration time.Duration) (notification.WatchKeyStatus, error) {
 	registerHandlerOpenAtWatching.Inc()
 	defer registerHandlerOpenAtWatching.Dec()
 
@@ -122,6 +122,8 @@ func RegisterHandler(h http.Handler, watchHandler WatchKeyHandler, pollingDurati
 		return h
 	}
 
+	noJobs := newNoJobsCache()
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set(runnerBuildQueueHeaderKey, runnerBuildQueueHeaderValue)
 
@@ -142,44 +144,68 @@ func RegisterHandler(h http.Handler, watchHandler WatchKeyHandler, pollingDurati
 			return
 		}
 
-		if runnerRequest.Token == "" || runnerRequest.LastUpdate == "" {
+		if runnerRequest.Token == "" {
 			registerHandlerMissingValues.Inc()
 			proxyRegisterRequest(h, w, newRequest)
 			return
 		}
 
+		// Rails recently said there are no jobs at a newer value, so we can
+		// watch that value instead of asking Rails again
+		lastUpdate := runnerRequest.LastUpdate
+		noJobsValue, useNoJobs := noJobs.Get(runnerRequest.Token)
+		if useNoJobs && noJobsValue != lastUpdate {
+			lastUpdate = noJobsValue
+		} else {
+			useNoJobs = false
+		}
+
+		if lastUpdate == "" {
+			registerHandlerMissingValues.Inc()
+			noJobs.proxy(h, w, newRequest, runnerRequest.Token)
+			return
+		}
+
 		result, err := watchForRunnerChange(r.Context(), watchHandler, runnerRequest.Token,
-			runnerRequest.LastUpdate, pollingDuration)
+			lastUpdate, pollingDuration)
 		if err != nil {
 			registerHandlerWatchErrors.Inc()
-			proxyRegisterRequest(h, w, newRequest)
+			noJobs.proxy(h, w, newRequest, runnerRequest.Token)
 			return
 		}
 
+		if useNoJobs && result != notification.WatchKeyStatusAlreadyChanged {
+			registerHandlerSavedRequests.Inc()
+			if result != notification.WatchKeyStatusSeenChange {
+				// Tell the runner the value it is up to date with, as Rails does
+				w.Header().Set(runnerLastUpdateHeaderKey, lastUpdate)
+			}
+		}
+
 		switch result {
 		// It means that we detected a change before starting watching on change,
 		// We proxy request to Rails, to see whether we have a build to receive
-		case redis.WatchKeyStatusAlreadyChanged:
+		case notification.WatchKeyStatusAlreadyChanged:
 			registerHandlerAlreadyChangedRequests.Inc()
-			proxyRegisterRequest(h, w, newRequest)
+			noJobs.proxy(h, w, newRequest, runnerRequest.Token)
 
 		// It means that we detected a change after watching.
 		// We could potentially proxy request to Rails, but...
 		// We can end-up with unreliable responses,
 		// as don't really know whether ResponseWriter is still in a sane state,
 		// for example the connection is dead
-		case redis.WatchKeyStatusSeenChange:
+		case notification.WatchKeyStatusSeenChange:
 			registerHandlerSeenChangeRequests.Inc()
 			w.WriteHeader(http.StatusNoContent)
 
 		// When we receive one of these statuses, it means that we detected no change,
 		// so we return to runner 204, which means nothing got changed,
 		// and there's no new builds to process
-		case redis.WatchKeyStatusTimeout:
+		case notification.WatchKeyStatusTimeout:
 			registerHandlerTimeoutRequests.Inc()
 			w.WriteHeader(http.StatusNoContent)
 
-		case redis.WatchKeyStatusNoChange:
+		case notification.WatchKeyStatusNoChange:
 			registerHandlerNoChangeRequests.Inc()
 			w.WriteHeader(http.StatusNoContent)
 		}
@@ -13,7 +13,7 @@ import (
 
 	"github.com/stretchr/testify/require"
 
-	"gitlab.com/gitlab-org/gitlab/workhorse/internal/redis"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/notification"
 )
 
 const upstreamResponseCode = 999
@@ -69,10 +69,10 @@ func TestRegisterHandlerMissingData(t *testing.T) {
 	}
 }
 
-func expectWatcherToBeExecuted(t *testing.T, watchKeyStatus redis.WatchKeyStatus, watchKeyError error,
+func expectWatcherToBeExecuted(t *testing.T, watchKeyStatus notification.WatchKeyStatus, watchKeyError error,
 	httpStatus int, msgAndArgs ...interface{}) {
 	executed := false
-	watchKeyHandler := func(_ context.Context, _, _ string, _ time.Duration) (redis.WatchKeyStatus, error) {
+	watchKeyHandler := func(_ context.Context, _, _ string, _ time.Duration) (notification.WatchKeyStatus, error) {
 		executed = true
 		return watchKeyStatus, watchKeyError
 	}
@@ -84,30 +84,96 @@ func expectWatcherToBeExecuted(t *testing.T, watchKeyStatus redis.WatchKeyStatus
 }
 
 func TestRegisterHandlerWatcherError(t *testing.T) {
-	expectWatcherToBeExecuted(t, redis.WatchKeyStatusNoChange, errors.New("redis connection"),
+	expectWatcherToBeExecuted(t, notification.WatchKeyStatusNoChange, errors.New("redis connection"),
 		upstreamResponseCode, "proxies data to upstream")
 }
 
 func TestRegisterHandlerWatcherAlreadyChanged(t *testing.T) {
-	expectWatcherToBeExecuted(t, redis.WatchKeyStatusAlreadyChanged, nil,
+	expectWatcherToBeExecuted(t, notification.WatchKeyStatusAlreadyChanged, nil,
 		upstreamResponseCode, "proxies data to upstream")
 }
 
 func TestRegisterHandlerWatcherSeenChange(t *testing.T) {
-	expectWatcherToBeExecuted(t, redis.WatchKeyStatusSeenChange, nil,
+	expectWatcherToBeExecuted(t, notification.WatchKeyStatusSeenChange, nil,
 		http.StatusNoContent)
 }
 
 func TestRegisterHandlerWatcherTimeout(t *testing.T) {
-	expectWatcherToBeExecuted(t, redis.WatchKeyStatusTimeout, nil,
+	expectWatcherToBeExecuted(t, notification.WatchKeyStatusTimeout, nil,
 		http.StatusNoContent)
 }
 
 func TestRegisterHandlerWatcherNoChange(t *testing.T) {
-	expectWatcherToBeExecuted(t, redis.WatchKeyStatusNoChange, nil,
+	expectWatcherToBeExecuted(t, notification.WatchKeyStatusNoChange, nil,
 		http.StatusNoContent)
 }
 
+func TestRegisterHandlerNoJobsCache(t *testing.T) {
+	queueValue := "2"
+	upstreamStatus := http.StatusNoContent
+	upstreamCalls := 0
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		upstreamCalls++
+		w.Header().Set("X-GitLab-Last-Update", queueValue)
+		w.WriteHeader(upstreamStatus)
+	})
+
+	var watchedValue string
+	watchKeyHandler := func(_ context.Context, _, value string, _ time.Duration) (notification.WatchKeyStatus, error) {
+		watchedValue = value
+		if value != queueValue {
+			return notification.WatchKeyStatusAlreadyChanged, nil
+		}
+		return notification.WatchKeyStatusTimeout, nil
+	}
+
+	h := RegisterHandler(upstream, watchKeyHandler, time.Second)
+	request := func(body string) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		h.ServeHTTP(rw, req)
+		return rw
+	}
+
+	rw := request(`{"token":"token","last_update":"1"}`)
+	require.Equal(t, http.StatusNoContent, rw.Code)
+	require.Equal(t, "1", watchedValue)
+	require.Equal(t, 1, upstreamCalls, "proxies outdated value")
+
+	rw = request(`{"token":"token","last_update":"1"}`)
+	require.Equal(t, http.StatusNoContent, rw.Code)
+	require.Equal(t, "2", watchedValue, "watches the value Rails answered no jobs for")
+	require.Equal(t, "2", rw.Header().Get("X-GitLab-Last-Update"))
+	require.Equal(t, 1, upstreamCalls)
+
+	rw = request(`{"token":"token"}`)
+	require.Equal(t, http.StatusNoContent, rw.Code)
+	require.Equal(t, "2", watchedValue, "watches the value Rails answered no jobs for without last_update")
+	require.Equal(t, 1, upstreamCalls)
+
+	queueValue = "3"
+	upstreamStatus = http.StatusCreated
+	rw = request(`{"token":"token","last_update":"1"}`)
+	require.Equal(t, http.StatusCreated, rw.Code)
+	require.Equal(t, "2", watchedValue)
+	require.Equal(t, 2, upstreamCalls, "proxies changed value")
+
+	rw = request(`{"token":"token","last_update":"1"}`)
+	require.Equal(t, http.StatusCreated, rw.Code)
+	require.Equal(t, "1", watchedValue, "forgets the value after a job")
+	require.Equal(t, 3, upstreamCalls)
+
+	upstreamStatus = http.StatusNoContent
+	rw = request(`{"token":"other","last_update":"1"}`)
+	require.Equal(t, http.StatusNoContent, rw.Code)
+	require.Equal(t, 4, upstreamCalls)
+
+	rw = request(`{"token":"other","last_update":"1"}`)
+	require.Equal(t, "3", watchedValue, "caches values per token")
+	require.Equal(t, 4, upstreamCalls)
+}
+
 func TestReadRequestBody(t *testing.T) {
 	data := []byte("123456")
 	rw := httptest.NewRecorder()
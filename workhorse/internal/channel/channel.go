@@ -1,6 +1,8 @@
 package channel
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
@@ -17,7 +19,7 @@ import (
 
 var (
 	// See doc/channel.md for documentation of this subprotocol
-	subprotocols = []string{"terminal.gitlab.com", "base64.terminal.gitlab.com"}
+	subprotocols = []string{"v2.terminal.gitlab.com", "terminal.gitlab.com", "base64.terminal.gitlab.com"}
 	upgrader     = &websocket.Upgrader{Subprotocols: subprotocols}
 	// ReauthenticationInterval specifies the interval for reauthentication.
 	ReauthenticationInterval = 5 * time.Minute
@@ -28,12 +30,40 @@ var (
 // Handler returns an HTTP handler for handling API requests using the provided API instance.
 func Handler(myAPI *api.API) http.Handler {
 	return myAPI.PreAuthorizeHandler(func(w http.ResponseWriter, r *http.Request, a *api.Response) {
-		if err := a.Channel.Validate(); err != nil {
+		portForward := isPortForward(r)
+		validate := a.Channel.Validate
+		if portForward {
+			validate = a.Channel.ValidatePortForward
+		}
+		if err := validate(); err != nil {
 			fail.Request(w, r, err)
 			return
 		}
+		if portForward && a.Channel.RecordSession {
+			// Auditors rely on recordings, which only exist for terminals
+			fail.Request(w, r, errors.New("port forwarding of recorded sessions is not supported"), fail.WithStatus(http.StatusForbidden))
+			return
+		}
 
 		proxy := NewProxy(2) // two stoppers: auth checker, max time
+		proxy.MaxIdleTime = time.Duration(a.Channel.MaxIdleTime) * time.Second
+		proxy.MaxInputBytes = a.Channel.MaxInputBytes
+		proxy.MaxOutputBytes = a.Channel.MaxOutputBytes
+		if a.Channel.RecordSession {
+			recorder, err := NewRecorder(r, a)
+			if err != nil {
+				fail.Request(w, r, err)
+				return
+			}
+			defer func() {
+				// Sessions closed by the client are recorded too
+				if err := recorder.Close(context.WithoutCancel(r.Context())); err != nil {
+					log.ContextLogger(r.Context()).WithError(err).Error("Channel: saving session recording failed")
+				}
+			}()
+			proxy.Recorder = recorder
+		}
+
 		checker := NewAuthChecker(
 			authCheckFunc(myAPI, r, "authorize"),
 			a.Channel,
@@ -45,6 +75,11 @@ func Handler(myAPI *api.API) http.Handler {
 		go checker.Loop(ReauthenticationInterval)
 		go closeAfterMaxTime(proxy, a.Channel.MaxSessionTime)
 
+		if portForward {
+			PortForward(w, r, a.Channel, proxy)
+			return
+		}
+
 		ProxyChannel(w, r, a.Channel, proxy)
 	}, "authorize")
 }
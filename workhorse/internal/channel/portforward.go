@@ -0,0 +1,67 @@
+package channel
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/portforward"
+)
+
+var portForwardUpgrader = &websocket.Upgrader{Subprotocols: []string{portforward.Subprotocol}}
+
+func isPortForward(r *http.Request) bool {
+	return slices.Contains(websocket.Subprotocols(r), portforward.Subprotocol)
+}
+
+// PortForward connects the TCP streams of a port-forward client to the dial
+// targets of the channel settings.
+func PortForward(w http.ResponseWriter, r *http.Request, settings *api.ChannelSettings, proxy *Proxy) {
+	conn, err := portForwardUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.ContextLogger(r.Context()).WithError(err).Print("Channel: upgrading client to websocket failed")
+		return
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	// Regularly send ping messages to keep the websocket from being timed
+	// out by intervening proxies.
+	go pingLoop(conn)
+
+	logEntry := log.WithContextFields(r.Context(), log.Fields{
+		"clientAddr":  getClientAddr(r),
+		"dialTargets": settings.DialTargets,
+	})
+
+	logEntry.Print("Channel: started port forwarding")
+	defer logEntry.Print("Channel: finished port forwarding")
+
+	server := portforward.NewServer(conn, settings.DialTargets, portforward.Limits{
+		MaxIdleTime:    proxy.MaxIdleTime,
+		MaxInputBytes:  proxy.MaxInputBytes,
+		MaxOutputBytes: proxy.MaxOutputBytes,
+	})
+	go func() {
+		proxy.StopCh <- server.Serve(r.Context())
+	}()
+
+	err = <-proxy.StopCh
+
+	var limitErr *portforward.LimitError
+	if errors.As(err, &limitErr) {
+		msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, limitErr.Reason)
+		_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(5*time.Second))
+	}
+
+	if err != nil {
+		logEntry.WithError(err).Print("Channel: error port forwarding")
+	}
+}
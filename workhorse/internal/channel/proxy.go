@@ -1,8 +1,11 @@
 package channel
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -22,6 +25,27 @@ type Connection interface {
 // Proxy represents a proxy configuration.
 type Proxy struct {
 	StopCh chan error
+	// Recorder, if set, records the output of the upstream connection
+	Recorder *Recorder
+	// MaxIdleTime stops the session when no data is sent in either
+	// direction for that long. Zero means no limit.
+	MaxIdleTime time.Duration
+	// MaxInputBytes and MaxOutputBytes stop the session when more data is
+	// sent upstream or downstream respectively. Zero means no limit.
+	MaxInputBytes  int64
+	MaxOutputBytes int64
+
+	lastActivity atomic.Int64
+}
+
+// limitError is returned when a session is stopped by one of its limits.
+// The client is told the reason in a close frame.
+type limitError struct {
+	reason string
+}
+
+func (e *limitError) Error() string {
+	return "connection closed: " + e.reason
 }
 
 // NewProxy creates a new Proxy instance with the given number of stoppers.
@@ -33,18 +57,43 @@ func NewProxy(stoppers int) *Proxy {
 
 // Serve starts serving traffic between upstream and downstream connections.
 func (p *Proxy) Serve(upstream, downstream Connection, upstreamAddr, downstreamAddr string) error {
+	recorded := upstream
+	if p.Recorder != nil {
+		recorded = &recordingConnection{Connection: upstream, recorder: p.Recorder}
+	}
+
 	// This signals the upstream channel to kill the exec'd process
 	defer func() {
 		_ = upstream.WriteMessage(websocket.BinaryMessage, eot)
+		if c, ok := upstream.(inputCloser); ok {
+			_ = c.CloseInput()
+		}
 	}()
 
-	go p.proxy(upstream, downstream, upstreamAddr, downstreamAddr)
-	go p.proxy(downstream, upstream, downstreamAddr, upstreamAddr)
+	done := make(chan struct{})
+	defer close(done)
+
+	p.lastActivity.Store(time.Now().UnixNano())
+	if p.MaxIdleTime > 0 {
+		go p.idleLoop(done)
+	}
+
+	go p.proxy(upstream, downstream, upstreamAddr, downstreamAddr, "input", p.MaxInputBytes)
+	go p.proxy(downstream, recorded, downstreamAddr, upstreamAddr, "output", p.MaxOutputBytes)
+
+	err := <-p.StopCh
+
+	var limitErr *limitError
+	if errors.As(err, &limitErr) {
+		msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, limitErr.reason)
+		_ = downstream.WriteControl(websocket.CloseMessage, msg, time.Now().Add(5*time.Second))
+	}
 
-	return <-p.StopCh
+	return err
 }
 
-func (p *Proxy) proxy(to, from Connection, toAddr, fromAddr string) {
+func (p *Proxy) proxy(to, from Connection, toAddr, fromAddr string, direction string, maxBytes int64) {
+	var total int64
 	for {
 		messageType, data, err := from.ReadMessage()
 		if err != nil {
@@ -52,9 +101,67 @@ func (p *Proxy) proxy(to, from Connection, toAddr, fromAddr string) {
 			break
 		}
 
+		if messageType == resizeMessage {
+			if err := p.resize(to, data); err != nil {
+				p.StopCh <- fmt.Errorf("resizing %s: %s", toAddr, err)
+				break
+			}
+			continue
+		}
+
+		if isData(messageType) {
+			p.lastActivity.Store(time.Now().UnixNano())
+
+			total += int64(len(data))
+			if maxBytes > 0 && total > maxBytes {
+				p.StopCh <- &limitError{reason: fmt.Sprintf("%s limit of %d bytes exceeded", direction, maxBytes)}
+				break
+			}
+		}
+
 		if err := to.WriteMessage(messageType, data); err != nil {
 			p.StopCh <- fmt.Errorf("writing to %s: %s", toAddr, err)
 			break
 		}
 	}
 }
+
+func (p *Proxy) resize(to Connection, data []byte) error {
+	var size terminalSize
+	if err := json.Unmarshal(data, &size); err != nil {
+		return err
+	}
+
+	if p.Recorder != nil {
+		p.Recorder.Resize(size.Cols, size.Rows)
+	}
+
+	// Connections that cannot resize the terminal ignore the message
+	if r, ok := to.(resizer); ok {
+		return r.Resize(size)
+	}
+
+	return nil
+}
+
+func (p *Proxy) idleLoop(done chan struct{}) {
+	ticker := time.NewTicker(max(min(p.MaxIdleTime/4, time.Second), time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		idle := time.Since(time.Unix(0, p.lastActivity.Load()))
+		if idle >= p.MaxIdleTime {
+			select {
+			case p.StopCh <- &limitError{reason: fmt.Sprintf("idle for more than %v", p.MaxIdleTime)}:
+			case <-done:
+			}
+			return
+		}
+	}
+}
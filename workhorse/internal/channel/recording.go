@@ -0,0 +1,228 @@
+package channel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/upload/destination"
+)
+
+// The size of the terminal is not known until the first resize event
+const (
+	recordingWidth  = 80
+	recordingHeight = 24
+)
+
+// recordingHeader is the first line of an asciicast v2 file, see
+// https://docs.asciinema.org/manual/asciicast/v2/
+type recordingHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+	User      recordingUser     `json:"user"`
+}
+
+type recordingUser struct {
+	ID       string `json:"id,omitempty"`
+	Username string `json:"username,omitempty"`
+}
+
+// Recorder writes the output of a channel session in asciicast v2 format.
+// Input is not recorded because it may contain secrets, but its echo is part
+// of the output.
+type Recorder struct {
+	mu    sync.Mutex
+	file  *os.File
+	start time.Time
+	// pending holds an incomplete UTF-8 sequence at the end of the last
+	// output, because asciicast events must be valid UTF-8
+	pending []byte
+	// upload is the API response to upload the recording with, or nil if
+	// the recording stays on local disk
+	upload *api.Response
+	err    error
+}
+
+// NewRecorder starts a recording in the location specified by the API
+// response.
+func NewRecorder(r *http.Request, a *api.Response) (*Recorder, error) {
+	rec := &Recorder{start: time.Now()}
+
+	var err error
+	switch {
+	case a.RecordingPath != "":
+		if err := os.MkdirAll(a.RecordingPath, 0700); err != nil {
+			return nil, fmt.Errorf("recording: mkdir %q: %v", a.RecordingPath, err)
+		}
+		rec.file, err = os.CreateTemp(a.RecordingPath, "session-*.cast")
+	case a.RemoteObject.ID != "":
+		rec.upload = a
+		rec.file, err = os.CreateTemp("", "gitlab-workhorse-recording-*.cast")
+	default:
+		return nil, errors.New("recording: API response has neither RecordingPath nor RemoteObject")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("recording: create file: %v", err)
+	}
+
+	header := recordingHeader{
+		Version:   2,
+		Width:     recordingWidth,
+		Height:    recordingHeight,
+		Timestamp: rec.start.Unix(),
+		Title:     r.URL.Path,
+		Env:       map[string]string{"TERM": "xterm"},
+		User:      recordingUser{ID: a.GL_ID, Username: a.GL_USERNAME},
+	}
+	if err := rec.writeLine(header); err != nil {
+		rec.discard()
+		return nil, err
+	}
+
+	return rec, nil
+}
+
+// Output records data sent by the server.
+func (rec *Recorder) Output(data []byte) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	data = append(rec.pending, data...)
+	n := completeUTF8(data)
+	rec.pending = append([]byte(nil), data[n:]...)
+	if n > 0 {
+		rec.event("o", string(data[:n]))
+	}
+}
+
+// Resize records a change of the terminal size.
+func (rec *Recorder) Resize(cols, rows int) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.event("r", fmt.Sprintf("%dx%d", cols, rows))
+}
+
+func (rec *Recorder) event(code string, data string) {
+	elapsed := time.Since(rec.start).Seconds()
+	if err := rec.writeLine([]interface{}{elapsed, code, data}); err != nil && rec.err == nil {
+		rec.err = err
+	}
+}
+
+func (rec *Recorder) writeLine(v interface{}) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("recording: marshal: %v", err)
+	}
+
+	if _, err := rec.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("recording: write: %v", err)
+	}
+
+	return nil
+}
+
+// Close finishes the recording and uploads it if needed.
+func (rec *Recorder) Close(ctx context.Context) error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if len(rec.pending) > 0 {
+		rec.event("o", string(rec.pending))
+		rec.pending = nil
+	}
+
+	if err := rec.file.Close(); err != nil && rec.err == nil {
+		rec.err = fmt.Errorf("recording: close: %v", err)
+	}
+
+	if rec.upload == nil || rec.err != nil {
+		return rec.err
+	}
+	defer rec.discard()
+
+	return rec.uploadFile(ctx)
+}
+
+func (rec *Recorder) uploadFile(ctx context.Context) error {
+	file, err := os.Open(rec.file.Name())
+	if err != nil {
+		return fmt.Errorf("recording: open: %v", err)
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("recording: stat: %v", err)
+	}
+
+	opts, err := destination.GetOpts(rec.upload)
+	if err != nil {
+		return fmt.Errorf("recording: upload options: %v", err)
+	}
+	// Nothing finalizes the upload, so the object must not be removed
+	// once ctx is done
+	opts.SkipDelete = true
+
+	fh, err := destination.Upload(ctx, file, fi.Size(), "recording.cast", opts)
+	if err != nil {
+		return fmt.Errorf("recording: upload: %v", err)
+	}
+
+	log.WithContextFields(ctx, log.Fields{
+		"remote_id": fh.RemoteID,
+		"size":      fh.Size,
+	}).Print("Channel: uploaded session recording")
+
+	return nil
+}
+
+func (rec *Recorder) discard() {
+	_ = rec.file.Close()
+	_ = os.Remove(rec.file.Name())
+}
+
+// completeUTF8 returns the length of data without an incomplete UTF-8
+// sequence at its end.
+func completeUTF8(data []byte) int {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if !utf8.RuneStart(data[i]) {
+			continue
+		}
+		if !utf8.FullRune(data[i:]) {
+			return i
+		}
+		break
+	}
+
+	return len(data)
+}
+
+// recordingConnection records the data messages read from a channel server.
+type recordingConnection struct {
+	Connection
+	recorder *Recorder
+}
+
+func (c *recordingConnection) ReadMessage() (int, []byte, error) {
+	mt, data, err := c.Connection.ReadMessage()
+	if err == nil && isData(mt) {
+		c.recorder.Output(data)
+	}
+
+	return mt, data, err
+}
@@ -0,0 +1,141 @@
+package channel
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
+)
+
+type fakeConnection struct {
+	Connection
+	messages [][]byte
+}
+
+func (c *fakeConnection) ReadMessage() (int, []byte, error) {
+	if len(c.messages) == 0 {
+		return 0, nil, io.EOF
+	}
+
+	data := c.messages[0]
+	c.messages = c.messages[1:]
+	return websocket.BinaryMessage, data, nil
+}
+
+func readRecording(t *testing.T, r io.Reader) (recordingHeader, [][]interface{}) {
+	t.Helper()
+
+	scanner := bufio.NewScanner(r)
+	require.True(t, scanner.Scan())
+
+	var header recordingHeader
+	require.NoError(t, json.Unmarshal(scanner.Bytes(), &header))
+
+	var events [][]interface{}
+	for scanner.Scan() {
+		var event []interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, event)
+	}
+	require.NoError(t, scanner.Err())
+
+	return header, events
+}
+
+func TestRecorderLocal(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "recordings")
+	r := httptest.NewRequest("GET", "/group/project/-/environments/1/terminal.ws", nil)
+	a := &api.Response{GL_ID: "user-1", GL_USERNAME: "alice", RecordingPath: dir}
+
+	rec, err := NewRecorder(r, a)
+	require.NoError(t, err)
+
+	// "é" is split over two messages
+	conn := &recordingConnection{
+		Connection: &fakeConnection{messages: [][]byte{[]byte("$ caf\xc3"), []byte("\xa9\r\n")}},
+		recorder:   rec,
+	}
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+	rec.Resize(120, 40)
+	require.NoError(t, rec.Close(context.Background()))
+
+	files, err := filepath.Glob(filepath.Join(dir, "session-*.cast"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	file, err := os.Open(files[0])
+	require.NoError(t, err)
+	defer file.Close()
+
+	header, events := readRecording(t, file)
+	require.Equal(t, 2, header.Version)
+	require.Equal(t, recordingWidth, header.Width)
+	require.Equal(t, recordingHeight, header.Height)
+	require.Equal(t, "/group/project/-/environments/1/terminal.ws", header.Title)
+	require.Equal(t, recordingUser{ID: "user-1", Username: "alice"}, header.User)
+
+	require.Len(t, events, 3)
+	require.Equal(t, []interface{}{"o", "$ caf"}, events[0][1:])
+	require.Equal(t, []interface{}{"o", "é\r\n"}, events[1][1:])
+	require.Equal(t, []interface{}{"r", "120x40"}, events[2][1:])
+	require.LessOrEqual(t, events[0][0].(float64), events[2][0].(float64))
+}
+
+func TestRecorderUpload(t *testing.T) {
+	uploaded := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "PUT", r.Method)
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, md5.Sum(body)))
+		uploaded <- string(body)
+	}))
+	defer ts.Close()
+
+	r := httptest.NewRequest("GET", "/group/project/-/jobs/1/terminal.ws", nil)
+	a := &api.Response{GL_USERNAME: "alice", RemoteObject: api.RemoteObject{ID: "recording-1", StoreURL: ts.URL + "/recording-1"}}
+
+	rec, err := NewRecorder(r, a)
+	require.NoError(t, err)
+	rec.Output([]byte("hello"))
+	require.NoError(t, rec.Close(context.Background()))
+
+	header, events := readRecording(t, strings.NewReader(<-uploaded))
+	require.Equal(t, "alice", header.User.Username)
+	require.Len(t, events, 1)
+	require.Equal(t, []interface{}{"o", "hello"}, events[0][1:])
+
+	_, err = os.Stat(rec.file.Name())
+	require.True(t, os.IsNotExist(err), "temporary recording is removed")
+}
+
+func TestRecorderWithoutDestination(t *testing.T) {
+	_, err := NewRecorder(httptest.NewRequest("GET", "/", nil), &api.Response{})
+	require.Error(t, err)
+}
+
+func TestCompleteUTF8(t *testing.T) {
+	require.Equal(t, 3, completeUTF8([]byte("abc")))
+	require.Equal(t, 0, completeUTF8(nil))
+	require.Equal(t, 1, completeUTF8([]byte("a\xe2\x82")))
+	require.Equal(t, 4, completeUTF8([]byte("a\xe2\x82\xac")))
+	// Invalid bytes are not held back
+	require.Equal(t, 2, completeUTF8([]byte("a\xff")))
+}
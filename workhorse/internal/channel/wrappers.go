@@ -2,23 +2,65 @@ package channel
 
 import (
 	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"net"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// resizeMessage is the message type wrappers use for terminal resize
+// messages. Its data is a JSON encoded terminalSize. It is never sent over
+// a websocket.
+const resizeMessage = 1000
+
+// Kubernetes stream numbers, see
+// https://github.com/kubernetes/apimachinery/blob/master/pkg/util/remotecommand/constants.go
+const (
+	kubeStdin       = 0
+	kubeErrorStream = 3
+	kubeResize      = 4
+	kubeCloseStream = 255
+)
+
+// terminalSize is the data of a resize message
+type terminalSize struct {
+	Cols int `json:"cols"`
+	Rows int `json:"rows"`
+}
+
+// resizer is implemented by connections that can resize the terminal at
+// the other end
+type resizer interface {
+	Resize(size terminalSize) error
+}
+
+// inputCloser is implemented by connections that can signal the end of the
+// input
+type inputCloser interface {
+	CloseInput() error
+}
+
 // Wrap wraps the provided connection with the specified subprotocol.
 func Wrap(conn Connection, subprotocol string) Connection {
 	switch subprotocol {
 	case "channel.k8s.io":
-		return &kubeWrapper{base64: false, conn: conn}
+		return &kubeWrapper{base64: false, conn: conn, version: 1}
 	case "base64.channel.k8s.io":
-		return &kubeWrapper{base64: true, conn: conn}
+		return &kubeWrapper{base64: true, conn: conn, version: 1}
+	case "v4.channel.k8s.io":
+		return &kubeWrapper{base64: false, conn: conn, version: 4}
+	case "v4.base64.channel.k8s.io":
+		return &kubeWrapper{base64: true, conn: conn, version: 4}
+	case "v5.channel.k8s.io":
+		return &kubeWrapper{base64: false, conn: conn, version: 5}
 	case "terminal.gitlab.com":
 		return &gitlabWrapper{base64: false, conn: conn}
 	case "base64.terminal.gitlab.com":
 		return &gitlabWrapper{base64: true, conn: conn}
+	case "v2.terminal.gitlab.com":
+		return &gitlabWrapper{base64: false, resize: true, conn: conn}
 	}
 
 	return conn
@@ -27,10 +69,16 @@ func Wrap(conn Connection, subprotocol string) Connection {
 type kubeWrapper struct {
 	base64 bool
 	conn   Connection
+	// version is the version of the Kubernetes streaming protocol. Version
+	// 4 adds the error stream status and the resize stream, version 5 the
+	// close stream signal.
+	version int
 }
 
 type gitlabWrapper struct {
 	base64 bool
+	// resize is true if text messages carry terminal resize messages
+	resize bool
 	conn   Connection
 }
 
@@ -40,6 +88,14 @@ func (w *gitlabWrapper) ReadMessage() (int, []byte, error) {
 		return mt, data, err
 	}
 
+	if w.resize && mt == websocket.TextMessage {
+		var size terminalSize
+		if err := json.Unmarshal(data, &size); err != nil {
+			return mt, data, fmt.Errorf("invalid resize message: %v", err)
+		}
+		return resizeMessage, data, nil
+	}
+
 	if isData(mt) {
 		mt = websocket.BinaryMessage
 		if w.base64 {
@@ -74,41 +130,115 @@ func (w *gitlabWrapper) UnderlyingConn() net.Conn {
 // Coalesces all wsstreams into a single stream. In practice, we should only
 // receive data on stream 1.
 func (w *kubeWrapper) ReadMessage() (int, []byte, error) {
-	mt, data, err := w.conn.ReadMessage()
-	if err != nil {
-		return mt, data, err
-	}
+	for {
+		mt, data, err := w.conn.ReadMessage()
+		if err != nil {
+			return mt, data, err
+		}
+
+		if !isData(mt) {
+			return mt, data, err
+		}
 
-	if isData(mt) {
 		mt = websocket.BinaryMessage
+		if len(data) == 0 {
+			return mt, data, err
+		}
+
+		stream := data[0]
+		if w.base64 {
+			stream -= '0'
+		}
 
 		// Remove the WSStream channel number, decode to raw
-		if len(data) > 0 {
-			data = data[1:]
-			if w.base64 {
-				data, err = decodeBase64(data)
+		data = data[1:]
+		if w.base64 {
+			data, err = decodeBase64(data)
+			if err != nil {
+				return mt, data, err
+			}
+		}
+
+		if w.version < 4 {
+			return mt, data, err
+		}
+
+		switch stream {
+		case kubeErrorStream:
+			if msg := kubeStatusMessage(data); msg != nil {
+				return mt, msg, nil
 			}
+		case kubeStdin, kubeResize, kubeCloseStream:
+			// Not sent by servers, or nothing to show
+		default:
+			return mt, data, nil
 		}
 	}
+}
 
-	return mt, data, err
+// kubeStatusMessage returns the message of a failure status received on
+// the error stream, which is shown in the terminal. Success is not shown.
+func kubeStatusMessage(data []byte) []byte {
+	var status struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(data, &status); err != nil {
+		return append(data, "\r\n"...)
+	}
+
+	if status.Status == "Success" || status.Message == "" {
+		return nil
+	}
+
+	return []byte(status.Message + "\r\n")
 }
 
 // Always sends to wsstream 0
 func (w *kubeWrapper) WriteMessage(mt int, data []byte) error {
 	if isData(mt) {
-		if w.base64 {
-			mt = websocket.TextMessage
-			data = append([]byte{'0'}, encodeBase64(data)...)
-		} else {
-			mt = websocket.BinaryMessage
-			data = append([]byte{0}, data...)
-		}
+		mt, data = w.frame(kubeStdin, data)
 	}
 
 	return w.conn.WriteMessage(mt, data)
 }
 
+func (w *kubeWrapper) frame(stream byte, data []byte) (int, []byte) {
+	if w.base64 {
+		return websocket.TextMessage, append([]byte{'0' + stream}, encodeBase64(data)...)
+	}
+
+	return websocket.BinaryMessage, append([]byte{stream}, data...)
+}
+
+// Resize sends the terminal size on the resize stream. Protocols before
+// version 4 have no resize stream, so nothing is sent.
+func (w *kubeWrapper) Resize(size terminalSize) error {
+	if w.version < 4 {
+		return nil
+	}
+
+	data, err := json.Marshal(struct {
+		Width  int
+		Height int
+	}{size.Cols, size.Rows})
+	if err != nil {
+		return err
+	}
+
+	return w.conn.WriteMessage(w.frame(kubeResize, data))
+}
+
+// CloseInput signals the end of stdin to the server. Only version 5 of the
+// protocol supports this.
+func (w *kubeWrapper) CloseInput() error {
+	if w.version < 5 {
+		return nil
+	}
+
+	return w.conn.WriteMessage(websocket.BinaryMessage, []byte{kubeCloseStream, kubeStdin})
+}
+
 func (w *kubeWrapper) WriteControl(mt int, data []byte, deadline time.Time) error {
 	return w.conn.WriteControl(mt, data, deadline)
 }
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
 )
 
 type testcase struct {
@@ -153,3 +154,113 @@ func TestWriteMessage(t *testing.T) {
 		}
 	}
 }
+
+func TestReadMessageKubeStreams(t *testing.T) {
+	for _, subprotocol := range []string{"v4.channel.k8s.io", "v5.channel.k8s.io"} {
+		t.Run(subprotocol, func(t *testing.T) {
+			for _, tc := range []testcase{
+				{fake(binary, append([]byte{1}, msg...), nil), fake(binary, msg, nil)},
+				{fake(binary, append([]byte{2}, msg...), nil), fake(binary, msg, nil)},
+				{fake(binary, []byte("\x03{\"status\":\"Failure\",\"message\":\"command terminated with non-zero exit code\"}"), nil), fake(binary, []byte("command terminated with non-zero exit code\r\n"), nil)},
+				{fake(binary, []byte("\x03not json"), nil), fake(binary, []byte("not json\r\n"), nil)},
+				{fakeOther, fakeOther},
+			} {
+				conn := Wrap(tc.input, subprotocol)
+				mt, data, err := conn.ReadMessage()
+				requireEqualConn(t, tc.expected, fake(mt, data, err), "%s", tc.input.data)
+			}
+		})
+	}
+}
+
+type messagesConn struct {
+	fakeConn
+	messages [][]byte
+}
+
+func (c *messagesConn) ReadMessage() (int, []byte, error) {
+	data := c.messages[0]
+	c.messages = c.messages[1:]
+	return binary, data, nil
+}
+
+func TestReadMessageKubeSkipsStreams(t *testing.T) {
+	conn := Wrap(&messagesConn{messages: [][]byte{
+		[]byte("\x03{\"status\":\"Success\"}"),
+		{kubeCloseStream, 1},
+		append([]byte{1}, msg...),
+	}}, "v5.channel.k8s.io")
+
+	mt, data, err := conn.ReadMessage()
+	requireEqualConn(t, fake(binary, msg, nil), fake(mt, data, err), "skips status and close stream messages")
+}
+
+func TestWriteMessageKubeV4(t *testing.T) {
+	for _, tc := range []struct {
+		subprotocol string
+		expected    *fakeConn
+	}{
+		{"v4.channel.k8s.io", fake(binary, kubeMsg, nil)},
+		{"v4.base64.channel.k8s.io", fake(text, kubeMsgBase64, nil)},
+		{"v5.channel.k8s.io", fake(binary, kubeMsg, nil)},
+	} {
+		actual := fake(0, nil, nil)
+		require.NoError(t, Wrap(actual, tc.subprotocol).WriteMessage(binary, msg))
+		requireEqualConn(t, tc.expected, actual, "%s", tc.subprotocol)
+	}
+}
+
+func TestResize(t *testing.T) {
+	size := terminalSize{Cols: 120, Rows: 40}
+	resizeJSON := []byte(`{"Width":120,"Height":40}`)
+
+	for _, tc := range []struct {
+		subprotocol string
+		expected    *fakeConn
+	}{
+		{"channel.k8s.io", fake(0, nil, nil)},
+		{"v4.channel.k8s.io", fake(binary, append([]byte{kubeResize}, resizeJSON...), nil)},
+		{"v4.base64.channel.k8s.io", fake(text, append([]byte{'4'}, encodeBase64(resizeJSON)...), nil)},
+		{"v5.channel.k8s.io", fake(binary, append([]byte{kubeResize}, resizeJSON...), nil)},
+	} {
+		actual := fake(0, nil, nil)
+		conn := Wrap(actual, tc.subprotocol)
+		require.Implements(t, (*resizer)(nil), conn)
+		require.NoError(t, conn.(resizer).Resize(size))
+		requireEqualConn(t, tc.expected, actual, "%s", tc.subprotocol)
+	}
+}
+
+func TestCloseInput(t *testing.T) {
+	for _, tc := range []struct {
+		subprotocol string
+		expected    *fakeConn
+	}{
+		{"v4.channel.k8s.io", fake(0, nil, nil)},
+		{"v5.channel.k8s.io", fake(binary, []byte{kubeCloseStream, kubeStdin}, nil)},
+	} {
+		actual := fake(0, nil, nil)
+		require.NoError(t, Wrap(actual, tc.subprotocol).(inputCloser).CloseInput())
+		requireEqualConn(t, tc.expected, actual, "%s", tc.subprotocol)
+	}
+}
+
+func TestReadMessageGitlabResize(t *testing.T) {
+	resize := []byte(`{"cols":120,"rows":40}`)
+
+	conn := Wrap(fake(text, resize, nil), "v2.terminal.gitlab.com")
+	mt, data, err := conn.ReadMessage()
+	requireEqualConn(t, fake(resizeMessage, resize, nil), fake(mt, data, err), "resize")
+
+	conn = Wrap(fake(binary, msg, nil), "v2.terminal.gitlab.com")
+	mt, data, err = conn.ReadMessage()
+	requireEqualConn(t, fake(binary, msg, nil), fake(mt, data, err), "data")
+
+	_, _, err = Wrap(fake(text, []byte("not json"), nil), "v2.terminal.gitlab.com").ReadMessage()
+	require.Error(t, err)
+
+	// Older subprotocols treat text messages as data
+	conn = Wrap(fake(text, resize, nil), "terminal.gitlab.com")
+	mt, data, err = conn.ReadMessage()
+	requireEqualConn(t, fake(binary, resize, nil), fake(mt, data, err), "data")
+}
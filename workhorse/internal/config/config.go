@@ -98,6 +98,7 @@ type GoogleCredentials struct {
 
 type RedisConfig struct {
 	URL              TomlURL
+	Cluster          []TomlURL
 	Sentinel         []TomlURL
 	SentinelMaster   string
 	SentinelUsername string
@@ -108,12 +109,85 @@ type RedisConfig struct {
 	MaxActive        *int
 }
 
+type NotificationsConfig struct {
+	Backend  string                      `toml:"backend" json:"backend"` // Allowed options: redis, postgres, memory
+	Postgres PostgresNotificationsConfig `toml:"postgres" json:"postgres"`
+}
+
+type PostgresNotificationsConfig struct {
+	URL     string `toml:"url" json:"url"`
+	Channel string `toml:"channel" json:"channel"`
+	Table   string `toml:"table" json:"table"`
+}
+
 type ImageResizerConfig struct {
 	MaxScalerProcs uint32 `toml:"max_scaler_procs" json:"max_scaler_procs"`
 	MaxScalerMem   uint64 `toml:"max_scaler_mem" json:"max_scaler_mem"`
 	MaxFilesize    uint64 `toml:"max_filesize" json:"max_filesize"`
 }
 
+type ImageUploadConfig struct {
+	Enabled         bool   `toml:"enabled" json:"enabled"`
+	MaxFilesize     uint64 `toml:"max_filesize" json:"max_filesize"`
+	MaxWidth        uint32 `toml:"max_width" json:"max_width"`
+	MaxHeight       uint32 `toml:"max_height" json:"max_height"`
+	MaxDecodedBytes uint64 `toml:"max_decoded_bytes" json:"max_decoded_bytes"`
+	SVGPolicy       string `toml:"svg_policy" json:"svg_policy"` // Allowed options: allow, sanitize, reject
+}
+
+type LsifConfig struct {
+	MaxInputBytes        int64 `toml:"max_input_bytes" json:"max_input_bytes"`
+	MaxDocuments         int   `toml:"max_documents" json:"max_documents"`
+	MaxRangesPerDocument int   `toml:"max_ranges_per_document" json:"max_ranges_per_document"`
+	MaxHoverBytes        int   `toml:"max_hover_bytes" json:"max_hover_bytes"`
+	MaxCacheBytes        int64 `toml:"max_cache_bytes" json:"max_cache_bytes"`
+}
+
+type UploadPackCacheConfig struct {
+	Enabled          bool         `toml:"enabled" json:"enabled"`
+	Dir              string       `toml:"dir" json:"dir"`
+	TTL              TomlDuration `toml:"ttl" json:"ttl"`
+	MaxBytes         int64        `toml:"max_bytes" json:"max_bytes"`
+	MaxEntryBytes    int64        `toml:"max_entry_bytes" json:"max_entry_bytes"`
+	MaxRequestBytes  int64        `toml:"max_request_bytes" json:"max_request_bytes"`
+	MinResponseBytes int64        `toml:"min_response_bytes" json:"min_response_bytes"`
+	MaxHaves         int64        `toml:"max_haves" json:"max_haves"`
+}
+
+type UploadPackPolicyConfig struct {
+	RequireFilterAboveBytes int64 `toml:"require_filter_above_bytes" json:"require_filter_above_bytes"`
+	Enforce                 bool  `toml:"enforce" json:"enforce"`
+}
+
+type LfsConfig struct {
+	DownloadAcceleration       bool         `toml:"download_acceleration" json:"download_acceleration"`
+	BatchCacheTTL              TomlDuration `toml:"batch_cache_ttl" json:"batch_cache_ttl"`
+	BatchCacheMaxEntries       int          `toml:"batch_cache_max_entries" json:"batch_cache_max_entries"`
+	BatchCacheMaxResponseBytes int64        `toml:"batch_cache_max_response_bytes" json:"batch_cache_max_response_bytes"`
+}
+
+type GeoProxyConfig struct {
+	PollingInterval     TomlDuration `toml:"polling_interval" json:"polling_interval"`
+	WatchKey            string       `toml:"watch_key" json:"watch_key"`
+	HealthCheckInterval TomlDuration `toml:"health_check_interval" json:"health_check_interval"`
+	HealthCheckPath     string       `toml:"health_check_path" json:"health_check_path"`
+}
+
+type GitAuditConfig struct {
+	SpoolDir         string        `toml:"spool_dir" json:"spool_dir"`
+	BatchSize        int           `toml:"batch_size" json:"batch_size"`
+	FlushInterval    TomlDuration  `toml:"flush_interval" json:"flush_interval"`
+	MaxRetryInterval TomlDuration  `toml:"max_retry_interval" json:"max_retry_interval"`
+	FileSink         string        `toml:"file_sink" json:"file_sink"`
+	Syslog           *SyslogConfig `toml:"syslog" json:"syslog"`
+}
+
+type SyslogConfig struct {
+	Network string `toml:"network" json:"network"`
+	Address string `toml:"address" json:"address"`
+	Tag     string `toml:"tag" json:"tag"`
+}
+
 type MetadataConfig struct {
 	ZipReaderLimitBytes int64 `toml:"zip_reader_limit_bytes"`
 }
@@ -134,6 +208,7 @@ type ListenerConfig struct {
 type Config struct {
 	ConfigCommand                string                   `toml:"config_command,omitempty" json:"config_command"`
 	Redis                        *RedisConfig             `toml:"redis" json:"redis"`
+	NotificationsConfig          NotificationsConfig      `toml:"notifications" json:"notifications"`
 	Backend                      *url.URL                 `toml:"-"`
 	CableBackend                 *url.URL                 `toml:"-"`
 	Version                      string                   `toml:"-"`
@@ -150,7 +225,14 @@ type Config struct {
 	ObjectStorageCredentials     ObjectStorageCredentials `toml:"object_storage" json:"object_storage"`
 	PropagateCorrelationID       bool                     `toml:"-"`
 	ImageResizerConfig           ImageResizerConfig       `toml:"image_resizer" json:"image_resizer"`
+	ImageUploadConfig            ImageUploadConfig        `toml:"image_upload" json:"image_upload"`
 	MetadataConfig               MetadataConfig           `toml:"metadata" json:"metadata"`
+	LsifConfig                   LsifConfig               `toml:"lsif" json:"lsif"`
+	UploadPackCacheConfig        UploadPackCacheConfig    `toml:"upload_pack_cache" json:"upload_pack_cache"`
+	UploadPackPolicyConfig       UploadPackPolicyConfig   `toml:"upload_pack_policy" json:"upload_pack_policy"`
+	GitAuditConfig               GitAuditConfig           `toml:"git_audit" json:"git_audit"`
+	LfsConfig                    LfsConfig                `toml:"lfs" json:"lfs"`
+	GeoProxyConfig               GeoProxyConfig           `toml:"geo_proxy" json:"geo_proxy"`
 	AltDocumentRoot              string                   `toml:"alt_document_root" json:"alt_document_root"`
 	ShutdownTimeout              TomlDuration             `toml:"shutdown_timeout" json:"shutdown_timeout"`
 	TrustedCIDRsForXForwardedFor []string                 `toml:"trusted_cidrs_for_x_forwarded_for" json:"trusted_cidrs_for_x_forwarded_for"`
@@ -159,19 +241,77 @@ type Config struct {
 	MetricsListener              *ListenerConfig          `toml:"metrics_listener" json:"metrics_listener"`
 }
 
+var DefaultNotificationsConfig = NotificationsConfig{
+	Backend: "redis",
+	Postgres: PostgresNotificationsConfig{
+		Channel: "workhorse_notifications",
+		Table:   "workhorse_notifications",
+	},
+}
+
 var DefaultImageResizerConfig = ImageResizerConfig{
 	MaxScalerProcs: uint32(math.Max(2, float64(runtime.NumCPU())/2)),
 	MaxFilesize:    250 * 1000, // 250kB,
 }
 
+var DefaultImageUploadConfig = ImageUploadConfig{
+	MaxFilesize:     10 * Megabyte,
+	MaxWidth:        8192,
+	MaxHeight:       8192,
+	MaxDecodedBytes: 100 * Megabyte,
+	SVGPolicy:       "sanitize",
+}
+
 var DefaultMetadataConfig = MetadataConfig{
 	ZipReaderLimitBytes: 100 * Megabyte,
 }
 
+var DefaultLsifConfig = LsifConfig{
+	MaxInputBytes:        1024 * Megabyte,
+	MaxDocuments:         200 * 1000,
+	MaxRangesPerDocument: 500 * 1000,
+	MaxHoverBytes:        64 * 1024,
+	MaxCacheBytes:        2048 * Megabyte,
+}
+
+var DefaultUploadPackCacheConfig = UploadPackCacheConfig{
+	TTL:              TomlDuration{Duration: 5 * time.Minute},
+	MaxBytes:         10 * 1024 * Megabyte,
+	MaxEntryBytes:    2048 * Megabyte,
+	MaxRequestBytes:  1 * Megabyte,
+	MinResponseBytes: 1 * Megabyte,
+	MaxHaves:         256,
+}
+
+var DefaultLfsConfig = LfsConfig{
+	BatchCacheMaxEntries:       10000,
+	BatchCacheMaxResponseBytes: 1 * Megabyte,
+}
+
+var DefaultGitAuditConfig = GitAuditConfig{
+	BatchSize:        100,
+	FlushInterval:    TomlDuration{Duration: time.Second},
+	MaxRetryInterval: TomlDuration{Duration: 5 * time.Minute},
+}
+
+var DefaultGeoProxyConfig = GeoProxyConfig{
+	PollingInterval:     TomlDuration{Duration: 10 * time.Second},
+	WatchKey:            "workhorse:geo_proxy:last_update",
+	HealthCheckInterval: TomlDuration{Duration: 10 * time.Second},
+	HealthCheckPath:     "/-/health",
+}
+
 func NewDefaultConfig() *Config {
 	return &Config{
-		ImageResizerConfig: DefaultImageResizerConfig,
-		MetadataConfig:     DefaultMetadataConfig,
+		NotificationsConfig:   DefaultNotificationsConfig,
+		ImageResizerConfig:    DefaultImageResizerConfig,
+		ImageUploadConfig:     DefaultImageUploadConfig,
+		MetadataConfig:        DefaultMetadataConfig,
+		LsifConfig:            DefaultLsifConfig,
+		UploadPackCacheConfig: DefaultUploadPackCacheConfig,
+		GitAuditConfig:        DefaultGitAuditConfig,
+		LfsConfig:             DefaultLfsConfig,
+		GeoProxyConfig:        DefaultGeoProxyConfig,
 	}
 }
 
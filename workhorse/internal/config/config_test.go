@@ -181,6 +181,25 @@ max_filesize = 350000
 	require.Equal(t, expected, cfg.ImageResizerConfig)
 }
 
+func TestLoadImageUploadConfig(t *testing.T) {
+	config := `
+[image_upload]
+enabled = true
+max_width = 1024
+svg_policy = "reject"
+`
+
+	cfg, err := LoadConfig(config)
+	require.NoError(t, err)
+
+	expected := DefaultImageUploadConfig
+	expected.Enabled = true
+	expected.MaxWidth = 1024
+	expected.SVGPolicy = "reject"
+
+	require.Equal(t, expected, cfg.ImageUploadConfig)
+}
+
 func TestAltDocumentConfig(t *testing.T) {
 	config := `
 alt_document_root = "/path/to/documents"
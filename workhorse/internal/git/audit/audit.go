@@ -0,0 +1,273 @@
+// Package audit delivers git audit events to Rails and optional additional
+// sinks.
+//
+// Without a spool directory, events are sent synchronously and are lost if
+// a sink is unavailable. With a spool directory, events are written to disk
+// first and delivered in batches in the background, retrying until every
+// sink has accepted them. Delivery is at-least-once: a batch that was only
+// partially delivered when workhorse stopped is sent again in full.
+package audit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/config"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/log"
+)
+
+var (
+	auditEvents = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_workhorse_git_audit_events",
+			Help: "How many git audit events have been handled by gitlab-workhorse, partitioned by sink and result.",
+		},
+		[]string{"sink", "result"},
+	)
+
+	auditDeliveryErrors = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_workhorse_git_audit_delivery_errors",
+			Help: "How many attempts to deliver a batch of git audit events have failed, partitioned by sink.",
+		},
+		[]string{"sink"},
+	)
+
+	auditSpoolSegments = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "gitlab_workhorse_git_audit_spool_segments",
+			Help: "Number of batches of git audit events waiting for delivery in the spool.",
+		},
+	)
+)
+
+// Auditor records git audit events.
+type Auditor struct {
+	sinks            []Sink
+	spool            *spool
+	flushInterval    time.Duration
+	maxRetryInterval time.Duration
+
+	cancel  context.CancelFunc
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// New creates an Auditor that sends events to Rails through myAPI, and to
+// the additional sinks in cfg.
+func New(cfg config.GitAuditConfig, myAPI *api.API) (*Auditor, error) {
+	sinks := []Sink{&railsSink{api: myAPI}}
+
+	if cfg.FileSink != "" {
+		sinks = append(sinks, &fileSink{path: cfg.FileSink})
+	}
+
+	if cfg.Syslog != nil {
+		sink, err := newSyslogSink(cfg.Syslog)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	return newAuditor(cfg, sinks)
+}
+
+func newAuditor(cfg config.GitAuditConfig, sinks []Sink) (*Auditor, error) {
+	a := &Auditor{
+		sinks:            sinks,
+		flushInterval:    cfg.FlushInterval.Duration,
+		maxRetryInterval: cfg.MaxRetryInterval.Duration,
+	}
+
+	if cfg.SpoolDir == "" {
+		return a, nil
+	}
+
+	if cfg.BatchSize <= 0 || a.flushInterval <= 0 {
+		return nil, fmt.Errorf("git audit: batch_size and flush_interval must be positive")
+	}
+	if a.maxRetryInterval < a.flushInterval {
+		a.maxRetryInterval = a.flushInterval
+	}
+
+	s, err := openSpool(cfg.SpoolDir, cfg.BatchSize)
+	if err != nil {
+		return nil, fmt.Errorf("git audit: %w", err)
+	}
+	a.spool = s
+
+	// Delivery outlives the requests that record events, until Close
+	ctx, cancel := context.WithCancel(context.Background()) // lint:allow context.Background
+	a.cancel = cancel
+	a.stopped = make(chan struct{})
+	go a.run(ctx)
+
+	return a, nil
+}
+
+// Record stores event for delivery. When spooling is disabled, or the
+// event cannot be spooled, it is sent right away.
+func (a *Auditor) Record(ctx context.Context, event api.GitAuditEventRequest) {
+	if a.spool != nil {
+		err := a.spool.append(event)
+		if err == nil {
+			auditEvents.WithLabelValues("spool", "stored").Inc()
+			return
+		}
+
+		auditEvents.WithLabelValues("spool", "failed").Inc()
+		eventLogger(ctx, event).WithError(err).Error("failed to spool git audit event, sending it directly")
+	}
+
+	for _, sink := range a.sinks {
+		n, err := sink.Send(ctx, []api.GitAuditEventRequest{event})
+		auditEvents.WithLabelValues(sink.Name(), "delivered").Add(float64(n))
+		if err != nil {
+			auditEvents.WithLabelValues(sink.Name(), "failed").Inc()
+			eventLogger(ctx, event).WithError(err).WithFields(log.Fields{"sink": sink.Name()}).Error("failed to send git audit event")
+		}
+	}
+}
+
+// Close stops the background delivery. Events that have not been delivered
+// yet remain in the spool.
+func (a *Auditor) Close() error {
+	if a.spool == nil {
+		return nil
+	}
+
+	a.once.Do(func() {
+		a.cancel()
+		<-a.stopped
+	})
+
+	return a.spool.seal()
+}
+
+func (a *Auditor) run(ctx context.Context) {
+	defer close(a.stopped)
+
+	ticker := time.NewTicker(a.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		if !a.deliver(ctx) {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-a.spool.sealed:
+		case <-ticker.C:
+			if err := a.spool.seal(); err != nil {
+				log.WithError(err).Error("failed to seal git audit spool segment")
+			}
+		}
+	}
+}
+
+// deliver sends all sealed segments, oldest first. It returns false if the
+// Auditor was closed in the meantime.
+func (a *Auditor) deliver(ctx context.Context) bool {
+	segments, err := a.spool.segments()
+	if err != nil {
+		log.WithError(err).Error("failed to list git audit spool segments")
+		return true
+	}
+
+	for i, segment := range segments {
+		auditSpoolSegments.Set(float64(len(segments) - i))
+		if !a.deliverSegment(ctx, segment) {
+			return false
+		}
+	}
+	auditSpoolSegments.Set(0)
+
+	return true
+}
+
+// deliverSegment retries with exponential backoff until each sink has
+// accepted all events of the segment, and then removes it.
+func (a *Auditor) deliverSegment(ctx context.Context, segment string) bool {
+	backoff := a.flushInterval
+	retry := func() bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+
+		backoff = min(2*backoff, a.maxRetryInterval)
+		return true
+	}
+
+	events, skipped, err := readSegment(segment)
+	for err != nil {
+		log.WithError(err).WithFields(log.Fields{"segment": segment}).Error("failed to read git audit spool segment")
+		if !retry() {
+			return false
+		}
+		events, skipped, err = readSegment(segment)
+	}
+
+	if skipped > 0 {
+		auditEvents.WithLabelValues("spool", "corrupt").Add(float64(skipped))
+		log.WithFields(log.Fields{"segment": segment, "skipped": skipped}).Error("skipped unreadable git audit events")
+	}
+
+	delivered := make([]int, len(a.sinks))
+	for {
+		done := true
+
+		for i, sink := range a.sinks {
+			if delivered[i] == len(events) {
+				continue
+			}
+
+			n, err := sink.Send(ctx, events[delivered[i]:])
+			delivered[i] += n
+			auditEvents.WithLabelValues(sink.Name(), "delivered").Add(float64(n))
+
+			if err != nil {
+				done = false
+				auditDeliveryErrors.WithLabelValues(sink.Name()).Inc()
+				log.WithError(err).WithFields(log.Fields{
+					"sink":    sink.Name(),
+					"segment": segment,
+					"pending": len(events) - delivered[i],
+				}).Error("failed to deliver git audit events")
+			}
+		}
+
+		if done {
+			break
+		}
+
+		if !retry() {
+			return false
+		}
+	}
+
+	if err := os.Remove(segment); err != nil {
+		log.WithError(err).WithFields(log.Fields{"segment": segment}).Error("failed to remove delivered git audit spool segment")
+	}
+
+	return true
+}
+
+func eventLogger(ctx context.Context, event api.GitAuditEventRequest) *log.Builder {
+	return log.WithContextFields(ctx, log.Fields{
+		"repo":     event.Repo,
+		"action":   event.Action,
+		"username": event.Username,
+	})
+}
@@ -0,0 +1,248 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/config"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/helper"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/testhelper"
+)
+
+type fakeSink struct {
+	mu       sync.Mutex
+	events   []api.GitAuditEventRequest
+	failures int
+}
+
+func (s *fakeSink) Name() string { return "fake" }
+
+func (s *fakeSink) Send(_ context.Context, events []api.GitAuditEventRequest) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failures > 0 {
+		s.failures--
+		// Accept the first event only, to check that delivery resumes
+		// with the second one
+		s.events = append(s.events, events[0])
+		return 1, errors.New("sink unavailable")
+	}
+
+	s.events = append(s.events, events...)
+	return len(events), nil
+}
+
+func (s *fakeSink) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var repos []string
+	for _, event := range s.events {
+		repos = append(repos, event.Repo)
+	}
+	return repos
+}
+
+func spoolConfig(dir string) config.GitAuditConfig {
+	return config.GitAuditConfig{
+		SpoolDir:         dir,
+		BatchSize:        2,
+		FlushInterval:    config.TomlDuration{Duration: 10 * time.Millisecond},
+		MaxRetryInterval: config.TomlDuration{Duration: 20 * time.Millisecond},
+	}
+}
+
+func event(repo string) api.GitAuditEventRequest {
+	return api.GitAuditEventRequest{Action: "git-upload-pack", Protocol: "http", Repo: repo, Username: "alice"}
+}
+
+func TestRecordWithoutSpool(t *testing.T) {
+	sink := &fakeSink{}
+	a, err := newAuditor(config.GitAuditConfig{}, []Sink{sink})
+	require.NoError(t, err)
+
+	a.Record(context.Background(), event("project-1"))
+
+	require.Equal(t, []string{"project-1"}, sink.received())
+	require.NoError(t, a.Close())
+}
+
+func TestRecordRetriesSpooledEvents(t *testing.T) {
+	dir := t.TempDir()
+	sink := &fakeSink{failures: 2}
+	a, err := newAuditor(spoolConfig(dir), []Sink{sink})
+	require.NoError(t, err)
+	defer a.Close()
+
+	for _, repo := range []string{"project-1", "project-2", "project-3"} {
+		a.Record(context.Background(), event(repo))
+	}
+
+	require.Eventually(t, func() bool {
+		return len(sink.received()) == 3
+	}, 5*time.Second, 5*time.Millisecond)
+	require.Equal(t, []string{"project-1", "project-2", "project-3"}, sink.received())
+
+	require.Eventually(t, func() bool {
+		segments, err := filepath.Glob(filepath.Join(dir, "*"))
+		require.NoError(t, err)
+		return len(segments) == 0
+	}, 5*time.Second, 5*time.Millisecond, "delivered segments are removed")
+}
+
+func TestRecordDeliversSpoolAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := openSpool(dir, 10)
+	require.NoError(t, err)
+	require.NoError(t, s.append(event("project-1")))
+
+	// Simulate a crash in the middle of writing an event
+	_, err = s.active.WriteString(`{"action":"git-upl`)
+	require.NoError(t, err)
+	require.NoError(t, s.active.Close())
+
+	sink := &fakeSink{}
+	a, err := newAuditor(spoolConfig(dir), []Sink{sink})
+	require.NoError(t, err)
+	defer a.Close()
+
+	a.Record(context.Background(), event("project-2"))
+
+	require.Eventually(t, func() bool {
+		return len(sink.received()) == 2
+	}, 5*time.Second, 5*time.Millisecond)
+	require.Equal(t, []string{"project-1", "project-2"}, sink.received())
+}
+
+func TestCloseKeepsUndeliveredEvents(t *testing.T) {
+	dir := t.TempDir()
+	sink := &fakeSink{failures: 1000}
+	a, err := newAuditor(spoolConfig(dir), []Sink{sink})
+	require.NoError(t, err)
+
+	a.Record(context.Background(), event("project-1"))
+	require.NoError(t, a.Close())
+
+	segments, err := filepath.Glob(filepath.Join(dir, "*"+sealedSegmentExt))
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+
+	events, skipped, err := readSegment(segments[0])
+	require.NoError(t, err)
+	require.Zero(t, skipped)
+	require.Equal(t, []api.GitAuditEventRequest{event("project-1")}, events)
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink := &fileSink{path: path}
+
+	for _, repo := range []string{"project-1", "project-2"} {
+		n, err := sink.Send(context.Background(), []api.GitAuditEventRequest{event(repo)})
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+	}
+
+	events, skipped, err := readSegment(path)
+	require.NoError(t, err)
+	require.Zero(t, skipped)
+	require.Equal(t, []api.GitAuditEventRequest{event("project-1"), event("project-2")}, events)
+}
+
+func TestRailsSink(t *testing.T) {
+	testhelper.ConfigureSecret()
+
+	tests := []struct {
+		desc      string
+		status    int
+		delivered int
+		fails     bool
+	}{
+		{desc: "accepted", status: http.StatusOK, delivered: 2},
+		{desc: "rejected", status: http.StatusBadRequest, delivered: 2},
+		{desc: "rate limited", status: http.StatusTooManyRequests, delivered: 0, fails: true},
+		{desc: "unavailable", status: http.StatusServiceUnavailable, delivered: 0, fails: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			requests := 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				w.WriteHeader(tc.status)
+			}))
+			defer ts.Close()
+
+			sink := &railsSink{api: api.NewAPI(helper.URLMustParse(ts.URL), "123", http.DefaultTransport)}
+			n, err := sink.Send(context.Background(), []api.GitAuditEventRequest{event("project-1"), event("project-2")})
+
+			require.Equal(t, tc.delivered, n)
+			if tc.fails {
+				require.Error(t, err)
+				require.Equal(t, 1, requests, "delivery stops at the first failure")
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, 2, requests)
+			}
+		})
+	}
+}
+
+func TestSpoolRecoversSegmentNumbers(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "00000000000000000041.jsonl"), nil, 0o600))
+
+	s, err := openSpool(dir, 1)
+	require.NoError(t, err)
+	require.NoError(t, s.append(event("project-1")))
+
+	segments, err := s.segments()
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		filepath.Join(dir, "00000000000000000041.jsonl"),
+		filepath.Join(dir, "00000000000000000042.jsonl"),
+	}, segments)
+}
+
+func TestSpoolConcurrentAppends(t *testing.T) {
+	s, err := openSpool(t.TempDir(), 7)
+	require.NoError(t, err)
+
+	const appends = 50
+	var wg sync.WaitGroup
+	for i := 0; i < appends; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, s.append(event("project-1")))
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, uint64(appends), s.synced.Load())
+	require.NoError(t, s.seal())
+
+	segments, err := s.segments()
+	require.NoError(t, err)
+
+	total := 0
+	for _, segment := range segments {
+		events, skipped, err := readSegment(segment)
+		require.NoError(t, err)
+		require.Zero(t, skipped)
+		total += len(events)
+	}
+	require.Equal(t, appends, total)
+}
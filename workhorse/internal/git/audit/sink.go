@@ -0,0 +1,140 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/syslog"
+	"net/http"
+	"os"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/config"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/log"
+)
+
+// Sink is a destination for audit events.
+type Sink interface {
+	Name() string
+	// Send delivers events in order. It returns how many events were
+	// delivered before an error occurred, so that a retry can continue
+	// with the first undelivered event.
+	Send(ctx context.Context, events []api.GitAuditEventRequest) (int, error)
+}
+
+// railsSink sends events to the internal API one by one, as Rails has no
+// endpoint that accepts several events.
+type railsSink struct {
+	api *api.API
+}
+
+func (s *railsSink) Name() string { return "rails" }
+
+func (s *railsSink) Send(ctx context.Context, events []api.GitAuditEventRequest) (int, error) {
+	for i, event := range events {
+		err := s.api.SendGitAuditEvent(ctx, event)
+		if err != nil && !isRejected(err) {
+			return i, err
+		}
+
+		if err != nil {
+			// Retrying an event that Rails considers invalid would block
+			// the delivery of all later events.
+			auditEvents.WithLabelValues(s.Name(), "dropped").Inc()
+			log.WithContextFields(ctx, log.Fields{
+				"repo":     event.Repo,
+				"action":   event.Action,
+				"username": event.Username,
+			}).WithError(err).Error("git audit event rejected by Rails")
+		}
+	}
+
+	return len(events), nil
+}
+
+// isRejected returns true if Rails responded with a client error that will
+// not go away when the request is retried.
+func isRejected(err error) bool {
+	var statusErr *api.GitAuditEventError
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+
+	switch statusErr.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+
+	return statusErr.StatusCode >= 400 && statusErr.StatusCode < 500
+}
+
+// fileSink appends events to a file as JSON lines.
+type fileSink struct {
+	path string
+}
+
+func (s *fileSink) Name() string { return "file" }
+
+func (s *fileSink) Send(_ context.Context, events []api.GitAuditEventRequest) (int, error) {
+	var buf []byte
+	for _, event := range events {
+		line, err := json.Marshal(event)
+		if err != nil {
+			return 0, err
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = f.Close() }()
+
+	if _, err := f.Write(buf); err != nil {
+		return 0, err
+	}
+	if err := f.Sync(); err != nil {
+		return 0, err
+	}
+
+	return len(events), nil
+}
+
+// syslogSink sends each event as a JSON syslog message.
+type syslogSink struct {
+	writer *syslog.Writer
+}
+
+func newSyslogSink(cfg *config.SyslogConfig) (*syslogSink, error) {
+	tag := cfg.Tag
+	if tag == "" {
+		tag = "gitlab-workhorse"
+	}
+
+	writer, err := syslog.Dial(cfg.Network, cfg.Address, syslog.LOG_INFO|syslog.LOG_AUTH, tag)
+	if err != nil {
+		return nil, fmt.Errorf("connect to syslog: %w", err)
+	}
+
+	return &syslogSink{writer: writer}, nil
+}
+
+func (s *syslogSink) Name() string { return "syslog" }
+
+func (s *syslogSink) Send(_ context.Context, events []api.GitAuditEventRequest) (int, error) {
+	for i, event := range events {
+		line, err := json.Marshal(event)
+		if err != nil {
+			return i, err
+		}
+
+		// The writer reconnects if the previous write failed
+		if err := s.writer.Info(string(line)); err != nil {
+			return i, err
+		}
+	}
+
+	return len(events), nil
+}
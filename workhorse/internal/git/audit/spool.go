@@ -0,0 +1,268 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
+)
+
+const (
+	// Events are appended to an open segment, which is renamed to a sealed
+	// segment once it is full or has been open for a flush interval. Only
+	// sealed segments are delivered.
+	openSegmentExt   = ".open"
+	sealedSegmentExt = ".jsonl"
+)
+
+// spool stores audit events on disk as JSON lines, split into numbered
+// segments of at most batchSize events.
+type spool struct {
+	dir       string
+	batchSize int
+	// sealed receives a value whenever a segment is sealed
+	sealed chan struct{}
+
+	mu     sync.Mutex
+	active *os.File
+	count  int
+	next   uint64
+	// written counts the events written to the spool
+	written uint64
+
+	// syncMu lets one append at a time sync the open segment, for all
+	// the events written so far, so that concurrent appends share fsyncs
+	syncMu sync.Mutex
+	// synced counts the events that are on disk
+	synced atomic.Uint64
+}
+
+func openSpool(dir string, batchSize int) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create spool directory: %w", err)
+	}
+
+	s := &spool{dir: dir, batchSize: batchSize, sealed: make(chan struct{}, 1)}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read spool directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		seq, ext, ok := parseSegmentName(entry.Name())
+		if !ok {
+			continue
+		}
+
+		if seq >= s.next {
+			s.next = seq + 1
+		}
+
+		// Segments that were open when workhorse stopped are delivered as-is
+		if ext == openSegmentExt {
+			if err := os.Rename(filepath.Join(dir, entry.Name()), s.segmentPath(seq, sealedSegmentExt)); err != nil {
+				return nil, fmt.Errorf("seal segment: %w", err)
+			}
+		}
+	}
+
+	s.notify()
+
+	return s, nil
+}
+
+func parseSegmentName(name string) (uint64, string, bool) {
+	ext := filepath.Ext(name)
+	if ext != openSegmentExt && ext != sealedSegmentExt {
+		return 0, "", false
+	}
+
+	seq, err := strconv.ParseUint(strings.TrimSuffix(name, ext), 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+
+	return seq, ext, true
+}
+
+func (s *spool) segmentPath(seq uint64, ext string) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, ext))
+}
+
+// append writes event to the open segment. The event is on disk when
+// append returns without an error. The global lock is not held while
+// waiting for the disk.
+func (s *spool) append(event api.GitAuditEventRequest) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	seq, err := s.write(line)
+	if err != nil {
+		return err
+	}
+
+	return s.syncTo(seq)
+}
+
+// write appends line to the open segment and returns the sequence number
+// of its event.
+func (s *spool) write(line []byte) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active == nil {
+		f, err := os.OpenFile(s.segmentPath(s.next, openSegmentExt), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err != nil {
+			return 0, err
+		}
+
+		s.active = f
+		s.count = 0
+		s.next++
+	}
+
+	if _, err := s.active.Write(line); err != nil {
+		return 0, err
+	}
+	s.written++
+	seq := s.written
+
+	s.count++
+	if s.count >= s.batchSize {
+		return seq, s.sealLocked()
+	}
+
+	return seq, nil
+}
+
+// syncTo returns once the event with sequence number seq is on disk. An
+// fsync covers all events written before it, so appends that wait for an
+// fsync in progress usually find their event synced when it finishes.
+func (s *spool) syncTo(seq uint64) error {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	if s.synced.Load() >= seq {
+		return nil
+	}
+
+	s.mu.Lock()
+	f, written := s.active, s.written
+	s.mu.Unlock()
+
+	// A sealed segment was synced before it was closed
+	if f != nil {
+		if err := f.Sync(); err != nil && !errors.Is(err, os.ErrClosed) {
+			return err
+		} else if err == nil {
+			s.setSynced(written)
+		}
+	}
+
+	if s.synced.Load() < seq {
+		return errors.New("spool segment was not synced")
+	}
+
+	return nil
+}
+
+func (s *spool) setSynced(seq uint64) {
+	for {
+		synced := s.synced.Load()
+		if synced >= seq || s.synced.CompareAndSwap(synced, seq) {
+			return
+		}
+	}
+}
+
+// seal makes the open segment, if any, available for delivery.
+func (s *spool) seal() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sealLocked()
+}
+
+func (s *spool) sealLocked() error {
+	if s.active == nil {
+		return nil
+	}
+
+	f := s.active
+	s.active = nil
+
+	syncErr := f.Sync()
+	if err := f.Close(); err != nil {
+		return err
+	} else if syncErr != nil {
+		return syncErr
+	}
+	s.setSynced(s.written)
+
+	open := f.Name()
+	if err := os.Rename(open, strings.TrimSuffix(open, openSegmentExt)+sealedSegmentExt); err != nil {
+		return err
+	}
+
+	s.notify()
+
+	return nil
+}
+
+func (s *spool) notify() {
+	select {
+	case s.sealed <- struct{}{}:
+	default:
+	}
+}
+
+// segments returns the paths of all sealed segments, oldest first.
+func (s *spool) segments() ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*"+sealedSegmentExt))
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// readSegment returns the events of a sealed segment. A line that was only
+// partially written before a crash is skipped.
+func readSegment(path string) ([]api.GitAuditEventRequest, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var events []api.GitAuditEventRequest
+	skipped := 0
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var event api.GitAuditEventRequest
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			skipped++
+			continue
+		}
+
+		events = append(events, event)
+	}
+
+	return events, skipped, scanner.Err()
+}
@@ -2,7 +2,10 @@ package git
 
 import (
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"gitlab.com/gitlab-org/gitaly/v16/proto/go/gitalypb"
 
@@ -20,6 +23,14 @@ type blobParams struct {
 
 var SendBlob = &blob{"git-blob:"}
 
+// blobRange is a single byte range of a Range header. An end of -1 means
+// the range extends to the end of the blob, and a start of -1 means the
+// range is the last end+1 bytes of the blob.
+type blobRange struct {
+	start int64
+	end   int64
+}
+
 func (b *blob) Inject(w http.ResponseWriter, r *http.Request, sendData string) {
 	var params blobParams
 	if err := b.Unpack(&params, sendData); err != nil {
@@ -27,18 +38,69 @@ func (b *blob) Inject(w http.ResponseWriter, r *http.Request, sendData string) {
 		return
 	}
 
-	ctx, blobClient, err := gitaly.NewBlobClient(r.Context(), params.GitalyServer)
+	setBlobHeaders(w)
 
+	// Truncated blobs are not cacheable or seekable
+	request := &params.GetBlobRequest
+	var rng *blobRange
+	if request.GetLimit() < 0 && request.GetOid() != "" {
+		etag := blobETag(request.GetOid())
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Accept-Ranges", "bytes")
+
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		rng = requestedBlobRange(r, etag)
+		if rng != nil && rng.start >= 0 && rng.end >= 0 {
+			// Gitaly stops streaming after the last byte of the range
+			request.Limit = rng.end + 1
+		}
+	}
+
+	ctx, blobClient, err := gitaly.NewBlobClient(r.Context(), params.GitalyServer)
 	if err != nil {
 		fail.Request(w, r, fmt.Errorf("blob.GetBlob: %v", err))
 		return
 	}
 
-	setBlobHeaders(w)
-	if err := blobClient.SendBlob(ctx, w, &params.GetBlobRequest); err != nil {
+	reader, size, err := blobClient.OpenBlob(ctx, request)
+	if err != nil {
 		fail.Request(w, r, fmt.Errorf("blob.GetBlob: %v", err))
 		return
 	}
+
+	if rng == nil {
+		if request.GetLimit() >= 0 {
+			size = min(size, request.GetLimit())
+		}
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		if _, err := io.Copy(w, reader); err != nil {
+			fail.Request(w, r, fmt.Errorf("blob.GetBlob: copy rpc data: %v", err))
+		}
+		return
+	}
+
+	start, end, ok := rng.resolve(size)
+	if !ok {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		http.Error(w, http.StatusText(http.StatusRequestedRangeNotSatisfiable), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	if _, err := io.CopyN(io.Discard, reader, start); err != nil {
+		fail.Request(w, r, fmt.Errorf("blob.GetBlob: skip to range start: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	w.WriteHeader(http.StatusPartialContent)
+	if _, err := io.CopyN(w, reader, end-start+1); err != nil {
+		fail.Request(w, r, fmt.Errorf("blob.GetBlob: copy rpc data: %v", err))
+	}
 }
 
 func setBlobHeaders(w http.ResponseWriter) {
@@ -47,3 +109,89 @@ func setBlobHeaders(w http.ResponseWriter) {
 	// for blobs.
 	w.Header().Del("Set-Cookie")
 }
+
+// blobETag returns a strong ETag for a blob. The OID identifies the
+// content of a blob, so it never changes for the same OID.
+func blobETag(oid string) string {
+	return `"` + oid + `"`
+}
+
+// etagMatches implements the weak comparison of If-None-Match.
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requestedBlobRange returns the range to serve, or nil if the complete blob
+// should be served. Multiple ranges are not supported and are answered with
+// the complete blob, which RFC 9110 allows.
+func requestedBlobRange(r *http.Request, etag string) *blobRange {
+	header := r.Header.Get("Range")
+	if header == "" {
+		return nil
+	}
+
+	// If-Range only accepts strong ETags, dates are never a match
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && ifRange != etag {
+		return nil
+	}
+
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return nil
+	}
+
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return nil
+	}
+
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return nil
+		}
+		return &blobRange{start: -1, end: n - 1}
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return nil
+	}
+	if last == "" {
+		return &blobRange{start: start, end: -1}
+	}
+
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || end < start {
+		return nil
+	}
+
+	return &blobRange{start: start, end: end}
+}
+
+// resolve returns the first and last byte of the range within a blob of the
+// given size. It returns false if the range is not satisfiable.
+func (rng *blobRange) resolve(size int64) (int64, int64, bool) {
+	if rng.start < 0 {
+		if rng.end < 0 || size == 0 {
+			return 0, 0, false
+		}
+		return max(size-rng.end-1, 0), size - 1, true
+	}
+
+	if rng.start >= size {
+		return 0, 0, false
+	}
+	if rng.end < 0 || rng.end >= size {
+		return rng.start, size - 1, true
+	}
+
+	return rng.start, rng.end, true
+}
@@ -1,12 +1,95 @@
 package git
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"gitlab.com/gitlab-org/gitaly/v16/proto/go/gitalypb"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/testhelper"
 )
 
+const (
+	testBlobOid  = "3f1b0d0a8e5c4e54ba0f7b3f1d8e6c2ab1c0d9e7"
+	testBlobData = "0123456789abcdefghijklmnopqrstuvwxyz"
+)
+
+type blobServiceServer struct {
+	gitalypb.UnimplementedBlobServiceServer
+	calls  atomic.Int32
+	limits chan int64
+}
+
+func (s *blobServiceServer) GetBlob(in *gitalypb.GetBlobRequest, stream gitalypb.BlobService_GetBlobServer) error {
+	s.calls.Add(1)
+	s.limits <- in.GetLimit()
+
+	data := []byte(testBlobData)
+	if in.GetLimit() >= 0 && in.GetLimit() < int64(len(data)) {
+		data = data[:in.GetLimit()]
+	}
+
+	response := &gitalypb.GetBlobResponse{Oid: in.GetOid(), Size: int64(len(testBlobData))}
+	for len(data) > 0 {
+		n := min(len(data), 5)
+		response.Data = data[:n]
+		if err := stream.Send(response); err != nil {
+			return err
+		}
+		data = data[n:]
+		response = &gitalypb.GetBlobResponse{}
+	}
+
+	return nil
+}
+
+func startBlobServer(t *testing.T, s gitalypb.BlobServiceServer) string {
+	t.Helper()
+
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "gitaly.sock"))
+	require.NoError(t, err)
+
+	srv := grpc.NewServer(testhelper.WithSidechannel())
+	gitalypb.RegisterBlobServiceServer(srv, s)
+	go func() {
+		require.NoError(t, srv.Serve(ln))
+	}()
+
+	t.Cleanup(func() {
+		srv.GracefulStop()
+	})
+
+	return fmt.Sprintf("%s://%s", ln.Addr().Network(), ln.Addr().String())
+}
+
+func blobSendData(t *testing.T, addr string, limit int64) string {
+	t.Helper()
+
+	params := blobParams{
+		GitalyServer: api.GitalyServer{Address: addr},
+		GetBlobRequest: gitalypb.GetBlobRequest{
+			Repository: &gitalypb.Repository{StorageName: "default", RelativePath: "foo/bar.git"},
+			Oid:        testBlobOid,
+			Limit:      limit,
+		},
+	}
+	jsonBytes, err := json.Marshal(&params)
+	require.NoError(t, err)
+
+	return "git-blob:" + base64.URLEncoding.EncodeToString(jsonBytes)
+}
+
 func TestSetBlobHeaders(t *testing.T) {
 	w := httptest.NewRecorder()
 	w.Header().Set("Set-Cookie", "gitlab_cookie=123456")
@@ -15,3 +98,113 @@ func TestSetBlobHeaders(t *testing.T) {
 
 	require.Empty(t, w.Header().Get("Set-Cookie"), "remove Set-Cookie")
 }
+
+func TestSendBlob(t *testing.T) {
+	etag := `"` + testBlobOid + `"`
+
+	tests := []struct {
+		desc          string
+		headers       map[string]string
+		limit         int64
+		code          int
+		body          string
+		contentRange  string
+		gitalyLimit   int64
+		contactGitaly bool
+	}{
+		{desc: "complete blob", limit: -1, code: 200, body: testBlobData, gitalyLimit: -1, contactGitaly: true},
+		{desc: "not modified", limit: -1, headers: map[string]string{"If-None-Match": etag}, code: 304},
+		{desc: "not modified weak", limit: -1, headers: map[string]string{"If-None-Match": `"other", W/` + etag}, code: 304},
+		{desc: "modified", limit: -1, headers: map[string]string{"If-None-Match": `"other"`}, code: 200, body: testBlobData, gitalyLimit: -1, contactGitaly: true},
+		{
+			desc: "range", limit: -1, headers: map[string]string{"Range": "bytes=7-12"},
+			code: 206, body: testBlobData[7:13], contentRange: "bytes 7-12/36", gitalyLimit: 13, contactGitaly: true,
+		},
+		{
+			desc: "open range", limit: -1, headers: map[string]string{"Range": "bytes=30-"},
+			code: 206, body: testBlobData[30:], contentRange: "bytes 30-35/36", gitalyLimit: -1, contactGitaly: true,
+		},
+		{
+			desc: "suffix range", limit: -1, headers: map[string]string{"Range": "bytes=-4"},
+			code: 206, body: testBlobData[32:], contentRange: "bytes 32-35/36", gitalyLimit: -1, contactGitaly: true,
+		},
+		{
+			desc: "range past the end", limit: -1, headers: map[string]string{"Range": "bytes=30-100"},
+			code: 206, body: testBlobData[30:], contentRange: "bytes 30-35/36", gitalyLimit: 101, contactGitaly: true,
+		},
+		{
+			desc: "unsatisfiable range", limit: -1, headers: map[string]string{"Range": "bytes=36-"},
+			code: 416, contentRange: "bytes */36", gitalyLimit: -1, contactGitaly: true,
+		},
+		{
+			desc: "matching if-range", limit: -1, headers: map[string]string{"Range": "bytes=0-1", "If-Range": etag},
+			code: 206, body: testBlobData[:2], contentRange: "bytes 0-1/36", gitalyLimit: 2, contactGitaly: true,
+		},
+		{
+			desc: "stale if-range", limit: -1, headers: map[string]string{"Range": "bytes=0-1", "If-Range": `"other"`},
+			code: 200, body: testBlobData, gitalyLimit: -1, contactGitaly: true,
+		},
+		{
+			desc: "multiple ranges", limit: -1, headers: map[string]string{"Range": "bytes=0-1,4-5"},
+			code: 200, body: testBlobData, gitalyLimit: -1, contactGitaly: true,
+		},
+		{
+			desc: "truncated blob", limit: 10, headers: map[string]string{"If-None-Match": etag, "Range": "bytes=0-1"},
+			code: 200, body: testBlobData[:10], gitalyLimit: 10, contactGitaly: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			server := &blobServiceServer{limits: make(chan int64, 1)}
+			// Conditional requests that are answered without Gitaly use an
+			// address that cannot be dialed
+			addr := "unix:/nonexistent/gitaly.sock"
+			if tc.contactGitaly {
+				addr = startBlobServer(t, server)
+			}
+
+			w := httptest.NewRecorder()
+			w.Header().Set("Set-Cookie", "gitlab_cookie=123456")
+			r := httptest.NewRequest("GET", "/raw/main/file", nil)
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+
+			SendBlob.Inject(w, r, blobSendData(t, addr, tc.limit))
+
+			require.Equal(t, tc.code, w.Code)
+			require.Empty(t, w.Header().Get("Set-Cookie"))
+			if tc.code != http.StatusRequestedRangeNotSatisfiable {
+				require.Equal(t, tc.body, w.Body.String())
+			}
+			require.Equal(t, tc.contentRange, w.Header().Get("Content-Range"))
+
+			if tc.limit < 0 {
+				require.Equal(t, etag, w.Header().Get("ETag"))
+				require.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+			} else {
+				require.Empty(t, w.Header().Get("ETag"))
+			}
+
+			if tc.code == http.StatusOK || tc.code == http.StatusPartialContent {
+				require.Equal(t, fmt.Sprint(len(tc.body)), w.Header().Get("Content-Length"))
+			}
+
+			if !tc.contactGitaly {
+				require.Zero(t, server.calls.Load())
+				return
+			}
+			require.Equal(t, int32(1), server.calls.Load())
+			require.Equal(t, tc.gitalyLimit, <-server.limits)
+		})
+	}
+}
+
+func TestRequestedBlobRange(t *testing.T) {
+	for _, header := range []string{"items=0-1", "bytes=", "bytes=5", "bytes=5-2", "bytes=a-b", "bytes=-x", "bytes=-1-2"} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Range", header)
+		require.Nil(t, requestedBlobRange(r, `"etag"`), header)
+	}
+}
@@ -0,0 +1,155 @@
+/*
+In this file we handle the Git protocol v2 bundle-uri command
+*/
+
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
+)
+
+const (
+	bundleURICapability = "bundle-uri"
+	bundleURICommand    = "command=bundle-uri"
+)
+
+var gitBundleURIRequests = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Name: "gitlab_workhorse_git_bundle_uri_requests",
+		Help: "How many Git protocol v2 bundle-uri commands have been answered by gitlab-workhorse instead of Gitaly.",
+	},
+)
+
+// bundleURIEnabled returns true if workhorse should advertise and answer
+// the bundle-uri command itself.
+func bundleURIEnabled(a *api.Response, gitProtocol string) bool {
+	return len(a.BundleURIs) > 0 && isProtocolV2(gitProtocol)
+}
+
+// isProtocolV2 checks the Git-Protocol header, a colon-separated list of
+// key=value parameters, for a request of protocol version 2.
+func isProtocolV2(gitProtocol string) bool {
+	for _, param := range strings.Split(gitProtocol, ":") {
+		if param == "version=2" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// copyAdvertisementWithBundleURI copies a capability advertisement from r to
+// w, adding the bundle-uri capability to protocol v2 advertisements that do
+// not contain it yet. Anything that cannot be parsed is copied verbatim.
+func copyAdvertisementWithBundleURI(w io.Writer, r io.Reader) error {
+	br := bufio.NewReaderSize(r, maxPktLen)
+	v2 := false
+
+	for {
+		pkt, err := readPktLine(br)
+		if err != nil {
+			break
+		}
+
+		if v2 && bytes.Equal(pkt, pktFlush) {
+			if err := writePktLine(w, bundleURICapability+"\n"); err != nil {
+				return err
+			}
+			if _, err := w.Write(pkt); err != nil {
+				return err
+			}
+			break
+		}
+
+		if _, err := w.Write(pkt); err != nil {
+			return err
+		}
+
+		capability := strings.TrimSuffix(string(pktLinePayload(pkt)), "\n")
+		if capability == "version 2" {
+			v2 = true
+		} else if v2 && (capability == bundleURICapability || strings.HasPrefix(capability, bundleURICapability+"=")) {
+			// Gitaly advertises bundle-uri itself
+			break
+		}
+	}
+
+	_, err := io.Copy(w, br)
+	return err
+}
+
+// serveBundleURI answers a protocol v2 bundle-uri command with the given
+// bundles. It returns false, without consuming anything from r, if the
+// request contains another command.
+func serveBundleURI(w io.Writer, r *bufio.Reader, bundles []api.BundleURI) (bool, error) {
+	pkt, err := peekPktLine(r)
+	if err != nil || strings.TrimSuffix(string(pktLinePayload(pkt)), "\n") != bundleURICommand {
+		// Let Gitaly deal with other commands and malformed requests
+		return false, nil
+	}
+
+	// The command has no arguments we care about, but the request must be
+	// read in full before responding.
+	for {
+		pkt, err := readPktLine(r)
+		if err != nil {
+			return true, fmt.Errorf("read bundle-uri request: %w", err)
+		}
+		if bytes.Equal(pkt, pktFlush) {
+			break
+		}
+	}
+
+	gitBundleURIRequests.Inc()
+
+	var buf bytes.Buffer
+	if err := writeBundleList(&buf, bundles); err != nil {
+		return true, err
+	}
+
+	_, err = buf.WriteTo(w)
+	return true, err
+}
+
+// writeBundleList writes bundles as the key=value lines of a bundle list,
+// terminated by a flush packet.
+func writeBundleList(w io.Writer, bundles []api.BundleURI) error {
+	lines := []string{"bundle.version=1", "bundle.mode=all"}
+
+	for _, bundle := range bundles {
+		if bundle.CreationToken > 0 {
+			lines = append(lines, "bundle.heuristic=creationToken")
+			break
+		}
+	}
+
+	for _, bundle := range bundles {
+		// Line breaks would end up in separate pkt-lines
+		if bundle.ID == "" || bundle.URI == "" || strings.ContainsAny(bundle.ID+bundle.URI, "\r\n") {
+			continue
+		}
+
+		lines = append(lines, fmt.Sprintf("bundle.%s.uri=%s", bundle.ID, bundle.URI))
+		if bundle.CreationToken > 0 {
+			lines = append(lines, fmt.Sprintf("bundle.%s.creationToken=%d", bundle.ID, bundle.CreationToken))
+		}
+	}
+
+	for _, line := range lines {
+		if err := writePktLine(w, line+"\n"); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write(pktFlush)
+	return err
+}
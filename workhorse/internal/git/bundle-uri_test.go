@@ -0,0 +1,172 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitaly/v16/proto/go/gitalypb"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/config"
+)
+
+var testBundles = []api.BundleURI{
+	{ID: "base", URI: "https://bundles.example.com/base.bundle", CreationToken: 1},
+	{ID: "incr", URI: "https://bundles.example.com/incr.bundle", CreationToken: 2},
+}
+
+func pktLines(lines ...string) string {
+	var buf bytes.Buffer
+	for _, line := range lines {
+		switch line {
+		case "0000", "0001":
+			buf.WriteString(line)
+		default:
+			_ = writePktLine(&buf, line)
+		}
+	}
+
+	return buf.String()
+}
+
+func TestIsProtocolV2(t *testing.T) {
+	require.True(t, isProtocolV2("version=2"))
+	require.True(t, isProtocolV2("object-format=sha1:version=2"))
+	require.False(t, isProtocolV2("version=1"))
+	require.False(t, isProtocolV2(""))
+}
+
+func TestCopyAdvertisementWithBundleURI(t *testing.T) {
+	header := pktLines("# service=git-upload-pack\n", "0000")
+
+	tests := []struct {
+		desc     string
+		input    string
+		expected string
+	}{
+		{
+			desc:     "protocol v2",
+			input:    header + pktLines("version 2\n", "ls-refs=unborn\n", "fetch=shallow\n", "0000"),
+			expected: header + pktLines("version 2\n", "ls-refs=unborn\n", "fetch=shallow\n", "bundle-uri\n", "0000"),
+		},
+		{
+			desc:     "already advertised",
+			input:    header + pktLines("version 2\n", "bundle-uri\n", "0000"),
+			expected: header + pktLines("version 2\n", "bundle-uri\n", "0000"),
+		},
+		{
+			desc:     "protocol v0",
+			input:    header + pktLines("1a2b refs/heads/main\x00multi_ack\n", "0000"),
+			expected: header + pktLines("1a2b refs/heads/main\x00multi_ack\n", "0000"),
+		},
+		{
+			desc:     "malformed",
+			input:    header + "zzzzgarbage",
+			expected: header + "zzzzgarbage",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			var out bytes.Buffer
+			require.NoError(t, copyAdvertisementWithBundleURI(&out, strings.NewReader(tc.input)))
+			require.Equal(t, tc.expected, out.String())
+		})
+	}
+}
+
+func TestServeBundleURI(t *testing.T) {
+	request := pktLines("command=bundle-uri\n", "agent=git/2.45.0\n", "object-format=sha1\n", "0001", "0000")
+
+	var out bytes.Buffer
+	handled, err := serveBundleURI(&out, bufio.NewReader(strings.NewReader(request)), testBundles)
+	require.NoError(t, err)
+	require.True(t, handled)
+
+	expected := pktLines(
+		"bundle.version=1\n",
+		"bundle.mode=all\n",
+		"bundle.heuristic=creationToken\n",
+		"bundle.base.uri=https://bundles.example.com/base.bundle\n",
+		"bundle.base.creationToken=1\n",
+		"bundle.incr.uri=https://bundles.example.com/incr.bundle\n",
+		"bundle.incr.creationToken=2\n",
+		"0000",
+	)
+	require.Equal(t, expected, out.String())
+}
+
+func TestServeBundleURIOtherCommand(t *testing.T) {
+	request := pktLines("command=fetch\n", "0001", "want 1a2b\n", "done\n", "0000")
+	br := bufio.NewReader(strings.NewReader(request))
+
+	var out bytes.Buffer
+	handled, err := serveBundleURI(&out, br, testBundles)
+	require.NoError(t, err)
+	require.False(t, handled)
+	require.Empty(t, out.String())
+
+	var rest bytes.Buffer
+	_, err = rest.ReadFrom(br)
+	require.NoError(t, err)
+	require.Equal(t, request, rest.String())
+}
+
+func TestServeBundleURITruncatedRequest(t *testing.T) {
+	handled, err := serveBundleURI(&bytes.Buffer{}, bufio.NewReader(strings.NewReader(pktLines("command=bundle-uri\n"))), testBundles)
+	require.True(t, handled)
+	require.Error(t, err)
+}
+
+func TestUploadPackServesBundleURI(t *testing.T) {
+	body := pktLines("command=bundle-uri\n", "0001", "0000")
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/git-upload-pack", strings.NewReader(body))
+	r.Header.Set("Git-Protocol", "version=2")
+	// No Gitaly server is configured: the request must not reach it
+	a := &api.Response{BundleURIs: []api.BundleURI{{ID: "base", URI: "https://bundles.example.com/base.bundle"}}}
+
+	_, err := handleUploadPack(NewHTTPResponseWriter(w), r, a, nil, config.UploadPackPolicyConfig{})
+	require.NoError(t, err)
+	require.Equal(t, "application/x-git-upload-pack-result", w.Header().Get("Content-Type"))
+	require.Contains(t, w.Body.String(), "bundle.base.uri=https://bundles.example.com/base.bundle\n")
+	require.NotContains(t, w.Body.String(), "heuristic")
+}
+
+func TestGetInfoRefsAdvertisesBundleURI(t *testing.T) {
+	advertisement := pktLines("# service=git-upload-pack\n", "0000", "version 2\n", "fetch=shallow\n", "0000")
+
+	addr := startSmartHTTPServer(t, &smartHTTPServiceServerWithInfoRefs{
+		InfoRefsUploadPackFunc: func(_ *gitalypb.InfoRefsRequest, s gitalypb.SmartHTTPService_InfoRefsUploadPackServer) error {
+			return s.Send(&gitalypb.InfoRefsResponse{Data: []byte(advertisement)})
+		},
+	})
+
+	for _, tc := range []struct {
+		desc        string
+		gitProtocol string
+		bundles     []api.BundleURI
+		advertised  bool
+	}{
+		{desc: "protocol v2 with bundles", gitProtocol: "version=2", bundles: testBundles, advertised: true},
+		{desc: "protocol v2 without bundles", gitProtocol: "version=2"},
+		{desc: "protocol v0 with bundles", bundles: testBundles},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/?service=git-upload-pack", nil)
+			r.Header.Set("Git-Protocol", tc.gitProtocol)
+			a := &api.Response{GitalyServer: api.GitalyServer{Address: addr}, BundleURIs: tc.bundles}
+
+			handleGetInfoRefs(NewHTTPResponseWriter(w), r, a)
+			require.Equal(t, 200, w.Code)
+			require.Equal(t, tc.advertised, strings.Contains(w.Body.String(), "bundle-uri\n"))
+		})
+	}
+}
@@ -0,0 +1,285 @@
+/*
+In this file we render the structured diff of a commit as JSON lines or HTML
+*/
+
+package git
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gitlab.com/gitlab-org/gitaly/v16/proto/go/gitalypb"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/gitaly"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/helper/fail"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/highlight"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/log"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/senddata"
+)
+
+// maxHighlightBytes limits the size of patches that are syntax highlighted.
+// Larger patches are rendered as plain text.
+const maxHighlightBytes = 512 * 1024
+
+var hunkHeaderRegex = regexp.MustCompile(`^@@ -(\d+)(?:,\d+)? \+(\d+)(?:,\d+)? @@`)
+
+type commitDiff struct {
+	senddata.Prefix
+	contentType string
+	writeFile   func(io.Writer, *gitalypb.CommitDiffResponse) error
+}
+type commitDiffParams struct {
+	GitalyServer      api.GitalyServer
+	CommitDiffRequest string
+}
+
+// SendDiffJSON streams one JSON object per changed file
+var SendDiffJSON = &commitDiff{
+	Prefix:      "git-diff-json:",
+	contentType: "application/x-ndjson",
+	writeFile:   writeDiffFileJSON,
+}
+
+// SendDiffHTML streams syntax highlighted HTML for each changed file
+var SendDiffHTML = &commitDiff{
+	Prefix:      "git-diff-html:",
+	contentType: "text/html; charset=utf-8",
+	writeFile:   writeDiffFileHTML,
+}
+
+func (d *commitDiff) Inject(w http.ResponseWriter, r *http.Request, sendData string) {
+	var params commitDiffParams
+	if err := d.Unpack(&params, sendData); err != nil {
+		fail.Request(w, r, fmt.Errorf("SendCommitDiff: unpack sendData: %v", err))
+		return
+	}
+
+	request := &gitalypb.CommitDiffRequest{}
+	if err := gitaly.UnmarshalJSON(params.CommitDiffRequest, request); err != nil {
+		fail.Request(w, r, fmt.Errorf("diff.CommitDiff: %v", err))
+		return
+	}
+
+	ctx, diffClient, err := gitaly.NewDiffClient(r.Context(), params.GitalyServer)
+	if err != nil {
+		fail.Request(w, r, fmt.Errorf("diff.CommitDiff: %v", err))
+		return
+	}
+
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", d.contentType)
+
+	written := false
+	err = diffClient.StreamCommitDiff(ctx, request, func(file *gitalypb.CommitDiffResponse) error {
+		written = true
+		return d.writeFile(w, file)
+	})
+	if err == nil {
+		return
+	}
+
+	if !written {
+		fail.Request(w, r, fmt.Errorf("diff.CommitDiff: %v", err))
+		return
+	}
+	log.WithRequest(r).WithError(&copyError{fmt.Errorf("diff.CommitDiff: %v", err)}).Error()
+}
+
+type diffFileJSON struct {
+	OldPath     string `json:"old_path,omitempty"`
+	NewPath     string `json:"new_path,omitempty"`
+	OldBlobID   string `json:"old_blob_id,omitempty"`
+	NewBlobID   string `json:"new_blob_id,omitempty"`
+	AMode       string `json:"a_mode,omitempty"`
+	BMode       string `json:"b_mode,omitempty"`
+	NewFile     bool   `json:"new_file"`
+	DeletedFile bool   `json:"deleted_file"`
+	RenamedFile bool   `json:"renamed_file"`
+	Binary      bool   `json:"binary"`
+	TooLarge    bool   `json:"too_large"`
+	Collapsed   bool   `json:"collapsed"`
+	Overflow    bool   `json:"overflow,omitempty"`
+	Diff        string `json:"diff"`
+}
+
+func newDiffFileJSON(file *gitalypb.CommitDiffResponse) *diffFileJSON {
+	if file.GetOverflowMarker() {
+		return &diffFileJSON{Overflow: true}
+	}
+
+	return &diffFileJSON{
+		OldPath:     string(file.GetFromPath()),
+		NewPath:     string(file.GetToPath()),
+		OldBlobID:   file.GetFromId(),
+		NewBlobID:   file.GetToId(),
+		AMode:       strconv.FormatInt(int64(file.GetOldMode()), 8),
+		BMode:       strconv.FormatInt(int64(file.GetNewMode()), 8),
+		NewFile:     file.GetOldMode() == 0,
+		DeletedFile: file.GetNewMode() == 0,
+		RenamedFile: string(file.GetFromPath()) != string(file.GetToPath()),
+		Binary:      file.GetBinary(),
+		TooLarge:    file.GetTooLarge(),
+		Collapsed:   file.GetCollapsed(),
+		Diff:        string(file.GetRawPatchData()),
+	}
+}
+
+func writeDiffFileJSON(w io.Writer, file *gitalypb.CommitDiffResponse) error {
+	return json.NewEncoder(w).Encode(newDiffFileJSON(file))
+}
+
+// diffLine is a line of a patch. Hunk headers have the kind "match", removed
+// lines "old", added lines "new" and context lines no kind.
+type diffLine struct {
+	kind    string
+	oldLine int
+	newLine int
+	text    string
+}
+
+func parseDiffLines(patch string) []diffLine {
+	if patch == "" {
+		return nil
+	}
+
+	var lines []diffLine
+	oldLine, newLine := 0, 0
+	for _, line := range strings.Split(strings.TrimSuffix(patch, "\n"), "\n") {
+		if m := hunkHeaderRegex.FindStringSubmatch(line); m != nil {
+			oldLine, _ = strconv.Atoi(m[1])
+			newLine, _ = strconv.Atoi(m[2])
+			lines = append(lines, diffLine{kind: "match", text: line})
+			continue
+		}
+
+		prefix, text := "", line
+		if line != "" {
+			prefix, text = line[:1], line[1:]
+		}
+
+		switch prefix {
+		case "+":
+			lines = append(lines, diffLine{kind: "new", newLine: newLine, text: text})
+			newLine++
+		case "-":
+			lines = append(lines, diffLine{kind: "old", oldLine: oldLine, text: text})
+			oldLine++
+		case "\\":
+			// "\ No newline at end of file"
+			lines = append(lines, diffLine{kind: "match", text: line})
+		default:
+			lines = append(lines, diffLine{oldLine: oldLine, newLine: newLine, text: text})
+			oldLine++
+			newLine++
+		}
+	}
+
+	return lines
+}
+
+// highlightDiffLines tokenizes the code of all lines at once, so that
+// constructs spanning several lines are mostly recognized. It returns nil if
+// the file cannot be highlighted.
+func highlightDiffLines(path string, lines []diffLine) [][]highlight.Token {
+	lexer := highlight.LexerForFile(path)
+	if lexer == nil {
+		return nil
+	}
+
+	var source strings.Builder
+	for _, line := range lines {
+		if line.kind != "match" {
+			source.WriteString(line.text)
+			source.WriteString("\n")
+		}
+	}
+
+	return highlight.Tokenize(lexer, source.String())
+}
+
+func writeDiffFileHTML(w io.Writer, file *gitalypb.CommitDiffResponse) error {
+	bw := bufio.NewWriter(w)
+
+	if file.GetOverflowMarker() {
+		bw.WriteString(`<div class="diff-overflow">Too many changes to show.</div>` + "\n")
+		return bw.Flush()
+	}
+
+	fmt.Fprintf(bw, `<div class="diff-file" data-old-path="%s" data-new-path="%s" data-blob-id="%s">`+"\n",
+		html.EscapeString(string(file.GetFromPath())), html.EscapeString(string(file.GetToPath())), html.EscapeString(file.GetToId()))
+
+	switch {
+	case file.GetBinary():
+		bw.WriteString(`<div class="nothing-here-block">Binary file not shown.</div>` + "\n")
+	case file.GetTooLarge():
+		bw.WriteString(`<div class="nothing-here-block">Changes are too large to be shown.</div>` + "\n")
+	case file.GetCollapsed():
+		bw.WriteString(`<div class="nothing-here-block">Changes are collapsed.</div>` + "\n")
+	default:
+		writeDiffTableHTML(bw, file)
+	}
+
+	bw.WriteString("</div>\n")
+	return bw.Flush()
+}
+
+func writeDiffTableHTML(bw *bufio.Writer, file *gitalypb.CommitDiffResponse) {
+	lines := parseDiffLines(string(file.GetRawPatchData()))
+
+	var tokens [][]highlight.Token
+	if len(file.GetRawPatchData()) <= maxHighlightBytes {
+		tokens = highlightDiffLines(string(file.GetToPath()), lines)
+	}
+
+	bw.WriteString(`<table class="diff-table code">` + "\n")
+	code := 0
+	for _, line := range lines {
+		fmt.Fprintf(bw, `<tr class="%s"><td class="old_line diff-line-num">%s</td><td class="new_line diff-line-num">%s</td><td class="%s">`,
+			strings.TrimSpace("line_holder "+line.kind), lineNumberHTML(line.oldLine, line.kind != "new"), lineNumberHTML(line.newLine, line.kind != "old"), strings.TrimSpace("line_content "+line.kind))
+
+		switch {
+		case line.kind == "match":
+			bw.WriteString(html.EscapeString(line.text))
+		case code < len(tokens):
+			writeTokensHTML(bw, tokens[code])
+			code++
+		default:
+			bw.WriteString(html.EscapeString(line.text))
+			code++
+		}
+
+		bw.WriteString("</td></tr>\n")
+	}
+	bw.WriteString("</table>\n")
+}
+
+func lineNumberHTML(n int, show bool) string {
+	if !show || n == 0 {
+		return ""
+	}
+
+	return strconv.Itoa(n)
+}
+
+func writeTokensHTML(bw *bufio.Writer, tokens []highlight.Token) {
+	for _, token := range tokens {
+		value := html.EscapeString(strings.TrimSuffix(token.Value, "\n"))
+		if value == "" {
+			continue
+		}
+
+		if token.Class == "" {
+			bw.WriteString(value)
+		} else {
+			fmt.Fprintf(bw, `<span class="%s">%s</span>`, token.Class, value)
+		}
+	}
+}
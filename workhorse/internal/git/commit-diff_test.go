@@ -0,0 +1,176 @@
+package git
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"gitlab.com/gitlab-org/gitaly/v16/proto/go/gitalypb"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/testhelper"
+)
+
+const testGoPatch = "@@ -1,3 +1,3 @@\n package main\n-func old() {}\n+func main() {}\n\\ No newline at end of file\n"
+
+type diffServiceServer struct {
+	gitalypb.UnimplementedDiffServiceServer
+	responses []*gitalypb.CommitDiffResponse
+}
+
+func (s *diffServiceServer) CommitDiff(_ *gitalypb.CommitDiffRequest, stream gitalypb.DiffService_CommitDiffServer) error {
+	for _, resp := range s.responses {
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func startDiffServer(t *testing.T, s gitalypb.DiffServiceServer) string {
+	t.Helper()
+
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "gitaly.sock"))
+	require.NoError(t, err)
+
+	srv := grpc.NewServer(testhelper.WithSidechannel())
+	gitalypb.RegisterDiffServiceServer(srv, s)
+	go func() {
+		require.NoError(t, srv.Serve(ln))
+	}()
+
+	t.Cleanup(func() {
+		srv.GracefulStop()
+	})
+
+	return fmt.Sprintf("%s://%s", ln.Addr().Network(), ln.Addr().String())
+}
+
+func commitDiffSendData(t *testing.T, prefix string, addr string) string {
+	t.Helper()
+
+	params := commitDiffParams{
+		GitalyServer:      api.GitalyServer{Address: addr},
+		CommitDiffRequest: `{"repository":{"storageName":"default","relativePath":"foo/bar.git"},"leftCommitId":"a","rightCommitId":"b"}`,
+	}
+	jsonBytes, err := json.Marshal(&params)
+	require.NoError(t, err)
+
+	return prefix + base64.URLEncoding.EncodeToString(jsonBytes)
+}
+
+func TestSendDiffJSON(t *testing.T) {
+	addr := startDiffServer(t, &diffServiceServer{responses: []*gitalypb.CommitDiffResponse{
+		{FromPath: []byte("main.go"), ToPath: []byte("main.go"), FromId: "1a", ToId: "2b", OldMode: 0o100644, NewMode: 0o100644, RawPatchData: []byte(testGoPatch[:20])},
+		{RawPatchData: []byte(testGoPatch[20:]), EndOfPatch: true},
+		{ToPath: []byte("new.txt"), ToId: "3c", NewMode: 0o100644, RawPatchData: []byte("@@ -0,0 +1 @@\n+hello\n"), EndOfPatch: true},
+		{OverflowMarker: true, EndOfPatch: true},
+	}})
+
+	w := httptest.NewRecorder()
+	w.Header().Set("Content-Length", "123")
+	r := httptest.NewRequest("GET", "/diffs.json", nil)
+
+	SendDiffJSON.Inject(w, r, commitDiffSendData(t, "git-diff-json:", addr))
+
+	require.Equal(t, 200, w.Code)
+	require.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	require.Empty(t, w.Header().Get("Content-Length"))
+
+	lines := strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n")
+	require.Len(t, lines, 3)
+
+	var files []diffFileJSON
+	for _, line := range lines {
+		var file diffFileJSON
+		require.NoError(t, json.Unmarshal([]byte(line), &file))
+		files = append(files, file)
+	}
+
+	require.Equal(t, diffFileJSON{
+		OldPath: "main.go", NewPath: "main.go", OldBlobID: "1a", NewBlobID: "2b", AMode: "100644", BMode: "100644", Diff: testGoPatch,
+	}, files[0])
+	require.True(t, files[1].NewFile)
+	require.Equal(t, "0", files[1].AMode)
+	require.True(t, files[1].RenamedFile)
+	require.Equal(t, diffFileJSON{Overflow: true}, files[2])
+}
+
+func TestSendDiffHTMLTruncatedStream(t *testing.T) {
+	addr := startDiffServer(t, &diffServiceServer{responses: []*gitalypb.CommitDiffResponse{
+		{FromPath: []byte("main.go"), ToPath: []byte("main.go"), RawPatchData: []byte(testGoPatch)},
+	}})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/diffs", nil)
+
+	SendDiffHTML.Inject(w, r, commitDiffSendData(t, "git-diff-html:", addr))
+
+	require.Equal(t, 500, w.Code)
+}
+
+func TestParseDiffLines(t *testing.T) {
+	require.Equal(t, []diffLine{
+		{kind: "match", text: "@@ -1,3 +1,3 @@"},
+		{oldLine: 1, newLine: 1, text: "package main"},
+		{kind: "old", oldLine: 2, text: "func old() {}"},
+		{kind: "new", newLine: 2, text: "func main() {}"},
+		{kind: "match", text: `\ No newline at end of file`},
+	}, parseDiffLines(testGoPatch))
+
+	require.Nil(t, parseDiffLines(""))
+}
+
+func TestWriteDiffFileHTML(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writeDiffFileHTML(&buf, &gitalypb.CommitDiffResponse{
+		FromPath: []byte("main.go"), ToPath: []byte("main.go"), ToId: "2b", RawPatchData: []byte(testGoPatch),
+	}))
+
+	out := buf.String()
+	require.Contains(t, out, `<div class="diff-file" data-old-path="main.go" data-new-path="main.go" data-blob-id="2b">`)
+	require.Contains(t, out, `<tr class="line_holder match"><td class="old_line diff-line-num"></td><td class="new_line diff-line-num"></td><td class="line_content match">@@ -1,3 +1,3 @@</td></tr>`)
+	require.Contains(t, out, `<tr class="line_holder old"><td class="old_line diff-line-num">2</td><td class="new_line diff-line-num"></td><td class="line_content old"><span class="kd">func</span> old() {}</td></tr>`)
+	require.Contains(t, out, `<tr class="line_holder new"><td class="old_line diff-line-num"></td><td class="new_line diff-line-num">2</td><td class="line_content new"><span class="kd">func</span> main() {}</td></tr>`)
+	require.True(t, strings.HasSuffix(out, "</table>\n</div>\n"))
+}
+
+func TestWriteDiffFileHTMLEscapesPlainText(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writeDiffFileHTML(&buf, &gitalypb.CommitDiffResponse{
+		FromPath: []byte("<x>"), ToPath: []byte("<x>"), RawPatchData: []byte("@@ -1 +1 @@\n-<b>\n+<i>\n"),
+	}))
+
+	out := buf.String()
+	require.Contains(t, out, `data-old-path="&lt;x&gt;"`)
+	require.Contains(t, out, `<td class="line_content old">&lt;b&gt;</td>`)
+	require.Contains(t, out, `<td class="line_content new">&lt;i&gt;</td>`)
+	require.NotContains(t, out, "<b>")
+}
+
+func TestWriteDiffFileHTMLWithoutPatch(t *testing.T) {
+	for _, tc := range []struct {
+		file     *gitalypb.CommitDiffResponse
+		expected string
+	}{
+		{file: &gitalypb.CommitDiffResponse{Binary: true}, expected: "Binary file not shown."},
+		{file: &gitalypb.CommitDiffResponse{TooLarge: true}, expected: "Changes are too large to be shown."},
+		{file: &gitalypb.CommitDiffResponse{Collapsed: true}, expected: "Changes are collapsed."},
+		{file: &gitalypb.CommitDiffResponse{OverflowMarker: true}, expected: "Too many changes to show."},
+	} {
+		var buf bytes.Buffer
+		require.NoError(t, writeDiffFileHTML(&buf, tc.file))
+		require.Contains(t, buf.String(), tc.expected)
+		require.NotContains(t, buf.String(), "<table")
+	}
+}
@@ -98,3 +98,15 @@ func writeUploadPackError(w io.Writer) error {
 	_, err := fmt.Fprintf(w, "%04xERR GitLab is currently unable to handle this request due to load.\n", 71)
 	return err
 }
+
+// writeReceivePackFatalError writes an error message that git prints before
+// aborting a push. Clients that requested a side-band receive the message on
+// the error band (3), others as an ERR pkt-line.
+// See https://git-scm.com/docs/pack-protocol#_pkt_line_format.
+func writeReceivePackFatalError(w io.Writer, sideband bool, msg string) error {
+	if sideband {
+		return writePktLine(w, "\x03"+msg+"\n")
+	}
+
+	return writePktLine(w, "ERR "+msg+"\n")
+}
@@ -7,13 +7,17 @@ package git
 import (
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"gitlab.com/gitlab-org/gitaly/v16/proto/go/gitalypb"
 
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/config"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/git/audit"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/log"
 )
 
@@ -23,12 +27,25 @@ const (
 	GitConfigShowAllRefs = "transfer.hideRefs=!refs"
 )
 
-func ReceivePack(a *api.API) http.Handler {
-	return postRPCHandler(a, "handleReceivePack", handleReceivePack, sendGitAuditEvent("git-receive-pack"), writeReceivePackError)
+func ReceivePack(a *api.API, auditor *audit.Auditor) http.Handler {
+	return postRPCHandler(a, "handleReceivePack", handleReceivePack, sendGitAuditEvent(auditor, "git-receive-pack"), writeReceivePackError)
 }
 
-func UploadPack(a *api.API) http.Handler {
-	return postRPCHandler(a, "handleUploadPack", handleUploadPack, sendGitAuditEvent("git-upload-pack"), writeUploadPackError)
+func UploadPack(a *api.API, cache *UploadPackCache, policy config.UploadPackPolicyConfig, auditor *audit.Auditor) http.Handler {
+	handler := func(w *HTTPResponseWriter, r *http.Request, ar *api.Response) (*gitalypb.PackfileNegotiationStatistics, error) {
+		return handleUploadPack(w, r, ar, cache, policy)
+	}
+
+	return postRPCHandler(a, "handleUploadPack", handler, sendGitAuditEvent(auditor, "git-upload-pack"), writeUploadPackError)
+}
+
+// gitRPCResult describes a finished git RPC for the audit log.
+type gitRPCResult struct {
+	stats    *gitalypb.PackfileNegotiationStatistics
+	bytesIn  int64
+	bytesOut int64
+	duration time.Duration
+	err      error
 }
 
 func gitConfigOptions(a *api.Response) []string {
@@ -45,7 +62,7 @@ func postRPCHandler(
 	a *api.API,
 	name string,
 	handler func(*HTTPResponseWriter, *http.Request, *api.Response) (*gitalypb.PackfileNegotiationStatistics, error),
-	postFunc func(*api.API, *http.Request, *api.Response, *gitalypb.PackfileNegotiationStatistics),
+	postFunc func(*http.Request, *api.Response, gitRPCResult),
 	errWriter func(io.Writer) error,
 ) http.Handler {
 	return repoPreAuthorizeHandler(a, func(rw http.ResponseWriter, r *http.Request, ar *api.Response) {
@@ -57,6 +74,7 @@ func postRPCHandler(
 			w.Log(r, cr.Count())
 		}()
 
+		start := time.Now()
 		stats, err := handler(w, r, ar)
 		if err != nil {
 			handleLimitErr(err, w, errWriter)
@@ -67,7 +85,13 @@ func postRPCHandler(
 			log.WithRequest(r).WithError(fmt.Errorf("%s: %v", name, err)).Error()
 		}
 
-		postFunc(a, r, ar, stats)
+		postFunc(r, ar, gitRPCResult{
+			stats:    stats,
+			bytesIn:  cr.Count(),
+			bytesOut: w.Count(),
+			duration: time.Since(start),
+			err:      err,
+		})
 	})
 }
 
@@ -77,27 +101,39 @@ func repoPreAuthorizeHandler(myAPI *api.API, handleFunc api.HandleFunc) http.Han
 	}, "")
 }
 
-func sendGitAuditEvent(action string) func(*api.API, *http.Request, *api.Response, *gitalypb.PackfileNegotiationStatistics) {
-	return func(a *api.API, r *http.Request, response *api.Response, stats *gitalypb.PackfileNegotiationStatistics) {
+func sendGitAuditEvent(auditor *audit.Auditor, action string) func(*http.Request, *api.Response, gitRPCResult) {
+	return func(r *http.Request, response *api.Response, result gitRPCResult) {
 		if !response.NeedAudit {
 			return
 		}
 
-		ctx := r.Context()
-		err := a.SendGitAuditEvent(ctx, api.GitAuditEventRequest{
-			Action:        action,
-			Protocol:      "http",
-			Repo:          response.GL_REPOSITORY,
-			Username:      response.GL_USERNAME,
-			PackfileStats: stats,
-		})
-		if err != nil {
-			log.WithContextFields(ctx, log.Fields{
-				"repo":     response.GL_REPOSITORY,
-				"action":   action,
-				"username": response.GL_USERNAME,
-			}).WithError(err).Error("failed to send git audit event")
-		}
+		auditor.Record(r.Context(), newGitAuditEvent(r, response, action, "http", result))
+	}
+}
+
+func newGitAuditEvent(r *http.Request, response *api.Response, action string, protocol string, result gitRPCResult) api.GitAuditEventRequest {
+	outcome := "success"
+	if result.err != nil {
+		outcome = "failure"
+	}
+
+	clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		clientIP = r.RemoteAddr
+	}
+
+	return api.GitAuditEventRequest{
+		Action:        action,
+		Protocol:      protocol,
+		Repo:          response.GL_REPOSITORY,
+		Username:      response.GL_USERNAME,
+		PackfileStats: result.stats,
+		Time:          time.Now().UTC(),
+		ClientIP:      clientIP,
+		Outcome:       outcome,
+		BytesIn:       result.bytesIn,
+		BytesOut:      result.bytesOut,
+		DurationS:     result.duration.Seconds(),
 	}
 }
 
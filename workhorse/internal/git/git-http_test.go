@@ -0,0 +1,42 @@
+package git
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/gitlab-org/gitaly/v16/proto/go/gitalypb"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
+)
+
+func TestNewGitAuditEvent(t *testing.T) {
+	r := httptest.NewRequest("POST", "/group/project.git/git-upload-pack", nil)
+	r.RemoteAddr = "203.0.113.7:52000"
+	response := &api.Response{GL_REPOSITORY: "project-1", GL_USERNAME: "alice"}
+	stats := &gitalypb.PackfileNegotiationStatistics{Wants: 3}
+
+	event := newGitAuditEvent(r, response, "git-upload-pack", "http", gitRPCResult{
+		stats:    stats,
+		bytesIn:  10,
+		bytesOut: 20,
+		duration: 1500 * time.Millisecond,
+	})
+
+	require.Equal(t, "git-upload-pack", event.Action)
+	require.Equal(t, "http", event.Protocol)
+	require.Equal(t, "project-1", event.Repo)
+	require.Equal(t, "alice", event.Username)
+	require.Equal(t, stats, event.PackfileStats)
+	require.Equal(t, "203.0.113.7", event.ClientIP)
+	require.Equal(t, "success", event.Outcome)
+	require.Equal(t, int64(10), event.BytesIn)
+	require.Equal(t, int64(20), event.BytesOut)
+	require.InDelta(t, 1.5, event.DurationS, 0.001)
+	require.WithinDuration(t, time.Now(), event.Time, time.Minute)
+
+	event = newGitAuditEvent(r, response, "git-upload-pack", "ssh", gitRPCResult{err: errors.New("upload pack failed")})
+	require.Equal(t, "failure", event.Outcome)
+}
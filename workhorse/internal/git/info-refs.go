@@ -75,7 +75,12 @@ func handleGetInfoRefsWithGitaly(ctx context.Context, responseWriter *HTTPRespon
 		responseWriter.Header().Set("Content-Encoding", "gzip")
 	}
 
-	if _, err = io.Copy(w, infoRefsResponseReader); err != nil {
+	if rpc == "git-upload-pack" && bundleURIEnabled(a, gitProtocol) {
+		err = copyAdvertisementWithBundleURI(w, infoRefsResponseReader)
+	} else {
+		_, err = io.Copy(w, infoRefsResponseReader)
+	}
+	if err != nil {
 		return err
 	}
 
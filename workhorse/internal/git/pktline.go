@@ -0,0 +1,89 @@
+package git
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const (
+	pktLenSize = 4
+	// maxPktLen is the maximum length of a pkt-line including its length
+	// prefix, as defined by the Git protocol.
+	maxPktLen = 65520
+)
+
+var (
+	pktFlush = []byte("0000")
+	pktDelim = []byte("0001")
+
+	errInvalidPktLine = errors.New("invalid pkt-line")
+)
+
+// peekPktLine returns the next pkt-line of r, including its length prefix,
+// without consuming it. Special packets such as flush and delim are
+// returned as their 4-byte prefix.
+func peekPktLine(r *bufio.Reader) ([]byte, error) {
+	prefix, err := r.Peek(pktLenSize)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := pktLen(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Peek(n)
+}
+
+// readPktLine reads the next pkt-line of r, including its length prefix.
+func readPktLine(r *bufio.Reader) ([]byte, error) {
+	pkt, err := peekPktLine(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Peek returns a slice of the buffer, which is overwritten by the next read
+	pkt = append([]byte(nil), pkt...)
+	if _, err := r.Discard(len(pkt)); err != nil {
+		return nil, err
+	}
+
+	return pkt, nil
+}
+
+// pktLen returns the total length of a pkt-line, given its length prefix.
+func pktLen(prefix []byte) (int, error) {
+	n, err := strconv.ParseUint(string(prefix), 16, 16)
+	if err != nil {
+		return 0, errInvalidPktLine
+	}
+
+	switch {
+	case n < pktLenSize:
+		// flush, delim and response-end packets have no payload
+		return pktLenSize, nil
+	case n > maxPktLen:
+		return 0, errInvalidPktLine
+	}
+
+	return int(n), nil
+}
+
+// pktLinePayload returns the data of a pkt-line without its length prefix.
+func pktLinePayload(pkt []byte) []byte {
+	return pkt[pktLenSize:]
+}
+
+// writePktLine writes data as a single pkt-line.
+func writePktLine(w io.Writer, data string) error {
+	if len(data)+pktLenSize > maxPktLen {
+		return errInvalidPktLine
+	}
+
+	_, err := fmt.Fprintf(w, "%04x%s", len(data)+pktLenSize, data)
+	return err
+}
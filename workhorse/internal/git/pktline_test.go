@@ -0,0 +1,40 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadPktLine(t *testing.T) {
+	br := bufio.NewReader(strings.NewReader("000ahello\n00000001000aworld\n"))
+
+	for _, expected := range []string{"000ahello\n", "0000", "0001", "000aworld\n"} {
+		pkt, err := readPktLine(br)
+		require.NoError(t, err)
+		require.Equal(t, expected, string(pkt))
+	}
+
+	_, err := readPktLine(br)
+	require.Equal(t, io.EOF, err)
+}
+
+func TestReadPktLineInvalid(t *testing.T) {
+	for _, input := range []string{"zzzz", "fff1" + strings.Repeat("a", 70000)} {
+		_, err := readPktLine(bufio.NewReaderSize(strings.NewReader(input), 2*maxPktLen))
+		require.Equal(t, errInvalidPktLine, err)
+	}
+}
+
+func TestWritePktLine(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writePktLine(&buf, "hello\n"))
+	require.Equal(t, "000ahello\n", buf.String())
+	require.Equal(t, "hello\n", string(pktLinePayload(buf.Bytes())))
+
+	require.Equal(t, errInvalidPktLine, writePktLine(&buf, strings.Repeat("a", maxPktLen)))
+}
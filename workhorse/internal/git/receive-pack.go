@@ -1,23 +1,89 @@
 package git
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
+	"io"
 	"net/http"
+	"sync/atomic"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"gitlab.com/gitlab-org/gitaly/v16/proto/go/gitalypb"
 
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/gitaly"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/log"
 )
 
+var gitPushesTooLarge = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Name: "gitlab_workhorse_git_push_size_limit_exceeded",
+		Help: "How many git pushes have been rejected by gitlab-workhorse because they exceed the maximum push size.",
+	},
+)
+
+// pushTooLargeError is returned when the request body of a push exceeds
+// api.Response.MaxPushSize.
+type pushTooLargeError struct {
+	limit int64
+}
+
+func (e *pushTooLargeError) Error() string {
+	return fmt.Sprintf("push exceeds the maximum size of %d bytes", e.limit)
+}
+
+// pushSizeReader fails once more than limit bytes have been read, which
+// aborts the Gitaly call that is reading the push.
+type pushSizeReader struct {
+	io.Reader
+	limit int64
+	n     int64
+}
+
+func (r *pushSizeReader) Read(p []byte) (int, error) {
+	if r.exceeded() {
+		return 0, &pushTooLargeError{limit: r.limit}
+	}
+
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	if r.exceeded() {
+		return 0, &pushTooLargeError{limit: r.limit}
+	}
+
+	return n, err
+}
+
+func (r *pushSizeReader) exceeded() bool {
+	return r.n > r.limit
+}
+
 // Will not return a non-nil error after the response body has been
-// written to.
-// and `git push` doesn't provide `gitalypb.PackfileNegotiationStatistics`.
+// written to, unless the push is rejected for exceeding MaxPushSize.
+// `git push` doesn't provide `gitalypb.PackfileNegotiationStatistics`.
 func handleReceivePack(w *HTTPResponseWriter, r *http.Request, a *api.Response) (*gitalypb.PackfileNegotiationStatistics, error) {
 	action := getService(r)
 	writePostRPCHeader(w, action)
 
-	cr, cw := newWriteAfterReader(r.Body, w)
+	var body io.Reader = r.Body
+	var sizeReader *pushSizeReader
+	sideband := false
+	if a.MaxPushSize > 0 {
+		br := bufio.NewReaderSize(r.Body, maxPktLen)
+		sideband = requestsSideband(br)
+
+		// Reject pushes of known size without contacting Gitaly
+		if r.ContentLength > a.MaxPushSize {
+			return nil, rejectPush(w, r, a, sideband, r.ContentLength)
+		}
+
+		sizeReader = &pushSizeReader{Reader: br, limit: a.MaxPushSize}
+		body = sizeReader
+	}
+
+	cr, cw := newWriteAfterReader(body, w)
 	defer cw.Flush()
 
 	gitProtocol := r.Header.Get("Git-Protocol")
@@ -28,8 +94,101 @@ func handleReceivePack(w *HTTPResponseWriter, r *http.Request, a *api.Response)
 	}
 
 	if err := smarthttp.ReceivePack(ctx, &a.Repository, a.GL_ID, a.GL_USERNAME, a.GL_REPOSITORY, a.GitConfigOptions, cr, cw, gitProtocol); err != nil {
+		if sizeReader != nil && sizeReader.exceeded() {
+			return nil, rejectPush(cw, r, a, sideband, sizeReader.n)
+		}
+
 		return nil, fmt.Errorf("smarthttp.ReceivePack: %w", err)
 	}
 
 	return nil, nil
 }
+
+func rejectPush(w io.Writer, r *http.Request, a *api.Response, sideband bool, size int64) error {
+	err := &pushTooLargeError{limit: a.MaxPushSize}
+
+	gitPushesTooLarge.Inc()
+	log.WithContextFields(r.Context(), log.Fields{
+		"repo":          a.GL_REPOSITORY,
+		"username":      a.GL_USERNAME,
+		"max_push_size": a.MaxPushSize,
+		"push_size":     size,
+	}).Info("rejecting git push: maximum push size exceeded")
+
+	msg := fmt.Sprintf("Your push has been rejected, because it exceeds the maximum push size of %d bytes for this repository.", a.MaxPushSize)
+	if writeErr := writeReceivePackFatalError(w, sideband, msg); writeErr != nil {
+		return fmt.Errorf("%w: write error: %v", err, writeErr)
+	}
+
+	return err
+}
+
+// requestsSideband checks the capabilities sent by the client with the
+// first command of a push, without consuming the request.
+func requestsSideband(br *bufio.Reader) bool {
+	pkt, err := peekPktLine(br)
+	if err != nil {
+		return false
+	}
+
+	return hasSidebandCapability(pkt)
+}
+
+func hasSidebandCapability(pkt []byte) bool {
+	if len(pkt) <= pktLenSize {
+		return false
+	}
+
+	_, capabilities, found := bytes.Cut(pktLinePayload(pkt), []byte{0})
+	if !found {
+		return false
+	}
+
+	for _, capability := range bytes.Fields(capabilities) {
+		if string(capability) == "side-band" || string(capability) == "side-band-64k" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sidebandReader checks the capabilities sent with the first command of a
+// push while the push is read. Over SSH, the client only sends its commands
+// after it received the advertisement of git-receive-pack, so they cannot be
+// inspected before the push reaches Gitaly.
+type sidebandReader struct {
+	io.Reader
+
+	pkt      []byte
+	done     bool
+	sideband atomic.Bool
+}
+
+func (r *sidebandReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if !r.done && n > 0 {
+		r.inspect(p[:n])
+	}
+
+	return n, err
+}
+
+func (r *sidebandReader) inspect(p []byte) {
+	r.pkt = append(r.pkt, p...)
+	if len(r.pkt) < pktLenSize {
+		return
+	}
+
+	n, err := pktLen(r.pkt[:pktLenSize])
+	if err != nil {
+		r.done, r.pkt = true, nil
+		return
+	}
+	if len(r.pkt) < n {
+		return
+	}
+
+	r.sideband.Store(hasSidebandCapability(r.pkt[:n]))
+	r.done, r.pkt = true, nil
+}
@@ -0,0 +1,124 @@
+package git
+
+import (
+	"bufio"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitaly/v16/proto/go/gitalypb"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
+)
+
+type smartHTTPServiceServerWithReceivePack struct {
+	gitalypb.UnimplementedSmartHTTPServiceServer
+	received atomic.Int64
+}
+
+func (srv *smartHTTPServiceServerWithReceivePack) PostReceivePack(s gitalypb.SmartHTTPService_PostReceivePackServer) error {
+	for {
+		req, err := s.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		srv.received.Add(int64(len(req.GetData())))
+	}
+
+	return s.Send(&gitalypb.PostReceivePackResponse{Data: []byte("000eunpack ok\n0000")})
+}
+
+const pushCommand = "0000000000000000000000000000000000000000 1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b refs/heads/main"
+
+// slowReader hides the size of the request body
+type slowReader struct{ io.Reader }
+
+func pushRequest(capabilities string, packSize int) string {
+	return pktLines(pushCommand+"\x00"+capabilities+"\n", "0000") + "PACK" + strings.Repeat("x", packSize)
+}
+
+func TestReceivePackMaxPushSize(t *testing.T) {
+	tests := []struct {
+		desc         string
+		capabilities string
+		packSize     int
+		knownSize    bool
+		expected     string
+	}{
+		{
+			desc:     "under the limit",
+			packSize: 10,
+			expected: "000eunpack ok\n0000",
+		},
+		{
+			desc:         "streamed over the limit",
+			capabilities: "report-status",
+			packSize:     4096,
+			expected:     "ERR Your push has been rejected, because it exceeds the maximum push size of 1024 bytes for this repository.\n",
+		},
+		{
+			desc:         "streamed over the limit with side-band",
+			capabilities: "report-status side-band-64k",
+			packSize:     4096,
+			expected:     "\x03Your push has been rejected, because it exceeds the maximum push size of 1024 bytes for this repository.\n",
+		},
+		{
+			desc:         "known size over the limit",
+			capabilities: "report-status",
+			packSize:     4096,
+			knownSize:    true,
+			expected:     "ERR Your push has been rejected, because it exceeds the maximum push size of 1024 bytes for this repository.\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			srv := &smartHTTPServiceServerWithReceivePack{}
+			// Pushes of known size are rejected without contacting Gitaly
+			addr := "unix:/nonexistent/gitaly.sock"
+			if !tc.knownSize {
+				addr = startSmartHTTPServer(t, srv)
+			}
+
+			var body io.Reader = strings.NewReader(pushRequest(tc.capabilities, tc.packSize))
+			if !tc.knownSize {
+				body = &slowReader{body}
+			}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/git-receive-pack", body)
+			a := &api.Response{GitalyServer: api.GitalyServer{Address: addr}, MaxPushSize: 1024}
+
+			_, err := handleReceivePack(NewHTTPResponseWriter(w), r, a)
+			if tc.packSize < 1024 {
+				require.NoError(t, err)
+				require.Equal(t, tc.expected, w.Body.String())
+				return
+			}
+
+			var pushErr *pushTooLargeError
+			require.ErrorAs(t, err, &pushErr)
+			require.Equal(t, 200, w.Code)
+
+			pkt, err := readPktLine(bufio.NewReader(w.Body))
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, string(pktLinePayload(pkt)))
+			require.LessOrEqual(t, srv.received.Load(), int64(1024))
+		})
+	}
+}
+
+func TestRequestsSideband(t *testing.T) {
+	require.True(t, requestsSideband(bufio.NewReader(strings.NewReader(pushRequest("report-status side-band-64k", 0)))))
+	require.True(t, requestsSideband(bufio.NewReader(strings.NewReader(pushRequest("side-band", 0)))))
+	require.False(t, requestsSideband(bufio.NewReader(strings.NewReader(pushRequest("report-status", 0)))))
+	require.False(t, requestsSideband(bufio.NewReader(strings.NewReader(pktLines(pushCommand+"\n", "0000")))))
+	require.False(t, requestsSideband(bufio.NewReader(strings.NewReader(""))))
+}
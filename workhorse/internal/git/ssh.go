@@ -7,13 +7,16 @@ package git
 
 import (
 	"fmt"
+	"io"
 	"net/http"
+	"time"
 
 	"gitlab.com/gitlab-org/gitaly/v16/proto/go/gitalypb"
 
 	"gitlab.com/gitlab-org/gitaly/v16/client"
 
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/git/audit"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/gitaly"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/helper/fail"
 )
@@ -21,10 +24,12 @@ import (
 type flushWriter struct {
 	http.ResponseWriter
 	controller *http.ResponseController
+	count      int64
 }
 
 func (f *flushWriter) Write(p []byte) (int, error) {
 	n, err := f.ResponseWriter.Write(p)
+	f.count += int64(n)
 	if err != nil {
 		return n, err
 	}
@@ -33,21 +38,55 @@ func (f *flushWriter) Write(p []byte) (int, error) {
 }
 
 // SSHUploadPack handles git pull SSH connection between GitLab-Shell and Gitaly through Workhorse
-func SSHUploadPack(a *api.API) http.Handler {
-	return repoPreAuthorizeHandler(a, handleSSHUploadPack)
+func SSHUploadPack(a *api.API, auditor *audit.Auditor) http.Handler {
+	return sshHandler(a, auditor, "git-upload-pack", handleSSHUploadPack)
 }
 
-func handleSSHUploadPack(w http.ResponseWriter, r *http.Request, a *api.Response) {
+// SSHReceivePack handles git push SSH connection between GitLab-Shell and Gitaly through Workhorse
+func SSHReceivePack(a *api.API, auditor *audit.Auditor) http.Handler {
+	return sshHandler(a, auditor, "git-receive-pack", handleSSHReceivePack)
+}
+
+func sshHandler(
+	a *api.API,
+	auditor *audit.Auditor,
+	action string,
+	handler func(http.ResponseWriter, *http.Request, *api.Response) (*gitalypb.PackfileNegotiationStatistics, int64, error),
+) http.Handler {
+	return repoPreAuthorizeHandler(a, func(w http.ResponseWriter, r *http.Request, ar *api.Response) {
+		cr := &countReadCloser{ReadCloser: r.Body}
+		r.Body = cr
+
+		start := time.Now()
+		stats, bytesOut, err := handler(w, r, ar)
+
+		if ar.NeedAudit {
+			auditor.Record(r.Context(), newGitAuditEvent(r, ar, action, "ssh", gitRPCResult{
+				stats:    stats,
+				bytesIn:  cr.Count(),
+				bytesOut: bytesOut,
+				duration: time.Since(start),
+				err:      err,
+			}))
+		}
+	})
+}
+
+// handleSSHUploadPack returns the number of bytes sent to GitLab-Shell. It
+// responds with an error itself.
+func handleSSHUploadPack(w http.ResponseWriter, r *http.Request, a *api.Response) (*gitalypb.PackfileNegotiationStatistics, int64, error) {
 	controller := http.NewResponseController(w) //nolint:bodyclose // false-positive https://github.com/timakin/bodyclose/issues/52
 	if err := controller.EnableFullDuplex(); err != nil {
-		fail.Request(w, r, fmt.Errorf("enabling full duplex: %v", err))
-		return
+		err = fmt.Errorf("enabling full duplex: %v", err)
+		fail.Request(w, r, err)
+		return nil, 0, err
 	}
 
 	conn, registry, err := gitaly.NewConnectionWithSidechannel(a.GitalyServer)
 	if err != nil {
-		fail.Request(w, r, fmt.Errorf("look up for gitaly connection: %v", err))
-		return
+		err = fmt.Errorf("look up for gitaly connection: %v", err)
+		fail.Request(w, r, err)
+		return nil, 0, err
 	}
 
 	w.WriteHeader(http.StatusOK)
@@ -58,9 +97,69 @@ func handleSSHUploadPack(w http.ResponseWriter, r *http.Request, a *api.Response
 		GitConfigOptions: a.GitConfigOptions,
 	}
 	out := &flushWriter{ResponseWriter: w, controller: controller}
-	_, err = client.UploadPackWithSidechannelWithResult(r.Context(), conn, registry, r.Body, out, out, request)
+	result, err := client.UploadPackWithSidechannelWithResult(r.Context(), conn, registry, r.Body, out, out, request)
+	if err != nil {
+		err = fmt.Errorf("upload pack failed: %v", err)
+		fail.Request(w, r, err)
+		return nil, out.count, err
+	}
+
+	return result.PackfileNegotiationStatistics, out.count, nil
+}
+
+// handleSSHReceivePack returns the number of bytes sent to GitLab-Shell. It
+// responds with an error itself. `git push` doesn't provide
+// `gitalypb.PackfileNegotiationStatistics`.
+func handleSSHReceivePack(w http.ResponseWriter, r *http.Request, a *api.Response) (*gitalypb.PackfileNegotiationStatistics, int64, error) {
+	controller := http.NewResponseController(w) //nolint:bodyclose // false-positive https://github.com/timakin/bodyclose/issues/52
+	if err := controller.EnableFullDuplex(); err != nil {
+		err = fmt.Errorf("enabling full duplex: %v", err)
+		fail.Request(w, r, err)
+		return nil, 0, err
+	}
+
+	conn, _, err := gitaly.NewConnectionWithSidechannel(a.GitalyServer)
 	if err != nil {
-		fail.Request(w, r, fmt.Errorf("upload pack failed: %v", err))
-		return
+		err = fmt.Errorf("look up for gitaly connection: %v", err)
+		fail.Request(w, r, err)
+		return nil, 0, err
 	}
+
+	var body io.Reader = r.Body
+	var sizeReader *pushSizeReader
+	var sbReader *sidebandReader
+	if a.MaxPushSize > 0 {
+		sbReader = &sidebandReader{Reader: r.Body}
+		sizeReader = &pushSizeReader{Reader: sbReader, limit: a.MaxPushSize}
+		body = sizeReader
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	request := &gitalypb.SSHReceivePackRequest{
+		Repository:       &a.Repository,
+		GlId:             a.GL_ID,
+		GlUsername:       a.GL_USERNAME,
+		GlRepository:     a.GL_REPOSITORY,
+		GitProtocol:      r.Header.Get("Git-Protocol"),
+		GitConfigOptions: a.GitConfigOptions,
+	}
+	out := &flushWriter{ResponseWriter: w, controller: controller}
+	exitCode, err := client.ReceivePack(r.Context(), conn, body, out, out, request)
+	if err != nil {
+		if sizeReader != nil && sizeReader.exceeded() {
+			return nil, out.count, rejectPush(out, r, a, sbReader.sideband.Load(), sizeReader.n)
+		}
+
+		err = fmt.Errorf("receive pack failed: %v", err)
+		fail.Request(w, r, err)
+		return nil, out.count, err
+	}
+
+	if exitCode != 0 {
+		// git-receive-pack has already reported the error to the client
+		return nil, out.count, fmt.Errorf("receive pack failed: exit code %d", exitCode)
+	}
+
+	return nil, out.count, nil
 }
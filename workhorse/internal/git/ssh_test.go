@@ -3,17 +3,23 @@ package git
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
 	"gitlab.com/gitlab-org/gitaly/v16/client"
 	"gitlab.com/gitlab-org/gitaly/v16/proto/go/gitalypb"
+	"google.golang.org/grpc"
 
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/testhelper"
 )
 
 const (
@@ -92,3 +98,175 @@ func setupGitalyServer(t *testing.T) string {
 		},
 	})
 }
+
+type sshServiceServer struct {
+	gitalypb.UnimplementedSSHServiceServer
+	request  *gitalypb.SSHReceivePackRequest
+	stdin    bytes.Buffer
+	exitCode int32
+
+	// Sent before any stdin is read, like git-receive-pack does
+	advertisement string
+}
+
+func (srv *sshServiceServer) SSHReceivePack(s gitalypb.SSHService_SSHReceivePackServer) error {
+	if srv.advertisement != "" {
+		if err := s.Send(&gitalypb.SSHReceivePackResponse{Stdout: []byte(srv.advertisement)}); err != nil {
+			return err
+		}
+	}
+
+	for {
+		req, err := s.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if srv.request == nil {
+			srv.request = req
+		}
+		srv.stdin.Write(req.GetStdin())
+	}
+
+	if err := s.Send(&gitalypb.SSHReceivePackResponse{Stdout: []byte("000eunpack ok\n0000")}); err != nil {
+		return err
+	}
+
+	return s.Send(&gitalypb.SSHReceivePackResponse{ExitStatus: &gitalypb.ExitStatus{Value: srv.exitCode}})
+}
+
+func startSSHServer(t *testing.T, s gitalypb.SSHServiceServer) string {
+	t.Helper()
+
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "gitaly.sock"))
+	require.NoError(t, err)
+
+	srv := grpc.NewServer(testhelper.WithSidechannel())
+	gitalypb.RegisterSSHServiceServer(srv, s)
+	go func() {
+		require.NoError(t, srv.Serve(ln))
+	}()
+
+	t.Cleanup(func() {
+		srv.GracefulStop()
+	})
+
+	return fmt.Sprintf("%s://%s", ln.Addr().Network(), ln.Addr().String())
+}
+
+func TestSSHReceivePack(t *testing.T) {
+	tests := []struct {
+		desc     string
+		exitCode int32
+		fails    bool
+	}{
+		{desc: "accepted"},
+		{desc: "rejected by git", exitCode: 1, fails: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			srv := &sshServiceServer{exitCode: tc.exitCode}
+			a := &api.Response{
+				GL_ID:         "user-1",
+				GL_USERNAME:   "alice",
+				GL_REPOSITORY: "project-1",
+				GitalyServer:  api.GitalyServer{Address: startSSHServer(t, srv)},
+			}
+
+			var err error
+			var bytesOut int64
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, bytesOut, err = handleSSHReceivePack(w, r, a)
+			}))
+			defer ts.Close()
+
+			push := pushRequest("report-status", 100)
+			res, postErr := http.Post(ts.URL+"/ssh-receive-pack", "", strings.NewReader(push))
+			require.NoError(t, postErr)
+			body, readErr := io.ReadAll(res.Body)
+			require.NoError(t, readErr)
+			require.NoError(t, res.Body.Close())
+
+			require.Equal(t, http.StatusOK, res.StatusCode)
+			require.Equal(t, "000eunpack ok\n0000", string(body))
+			require.Equal(t, int64(len(body)), bytesOut)
+			require.Equal(t, push, srv.stdin.String())
+			require.Equal(t, "user-1", srv.request.GetGlId())
+			require.Equal(t, "alice", srv.request.GetGlUsername())
+			require.Equal(t, "project-1", srv.request.GetGlRepository())
+
+			if tc.fails {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestSSHReceivePackMaxPushSize(t *testing.T) {
+	srv := &sshServiceServer{}
+	a := &api.Response{
+		MaxPushSize:  50,
+		GitalyServer: api.GitalyServer{Address: startSSHServer(t, srv)},
+	}
+
+	var err error
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _, err = handleSSHReceivePack(w, r, a)
+	}))
+	defer ts.Close()
+
+	res, postErr := http.Post(ts.URL+"/ssh-receive-pack", "", strings.NewReader(pushRequest("report-status side-band-64k", 1000)))
+	require.NoError(t, postErr)
+	body, readErr := io.ReadAll(res.Body)
+	require.NoError(t, readErr)
+	require.NoError(t, res.Body.Close())
+
+	var tooLarge *pushTooLargeError
+	require.ErrorAs(t, err, &tooLarge)
+	require.Contains(t, string(body), "\x03Your push has been rejected")
+}
+
+func TestSSHReceivePackMaxPushSizeAfterAdvertisement(t *testing.T) {
+	advertisement := pktLines("0000000000000000000000000000000000000000 capabilities^{}\x00report-status side-band-64k\n", "0000")
+	srv := &sshServiceServer{advertisement: advertisement}
+	a := &api.Response{
+		MaxPushSize:  50,
+		GitalyServer: api.GitalyServer{Address: startSSHServer(t, srv)},
+	}
+
+	var err error
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _, err = handleSSHReceivePack(w, r, a)
+	}))
+	defer ts.Close()
+
+	// Like a git client, only send the push once the advertisement arrived
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	res, postErr := http.Post(ts.URL+"/ssh-receive-pack", "", pr)
+	require.NoError(t, postErr)
+	defer res.Body.Close()
+
+	received := make([]byte, len(advertisement))
+	_, readErr := io.ReadFull(res.Body, received)
+	require.NoError(t, readErr)
+	require.Equal(t, advertisement, string(received))
+
+	go func() {
+		_, _ = io.WriteString(pw, pushRequest("report-status side-band-64k", 1000))
+		pw.Close()
+	}()
+
+	body, readErr := io.ReadAll(res.Body)
+	require.NoError(t, readErr)
+
+	var tooLarge *pushTooLargeError
+	require.ErrorAs(t, err, &tooLarge)
+	require.Contains(t, string(body), "\x03Your push has been rejected")
+}
@@ -0,0 +1,473 @@
+/*
+In this file we cache the responses of identical git-upload-pack requests
+*/
+
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"gitlab.com/gitlab-org/gitaly/v16/proto/go/gitalypb"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/config"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/gitaly"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/log"
+)
+
+const uploadPackCacheDirName = "gitlab-workhorse-upload-pack-cache"
+
+var (
+	uploadPackCacheRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_workhorse_git_upload_pack_cache_requests",
+			Help: "How many git-upload-pack requests have been looked up in the response cache, partitioned by result.",
+		},
+		[]string{"result"},
+	)
+
+	uploadPackCacheStores = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_workhorse_git_upload_pack_cache_stores",
+			Help: "How many git-upload-pack responses have been considered for caching, partitioned by result.",
+		},
+		[]string{"result"},
+	)
+
+	uploadPackCacheEvictions = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_workhorse_git_upload_pack_cache_evictions",
+			Help: "How many git-upload-pack responses have been evicted from the cache, partitioned by reason.",
+		},
+		[]string{"reason"},
+	)
+
+	uploadPackCacheBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gitlab_workhorse_git_upload_pack_cache_bytes",
+		Help: "Disk space used by cached git-upload-pack responses.",
+	})
+)
+
+// UploadPackCache stores git-upload-pack responses on local disk, so that
+// identical negotiations, such as many CI jobs cloning the same commit, are
+// answered by Gitaly only once. Concurrent identical requests wait for the
+// first one to finish instead of reaching Gitaly.
+type UploadPackCache struct {
+	cfg config.UploadPackCacheConfig
+	dir string
+
+	mu       sync.Mutex
+	entries  map[string]*list.Element
+	lru      *list.List
+	size     int64
+	inflight map[string]chan struct{}
+
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	// checksum returns a snapshot of the refs of a repository
+	checksum func(ctx context.Context, a *api.Response) (string, error)
+}
+
+type uploadPackCacheEntry struct {
+	key     string
+	path    string
+	size    int64
+	created time.Time
+	stats   *gitalypb.PackfileNegotiationStatistics
+}
+
+// NewUploadPackCache returns a cache configured by cfg, or nil if caching is
+// disabled. Responses cached by previous processes are removed. Expired
+// responses are removed periodically until Close is called.
+func NewUploadPackCache(cfg config.UploadPackCacheConfig) (*UploadPackCache, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	dir := cfg.Dir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	dir = filepath.Join(dir, uploadPackCacheDirName)
+
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, fmt.Errorf("clean upload-pack cache: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("create upload-pack cache: %w", err)
+	}
+
+	uploadPackCacheBytes.Set(0)
+
+	c := &UploadPackCache{
+		cfg:      cfg,
+		dir:      dir,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		inflight: make(map[string]chan struct{}),
+		stop:     make(chan struct{}),
+		checksum: repositoryChecksum,
+	}
+
+	if cfg.TTL.Duration > 0 {
+		go c.sweep(cfg.TTL.Duration)
+	}
+
+	return c, nil
+}
+
+// Close stops removing expired responses.
+func (c *UploadPackCache) Close() {
+	if c == nil {
+		return
+	}
+
+	c.stopOnce.Do(func() { close(c.stop) })
+}
+
+// sweep removes expired responses every interval, so that responses that
+// are never requested again don't keep their disk space until they are
+// evicted by size.
+func (c *UploadPackCache) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.removeExpired()
+		}
+	}
+}
+
+func (c *UploadPackCache) removeExpired() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, el := range c.entries {
+		if c.expired(el.Value.(*uploadPackCacheEntry)) {
+			c.removeLocked(el, "ttl")
+		}
+	}
+}
+
+func (c *UploadPackCache) expired(entry *uploadPackCacheEntry) bool {
+	return c.cfg.TTL.Duration > 0 && time.Since(entry.created) > c.cfg.TTL.Duration
+}
+
+// uploadPack answers a git-upload-pack request from the cache if possible.
+// It returns false if the request cannot be cached, in which case the
+// returned reader must be used in place of body.
+func (c *UploadPackCache) uploadPack(ctx context.Context, a *api.Response, body io.Reader, w io.Writer, gitProtocol string) (bool, io.Reader, *gitalypb.PackfileNegotiationStatistics, error) {
+	request, err := io.ReadAll(io.LimitReader(body, c.cfg.MaxRequestBytes+1))
+	if err != nil {
+		return true, nil, nil, fmt.Errorf("read upload-pack request: %w", err)
+	}
+
+	if int64(len(request)) > c.cfg.MaxRequestBytes {
+		uploadPackCacheRequests.WithLabelValues("bypass").Inc()
+		return false, io.MultiReader(bytes.NewReader(request), body), nil, nil
+	}
+
+	// Computing the checksum costs a Gitaly call, so only do it for requests
+	// whose response could be stored
+	if !c.cacheableRequest(request) {
+		uploadPackCacheRequests.WithLabelValues("bypass").Inc()
+		return false, bytes.NewReader(request), nil, nil
+	}
+
+	checksum, err := c.checksum(ctx, a)
+	if err != nil {
+		// Empty repositories have no checksum and nothing worth caching
+		log.WithContextFields(ctx, log.Fields{"repo": a.GL_REPOSITORY}).WithError(err).Info("upload-pack cache: skipping request")
+		uploadPackCacheRequests.WithLabelValues("bypass").Inc()
+		return false, bytes.NewReader(request), nil, nil
+	}
+
+	key := uploadPackCacheKey(a, gitProtocol, checksum, request)
+	stats, err := c.serve(ctx, key, a, request, w, gitProtocol)
+
+	return true, nil, stats, err
+}
+
+func (c *UploadPackCache) serve(ctx context.Context, key string, a *api.Response, request []byte, w io.Writer, gitProtocol string) (*gitalypb.PackfileNegotiationStatistics, error) {
+	if served, stats, err := c.serveEntry(key, w); served {
+		uploadPackCacheRequests.WithLabelValues("hit").Inc()
+		return stats, err
+	}
+
+	done, leader := c.begin(key)
+	if !leader {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		if served, stats, err := c.serveEntry(key, w); served {
+			uploadPackCacheRequests.WithLabelValues("coalesced").Inc()
+			return stats, err
+		}
+
+		// The response was not worth caching, or the request failed
+		uploadPackCacheRequests.WithLabelValues("miss").Inc()
+		return handleUploadPackWithGitaly(ctx, a, bytes.NewReader(request), w, gitProtocol)
+	}
+	defer c.end(key, done)
+
+	uploadPackCacheRequests.WithLabelValues("miss").Inc()
+
+	f, err := os.CreateTemp(c.dir, "tmp-")
+	if err != nil {
+		return handleUploadPackWithGitaly(ctx, a, bytes.NewReader(request), w, gitProtocol)
+	}
+	defer func() {
+		// A no-op once the file has been stored
+		f.Close()
+		os.Remove(f.Name())
+	}()
+
+	tee := &uploadPackCacheWriter{Writer: w, file: f, max: c.cfg.MaxEntryBytes}
+	stats, err := handleUploadPackWithGitaly(ctx, a, bytes.NewReader(request), tee, gitProtocol)
+	if err != nil {
+		return stats, err
+	}
+
+	if !tee.ok() || !c.worthCaching(stats, tee.n) {
+		uploadPackCacheStores.WithLabelValues("skipped").Inc()
+		return stats, nil
+	}
+
+	if err := f.Close(); err != nil {
+		return stats, nil
+	}
+
+	c.store(&uploadPackCacheEntry{key: key, path: f.Name(), size: tee.n, created: time.Now(), stats: stats})
+	uploadPackCacheStores.WithLabelValues("stored").Inc()
+
+	return stats, nil
+}
+
+// cacheableRequest tells from the wants and haves of a request whether its
+// response could be worth caching, before the request reaches Gitaly.
+func (c *UploadPackCache) cacheableRequest(request []byte) bool {
+	var wants, haves int64
+
+	r := bufio.NewReader(bytes.NewReader(request))
+	for {
+		pkt, err := readPktLine(r)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return false
+		}
+
+		payload := pktLinePayload(pkt)
+		switch {
+		case bytes.HasPrefix(payload, []byte("want ")):
+			wants++
+		case bytes.HasPrefix(payload, []byte("have ")):
+			haves++
+		}
+	}
+
+	return wants > 0 && (c.cfg.MaxHaves <= 0 || haves <= c.cfg.MaxHaves)
+}
+
+// worthCaching decides from the negotiation statistics whether a response
+// is likely to be requested again and expensive enough to generate.
+func (c *UploadPackCache) worthCaching(stats *gitalypb.PackfileNegotiationStatistics, size int64) bool {
+	// Requests without wants, such as ls-refs, do not generate a packfile
+	if stats == nil || stats.GetWants() == 0 {
+		return false
+	}
+
+	// Clients announcing many objects have a local history that other
+	// clients are unlikely to share
+	if c.cfg.MaxHaves > 0 && stats.GetHaves() > c.cfg.MaxHaves {
+		return false
+	}
+
+	return size >= c.cfg.MinResponseBytes
+}
+
+// serveEntry copies a cached response to w. It returns false if there is no
+// fresh entry for the key.
+func (c *UploadPackCache) serveEntry(key string, w io.Writer) (bool, *gitalypb.PackfileNegotiationStatistics, error) {
+	c.mu.Lock()
+	el, ok := c.entries[key]
+	if !ok {
+		c.mu.Unlock()
+		return false, nil, nil
+	}
+
+	entry := el.Value.(*uploadPackCacheEntry)
+	if c.expired(entry) {
+		c.removeLocked(el, "ttl")
+		c.mu.Unlock()
+		return false, nil, nil
+	}
+
+	// The file may be evicted while it is being read. Opening it before
+	// releasing the lock keeps its contents readable until it is closed.
+	f, err := os.Open(entry.path)
+	if err != nil {
+		c.removeLocked(el, "error")
+		c.mu.Unlock()
+		return false, nil, nil
+	}
+	c.lru.MoveToFront(el)
+	c.mu.Unlock()
+
+	defer f.Close()
+
+	if _, err := io.Copy(w, f); err != nil {
+		return true, entry.stats, &copyError{fmt.Errorf("copy cached upload-pack response: %w", err)}
+	}
+
+	return true, entry.stats, nil
+}
+
+// begin registers a request for key. It returns true if the caller is the
+// first one and must generate the response, and otherwise a channel that is
+// closed when the first caller is done.
+func (c *UploadPackCache) begin(key string) (chan struct{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if done, ok := c.inflight[key]; ok {
+		return done, false
+	}
+
+	done := make(chan struct{})
+	c.inflight[key] = done
+
+	return done, true
+}
+
+func (c *UploadPackCache) end(key string, done chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.inflight, key)
+	close(done)
+}
+
+func (c *UploadPackCache) store(entry *uploadPackCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// The replaced entry has the same path, so it must be removed before
+	// the new file takes its place. Readers of the old file keep reading it.
+	if el, ok := c.entries[entry.key]; ok {
+		c.removeLocked(el, "replaced")
+	}
+
+	path := filepath.Join(c.dir, entry.key)
+	if err := os.Rename(entry.path, path); err != nil {
+		return
+	}
+	entry.path = path
+
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.size += entry.size
+
+	for c.cfg.MaxBytes > 0 && c.size > c.cfg.MaxBytes {
+		c.removeLocked(c.lru.Back(), "size")
+	}
+
+	uploadPackCacheBytes.Set(float64(c.size))
+}
+
+func (c *UploadPackCache) removeLocked(el *list.Element, reason string) {
+	entry := el.Value.(*uploadPackCacheEntry)
+
+	c.lru.Remove(el)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+	os.Remove(entry.path)
+
+	uploadPackCacheEvictions.WithLabelValues(reason).Inc()
+	uploadPackCacheBytes.Set(float64(c.size))
+}
+
+// uploadPackCacheKey identifies the response to a request: the same request
+// against the same refs of the same repository yields the same packfile.
+func uploadPackCacheKey(a *api.Response, gitProtocol, checksum string, request []byte) string {
+	h := sha256.New()
+	for _, part := range append([]string{
+		a.Repository.GetStorageName(),
+		a.Repository.GetRelativePath(),
+		gitProtocol,
+		checksum,
+	}, gitConfigOptions(a)...) {
+		fmt.Fprintf(h, "%d:%s\x00", len(part), part)
+	}
+	h.Write(request)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func repositoryChecksum(ctx context.Context, a *api.Response) (string, error) {
+	ctx, client, err := gitaly.NewRepositoryClient(ctx, a.GitalyServer)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := client.CalculateChecksum(ctx, &gitalypb.CalculateChecksumRequest{Repository: &a.Repository})
+	if err != nil {
+		return "", fmt.Errorf("RepositoryService::CalculateChecksum: %w", err)
+	}
+
+	return resp.GetChecksum(), nil
+}
+
+// uploadPackCacheWriter writes a response to the client and a copy of it to
+// a file. Failing to write the copy never fails the client response.
+type uploadPackCacheWriter struct {
+	io.Writer
+
+	file   *os.File
+	n      int64
+	max    int64
+	failed bool
+}
+
+func (w *uploadPackCacheWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	if err != nil {
+		w.failed = true
+		return n, err
+	}
+
+	if !w.failed {
+		if w.max > 0 && w.n+int64(n) > w.max {
+			w.failed = true
+		} else if _, err := w.file.Write(p[:n]); err != nil {
+			w.failed = true
+		}
+	}
+	w.n += int64(n)
+
+	return n, nil
+}
+
+func (w *uploadPackCacheWriter) ok() bool {
+	return !w.failed
+}
@@ -0,0 +1,323 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitaly/v16/client"
+	"gitlab.com/gitlab-org/gitaly/v16/proto/go/gitalypb"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/config"
+)
+
+type fakeUploadPackServer struct {
+	calls    atomic.Int32
+	response string
+	stats    *gitalypb.PackfileNegotiationStatistics
+	release  chan struct{}
+}
+
+func (s *fakeUploadPackServer) start(t *testing.T) string {
+	return startSmartHTTPServer(t, &smartHTTPServiceServer{
+		handler: func(ctx context.Context, _ *gitalypb.PostUploadPackWithSidechannelRequest) (*gitalypb.PostUploadPackWithSidechannelResponse, error) {
+			s.calls.Add(1)
+
+			conn, err := client.OpenServerSidechannel(ctx)
+			if err != nil {
+				return nil, err
+			}
+			defer conn.Close()
+
+			if _, err := io.Copy(io.Discard, conn); err != nil {
+				return nil, err
+			}
+
+			if s.release != nil {
+				<-s.release
+			}
+
+			if _, err := io.WriteString(conn, s.response); err != nil {
+				return nil, err
+			}
+			if err := conn.Close(); err != nil {
+				return nil, err
+			}
+
+			return &gitalypb.PostUploadPackWithSidechannelResponse{PackfileNegotiationStatistics: s.stats}, nil
+		},
+	})
+}
+
+func newTestUploadPackCache(t *testing.T, cfg config.UploadPackCacheConfig, checksum string) *UploadPackCache {
+	cfg.Enabled = true
+	cfg.Dir = t.TempDir()
+
+	cache, err := NewUploadPackCache(cfg)
+	require.NoError(t, err)
+
+	cache.checksum = func(context.Context, *api.Response) (string, error) {
+		return checksum, nil
+	}
+	t.Cleanup(cache.Close)
+
+	return cache
+}
+
+func testCacheConfig() config.UploadPackCacheConfig {
+	cfg := config.DefaultUploadPackCacheConfig
+	cfg.MinResponseBytes = 0
+
+	return cfg
+}
+
+func uploadPackWithCache(t *testing.T, cache *UploadPackCache, addr, body string) (string, *gitalypb.PackfileNegotiationStatistics) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/git-upload-pack", strings.NewReader(body))
+	a := &api.Response{
+		GitalyServer: api.GitalyServer{Address: addr},
+		Repository:   gitalypb.Repository{StorageName: "default", RelativePath: "group/project.git"},
+	}
+
+	stats, err := handleUploadPack(NewHTTPResponseWriter(w), r, a, cache, config.UploadPackPolicyConfig{})
+	require.NoError(t, err)
+
+	return w.Body.String(), stats
+}
+
+func TestNewUploadPackCacheDisabled(t *testing.T) {
+	cache, err := NewUploadPackCache(config.UploadPackCacheConfig{})
+	require.NoError(t, err)
+	require.Nil(t, cache)
+}
+
+func TestUploadPackCacheHit(t *testing.T) {
+	srv := &fakeUploadPackServer{response: "PACK data", stats: &gitalypb.PackfileNegotiationStatistics{Wants: 1}}
+	addr := srv.start(t)
+	cache := newTestUploadPackCache(t, testCacheConfig(), "refs-1")
+
+	for i := 0; i < 3; i++ {
+		body, stats := uploadPackWithCache(t, cache, addr, "000ewant 1a2b\n00000009done\n")
+		require.Equal(t, "PACK data", body)
+		require.Equal(t, int64(1), stats.GetWants())
+	}
+	require.Equal(t, int32(1), srv.calls.Load())
+
+	// Another request is not answered from the cache
+	body, _ := uploadPackWithCache(t, cache, addr, "000ewant 3c4d\n00000009done\n")
+	require.Equal(t, "PACK data", body)
+	require.Equal(t, int32(2), srv.calls.Load())
+
+	// Neither is the same request once refs have changed
+	cache.checksum = func(context.Context, *api.Response) (string, error) { return "refs-2", nil }
+	uploadPackWithCache(t, cache, addr, "000ewant 1a2b\n00000009done\n")
+	require.Equal(t, int32(3), srv.calls.Load())
+}
+
+func TestUploadPackCacheSkipsResponses(t *testing.T) {
+	tests := []struct {
+		desc  string
+		cfg   func(*config.UploadPackCacheConfig)
+		stats *gitalypb.PackfileNegotiationStatistics
+	}{
+		{
+			desc:  "no wants",
+			stats: &gitalypb.PackfileNegotiationStatistics{},
+		},
+		{
+			desc:  "too many haves",
+			cfg:   func(cfg *config.UploadPackCacheConfig) { cfg.MaxHaves = 10 },
+			stats: &gitalypb.PackfileNegotiationStatistics{Wants: 1, Haves: 11},
+		},
+		{
+			desc:  "small response",
+			cfg:   func(cfg *config.UploadPackCacheConfig) { cfg.MinResponseBytes = 1024 },
+			stats: &gitalypb.PackfileNegotiationStatistics{Wants: 1},
+		},
+		{
+			desc:  "large response",
+			cfg:   func(cfg *config.UploadPackCacheConfig) { cfg.MaxEntryBytes = 4 },
+			stats: &gitalypb.PackfileNegotiationStatistics{Wants: 1},
+		},
+		{
+			desc:  "large request",
+			cfg:   func(cfg *config.UploadPackCacheConfig) { cfg.MaxRequestBytes = 8 },
+			stats: &gitalypb.PackfileNegotiationStatistics{Wants: 1},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			srv := &fakeUploadPackServer{response: "PACK data", stats: tc.stats}
+			addr := srv.start(t)
+
+			cfg := testCacheConfig()
+			if tc.cfg != nil {
+				tc.cfg(&cfg)
+			}
+			cache := newTestUploadPackCache(t, cfg, "refs")
+
+			for i := 0; i < 2; i++ {
+				body, _ := uploadPackWithCache(t, cache, addr, "000ewant 1a2b\n00000009done\n")
+				require.Equal(t, "PACK data", body)
+			}
+			require.Equal(t, int32(2), srv.calls.Load())
+		})
+	}
+}
+
+func TestUploadPackCacheSkipsChecksum(t *testing.T) {
+	tests := []struct {
+		desc string
+		body string
+	}{
+		{desc: "no wants", body: "0014command=ls-refs\n0000"},
+		{desc: "too many haves", body: "000ewant 1a2b\n000ehave 3c4d\n000ehave 5e6f\n00000009done\n"},
+		{desc: "malformed request", body: "0032want 1a2b\n"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			srv := &fakeUploadPackServer{response: "PACK data"}
+			addr := srv.start(t)
+
+			cfg := testCacheConfig()
+			cfg.MaxHaves = 1
+			cache := newTestUploadPackCache(t, cfg, "refs")
+
+			var checksums atomic.Int32
+			cache.checksum = func(context.Context, *api.Response) (string, error) {
+				checksums.Add(1)
+				return "refs", nil
+			}
+
+			body, _ := uploadPackWithCache(t, cache, addr, tc.body)
+			require.Equal(t, "PACK data", body)
+			require.Equal(t, int32(1), srv.calls.Load())
+			require.Zero(t, checksums.Load())
+		})
+	}
+}
+
+func TestUploadPackCacheCoalescesRequests(t *testing.T) {
+	srv := &fakeUploadPackServer{
+		response: "PACK data",
+		stats:    &gitalypb.PackfileNegotiationStatistics{Wants: 1},
+		release:  make(chan struct{}),
+	}
+	addr := srv.start(t)
+	cache := newTestUploadPackCache(t, testCacheConfig(), "refs")
+
+	const requests = 5
+
+	var wg sync.WaitGroup
+	bodies := make([]string, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			bodies[i], _ = uploadPackWithCache(t, cache, addr, "000ewant 1a2b\n00000009done\n")
+		}(i)
+	}
+
+	require.Eventually(t, func() bool { return srv.calls.Load() == 1 }, 5*time.Second, 10*time.Millisecond)
+	// Give the other requests time to wait for the first one
+	time.Sleep(50 * time.Millisecond)
+	close(srv.release)
+	wg.Wait()
+
+	require.Equal(t, int32(1), srv.calls.Load())
+	for _, body := range bodies {
+		require.Equal(t, "PACK data", body)
+	}
+}
+
+func TestUploadPackCacheEviction(t *testing.T) {
+	cfg := testCacheConfig()
+	cfg.MaxBytes = 10
+	cfg.TTL = config.TomlDuration{Duration: time.Hour}
+	cache := newTestUploadPackCache(t, cfg, "refs")
+
+	store := func(key, data string) {
+		storeTestEntry(t, cache, key, data, time.Now())
+	}
+
+	serve := func(key string) bool {
+		var buf bytes.Buffer
+		served, _, err := cache.serveEntry(key, &buf)
+		require.NoError(t, err)
+		return served
+	}
+
+	store("a", "aaaa")
+	store("b", "bbbb")
+	require.True(t, serve("a"))
+
+	// "b" is the least recently used entry
+	store("c", "cccc")
+	require.True(t, serve("a"))
+	require.False(t, serve("b"))
+	require.True(t, serve("c"))
+	require.Equal(t, int64(8), cache.size)
+
+	cache.cfg.TTL = config.TomlDuration{Duration: time.Nanosecond}
+	require.False(t, serve("a"))
+	require.Equal(t, int64(4), cache.size)
+}
+
+func storeTestEntry(t *testing.T, cache *UploadPackCache, key, data string, created time.Time) {
+	t.Helper()
+
+	f, err := os.CreateTemp(cache.dir, "tmp-")
+	require.NoError(t, err)
+	_, err = f.WriteString(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	cache.store(&uploadPackCacheEntry{key: key, path: f.Name(), size: int64(len(data)), created: created})
+}
+
+func TestUploadPackCacheReplace(t *testing.T) {
+	cache := newTestUploadPackCache(t, testCacheConfig(), "refs")
+
+	storeTestEntry(t, cache, "a", "old", time.Now())
+	storeTestEntry(t, cache, "a", "new", time.Now())
+
+	var buf bytes.Buffer
+	served, _, err := cache.serveEntry("a", &buf)
+	require.NoError(t, err)
+	require.True(t, served, "the replacing file is kept")
+	require.Equal(t, "new", buf.String())
+	require.Equal(t, int64(3), cache.size)
+}
+
+func TestUploadPackCacheSweep(t *testing.T) {
+	cfg := testCacheConfig()
+	cfg.TTL = config.TomlDuration{Duration: 10 * time.Millisecond}
+	cache := newTestUploadPackCache(t, cfg, "refs")
+
+	storeTestEntry(t, cache, "a", "aaaa", time.Now())
+
+	require.Eventually(t, func() bool {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+
+		return len(cache.entries) == 0 && cache.size == 0
+	}, time.Second, 5*time.Millisecond, "expired entries are removed without being requested")
+
+	_, err := os.Stat(filepath.Join(cache.dir, "a"))
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,239 @@
+/*
+In this file we inspect the negotiation of git-upload-pack requests
+*/
+
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/config"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/log"
+)
+
+// maxNegotiationInspectBytes limits how much of a request is read ahead to
+// inspect it. Clones fit easily; longer requests contain many haves, which
+// makes them fetches.
+const maxNegotiationInspectBytes = 64 * 1024
+
+var (
+	gitUploadPackNegotiations = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_workhorse_git_upload_pack_negotiations",
+			Help: "How many git-upload-pack fetch requests have been inspected by gitlab-workhorse, partitioned by kind, filter, shallowness and agent.",
+		},
+		[]string{"kind", "filter", "shallow", "agent"},
+	)
+
+	gitUploadPackPolicyViolations = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_workhorse_git_upload_pack_policy_violations",
+			Help: "How many git-upload-pack requests have violated an upload-pack policy, partitioned by policy and whether the request was rejected.",
+		},
+		[]string{"policy", "rejected"},
+	)
+)
+
+// uploadPackNegotiation summarizes what a client asks for in a
+// git-upload-pack request.
+type uploadPackNegotiation struct {
+	// command is "fetch" for all protocol v0 and v1 requests
+	command  string
+	wants    int
+	haves    int
+	shallows int
+	filter   string
+	deepen   string
+	done     bool
+	// complete is false if the request was too long to be inspected in full
+	complete bool
+}
+
+// inspectUploadPack parses the pkt-lines of a git-upload-pack request. It
+// returns a reader that yields the complete request, including the part that
+// was read for the inspection.
+func inspectUploadPack(r io.Reader, v2 bool) (*uploadPackNegotiation, io.Reader) {
+	br := bufio.NewReaderSize(r, maxPktLen)
+	var buf bytes.Buffer
+
+	n := &uploadPackNegotiation{}
+	if !v2 {
+		n.command = "fetch"
+	}
+
+	flushes := 0
+	for buf.Len() < maxNegotiationInspectBytes {
+		pkt, err := readPktLine(br)
+		if err != nil {
+			break
+		}
+		buf.Write(pkt)
+
+		if bytes.Equal(pkt, pktFlush) {
+			flushes++
+			// A protocol v2 command ends with a flush. In protocol v0 the
+			// wants end with a flush, and so do the haves of a negotiation
+			// that is not done yet.
+			if v2 || flushes == 2 {
+				n.complete = true
+				break
+			}
+			continue
+		}
+
+		if bytes.Equal(pkt, pktDelim) {
+			continue
+		}
+
+		n.parseLine(strings.TrimSuffix(string(pktLinePayload(pkt)), "\n"))
+		if n.done && !v2 {
+			n.complete = true
+			break
+		}
+	}
+
+	return n, io.MultiReader(&buf, br)
+}
+
+func (n *uploadPackNegotiation) parseLine(line string) {
+	if command, ok := strings.CutPrefix(line, "command="); ok {
+		n.command = command
+		return
+	}
+
+	key, value, _ := strings.Cut(line, " ")
+	switch key {
+	case "want", "want-ref":
+		n.wants++
+	case "have":
+		n.haves++
+	case "shallow":
+		n.shallows++
+	case "filter":
+		n.filter = value
+	case "deepen", "deepen-since", "deepen-not":
+		n.deepen = line
+	case "done":
+		n.done = true
+	}
+}
+
+func (n *uploadPackNegotiation) isFetch() bool {
+	return n.command == "fetch"
+}
+
+// isFullClone returns true if the client asks for all objects reachable from
+// its wants.
+func (n *uploadPackNegotiation) isFullClone() bool {
+	return n.isFetch() && n.complete && n.wants > 0 && n.haves == 0 && n.filter == "" && n.deepen == ""
+}
+
+func (n *uploadPackNegotiation) kind() string {
+	switch {
+	case !n.complete && n.haves == 0:
+		return "unknown"
+	case n.haves == 0:
+		return "clone"
+	default:
+		return "fetch"
+	}
+}
+
+// filterLabel reduces a filter spec to its type, to keep the number of
+// metric labels bounded.
+func filterLabel(filter string) string {
+	switch {
+	case filter == "":
+		return "none"
+	case filter == "blob:none":
+		return "blob:none"
+	case strings.HasPrefix(filter, "blob:limit="):
+		return "blob:limit"
+	case strings.HasPrefix(filter, "tree:"):
+		return "tree"
+	case strings.HasPrefix(filter, "sparse:"):
+		return "sparse"
+	case strings.HasPrefix(filter, "object:type="):
+		return "object:type"
+	case strings.HasPrefix(filter, "combine:"):
+		return "combine"
+	default:
+		return "other"
+	}
+}
+
+func (n *uploadPackNegotiation) observe(ctx context.Context, r *http.Request, a *api.Response) {
+	if !n.isFetch() {
+		return
+	}
+
+	agent := getRequestAgent(r)
+	gitUploadPackNegotiations.WithLabelValues(n.kind(), filterLabel(n.filter), strconv.FormatBool(n.deepen != ""), agent).Inc()
+
+	log.WithContextFields(ctx, log.Fields{
+		"repo":            a.GL_REPOSITORY,
+		"username":        a.GL_USERNAME,
+		"agent":           agent,
+		"wants":           n.wants,
+		"haves":           n.haves,
+		"shallows":        n.shallows,
+		"filter":          n.filter,
+		"deepen":          n.deepen,
+		"kind":            n.kind(),
+		"full_clone":      n.isFullClone(),
+		"repository_size": a.RepositorySize,
+	}).Info("git-upload-pack negotiation")
+}
+
+// uploadPackPolicyError is returned when a request is rejected by an
+// upload-pack policy.
+type uploadPackPolicyError struct {
+	policy string
+}
+
+func (e *uploadPackPolicyError) Error() string {
+	return fmt.Sprintf("upload-pack request violates the %s policy", e.policy)
+}
+
+// checkUploadPackPolicy rejects requests that are not allowed by policy by
+// writing an error that git shows to the user. Violations are only logged
+// unless the policy is enforced.
+func checkUploadPackPolicy(ctx context.Context, w io.Writer, a *api.Response, n *uploadPackNegotiation, policy config.UploadPackPolicyConfig) error {
+	limit := policy.RequireFilterAboveBytes
+	if limit <= 0 || a.RepositorySize <= limit || !n.isFullClone() {
+		return nil
+	}
+
+	const name = "require_filter"
+	gitUploadPackPolicyViolations.WithLabelValues(name, strconv.FormatBool(policy.Enforce)).Inc()
+	log.WithContextFields(ctx, log.Fields{
+		"repo":            a.GL_REPOSITORY,
+		"username":        a.GL_USERNAME,
+		"policy":          name,
+		"enforced":        policy.Enforce,
+		"repository_size": a.RepositorySize,
+	}).Info("git-upload-pack request violates policy")
+
+	if !policy.Enforce {
+		return nil
+	}
+
+	err := &uploadPackPolicyError{policy: name}
+	msg := fmt.Sprintf("ERR This repository is larger than %d bytes and must be cloned with a filter, for example `git clone --filter=blob:none`.\n", limit)
+	if writeErr := writePktLine(w, msg); writeErr != nil {
+		return fmt.Errorf("%w: write error: %v", err, writeErr)
+	}
+
+	return err
+}
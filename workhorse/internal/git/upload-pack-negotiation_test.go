@@ -0,0 +1,159 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/config"
+)
+
+const (
+	wantOID = "1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b"
+	haveOID = "0a9f8e7d6c5b4a3f2e1d0c9b8a7f6e5d4c3b2a1f"
+)
+
+func TestInspectUploadPack(t *testing.T) {
+	tests := []struct {
+		desc      string
+		request   string
+		v2        bool
+		expected  uploadPackNegotiation
+		fullClone bool
+	}{
+		{
+			desc:      "v0 clone",
+			request:   pktLines("want "+wantOID+" multi_ack side-band-64k\n", "0000", "done\n"),
+			expected:  uploadPackNegotiation{command: "fetch", wants: 1, done: true, complete: true},
+			fullClone: true,
+		},
+		{
+			desc:     "v0 partial clone",
+			request:  pktLines("want "+wantOID+" filter\n", "filter blob:none\n", "0000", "done\n"),
+			expected: uploadPackNegotiation{command: "fetch", wants: 1, filter: "blob:none", done: true, complete: true},
+		},
+		{
+			desc:     "v0 shallow clone",
+			request:  pktLines("want "+wantOID+" shallow\n", "deepen 1\n", "0000", "done\n"),
+			expected: uploadPackNegotiation{command: "fetch", wants: 1, deepen: "deepen 1", done: true, complete: true},
+		},
+		{
+			desc:     "v0 negotiation round",
+			request:  pktLines("want "+wantOID+"\n", "0000", "have "+haveOID+"\n", "have "+wantOID+"\n", "0000"),
+			expected: uploadPackNegotiation{command: "fetch", wants: 1, haves: 2, complete: true},
+		},
+		{
+			desc:      "v2 fetch",
+			request:   pktLines("command=fetch\n", "agent=git/2.45.0\n", "0001", "want "+wantOID+"\n", "done\n", "0000"),
+			v2:        true,
+			expected:  uploadPackNegotiation{command: "fetch", wants: 1, done: true, complete: true},
+			fullClone: true,
+		},
+		{
+			desc:     "v2 partial fetch",
+			request:  pktLines("command=fetch\n", "0001", "want "+wantOID+"\n", "have "+haveOID+"\n", "filter tree:0\n", "done\n", "0000"),
+			v2:       true,
+			expected: uploadPackNegotiation{command: "fetch", wants: 1, haves: 1, filter: "tree:0", done: true, complete: true},
+		},
+		{
+			desc:     "v2 ls-refs",
+			request:  pktLines("command=ls-refs\n", "0001", "peel\n", "0000"),
+			v2:       true,
+			expected: uploadPackNegotiation{command: "ls-refs", complete: true},
+		},
+		{
+			desc:     "not pkt-lines",
+			request:  "garbage",
+			expected: uploadPackNegotiation{command: "fetch"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			n, body := inspectUploadPack(strings.NewReader(tc.request), tc.v2)
+
+			require.Equal(t, tc.expected, *n)
+			require.Equal(t, tc.fullClone, n.isFullClone())
+
+			rest, err := io.ReadAll(body)
+			require.NoError(t, err)
+			require.Equal(t, tc.request, string(rest), "request is passed on unchanged")
+		})
+	}
+}
+
+func TestInspectUploadPackLongRequest(t *testing.T) {
+	lines := []string{"want " + wantOID + "\n", "0000"}
+	for i := 0; i < maxNegotiationInspectBytes/40; i++ {
+		lines = append(lines, "have "+haveOID+"\n")
+	}
+	lines = append(lines, "done\n")
+	request := pktLines(lines...)
+
+	n, body := inspectUploadPack(strings.NewReader(request), false)
+
+	require.False(t, n.complete)
+	require.Equal(t, "fetch", n.kind())
+	require.False(t, n.isFullClone())
+
+	rest, err := io.ReadAll(body)
+	require.NoError(t, err)
+	require.Equal(t, request, string(rest))
+}
+
+func TestFilterLabel(t *testing.T) {
+	for filter, label := range map[string]string{
+		"":                         "none",
+		"blob:none":                "blob:none",
+		"blob:limit=1m":            "blob:limit",
+		"tree:0":                   "tree",
+		"sparse:oid=main:.sparse":  "sparse",
+		"object:type=commit":       "object:type",
+		"combine:blob:none+tree:1": "combine",
+		"something-new-in-git-3.0": "other",
+	} {
+		require.Equal(t, label, filterLabel(filter), filter)
+	}
+}
+
+func TestCheckUploadPackPolicy(t *testing.T) {
+	fullClone := &uploadPackNegotiation{command: "fetch", wants: 1, done: true, complete: true}
+	partialClone := &uploadPackNegotiation{command: "fetch", wants: 1, filter: "blob:none", done: true, complete: true}
+	enforced := config.UploadPackPolicyConfig{RequireFilterAboveBytes: 1000, Enforce: true}
+
+	tests := []struct {
+		desc        string
+		size        int64
+		negotiation *uploadPackNegotiation
+		policy      config.UploadPackPolicyConfig
+		rejected    bool
+	}{
+		{desc: "large full clone", size: 1001, negotiation: fullClone, policy: enforced, rejected: true},
+		{desc: "large partial clone", size: 1001, negotiation: partialClone, policy: enforced},
+		{desc: "small full clone", size: 1000, negotiation: fullClone, policy: enforced},
+		{desc: "not enforced", size: 1001, negotiation: fullClone, policy: config.UploadPackPolicyConfig{RequireFilterAboveBytes: 1000}},
+		{desc: "disabled", size: 1001, negotiation: fullClone},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			var w bytes.Buffer
+			err := checkUploadPackPolicy(context.Background(), &w, &api.Response{RepositorySize: tc.size}, tc.negotiation, tc.policy)
+
+			if !tc.rejected {
+				require.NoError(t, err)
+				require.Empty(t, w.String())
+				return
+			}
+
+			var policyErr *uploadPackPolicyError
+			require.ErrorAs(t, err, &policyErr)
+			require.True(t, strings.HasPrefix(w.String()[pktLenSize:], "ERR This repository is larger than 1000 bytes"), w.String())
+		})
+	}
+}
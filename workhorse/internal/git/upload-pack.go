@@ -1,6 +1,7 @@
 package git
 
 import (
+	"bufio"
 	"context"
 	"fmt"
 	"io"
@@ -10,6 +11,7 @@ import (
 	"gitlab.com/gitlab-org/gitaly/v16/proto/go/gitalypb"
 
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/config"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/gitaly"
 )
 
@@ -19,7 +21,7 @@ var (
 
 // Will not return a non-nil error after the response body has been
 // written to.
-func handleUploadPack(w *HTTPResponseWriter, r *http.Request, a *api.Response) (*gitalypb.PackfileNegotiationStatistics, error) {
+func handleUploadPack(w *HTTPResponseWriter, r *http.Request, a *api.Response, cache *UploadPackCache, policy config.UploadPackPolicyConfig) (*gitalypb.PackfileNegotiationStatistics, error) {
 	ctx := r.Context()
 
 	// Prevent the client from holding the connection open indefinitely. A
@@ -32,15 +34,38 @@ func handleUploadPack(w *HTTPResponseWriter, r *http.Request, a *api.Response) (
 	readerCtx, cancel := context.WithTimeout(ctx, uploadPackTimeout)
 	defer cancel()
 
-	limited := newContextReader(readerCtx, r.Body)
-	cr, cw := newWriteAfterReader(limited, w)
-	defer cw.Flush()
+	var body io.Reader = newContextReader(readerCtx, r.Body)
 
 	action := getService(r)
 	writePostRPCHeader(w, action)
 
 	gitProtocol := r.Header.Get("Git-Protocol")
 
+	negotiation, body := inspectUploadPack(body, isProtocolV2(gitProtocol))
+	negotiation.observe(ctx, r, a)
+	if err := checkUploadPackPolicy(ctx, w, a, negotiation, policy); err != nil {
+		return nil, err
+	}
+
+	if bundleURIEnabled(a, gitProtocol) {
+		br := bufio.NewReaderSize(body, maxPktLen)
+		if handled, err := serveBundleURI(w, br, a.BundleURIs); handled {
+			return nil, err
+		}
+		body = br
+	}
+
+	if cache != nil {
+		handled, rest, stats, err := cache.uploadPack(ctx, a, body, w, gitProtocol)
+		if handled {
+			return stats, err
+		}
+		body = rest
+	}
+
+	cr, cw := newWriteAfterReader(body, w)
+	defer cw.Flush()
+
 	return handleUploadPackWithGitaly(ctx, a, cr, cw, gitProtocol)
 }
 
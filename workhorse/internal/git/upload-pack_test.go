@@ -18,6 +18,7 @@ import (
 	"gitlab.com/gitlab-org/gitaly/v16/proto/go/gitalypb"
 
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/config"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/testhelper"
 )
 
@@ -66,7 +67,7 @@ func TestUploadPackTimesOut(t *testing.T) {
 	r := httptest.NewRequest("GET", "/", body)
 	a := &api.Response{GitalyServer: api.GitalyServer{Address: addr}}
 
-	_, err := handleUploadPack(NewHTTPResponseWriter(w), r, a)
+	_, err := handleUploadPack(NewHTTPResponseWriter(w), r, a, nil, config.UploadPackPolicyConfig{})
 	require.True(t, errors.Is(err, context.DeadlineExceeded))
 }
 
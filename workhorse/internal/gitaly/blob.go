@@ -1,11 +1,11 @@
 package gitaly
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"net/http"
-	"strconv"
 
 	"gitlab.com/gitlab-org/gitaly/v16/proto/go/gitalypb"
 	"gitlab.com/gitlab-org/gitaly/v16/streamio"
@@ -15,27 +15,27 @@ type BlobClient struct {
 	gitalypb.BlobServiceClient
 }
 
-func (client *BlobClient) SendBlob(ctx context.Context, w http.ResponseWriter, request *gitalypb.GetBlobRequest) error {
+// OpenBlob starts streaming a blob. It returns a reader for the blob data and
+// the size of the complete blob, which is larger than the data if the request
+// has a limit.
+func (client *BlobClient) OpenBlob(ctx context.Context, request *gitalypb.GetBlobRequest) (io.Reader, int64, error) {
 	c, err := client.GetBlob(ctx, request)
 	if err != nil {
-		return fmt.Errorf("rpc failed: %v", err)
+		return nil, 0, fmt.Errorf("rpc failed: %v", err)
+	}
+
+	first, err := c.Recv()
+	if errors.Is(err, io.EOF) {
+		return &bytes.Reader{}, 0, nil
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("rpc failed: %v", err)
 	}
 
-	firstResponseReceived := false
 	rr := streamio.NewReader(func() ([]byte, error) {
 		resp, err := c.Recv()
-
-		if !firstResponseReceived && err == nil {
-			firstResponseReceived = true
-			w.Header().Set("Content-Length", strconv.FormatInt(resp.GetSize(), 10))
-		}
-
 		return resp.GetData(), err
 	})
 
-	if _, err := io.Copy(w, rr); err != nil {
-		return fmt.Errorf("copy rpc data: %v", err)
-	}
-
-	return nil
+	return io.MultiReader(bytes.NewReader(first.GetData()), rr), first.GetSize(), nil
 }
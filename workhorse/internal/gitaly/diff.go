@@ -2,6 +2,7 @@ package gitaly
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -53,3 +54,45 @@ func (client *DiffClient) SendRawPatch(ctx context.Context, w http.ResponseWrite
 
 	return nil
 }
+
+// StreamCommitDiff calls fn for each changed file of a CommitDiff. Gitaly
+// splits large patches over several messages, which are joined so that fn
+// receives the complete patch of a file.
+func (client *DiffClient) StreamCommitDiff(ctx context.Context, request *gitalypb.CommitDiffRequest, fn func(*gitalypb.CommitDiffResponse) error) error {
+	c, err := client.CommitDiff(ctx, request)
+	if err != nil {
+		return fmt.Errorf("rpc failed: %v", err)
+	}
+
+	var file *gitalypb.CommitDiffResponse
+	for {
+		resp, err := c.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("receive rpc data: %v", err)
+		}
+
+		if file == nil {
+			file = resp
+		} else {
+			file.RawPatchData = append(file.RawPatchData, resp.GetRawPatchData()...)
+		}
+
+		if !resp.GetEndOfPatch() {
+			continue
+		}
+
+		if err := fn(file); err != nil {
+			return err
+		}
+		file = nil
+	}
+
+	if file != nil {
+		return fmt.Errorf("rpc data ended in the middle of a patch")
+	}
+
+	return nil
+}
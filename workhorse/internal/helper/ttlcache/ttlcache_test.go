@@ -0,0 +1,60 @@
+package ttlcache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetPut(t *testing.T) {
+	c := New[string](time.Minute, 10)
+
+	_, ok := c.Get("a")
+	require.False(t, ok)
+
+	c.Put("a", "1")
+	v, ok := c.Get("a")
+	require.True(t, ok)
+	require.Equal(t, "1", v)
+
+	c.Delete("a")
+	_, ok = c.Get("a")
+	require.False(t, ok)
+}
+
+func TestExpiry(t *testing.T) {
+	c := New[int](time.Millisecond, 10)
+
+	c.Put("a", 1)
+	time.Sleep(5 * time.Millisecond)
+
+	_, ok := c.Get("a")
+	require.False(t, ok)
+	require.Zero(t, c.Len(), "expired entries are dropped when read")
+}
+
+func TestMaxEntries(t *testing.T) {
+	c := New[int](time.Minute, 2)
+
+	for i, k := range []string{"a", "b", "c"} {
+		c.Put(k, i)
+	}
+
+	require.Equal(t, 2, c.Len())
+	v, ok := c.Get("c")
+	require.True(t, ok, "the newest entry is kept")
+	require.Equal(t, 2, v)
+}
+
+func TestMaxEntriesDropsExpiredFirst(t *testing.T) {
+	c := New[int](time.Minute, 2)
+	c.Put("a", 1)
+	c.entries["b"] = entry[int]{value: 2, expires: time.Now().Add(-time.Second)}
+
+	c.Put("c", 3)
+
+	_, ok := c.Get("a")
+	require.True(t, ok)
+	require.Equal(t, 2, c.Len())
+}
@@ -0,0 +1,109 @@
+// Package highlight provides syntax highlighting of source code into tokens
+// with the CSS classes used by GitLab.
+package highlight
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/lexers"
+)
+
+// Token is a piece of source code. Code without highlighting has no class.
+type Token struct {
+	Class string `json:"class,omitempty"`
+	Value string `json:"value"`
+}
+
+// supportedLexerLanguages is used for a fast lookup to ensure the language
+// is supported by the lexer library.
+var supportedLexerLanguages = map[string]struct{}{}
+
+func init() {
+	for _, name := range lexers.Names(true) {
+		supportedLexerLanguages[name] = struct{}{}
+	}
+}
+
+// LexerForLanguage returns the lexer of a language, or nil if the language
+// is not supported.
+func LexerForLanguage(language string) chroma.Lexer {
+	// fastpath: bail early if no language specified
+	if language == "" {
+		return nil
+	}
+
+	// fastpath: lexer.Get() will first match against indexed languages by
+	// name and alias, and then fallback to a very slow filepath match. We
+	// avoid this slow path by first checking against languages we know to
+	// be within the index, and bailing if not found.
+	//
+	// Not case-folding immediately is done intentionally. These two lookups
+	// mirror the behavior of lexer.Get().
+	if _, ok := supportedLexerLanguages[language]; !ok {
+		if _, ok := supportedLexerLanguages[strings.ToLower(language)]; !ok {
+			return nil
+		}
+	}
+
+	return lexers.Get(language)
+}
+
+// LexerForFile returns the lexer matching the name of a file, or nil if
+// there is none.
+func LexerForFile(path string) chroma.Lexer {
+	if path == "" {
+		return nil
+	}
+
+	return lexers.Match(filepath.Base(path))
+}
+
+// Tokenize splits source code into lines of tokens. It returns nil if the
+// code cannot be tokenized.
+func Tokenize(lexer chroma.Lexer, source string) [][]Token {
+	iterator, err := lexer.Tokenise(nil, source)
+	if err != nil {
+		return nil
+	}
+
+	var tokenLines [][]Token
+	for _, tokenLine := range chroma.SplitTokensIntoLines(iterator.Tokens()) {
+		var tokens []Token
+		var rawToken string
+		for _, t := range tokenLine {
+			class := classFor(t.Type)
+
+			// accumulate consequent raw values in a single string to store them as
+			// [{ Class: "kd", Value: "func" }, { Value: " main() {" }] instead of
+			// [{ Class: "kd", Value: "func" }, { Value: " " }, { Value: "main" }, { Value: "(" }...]
+			if class == "" {
+				rawToken += t.Value
+			} else {
+				if rawToken != "" {
+					tokens = append(tokens, Token{Value: rawToken})
+					rawToken = ""
+				}
+
+				tokens = append(tokens, Token{Class: class, Value: t.Value})
+			}
+		}
+
+		if rawToken != "" {
+			tokens = append(tokens, Token{Value: rawToken})
+		}
+
+		tokenLines = append(tokenLines, tokens)
+	}
+
+	return tokenLines
+}
+
+func classFor(tokenType chroma.TokenType) string {
+	if strings.HasPrefix(tokenType.String(), "Keyword") || tokenType == chroma.String || tokenType == chroma.Comment {
+		return chroma.StandardTypes[tokenType]
+	}
+
+	return ""
+}
@@ -0,0 +1,30 @@
+package highlight
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLexerForLanguage(t *testing.T) {
+	require.NotNil(t, LexerForLanguage("go"))
+	require.NotNil(t, LexerForLanguage("Go"))
+	require.Nil(t, LexerForLanguage(""))
+	require.Nil(t, LexerForLanguage("no-such-language"))
+}
+
+func TestLexerForFile(t *testing.T) {
+	require.NotNil(t, LexerForFile("cmd/main.go"))
+	require.Nil(t, LexerForFile(""))
+	require.Nil(t, LexerForFile("unknown.extension-without-lexer"))
+}
+
+func TestTokenize(t *testing.T) {
+	tokens := Tokenize(LexerForLanguage("go"), "func main() {\n\treturn\n}")
+
+	require.Equal(t, [][]Token{
+		{{Class: "kd", Value: "func"}, {Value: " main() {\n"}},
+		{{Value: "\t"}, {Class: "k", Value: "return"}, {Value: "\n"}},
+		{{Value: "}"}},
+	}, tokens)
+}
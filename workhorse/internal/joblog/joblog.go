@@ -0,0 +1,343 @@
+// Package joblog streams the logs of CI jobs to browsers as Server-Sent
+// Events, fetching new parts of the log when GitLab notifies a change.
+package joblog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/builds"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/helper/fail"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/notification"
+)
+
+const (
+	// MaxChunkBytes is the maximum size of the log sent in one event
+	MaxChunkBytes = 1024 * 1024
+	// completeHeader is set by GitLab on the log response when the job has
+	// finished and no more log will follow
+	completeHeader = "Gitlab-Workhorse-Job-Log-Complete"
+)
+
+var (
+	// KeepaliveInterval is the interval of comments that keep intervening
+	// proxies from closing idle streams
+	KeepaliveInterval = 30 * time.Second
+	// PollInterval is used instead of notifications when watching fails,
+	// for example because Redis is down, or when the watched key is not
+	// ahead of the log
+	PollInterval = 5 * time.Second
+	// ReauthenticationInterval is how long a stream lasts. Browsers then
+	// reconnect with Last-Event-ID, which authorizes them again.
+	ReauthenticationInterval = 5 * time.Minute
+
+	openStreams = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "gitlab_workhorse_job_log_streams",
+			Help: "The number of open job log event streams",
+		},
+	)
+	fetches = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_workhorse_job_log_fetches_total",
+			Help: "How many times job log streams fetched the log, by what triggered the fetch",
+		},
+		[]string{"trigger"},
+	)
+)
+
+// Handler streams the log described by the JobLog of the authorization
+// response. Each event carries the byte offset after its data as ID, so
+// browsers resume with the Last-Event-ID header when they reconnect.
+func Handler(myAPI *api.API, logHandler http.Handler, watchKeyHandler builds.WatchKeyHandler) http.Handler {
+	return myAPI.PreAuthorizeHandler(func(w http.ResponseWriter, r *http.Request, a *api.Response) {
+		handleStream(w, r, a, logHandler, watchKeyHandler)
+	}, "authorize")
+}
+
+func handleStream(w http.ResponseWriter, r *http.Request, a *api.Response, logHandler http.Handler, watchKeyHandler builds.WatchKeyHandler) {
+	if watchKeyHandler == nil {
+		watchKeyHandler = func(context.Context, string, string, time.Duration) (notification.WatchKeyStatus, error) {
+			return notification.WatchKeyStatusNoChange, errors.New("no notification backend")
+		}
+	}
+
+	if a.JobLog == nil || a.JobLog.LogPath == "" || a.JobLog.WatchKey == "" {
+		fail.Request(w, r, errors.New("job log settings missing"))
+		return
+	}
+
+	logURL, err := url.Parse(a.JobLog.LogPath)
+	if err != nil {
+		fail.Request(w, r, fmt.Errorf("parse job log path: %v", err))
+		return
+	}
+
+	var offset int64
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		offset, err = strconv.ParseInt(id, 10, 64)
+		if err != nil || offset < 0 {
+			fail.Request(w, r, fmt.Errorf("invalid Last-Event-ID: %q", id), fail.WithStatus(http.StatusBadRequest))
+			return
+		}
+	}
+
+	s := &stream{
+		w:               w,
+		r:               r,
+		rc:              http.NewResponseController(w),
+		logURL:          logURL,
+		logHandler:      logHandler,
+		watchKeyHandler: watchKeyHandler,
+		watchKey:        a.JobLog.WatchKey,
+		offset:          offset,
+	}
+
+	openStreams.Inc()
+	defer openStreams.Dec()
+
+	if err := s.serve(); err != nil && r.Context().Err() == nil {
+		log.WithContextFields(r.Context(), log.Fields{"offset": s.offset}).WithError(err).Error("JobLog: stream failed")
+	}
+}
+
+type stream struct {
+	w               http.ResponseWriter
+	r               *http.Request
+	rc              *http.ResponseController
+	logURL          *url.URL
+	logHandler      http.Handler
+	watchKeyHandler builds.WatchKeyHandler
+	watchKey        string
+	offset          int64
+	// pending holds the start of a UTF-8 sequence that continues in the
+	// next part of the log
+	pending []byte
+}
+
+func (s *stream) serve() error {
+	ctx, cancel := context.WithTimeout(s.r.Context(), ReauthenticationInterval)
+	defer cancel()
+
+	s.w.Header().Set("Content-Type", "text/event-stream")
+	s.w.Header().Set("Cache-Control", "no-cache")
+	// Don't let NGINX buffer the events
+	s.w.Header().Set("X-Accel-Buffering", "no")
+	s.w.WriteHeader(http.StatusOK)
+	if err := s.rc.Flush(); err != nil {
+		return err
+	}
+
+	trigger := "start"
+	for {
+		fetches.WithLabelValues(trigger).Inc()
+		start := s.offset
+		complete, err := s.fetch(ctx)
+		if err != nil {
+			return err
+		}
+		if complete {
+			return s.send("complete", "", "null")
+		}
+
+		trigger, err = s.wait(ctx, s.offset > start)
+		if err != nil || trigger == "" {
+			return err
+		}
+	}
+}
+
+// fetch sends the log after s.offset in events of up to MaxChunkBytes,
+// and reports whether the log is complete.
+func (s *stream) fetch(ctx context.Context) (bool, error) {
+	for {
+		chunk, complete, err := s.fetchChunk(ctx)
+		if err != nil {
+			return false, err
+		}
+
+		if err := s.sendChunk(chunk, complete); err != nil {
+			return false, err
+		}
+
+		if complete || len(chunk) < MaxChunkBytes {
+			return complete, nil
+		}
+	}
+}
+
+func (s *stream) fetchChunk(ctx context.Context) ([]byte, bool, error) {
+	req := s.r.Clone(ctx)
+	req.Method = http.MethodGet
+	req.URL.Path = s.logURL.Path
+	req.URL.RawPath = s.logURL.RawPath
+	req.URL.RawQuery = s.logURL.RawQuery
+	req.RequestURI = s.logURL.RequestURI()
+	req.Body = http.NoBody
+	req.ContentLength = 0
+	req.Header.Del("Last-Event-ID")
+	req.Header.Set("Accept", "*/*")
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", s.offset, s.offset+MaxChunkBytes-1))
+
+	rec := &chunkRecorder{header: make(http.Header), skip: s.offset}
+	s.logHandler.ServeHTTP(rec, req)
+
+	switch rec.status {
+	case http.StatusOK, http.StatusPartialContent:
+	case http.StatusRequestedRangeNotSatisfiable:
+		// There is no log after s.offset yet
+		rec.body = nil
+	default:
+		return nil, false, fmt.Errorf("fetch job log: unexpected status %d", rec.status)
+	}
+
+	return rec.body, rec.header.Get(completeHeader) == "true", nil
+}
+
+func (s *stream) sendChunk(chunk []byte, complete bool) error {
+	s.offset += int64(len(chunk))
+
+	data := append(s.pending, chunk...)
+	s.pending = nil
+	if !complete {
+		// Keep an incomplete UTF-8 sequence at the end for the next event
+		for i := 1; i <= utf8.UTFMax && i <= len(data); i++ {
+			if utf8.RuneStart(data[len(data)-i]) {
+				if !utf8.FullRune(data[len(data)-i:]) {
+					s.pending = append([]byte(nil), data[len(data)-i:]...)
+					data = data[:len(data)-i]
+				}
+				break
+			}
+		}
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	// JSON keeps line breaks and carriage returns, which would end SSE data
+	// lines, from splitting the event
+	encoded, err := json.Marshal(string(data))
+	if err != nil {
+		return err
+	}
+
+	return s.send("append", strconv.FormatInt(s.offset-int64(len(s.pending)), 10), string(encoded))
+}
+
+func (s *stream) send(event, id, data string) error {
+	msg := "event: " + event + "\n"
+	if id != "" {
+		msg += "id: " + id + "\n"
+	}
+	msg += "data: " + data + "\n\n"
+
+	if _, err := s.w.Write([]byte(msg)); err != nil {
+		return err
+	}
+
+	return s.rc.Flush()
+}
+
+// wait blocks until the log may have grown, and returns what triggered the
+// next fetch, or an empty string when the stream should end. grew tells
+// whether the last fetch added to the log.
+func (s *stream) wait(ctx context.Context, grew bool) (string, error) {
+	deadline, _ := ctx.Deadline()
+
+	for {
+		timeout := min(KeepaliveInterval, time.Until(deadline))
+		if timeout <= 0 || ctx.Err() != nil {
+			return "", nil
+		}
+
+		status, err := s.watchKeyHandler(ctx, s.watchKey, strconv.FormatInt(s.offset, 10), timeout)
+		if err != nil {
+			log.WithContextFields(ctx, nil).WithError(err).Info("JobLog: watching failed, polling instead")
+			return poll(ctx), nil
+		}
+
+		switch status {
+		case notification.WatchKeyStatusAlreadyChanged:
+			// The key only counts as changed when it is ahead of s.offset. If
+			// the last fetch found nothing new, it is not, for example because
+			// it was never set, and watching it again would return at once.
+			if !grew {
+				return poll(ctx), nil
+			}
+			return "notification", nil
+		case notification.WatchKeyStatusSeenChange:
+			return "notification", nil
+		case notification.WatchKeyStatusTimeout:
+			if _, err := s.w.Write([]byte(": keepalive\n\n")); err != nil {
+				return "", err
+			}
+			if err := s.rc.Flush(); err != nil {
+				return "", err
+			}
+		default:
+			// Workhorse is shutting down
+			return "", nil
+		}
+	}
+}
+
+// poll waits for PollInterval, and returns the trigger of the next fetch,
+// or an empty string when ctx is done.
+func poll(ctx context.Context) string {
+	select {
+	case <-ctx.Done():
+		return ""
+	case <-time.After(PollInterval):
+		return "poll"
+	}
+}
+
+// chunkRecorder keeps up to MaxChunkBytes of a log response. Full responses
+// to the Range request are skipped up to the requested offset.
+type chunkRecorder struct {
+	header http.Header
+	status int
+	skip   int64
+	body   []byte
+}
+
+func (c *chunkRecorder) Header() http.Header {
+	return c.header
+}
+
+func (c *chunkRecorder) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+}
+
+func (c *chunkRecorder) Write(p []byte) (int, error) {
+	c.WriteHeader(http.StatusOK)
+	n := len(p)
+
+	if c.status == http.StatusOK && c.skip > 0 {
+		skipped := min(c.skip, int64(len(p)))
+		c.skip -= skipped
+		p = p[skipped:]
+	}
+
+	// Drop the rest of responses that ignore the Range header
+	c.body = append(c.body, p[:min(len(p), MaxChunkBytes-len(c.body))]...)
+
+	return n, nil
+}
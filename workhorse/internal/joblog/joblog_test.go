@@ -0,0 +1,226 @@
+package joblog
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/builds"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/notification"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/sendfile"
+)
+
+const watchKey = "job_log:1"
+
+type event struct {
+	name string
+	id   string
+	data string
+}
+
+func readEvent(t *testing.T, r *bufio.Reader) event {
+	t.Helper()
+
+	var e event
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+
+		switch {
+		case line == "":
+			if e.name != "" {
+				return e
+			}
+		case strings.HasPrefix(line, "event: "):
+			e.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "id: "):
+			e.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			e.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+// startJobLog serves the log file at path with sendfile, like GitLab does
+// for archived logs, and marks it complete once complete is set.
+func startJobLog(t *testing.T, path string, complete *atomic.Bool, watch builds.WatchKeyHandler) *httptest.Server {
+	rails := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/group/project/-/jobs/1/raw", r.URL.Path)
+		require.Empty(t, r.Header.Get("Last-Event-ID"))
+		if complete.Load() {
+			w.Header().Set("Gitlab-Workhorse-Job-Log-Complete", "true")
+		}
+		w.Header().Set("X-Sendfile", path)
+	})
+
+	a := &api.Response{JobLog: &api.JobLogSettings{LogPath: "/group/project/-/jobs/1/raw", WatchKey: watchKey}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleStream(w, r, a, sendfile.SendFile(rails), watch)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func appendLog(t *testing.T, path string, data string, backend *notification.Memory) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	require.NoError(t, err)
+	_, err = f.WriteString(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	backend.Set(watchKey, strconv.FormatInt(fi.Size(), 10))
+}
+
+func TestStream(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job.log")
+	require.NoError(t, os.WriteFile(path, nil, 0o600))
+
+	backend := notification.NewMemory()
+	defer backend.Shutdown()
+	appendLog(t, path, "Running\r\n", backend)
+
+	complete := &atomic.Bool{}
+	srv := startJobLog(t, path, complete, backend.WatchKey)
+
+	resp, err := http.Get(srv.URL + "/group/project/-/jobs/1/trace.sse")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	r := bufio.NewReader(resp.Body)
+	require.Equal(t, event{name: "append", id: "9", data: `"Running\r\n"`}, readEvent(t, r))
+
+	appendLog(t, path, "Done \xc3", backend)
+	require.Equal(t, event{name: "append", id: "14", data: `"Done "`}, readEvent(t, r), "holds back incomplete UTF-8")
+
+	complete.Store(true)
+	appendLog(t, path, "\xb6\n", backend)
+	require.Equal(t, event{name: "append", id: "17", data: `"ö\n"`}, readEvent(t, r))
+	require.Equal(t, event{name: "complete", data: "null"}, readEvent(t, r))
+}
+
+func TestStreamResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job.log")
+	require.NoError(t, os.WriteFile(path, []byte("Running\nDone\n"), 0o600))
+
+	complete := &atomic.Bool{}
+	complete.Store(true)
+	srv := startJobLog(t, path, complete, nil)
+
+	req, err := http.NewRequest("GET", srv.URL+"/group/project/-/jobs/1/trace.sse", nil)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "8")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	r := bufio.NewReader(resp.Body)
+	require.Equal(t, event{name: "append", id: "13", data: `"Done\n"`}, readEvent(t, r))
+	require.Equal(t, event{name: "complete", data: "null"}, readEvent(t, r))
+
+	req.Header.Set("Last-Event-ID", "-1")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestStreamPollsWithoutNotifications(t *testing.T) {
+	defer func(old time.Duration) { PollInterval = old }(PollInterval)
+	PollInterval = 10 * time.Millisecond
+
+	path := filepath.Join(t.TempDir(), "job.log")
+	require.NoError(t, os.WriteFile(path, []byte("Running\n"), 0o600))
+
+	complete := &atomic.Bool{}
+	srv := startJobLog(t, path, complete, nil)
+
+	resp, err := http.Get(srv.URL + "/group/project/-/jobs/1/trace.sse")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	r := bufio.NewReader(resp.Body)
+	require.Equal(t, event{name: "append", id: "8", data: `"Running\n"`}, readEvent(t, r))
+
+	complete.Store(true)
+	require.Equal(t, event{name: "complete", data: "null"}, readEvent(t, r))
+}
+
+func TestStreamPollsWhenKeyIsBehind(t *testing.T) {
+	defer func(old time.Duration) { PollInterval = old }(PollInterval)
+	defer func(old time.Duration) { ReauthenticationInterval = old }(ReauthenticationInterval)
+	PollInterval = 50 * time.Millisecond
+	ReauthenticationInterval = 275 * time.Millisecond
+
+	var fetched atomic.Int32
+	logHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched.Add(1)
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+	})
+	watch := func(context.Context, string, string, time.Duration) (notification.WatchKeyStatus, error) {
+		return notification.WatchKeyStatusAlreadyChanged, nil
+	}
+
+	a := &api.Response{JobLog: &api.JobLogSettings{LogPath: "/group/project/-/jobs/1/raw", WatchKey: watchKey}}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/group/project/-/jobs/1/trace.sse", nil)
+	handleStream(w, r, a, logHandler, watch)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	// One fetch at the start and at most one per poll until the stream ends
+	require.Greater(t, fetched.Load(), int32(1))
+	require.LessOrEqual(t, fetched.Load(), int32(6))
+}
+
+func TestStreamKeepalive(t *testing.T) {
+	defer func(old time.Duration) { KeepaliveInterval = old }(KeepaliveInterval)
+	KeepaliveInterval = 10 * time.Millisecond
+
+	path := filepath.Join(t.TempDir(), "job.log")
+	require.NoError(t, os.WriteFile(path, nil, 0o600))
+
+	backend := notification.NewMemory()
+	defer backend.Shutdown()
+	backend.Set(watchKey, "0")
+
+	srv := startJobLog(t, path, &atomic.Bool{}, backend.WatchKey)
+
+	resp, err := http.Get(srv.URL + "/group/project/-/jobs/1/trace.sse")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, ": keepalive\n", line)
+}
+
+func TestChunkRecorderSkipsFullResponses(t *testing.T) {
+	rec := &chunkRecorder{header: make(http.Header), skip: 3}
+	_, _ = rec.Write([]byte("ab"))
+	_, _ = rec.Write([]byte("cdef"))
+	require.Equal(t, http.StatusOK, rec.status)
+	require.Equal(t, "def", string(rec.body))
+
+	rec = &chunkRecorder{header: make(http.Header), skip: 3}
+	rec.WriteHeader(http.StatusPartialContent)
+	_, _ = rec.Write([]byte("def"))
+	require.Equal(t, "def", string(rec.body))
+}
@@ -0,0 +1,256 @@
+/*
+In this file we handle caching of LFS batch API responses
+*/
+
+package lfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/config"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/helper/ttlcache"
+)
+
+// maxBatchRequestBytes limits the size of batch requests that are
+// considered for caching. Larger requests are passed on unchanged.
+const maxBatchRequestBytes = 64 * 1024
+
+var lfsBatchCacheRequests = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "gitlab_workhorse_lfs_batch_cache_requests",
+		Help: "How many LFS batch requests have been handled by the gitlab-workhorse batch cache, partitioned by result.",
+	},
+	[]string{"result"},
+)
+
+type batchCacheEntry struct {
+	header  http.Header
+	body    []byte
+	created time.Time
+	// expiring is set when the actions in body expire, so that they need
+	// to be adjusted to the age of the entry
+	expiring bool
+}
+
+type batchCache struct {
+	handler          http.Handler
+	maxResponseBytes int64
+	entries          *ttlcache.Cache[*batchCacheEntry]
+}
+
+// BatchCache caches successful responses to LFS batch download requests
+// for cfg.BatchCacheTTL. Responses are cached per repository and
+// Authorization header, so they are never shared between users. Requests
+// without credentials, and upload requests, are never cached.
+func BatchCache(h http.Handler, cfg config.LfsConfig) http.Handler {
+	if cfg.BatchCacheTTL.Duration <= 0 || cfg.BatchCacheMaxEntries <= 0 {
+		return h
+	}
+
+	return &batchCache{
+		handler:          h,
+		maxResponseBytes: cfg.BatchCacheMaxResponseBytes,
+		entries:          ttlcache.New[*batchCacheEntry](cfg.BatchCacheTTL.Duration, cfg.BatchCacheMaxEntries),
+	}
+}
+
+func (c *batchCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, ok := c.requestKey(r)
+	if !ok {
+		lfsBatchCacheRequests.WithLabelValues("bypass").Inc()
+		c.handler.ServeHTTP(w, r)
+		return
+	}
+
+	if entry, ok := c.entries.Get(key); ok {
+		if body, ok := entry.replayBody(time.Now()); ok {
+			lfsBatchCacheRequests.WithLabelValues("hit").Inc()
+			for k, v := range entry.header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(body)
+			return
+		}
+
+		c.entries.Delete(key)
+	}
+
+	lfsBatchCacheRequests.WithLabelValues("miss").Inc()
+
+	rec := &batchResponseRecorder{ResponseWriter: w, limit: c.maxResponseBytes}
+	c.handler.ServeHTTP(rec, r)
+
+	if rec.status == http.StatusOK && !rec.overflow && w.Header().Get("Set-Cookie") == "" {
+		if entry, ok := newBatchCacheEntry(w.Header(), rec.body.Bytes(), time.Now()); ok {
+			c.entries.Put(key, entry)
+		}
+	}
+}
+
+// newBatchCacheEntry returns the entry for a response captured at created,
+// or false if the response cannot be replayed.
+func newBatchCacheEntry(header http.Header, body []byte, created time.Time) (*batchCacheEntry, bool) {
+	entry := &batchCacheEntry{header: header.Clone(), body: body, created: created}
+
+	// The server sets a new Date, and adjusting the body changes its length
+	entry.header.Del("Date")
+	entry.header.Del("Content-Length")
+
+	if bytes.Contains(body, []byte(`"expires_in"`)) || bytes.Contains(body, []byte(`"expires_at"`)) {
+		// Compressed bodies cannot be adjusted
+		if entry.header.Get("Content-Encoding") != "" {
+			return nil, false
+		}
+
+		entry.expiring = true
+		if _, ok := entry.replayBody(created); !ok {
+			return nil, false
+		}
+	}
+
+	return entry, true
+}
+
+// replayBody returns the body of the entry as of now: expires_in of every
+// action is lowered by the age of the entry. It returns false once an
+// action has expired.
+func (e *batchCacheEntry) replayBody(now time.Time) ([]byte, bool) {
+	if !e.expiring {
+		return e.body, true
+	}
+
+	var response map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(e.body))
+	dec.UseNumber()
+	if err := dec.Decode(&response); err != nil {
+		return nil, false
+	}
+
+	age := int64(math.Ceil(now.Sub(e.created).Seconds()))
+	objects, _ := response["objects"].([]interface{})
+	for _, object := range objects {
+		o, _ := object.(map[string]interface{})
+		actions, _ := o["actions"].(map[string]interface{})
+		for _, action := range actions {
+			a, _ := action.(map[string]interface{})
+			if !adjustExpiry(a, age, now) {
+				return nil, false
+			}
+		}
+	}
+
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(response); err != nil {
+		return nil, false
+	}
+
+	return bytes.TrimSuffix(body.Bytes(), []byte("\n")), true
+}
+
+// adjustExpiry lowers expires_in of action by age seconds. It returns false
+// if the action has expired by now.
+func adjustExpiry(action map[string]interface{}, age int64, now time.Time) bool {
+	if n, ok := action["expires_in"].(json.Number); ok {
+		expiresIn, err := n.Int64()
+		if err != nil || expiresIn-age <= 0 {
+			return false
+		}
+		action["expires_in"] = expiresIn - age
+	}
+
+	if at, ok := action["expires_at"].(string); ok {
+		expiresAt, err := time.Parse(time.RFC3339, at)
+		if err != nil || !now.Before(expiresAt) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// requestKey returns the cache key of r, or false if the response to r
+// must not be cached. The request body is restored for the next handler.
+func (c *batchCache) requestKey(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if r.Method != http.MethodPost || auth == "" {
+		return "", false
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBatchRequestBytes+1))
+	r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	if err != nil || len(body) > maxBatchRequestBytes {
+		return "", false
+	}
+
+	var request struct {
+		Operation string `json:"operation"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil || request.Operation != "download" {
+		return "", false
+	}
+
+	h := sha256.New()
+	for _, part := range []string{r.URL.Path, r.URL.RawQuery, auth, r.Header.Get("Accept"), r.Header.Get("Accept-Encoding"), string(body)} {
+		// Quoting keeps the parts from running into each other
+		_ = json.NewEncoder(h).Encode(part)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// batchResponseRecorder passes a response through while keeping a copy of
+// its body, up to limit bytes.
+type batchResponseRecorder struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	limit    int64
+	overflow bool
+}
+
+func (r *batchResponseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *batchResponseRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+
+	if !r.overflow {
+		if int64(r.body.Len()+len(p)) > r.limit {
+			r.overflow = true
+			r.body.Reset()
+		} else {
+			r.body.Write(p)
+		}
+	}
+
+	return r.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController get the underlying http.ResponseWriter.
+func (r *batchResponseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,211 @@
+package lfs
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/config"
+)
+
+const (
+	batchPath       = "/group/project.git/info/lfs/objects/batch"
+	downloadRequest = `{"operation":"download","objects":[{"oid":"abc","size":1}]}`
+	uploadRequest   = `{"operation":"upload","objects":[{"oid":"abc","size":1}]}`
+)
+
+func batchCacheConfig() config.LfsConfig {
+	return config.LfsConfig{
+		BatchCacheTTL:              config.TomlDuration{Duration: time.Minute},
+		BatchCacheMaxEntries:       2,
+		BatchCacheMaxResponseBytes: 1024,
+	}
+}
+
+// countingBackend answers each request with a response that differs from
+// the previous ones, and checks that the request body arrives intact
+func countingBackend(t *testing.T, status int) (http.Handler, *int) {
+	calls := 0
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(string(body), `{"operation":`))
+
+		w.Header().Set("Content-Type", "application/vnd.git-lfs+json")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"response":%d}`, calls)
+	}), &calls
+}
+
+func batchRequest(body string, auth string) *http.Request {
+	r := httptest.NewRequest("POST", batchPath, strings.NewReader(body))
+	if auth != "" {
+		r.Header.Set("Authorization", auth)
+	}
+	return r
+}
+
+func serveBatch(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestBatchCacheHit(t *testing.T) {
+	backend, calls := countingBackend(t, http.StatusOK)
+	h := BatchCache(backend, batchCacheConfig())
+
+	first := serveBatch(h, batchRequest(downloadRequest, "Basic alice"))
+	second := serveBatch(h, batchRequest(downloadRequest, "Basic alice"))
+
+	require.Equal(t, 1, *calls)
+	require.Equal(t, http.StatusOK, second.Code)
+	require.Equal(t, `{"response":1}`, second.Body.String())
+	require.Equal(t, first.Header().Get("Content-Type"), second.Header().Get("Content-Type"))
+}
+
+func TestBatchCacheBypass(t *testing.T) {
+	tests := []struct {
+		desc   string
+		body   string
+		auth   string
+		status int
+		cfg    func(*config.LfsConfig)
+	}{
+		{desc: "upload", body: uploadRequest, auth: "Basic alice", status: http.StatusOK},
+		{desc: "no credentials", body: downloadRequest, status: http.StatusOK},
+		{desc: "error response", body: downloadRequest, auth: "Basic alice", status: http.StatusNotFound},
+		{desc: "large request", body: `{"operation":"download","padding":"` + strings.Repeat("x", maxBatchRequestBytes) + `"}`, auth: "Basic alice", status: http.StatusOK},
+		{
+			desc: "large response", body: downloadRequest, auth: "Basic alice", status: http.StatusOK,
+			cfg: func(cfg *config.LfsConfig) { cfg.BatchCacheMaxResponseBytes = 5 },
+		},
+		{
+			desc: "disabled", body: downloadRequest, auth: "Basic alice", status: http.StatusOK,
+			cfg: func(cfg *config.LfsConfig) { cfg.BatchCacheTTL.Duration = 0 },
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			cfg := batchCacheConfig()
+			if tc.cfg != nil {
+				tc.cfg(&cfg)
+			}
+
+			backend, calls := countingBackend(t, tc.status)
+			h := BatchCache(backend, cfg)
+
+			serveBatch(h, batchRequest(tc.body, tc.auth))
+			w := serveBatch(h, batchRequest(tc.body, tc.auth))
+
+			require.Equal(t, 2, *calls)
+			require.Equal(t, tc.status, w.Code)
+			require.Equal(t, `{"response":2}`, w.Body.String())
+		})
+	}
+}
+
+func TestBatchCacheIsPerUser(t *testing.T) {
+	backend, calls := countingBackend(t, http.StatusOK)
+	h := BatchCache(backend, batchCacheConfig())
+
+	serveBatch(h, batchRequest(downloadRequest, "Basic alice"))
+	w := serveBatch(h, batchRequest(downloadRequest, "Basic bob"))
+
+	require.Equal(t, 2, *calls)
+	require.Equal(t, `{"response":2}`, w.Body.String())
+}
+
+func TestBatchCacheExpiry(t *testing.T) {
+	cfg := batchCacheConfig()
+	cfg.BatchCacheTTL.Duration = time.Millisecond
+
+	backend, calls := countingBackend(t, http.StatusOK)
+	h := BatchCache(backend, cfg)
+
+	serveBatch(h, batchRequest(downloadRequest, "Basic alice"))
+	time.Sleep(5 * time.Millisecond)
+	serveBatch(h, batchRequest(downloadRequest, "Basic alice"))
+
+	require.Equal(t, 2, *calls)
+}
+
+func TestBatchCacheMaxEntries(t *testing.T) {
+	backend, _ := countingBackend(t, http.StatusOK)
+	h := BatchCache(backend, batchCacheConfig()).(*batchCache)
+
+	for _, user := range []string{"alice", "bob", "carol"} {
+		serveBatch(h, batchRequest(downloadRequest, "Basic "+user))
+	}
+
+	require.Equal(t, 2, h.entries.Len())
+}
+
+func TestBatchCacheDropsDate(t *testing.T) {
+	backend := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Date", "Mon, 01 Jul 2024 00:00:00 GMT")
+		io.WriteString(w, `{"objects":[]}`)
+	})
+	h := BatchCache(backend, batchCacheConfig())
+
+	serveBatch(h, batchRequest(downloadRequest, "Basic alice"))
+	w := serveBatch(h, batchRequest(downloadRequest, "Basic alice"))
+
+	require.Empty(t, w.Header().Get("Date"), "the server sets the date of the replayed response")
+}
+
+func TestBatchCacheEntryReplayBody(t *testing.T) {
+	created := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	body := `{"objects":[{"oid":"abc","actions":{"download":{"href":"https://example.com/a?x=1&y=2","expires_in":60,"expires_at":"2024-07-01T00:02:00Z"}}}]}`
+
+	entry, ok := newBatchCacheEntry(http.Header{"Date": {"x"}, "Content-Length": {"1"}}, []byte(body), created)
+	require.True(t, ok)
+	require.Empty(t, entry.header)
+
+	replayed, ok := entry.replayBody(created.Add(10 * time.Second))
+	require.True(t, ok)
+	require.JSONEq(t, `{"objects":[{"oid":"abc","actions":{"download":{"href":"https://example.com/a?x=1&y=2","expires_in":50,"expires_at":"2024-07-01T00:02:00Z"}}}]}`, string(replayed))
+
+	_, ok = entry.replayBody(created.Add(time.Minute))
+	require.False(t, ok, "expires_in has passed")
+
+	entry, ok = newBatchCacheEntry(http.Header{}, []byte(`{"objects":[{"actions":{"download":{"expires_at":"2024-07-01T00:00:30Z"}}}]}`), created)
+	require.True(t, ok)
+	_, ok = entry.replayBody(created.Add(30 * time.Second))
+	require.False(t, ok, "expires_at has passed")
+
+	_, ok = newBatchCacheEntry(http.Header{}, []byte(`{"objects":[{"actions":{"download":{"expires_in":0}}}]}`), created)
+	require.False(t, ok, "expired responses are not cached")
+
+	_, ok = newBatchCacheEntry(http.Header{"Content-Encoding": {"gzip"}}, []byte(`"expires_in"`), created)
+	require.False(t, ok, "compressed responses are not cached")
+}
+
+func TestBatchCacheRefetchesExpiredActions(t *testing.T) {
+	calls := 0
+	backend := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls++
+		io.WriteString(w, `{"objects":[{"actions":{"download":{"expires_in":60}}}]}`)
+	})
+	h := BatchCache(backend, batchCacheConfig()).(*batchCache)
+
+	serveBatch(h, batchRequest(downloadRequest, "Basic alice"))
+	key, ok := h.requestKey(batchRequest(downloadRequest, "Basic alice"))
+	require.True(t, ok)
+	entry, ok := h.entries.Get(key)
+	require.True(t, ok)
+	entry.created = entry.created.Add(-time.Minute)
+
+	serveBatch(h, batchRequest(downloadRequest, "Basic alice"))
+	require.Equal(t, 2, calls)
+}
@@ -0,0 +1,91 @@
+/*
+Package lfs accelerates Git LFS requests that would otherwise be handled by
+Rails.
+
+In this file we handle LFS object downloads. Rails authorizes the download
+and tells workhorse where the object is stored, after which the object is
+served by the sendfile and sendurl middlewares, which support Range
+requests.
+*/
+package lfs
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/headers"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/helper/fail"
+)
+
+const sendURLPrefix = "send-url:"
+
+var lfsDownloads = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "gitlab_workhorse_lfs_downloads",
+		Help: "How many LFS object downloads have been accelerated by gitlab-workhorse, partitioned by object location.",
+	},
+	[]string{"source"},
+)
+
+// sendURLParams are the parameters of the sendurl injecter used for LFS
+// objects in object storage.
+type sendURLParams struct {
+	URL            string
+	AllowRedirects bool
+}
+
+// Download serves LFS objects after authorizing the request with Rails.
+// The handler must be wrapped by the sendfile and sendurl middlewares.
+func Download(myAPI *api.API) http.Handler {
+	return myAPI.PreAuthorizeHandler(handleDownload, "/authorize")
+}
+
+func handleDownload(w http.ResponseWriter, r *http.Request, a *api.Response) {
+	object := a.LfsObject
+	if object == nil {
+		fail.Request(w, r, fmt.Errorf("lfs download: no object location in authorization response"))
+		return
+	}
+
+	w.Header().Set(headers.ContentTypeHeader, "application/octet-stream")
+
+	switch {
+	case object.Path != "":
+		lfsDownloads.WithLabelValues("file").Inc()
+		w.Header().Set(headers.XSendFileHeader, object.Path)
+	case object.URL != "" && object.Redirect:
+		lfsDownloads.WithLabelValues("redirect").Inc()
+		w.Header().Del(headers.ContentTypeHeader)
+		http.Redirect(w, r, object.URL, http.StatusFound)
+		return
+	case object.URL != "":
+		sendData, err := encodeSendURL(object.URL)
+		if err != nil {
+			fail.Request(w, r, fmt.Errorf("lfs download: %v", err))
+			return
+		}
+
+		lfsDownloads.WithLabelValues("url").Inc()
+		w.Header().Set(headers.GitlabWorkhorseSendDataHeader, sendData)
+	default:
+		fail.Request(w, r, fmt.Errorf("lfs download: object has neither a path nor a URL"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func encodeSendURL(url string) (string, error) {
+	params, err := json.Marshal(sendURLParams{URL: url})
+	if err != nil {
+		return "", err
+	}
+
+	return sendURLPrefix + base64.URLEncoding.EncodeToString(params), nil
+}
@@ -0,0 +1,83 @@
+package lfs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/senddata"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/sendfile"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/sendurl"
+)
+
+const objectContent = "large binary asset"
+
+func downloadHandler(object *api.LfsObject) http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleDownload(w, r, &api.Response{LfsObject: object})
+	})
+
+	return senddata.SendData(sendfile.SendFile(h), sendurl.SendURL)
+}
+
+func TestDownload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "object")
+	require.NoError(t, os.WriteFile(path, []byte(objectContent), 0o600))
+
+	objectStorage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(objectContent))
+	}))
+	defer objectStorage.Close()
+
+	tests := []struct {
+		desc   string
+		object *api.LfsObject
+	}{
+		{desc: "local file", object: &api.LfsObject{Path: path}},
+		{desc: "object storage", object: &api.LfsObject{URL: objectStorage.URL + "/object"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/group/project.git/gitlab-lfs/objects/oid", nil)
+			downloadHandler(tc.object).ServeHTTP(w, r)
+
+			require.Equal(t, http.StatusOK, w.Code)
+			require.Equal(t, objectContent, w.Body.String())
+
+			w = httptest.NewRecorder()
+			r.Header.Set("Range", "bytes=6-11")
+			downloadHandler(tc.object).ServeHTTP(w, r)
+
+			require.Equal(t, http.StatusPartialContent, w.Code)
+			require.Equal(t, "binary", w.Body.String())
+		})
+	}
+}
+
+func TestDownloadRedirect(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/group/project.git/gitlab-lfs/objects/oid", nil)
+	downloadHandler(&api.LfsObject{URL: "https://objects.example.com/object?signature=x", Redirect: true}).ServeHTTP(w, r)
+
+	require.Equal(t, http.StatusFound, w.Code)
+	require.Equal(t, "https://objects.example.com/object?signature=x", w.Header().Get("Location"))
+}
+
+func TestDownloadWithoutLocation(t *testing.T) {
+	for _, object := range []*api.LfsObject{nil, {}} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/group/project.git/gitlab-lfs/objects/oid", nil)
+		downloadHandler(object).ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+	}
+}
@@ -43,6 +43,16 @@ func (c *cache) Entry(id ID, data interface{}) error {
 	return binary.Read(c.file, binary.LittleEndian, data)
 }
 
+// Size returns the size of the cache file on disk
+func (c *cache) Size() int64 {
+	fi, err := c.file.Stat()
+	if err != nil {
+		return 0
+	}
+
+	return fi.Size()
+}
+
 func (c *cache) Close() error {
 	return c.file.Close()
 }
@@ -2,19 +2,14 @@ package parser
 
 import (
 	"encoding/json"
-	"strings"
 	"unicode/utf8"
 
-	"github.com/alecthomas/chroma/v2"
-	"github.com/alecthomas/chroma/v2/lexers"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/highlight"
 )
 
 const maxValueSize = 250
 
-type token struct {
-	Class string `json:"class,omitempty"`
-	Value string `json:"value"`
-}
+type token = highlight.Token
 
 type codeHover struct {
 	TruncatedValue *truncatableString `json:"value,omitempty"`
@@ -28,16 +23,6 @@ type truncatableString struct {
 	Truncated bool
 }
 
-// supportedLexerLanguages is used for a fast lookup to ensure the language
-// is supported by the lexer library.
-var supportedLexerLanguages = map[string]struct{}{}
-
-func init() {
-	for _, name := range lexers.Names(true) {
-		supportedLexerLanguages[name] = struct{}{}
-	}
-}
-
 func (ts *truncatableString) UnmarshalText(b []byte) error {
 	s := 0
 	for i := 0; s < len(b); i++ {
@@ -103,70 +88,10 @@ func newCodeHover(content json.RawMessage) (*codeHover, error) {
 }
 
 func (c *codeHover) setTokens() {
-	// fastpath: bail early if no language specified
-	if c.Language == "" {
-		return
-	}
-
-	// fastpath: lexer.Get() will first match against indexed languages by
-	// name and alias, and then fallback to a very slow filepath match. We
-	// avoid this slow path by first checking against languages we know to
-	// be within the index, and bailing if not found.
-	//
-	// Not case-folding immediately is done intentionally. These two lookups
-	// mirror the behavior of lexer.Get().
-	if _, ok := supportedLexerLanguages[c.Language]; !ok {
-		if _, ok := supportedLexerLanguages[strings.ToLower(c.Language)]; !ok {
-			return
-		}
-	}
-
-	lexer := lexers.Get(c.Language)
+	lexer := highlight.LexerForLanguage(c.Language)
 	if lexer == nil {
 		return
 	}
 
-	iterator, err := lexer.Tokenise(nil, c.TruncatedValue.Value)
-	if err != nil {
-		return
-	}
-
-	var tokenLines [][]token
-	for _, tokenLine := range chroma.SplitTokensIntoLines(iterator.Tokens()) {
-		var tokens []token
-		var rawToken string
-		for _, t := range tokenLine {
-			class := c.classFor(t.Type)
-
-			// accumulate consequent raw values in a single string to store them as
-			// [{ Class: "kd", Value: "func" }, { Value: " main() {" }] instead of
-			// [{ Class: "kd", Value: "func" }, { Value: " " }, { Value: "main" }, { Value: "(" }...]
-			if class == "" {
-				rawToken += t.Value
-			} else {
-				if rawToken != "" {
-					tokens = append(tokens, token{Value: rawToken})
-					rawToken = ""
-				}
-
-				tokens = append(tokens, token{Class: class, Value: t.Value})
-			}
-		}
-
-		if rawToken != "" {
-			tokens = append(tokens, token{Value: rawToken})
-		}
-
-		tokenLines = append(tokenLines, tokens)
-	}
-
-	c.Tokens = tokenLines
-}
-
-func (c *codeHover) classFor(tokenType chroma.TokenType) string {
-	if strings.HasPrefix(tokenType.String(), "Keyword") || tokenType == chroma.String || tokenType == chroma.Comment {
-		return chroma.StandardTypes[tokenType]
-	}
-
-	return ""
+	c.Tokens = highlight.Tokenize(lexer, c.TruncatedValue.Value)
 }
@@ -7,6 +7,8 @@ import (
 	"io"
 	"path/filepath"
 	"strings"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/config"
 )
 
 const maxScanTokenSize = 1024 * 1024
@@ -22,6 +24,8 @@ type Docs struct {
 	Entries   map[ID]string
 	DocRanges map[ID][]ID
 	Ranges    *Ranges
+	Symbols   *Symbols
+	Limits    config.LsifConfig
 }
 
 // Document represents a single document in an LSIF dump
@@ -48,11 +52,17 @@ func NewDocs() (*Docs, error) {
 		return nil, err
 	}
 
+	symbols, err := NewSymbols()
+	if err != nil {
+		return nil, err
+	}
+
 	return &Docs{
 		Root:      "file:///",
 		Entries:   make(map[ID]string),
 		DocRanges: make(map[ID][]ID),
 		Ranges:    ranges,
+		Symbols:   symbols,
 	}, nil
 }
 
@@ -62,13 +72,23 @@ func (d *Docs) Parse(r io.Reader) error {
 	buf := make([]byte, 0, bufio.MaxScanTokenSize)
 	scanner.Buffer(buf, maxScanTokenSize)
 
-	for scanner.Scan() {
+	for lines := 1; scanner.Scan(); lines++ {
 		if err := d.process(scanner.Bytes()); err != nil {
 			return err
 		}
+
+		if lines%cacheCheckInterval == 0 {
+			if err := d.checkCacheLimit(); err != nil {
+				return err
+			}
+		}
 	}
 
-	return scanner.Err()
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return d.checkCacheLimit()
 }
 
 func (d *Docs) process(line []byte) error {
@@ -91,19 +111,41 @@ func (d *Docs) process(line []byte) error {
 			return err
 		}
 	default:
+		if err := d.Symbols.Read(l.Type, line); err != nil {
+			return err
+		}
+
 		return d.Ranges.Read(l.Type, line)
 	}
 
 	return nil
 }
 
+// SetLimits configures the resource limits enforced while parsing
+func (d *Docs) SetLimits(limits config.LsifConfig) {
+	d.Limits = limits
+	d.Ranges.ResultSet.Hovers.MaxBytes = limits.MaxHoverBytes
+}
+
 // Close closes the document parser
 func (d *Docs) Close() error {
-	return d.Ranges.Close()
+	for _, err := range []error{
+		d.Ranges.Close(),
+		d.Symbols.Close(),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // SerializeEntries serializes document entries to a zip writer
 func (d *Docs) SerializeEntries(w *zip.Writer) error {
+	if err := writeFormat(w); err != nil {
+		return err
+	}
+
 	for id, path := range d.Entries {
 		filePath := Lsif + "/" + path + ".json"
 
@@ -115,11 +157,35 @@ func (d *Docs) SerializeEntries(w *zip.Writer) error {
 		if err := d.Ranges.Serialize(f, d.DocRanges[id], d.Entries); err != nil {
 			return err
 		}
+
+		if !d.Symbols.HasSymbols(id) {
+			continue
+		}
+
+		f, err = w.Create(SymbolsDir + "/" + path + ".json")
+		if err != nil {
+			return err
+		}
+
+		if err := d.Symbols.Serialize(f, id, d.Ranges); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+func writeFormat(w *zip.Writer) error {
+	f, err := w.Create(FormatFile)
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(f).Encode(struct {
+		Version int `json:"version"`
+	}{FormatVersion})
+}
+
 func (d *Docs) addMetadata(line []byte) error {
 	var metadata Metadata
 	if err := json.Unmarshal(line, &metadata); err != nil {
@@ -137,6 +203,10 @@ func (d *Docs) addDocument(line []byte) error {
 		return err
 	}
 
+	if err := d.checkDocumentsLimit(); err != nil {
+		return err
+	}
+
 	relativePath, err := filepath.Rel(d.Root, doc.URI)
 	if err != nil {
 		relativePath = doc.URI
@@ -155,5 +225,5 @@ func (d *Docs) addDocRanges(line []byte) error {
 
 	d.DocRanges[docRange.OutV] = append(d.DocRanges[docRange.OutV], docRange.RangeIds...)
 
-	return nil
+	return d.checkRangesLimit(docRange.OutV)
 }
@@ -17,6 +17,9 @@ type Hovers struct {
 	File          *os.File
 	Offsets       *cache
 	CurrentOffset int
+	// MaxBytes limits the size of a single serialized hover. Larger hovers
+	// are dropped. Zero means no limit.
+	MaxBytes int
 }
 
 // RawResult represents the raw result
@@ -110,7 +113,11 @@ func (h *Hovers) addData(line []byte) error {
 		return err
 	}
 
-	codeHovers, err := newCodeHovers(rawData.Result.Contents)
+	return h.addContents(rawData.ID, rawData.Result.Contents)
+}
+
+func (h *Hovers) addContents(id ID, contents json.RawMessage) error {
+	codeHovers, err := newCodeHovers(contents)
 	if err != nil {
 		return err
 	}
@@ -120,6 +127,11 @@ func (h *Hovers) addData(line []byte) error {
 		return err
 	}
 
+	if h.MaxBytes > 0 && len(codeHoversData) > h.MaxBytes {
+		limitsExceeded.WithLabelValues("max_hover_bytes").Inc()
+		return nil
+	}
+
 	n, err := h.File.Write(codeHoversData)
 	if err != nil {
 		return err
@@ -128,7 +140,7 @@ func (h *Hovers) addData(line []byte) error {
 	offset := Offset{At: int32(h.CurrentOffset), Len: int32(n)}
 	h.CurrentOffset += n
 
-	return h.Offsets.SetEntry(rawData.ID, &offset)
+	return h.Offsets.SetEntry(id, &offset)
 }
 
 func (h *Hovers) addHoverRef(line []byte) error {
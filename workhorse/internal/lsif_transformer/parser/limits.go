@@ -0,0 +1,96 @@
+package parser
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// cacheCheckInterval is the number of processed LSIF lines after which the
+// disk usage of the caches is compared against the configured limit.
+const cacheCheckInterval = 10000
+
+var (
+	parseDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gitlab_workhorse_lsif_parse_duration_seconds",
+			Help:    "Time spent parsing LSIF and SCIP indexes. Partitioned by format and result.",
+			Buckets: []float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300},
+		},
+		[]string{"format", "result"},
+	)
+
+	cacheBytes = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "gitlab_workhorse_lsif_cache_bytes",
+			Help:    "Disk space used for intermediate data while processing an LSIF or SCIP index.",
+			Buckets: prometheus.ExponentialBuckets(1024*1024, 4, 8), // 1MB to 16GB
+		},
+	)
+
+	limitsExceeded = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_workhorse_lsif_limits_exceeded_total",
+			Help: "How many LSIF and SCIP indexes were rejected because they exceed a processing limit. Partitioned by limit.",
+		},
+		[]string{"limit"},
+	)
+)
+
+// LimitError is returned when an index exceeds one of the configured
+// processing limits. Its message is meant to be shown to the user.
+type LimitError struct {
+	Limit string
+	Max   int64
+}
+
+func newLimitError(limit string, maxValue int64) *LimitError {
+	limitsExceeded.WithLabelValues(limit).Inc()
+
+	return &LimitError{Limit: limit, Max: maxValue}
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("code intelligence index exceeds the %s limit of %d", e.Limit, e.Max)
+}
+
+func (d *Docs) checkDocumentsLimit() error {
+	if d.Limits.MaxDocuments > 0 && len(d.Entries) >= d.Limits.MaxDocuments {
+		return newLimitError("max_documents", int64(d.Limits.MaxDocuments))
+	}
+
+	return nil
+}
+
+func (d *Docs) checkRangesLimit(docID ID) error {
+	if d.Limits.MaxRangesPerDocument > 0 && len(d.DocRanges[docID]) > d.Limits.MaxRangesPerDocument {
+		return newLimitError("max_ranges_per_document", int64(d.Limits.MaxRangesPerDocument))
+	}
+
+	return nil
+}
+
+func (d *Docs) checkCacheLimit() error {
+	if d.Limits.MaxCacheBytes > 0 && d.CacheBytes() > d.Limits.MaxCacheBytes {
+		return newLimitError("max_cache_bytes", d.Limits.MaxCacheBytes)
+	}
+
+	return nil
+}
+
+// CacheBytes returns the disk space used by the intermediate caches
+func (d *Docs) CacheBytes() int64 {
+	r := d.Ranges
+
+	return r.Cache.Size() +
+		r.References.Items.Size() +
+		r.References.Offsets.Size() +
+		r.Implementations.Items.Size() +
+		r.Implementations.Offsets.Size() +
+		r.ResultSet.Cache.Size() +
+		r.ResultSet.Hovers.Offsets.Size() +
+		int64(r.ResultSet.Hovers.CurrentOffset) +
+		d.Symbols.Offsets.Size() +
+		int64(d.Symbols.CurrentOffset)
+}
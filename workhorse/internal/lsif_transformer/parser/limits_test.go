@@ -0,0 +1,83 @@
+package parser
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/config"
+)
+
+func newLimitedDocs(t *testing.T, limits config.LsifConfig) *Docs {
+	t.Helper()
+
+	d, err := NewDocs()
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+
+	d.SetLimits(limits)
+
+	return d
+}
+
+func TestDocumentsLimit(t *testing.T) {
+	d := newLimitedDocs(t, config.LsifConfig{MaxDocuments: 1})
+
+	data := createLine("2", "document", "file:///a.go")
+	data = append(data, createLine("3", "document", "file:///b.go")...)
+
+	err := d.Parse(bytes.NewReader(data))
+	require.Equal(t, &LimitError{Limit: "max_documents", Max: 1}, err)
+	require.EqualError(t, err, "code intelligence index exceeds the max_documents limit of 1")
+}
+
+func TestRangesPerDocumentLimit(t *testing.T) {
+	d := newLimitedDocs(t, config.LsifConfig{MaxRangesPerDocument: 2})
+
+	data := []byte(`{"id":"5","label":"contains","outV":"1","inVs":["2","3"]}` + "\n")
+	require.NoError(t, d.Parse(bytes.NewReader(data)))
+
+	data = []byte(`{"id":"6","label":"contains","outV":"1","inVs":["4"]}` + "\n")
+	require.Equal(t, &LimitError{Limit: "max_ranges_per_document", Max: 2}, d.Parse(bytes.NewReader(data)))
+}
+
+func TestHoverBytesLimit(t *testing.T) {
+	d := newLimitedDocs(t, config.LsifConfig{MaxHoverBytes: 20})
+
+	data := []byte(`{"id":"2","label":"hoverResult","result":{"contents":["short"]}}` + "\n")
+	data = append(data, []byte(`{"id":"3","label":"hoverResult","result":{"contents":["a much longer hover text"]}}`+"\n")...)
+	require.NoError(t, d.Parse(bytes.NewReader(data)))
+
+	hovers := d.Ranges.ResultSet.Hovers
+	require.JSONEq(t, `[{"value":"short"}]`, string(hovers.For(2)))
+	require.Nil(t, hovers.For(3))
+}
+
+func TestCacheBytesLimit(t *testing.T) {
+	d := newLimitedDocs(t, config.LsifConfig{MaxCacheBytes: 1})
+
+	data := []byte(`{"id":"2","label":"range","start":{"line":1,"character":2}}` + "\n")
+	require.Equal(t, &LimitError{Limit: "max_cache_bytes", Max: 1}, d.Parse(bytes.NewReader(data)))
+}
+
+func TestInputBytesLimit(t *testing.T) {
+	content, err := os.ReadFile("testdata/dump.lsif.zip")
+	require.NoError(t, err)
+
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	require.NoError(t, err)
+	uncompressed := int64(zr.File[0].UncompressedSize64)
+
+	for _, limit := range []int64{int64(len(content)) - 1, uncompressed - 1} {
+		_, err = NewParser(context.Background(), bytes.NewReader(content), config.LsifConfig{MaxInputBytes: limit})
+		require.Equal(t, &LimitError{Limit: "max_input_bytes", Max: limit}, err)
+	}
+
+	p, err := NewParser(context.Background(), bytes.NewReader(content), config.LsifConfig{MaxInputBytes: uncompressed})
+	require.NoError(t, err)
+	require.NoError(t, p.Close())
+}
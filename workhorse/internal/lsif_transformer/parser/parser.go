@@ -3,81 +3,178 @@ package parser
 
 import (
 	"archive/zip"
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"time"
 
 	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/config"
 )
 
 var (
 	// Lsif contains the lsif string name
 	Lsif = "lsif"
+
+	// SymbolsDir contains the name of the directory of document symbol entries
+	SymbolsDir = "symbols"
+)
+
+const (
+	// FormatVersion is the version of the output format. It is bumped
+	// whenever new optional fields or files are added, so that readers can
+	// tell which data to expect. Version 1 archives have no FormatFile.
+	//
+	// Version 2 adds implementations and type definitions to ranges.
+	// Version 3 adds the document symbols of each file to the SymbolsDir
+	// directory.
+	FormatVersion = 3
+
+	// FormatFile is the name of the output zip entry that records the
+	// FormatVersion.
+	FormatFile = "format.json"
 )
 
-// Parser is responsible for parsing LSIF data
+// Parser is responsible for parsing LSIF data. SCIP indexes are converted
+// to the same output format.
 type Parser struct {
 	Docs *Docs
 
 	pr *io.PipeReader
 }
 
-// NewParser creates a new Parser instance and initializes it with the provided reader
-func NewParser(ctx context.Context, r io.Reader) (io.ReadCloser, error) {
+// NewParser creates a new Parser instance and initializes it with the provided reader.
+// The index is rejected with a LimitError when it exceeds any of the limits.
+func NewParser(ctx context.Context, r io.Reader, limits config.LsifConfig) (io.ReadCloser, error) {
+	start := time.Now()
+
 	docs, err := NewDocs()
 	if err != nil {
 		return nil, err
 	}
+	docs.SetLimits(limits)
+
+	format, err := parse(ctx, docs, r)
+	if err != nil {
+		parseDuration.WithLabelValues(format, "error").Observe(time.Since(start).Seconds())
+		_ = docs.Close()
+		return nil, err
+	}
+
+	size := docs.CacheBytes()
+	parseDuration.WithLabelValues(format, "success").Observe(time.Since(start).Seconds())
+	cacheBytes.Observe(float64(size))
+	log.WithContextFields(ctx, log.Fields{
+		"lsif_index_format": format,
+		"lsif_cache_bytes":  size,
+		"lsif_documents":    len(docs.Entries),
+		"lsif_parse_time_s": time.Since(start).Seconds(),
+	}).Print("parsed code intelligence index")
+
+	pr, pw := io.Pipe()
+	parser := &Parser{
+		Docs: docs,
+		pr:   pr,
+	}
+
+	go func() { _ = parser.transform(pw) }()
 
+	return parser, nil
+}
+
+func parse(ctx context.Context, docs *Docs, r io.Reader) (string, error) {
 	// ZIP files need to be seekable. Don't hold it all in RAM, use a tempfile
 	tempFile, err := os.CreateTemp("", Lsif)
 	if err != nil {
-		return nil, err
+		return Lsif, err
 	}
 
 	defer func() { _ = tempFile.Close() }()
 
 	if osRemoveErr := os.Remove(tempFile.Name()); osRemoveErr != nil {
-		return nil, osRemoveErr
+		return Lsif, osRemoveErr
 	}
 
-	size, err := io.Copy(tempFile, r)
+	size, err := io.Copy(tempFile, limitReader(r, docs.Limits.MaxInputBytes))
 	if err != nil {
-		return nil, err
+		return Lsif, err
 	}
 	log.WithContextFields(ctx, log.Fields{"lsif_zip_cache_bytes": size}).Print("cached incoming LSIF zip on disk")
 
+	if err := checkInputLimit(size, docs.Limits.MaxInputBytes); err != nil {
+		return Lsif, err
+	}
+
 	zr, err := zip.NewReader(tempFile, size)
 	if err != nil {
-		return nil, err
+		return Lsif, err
 	}
 
 	if len(zr.File) == 0 {
-		return nil, errors.New("empty zip file")
+		return Lsif, errors.New("empty zip file")
+	}
+
+	if err := checkInputLimit(int64(zr.File[0].UncompressedSize64), docs.Limits.MaxInputBytes); err != nil {
+		return Lsif, err
 	}
 
 	file, err := zr.File[0].Open()
 	if err != nil {
-		return nil, err
+		return Lsif, err
 	}
 
 	defer func() { _ = file.Close() }()
 
-	if err := docs.Parse(file); err != nil {
-		return nil, err
+	// The uncompressed size in the zip header is not trustworthy, so the
+	// decompressed stream is limited as well.
+	counter := &countingReader{r: limitReader(file, docs.Limits.MaxInputBytes)}
+	br := bufio.NewReader(counter)
+	format := Lsif
+	if IsSCIP(zr.File[0].Name, br) {
+		format = "scip"
+		err = docs.ParseSCIP(br)
+	} else {
+		err = docs.Parse(br)
 	}
 
-	pr, pw := io.Pipe()
-	parser := &Parser{
-		Docs: docs,
-		pr:   pr,
+	if limitErr := checkInputLimit(counter.n, docs.Limits.MaxInputBytes); limitErr != nil {
+		return format, limitErr
 	}
 
-	go func() { _ = parser.transform(pw) }()
+	return format, err
+}
 
-	return parser, nil
+// limitReader returns a reader that reads at most one byte more than
+// limit, so that exceeding the limit can be detected.
+func limitReader(r io.Reader, limit int64) io.Reader {
+	if limit <= 0 {
+		return r
+	}
+
+	return io.LimitReader(r, limit+1)
+}
+
+func checkInputLimit(size, limit int64) error {
+	if limit > 0 && size > limit {
+		return newLimitError("max_input_bytes", limit)
+	}
+
+	return nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
 }
 
 // Read reads data from the parser's pipe reader
@@ -11,6 +11,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/config"
 )
 
 func TestGenerate(t *testing.T) {
@@ -22,6 +24,10 @@ func TestGenerate(t *testing.T) {
 
 	verifyCorrectnessOf(t, tmpDir, "lsif/main.go.json")
 	verifyCorrectnessOf(t, tmpDir, "lsif/morestrings/reverse.go.json")
+
+	format, err := os.ReadFile(filepath.Join(tmpDir, FormatFile))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"version":3}`, string(format))
 }
 
 func verifyCorrectnessOf(t *testing.T, tmpDir, fileName string) {
@@ -42,7 +48,7 @@ func createFiles(t *testing.T, filePath, tmpDir string) {
 	file, err := os.Open(filePath)
 	require.NoError(t, err)
 
-	parser, err := NewParser(context.Background(), file)
+	parser, err := NewParser(context.Background(), file, config.LsifConfig{})
 	require.NoError(t, err)
 
 	zipFileName := tmpDir + ".zip"
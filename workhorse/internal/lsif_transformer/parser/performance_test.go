@@ -8,6 +8,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/config"
 )
 
 func BenchmarkGenerate(b *testing.B) {
@@ -21,7 +23,7 @@ func BenchmarkGenerate(b *testing.B) {
 			file, err := os.Open(filePath)
 			require.NoError(b, err)
 
-			parser, err := NewParser(context.Background(), file)
+			parser, err := NewParser(context.Background(), file, config.LsifConfig{})
 			require.NoError(b, err)
 
 			_, err = io.Copy(io.Discard, parser)
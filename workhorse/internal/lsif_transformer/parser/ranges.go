@@ -9,10 +9,12 @@ import (
 
 // Ranges represents a collection of range data
 type Ranges struct {
-	DefRefs    map[ID]Item
-	References *References
-	ResultSet  *ResultSet
-	Cache      *cache
+	DefRefs         map[ID]Item
+	TypeDefRefs     map[ID]Item
+	References      *References
+	Implementations *References
+	ResultSet       *ResultSet
+	Cache           *cache
 }
 
 // RawRange represents a raw range with an ID and start position
@@ -43,12 +45,16 @@ type Item struct {
 }
 
 // SerializedRange represents a serialized range
+// Fields added after FormatVersion 1 are optional so that older readers
+// can ignore them.
 type SerializedRange struct {
-	StartLine      int32                 `json:"start_line"`
-	StartChar      int32                 `json:"start_char"`
-	DefinitionPath string                `json:"definition_path,omitempty"`
-	Hover          json.RawMessage       `json:"hover"`
-	References     []SerializedReference `json:"references,omitempty"`
+	StartLine          int32                 `json:"start_line"`
+	StartChar          int32                 `json:"start_char"`
+	DefinitionPath     string                `json:"definition_path,omitempty"`
+	Hover              json.RawMessage       `json:"hover"`
+	References         []SerializedReference `json:"references,omitempty"`
+	TypeDefinitionPath string                `json:"type_definition_path,omitempty"`
+	Implementations    []SerializedReference `json:"implementations,omitempty"`
 }
 
 // NewRanges creates a new instance of Ranges
@@ -63,16 +69,23 @@ func NewRanges() (*Ranges, error) {
 		return nil, err
 	}
 
+	implementations, err := NewReferences()
+	if err != nil {
+		return nil, err
+	}
+
 	cache, err := newCache("ranges", Range{})
 	if err != nil {
 		return nil, err
 	}
 
 	return &Ranges{
-		DefRefs:    make(map[ID]Item),
-		References: references,
-		Cache:      cache,
-		ResultSet:  resultSet,
+		DefRefs:         make(map[ID]Item),
+		TypeDefRefs:     make(map[ID]Item),
+		References:      references,
+		Implementations: implementations,
+		Cache:           cache,
+		ResultSet:       resultSet,
 	}, nil
 }
 
@@ -115,6 +128,9 @@ func (r *Ranges) Serialize(f io.Writer, rangeIds []ID, docs map[ID]string) error
 			DefinitionPath: r.definitionPathFor(docs, entry.ResultSetID),
 			Hover:          r.ResultSet.Hovers.For(entry.ResultSetID),
 			References:     r.References.For(docs, entry.ResultSetID),
+
+			TypeDefinitionPath: r.typeDefinitionPathFor(docs, entry.ResultSetID),
+			Implementations:    r.Implementations.For(docs, entry.ResultSetID),
 		}
 		if err := encoder.Encode(serializedRange); err != nil {
 			return err
@@ -138,6 +154,7 @@ func (r *Ranges) Close() error {
 	for _, err := range []error{
 		r.Cache.Close(),
 		r.References.Close(),
+		r.Implementations.Close(),
 		r.ResultSet.Close(),
 	} {
 		if err != nil {
@@ -148,14 +165,20 @@ func (r *Ranges) Close() error {
 }
 
 func (r *Ranges) definitionPathFor(docs map[ID]string, refID ID) string {
-	defRef, ok := r.DefRefs[refID]
+	return pathFor(docs, r.DefRefs, refID)
+}
+
+func (r *Ranges) typeDefinitionPathFor(docs map[ID]string, refID ID) string {
+	return pathFor(docs, r.TypeDefRefs, refID)
+}
+
+func pathFor(docs map[ID]string, refs map[ID]Item, refID ID) string {
+	ref, ok := refs[refID]
 	if !ok {
 		return ""
 	}
 
-	defPath := docs[defRef.DocID] + "#L" + strconv.Itoa(int(defRef.Line))
-
-	return defPath
+	return docs[ref.DocID] + "#L" + strconv.Itoa(int(ref.Line))
 }
 
 func (r *Ranges) addRange(line []byte) error {
@@ -182,6 +205,10 @@ func (r *Ranges) addItem(line []byte) error {
 		return nil
 	}
 
+	if resultSetRef.IsImplementation() || resultSetRef.IsTypeDefinition() {
+		return r.addRelatedItems(rawItem, resultSetRef)
+	}
+
 	var references []Item
 	for _, rangeID := range rawItem.RangeIds {
 		rg, err := r.getRange(rangeID)
@@ -219,6 +246,32 @@ func (r *Ranges) addItem(line []byte) error {
 	return nil
 }
 
+// addRelatedItems stores the locations of the implementations or the type
+// definition of a result set. Unlike definitions and references, these ranges
+// belong to other result sets, so they are left untouched.
+func (r *Ranges) addRelatedItems(rawItem RawItem, resultSetRef *ResultSetRef) error {
+	var items []Item
+	for _, rangeID := range rawItem.RangeIds {
+		rg, err := r.getRange(rangeID)
+		if err != nil {
+			break
+		}
+
+		items = append(items, Item{Line: rg.Line + 1, DocID: rawItem.DocID})
+	}
+
+	if len(items) == 0 {
+		return nil
+	}
+
+	if resultSetRef.IsTypeDefinition() {
+		r.TypeDefRefs[resultSetRef.ID] = items[0]
+		return nil
+	}
+
+	return r.Implementations.Store(resultSetRef.ID, items)
+}
+
 func (r *Ranges) getRange(rangeID ID) (*Range, error) {
 	var rg Range
 	if err := r.Cache.Entry(rangeID, &rg); err != nil {
@@ -39,6 +39,35 @@ func TestSerialize(t *testing.T) {
 	require.Equal(t, want, buf.String())
 }
 
+func TestSerializeRelatedResults(t *testing.T) {
+	r := setup(t)
+
+	require.NoError(t, r.Read("range", []byte(`{"id":20,"label":"range","start":{"line":10,"character":1}}`)))
+	require.NoError(t, r.Read("range", []byte(`{"id":21,"label":"range","start":{"line":20,"character":1}}`)))
+	require.NoError(t, r.Read("implementationResult", []byte(`{"id":22,"label":"implementationResult"}`)))
+	require.NoError(t, r.Read("typeDefinitionResult", []byte(`{"id":23,"label":"typeDefinitionResult"}`)))
+
+	require.NoError(t, r.Read("textDocument/implementation", []byte(`{"id":24,"label":"textDocument/implementation","outV":"4","inV":22}`)))
+	require.NoError(t, r.Read("textDocument/typeDefinition", []byte(`{"id":25,"label":"textDocument/typeDefinition","outV":"4","inV":23}`)))
+
+	require.NoError(t, r.Read("item", []byte(`{"id":26,"label":"item","outV":22,"inVs":[20],"document":"7"}`)))
+	require.NoError(t, r.Read("item", []byte(`{"id":27,"label":"item","outV":23,"inVs":[21],"document":"6"}`)))
+
+	// Ranges of related results keep pointing to their own result sets
+	rg, err := r.getRange(20)
+	require.NoError(t, err)
+	require.Equal(t, ID(0), rg.ResultSetID)
+
+	docs := map[ID]string{6: "def-path", 7: "ref-path"}
+
+	var buf bytes.Buffer
+	require.NoError(t, r.Serialize(&buf, []ID{1}, docs))
+
+	want := `[{"start_line":1,"start_char":2,"definition_path":"def-path#L2","hover":null,"references":[{"path":"ref-path#L6"},{"path":"ref-path#L8"}],` +
+		`"type_definition_path":"def-path#L21","implementations":[{"path":"ref-path#L11"}]}` + "\n]"
+	require.Equal(t, want, buf.String())
+}
+
 func setup(t *testing.T) *Ranges {
 	r, err := NewRanges()
 	require.NoError(t, err)
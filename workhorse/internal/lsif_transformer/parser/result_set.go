@@ -13,6 +13,12 @@ const (
 
 	// ReferencesProp represents a references property
 	ReferencesProp
+
+	// ImplementationProp represents an implementation property
+	ImplementationProp
+
+	// TypeDefinitionProp represents a type definition property
+	TypeDefinitionProp
 )
 
 // ResultSet represents a set of results, including hover information and a cache
@@ -62,6 +68,14 @@ func (r *ResultSet) Read(label string, line []byte) error {
 		if err := r.addResultSetRef(line, DefinitionProp); err != nil {
 			return err
 		}
+	case "textDocument/implementation":
+		if err := r.addResultSetRef(line, ImplementationProp); err != nil {
+			return err
+		}
+	case "textDocument/typeDefinition":
+		if err := r.addResultSetRef(line, TypeDefinitionProp); err != nil {
+			return err
+		}
 	default:
 		return r.Hovers.Read(label, line)
 	}
@@ -110,3 +124,13 @@ func (r *ResultSet) addResultSetRef(line []byte, property Property) error {
 func (r *ResultSetRef) IsDefinition() bool {
 	return r.Property == DefinitionProp
 }
+
+// IsImplementation checks if the ResultSetRef is an implementation
+func (r *ResultSetRef) IsImplementation() bool {
+	return r.Property == ImplementationProp
+}
+
+// IsTypeDefinition checks if the ResultSetRef is a type definition
+func (r *ResultSetRef) IsTypeDefinition() bool {
+	return r.Property == TypeDefinitionProp
+}
@@ -37,6 +37,27 @@ func TestResultSetRefById(t *testing.T) {
 	require.NoError(t, r.Close())
 }
 
+func TestResultSetReadRelatedResults(t *testing.T) {
+	r := setupResultSet(t)
+
+	require.NoError(t, r.Read("textDocument/implementation", []byte(`{"id":8,"label":"textDocument/implementation","outV":"1","inV":6}`)))
+	require.NoError(t, r.Read("textDocument/typeDefinition", []byte(`{"id":9,"label":"textDocument/typeDefinition","outV":"1","inV":7}`)))
+
+	ref, err := r.RefByID(6)
+	require.NoError(t, err)
+	require.Equal(t, &ResultSetRef{ID: 1, Property: ImplementationProp}, ref)
+	require.True(t, ref.IsImplementation())
+	require.False(t, ref.IsDefinition())
+
+	ref, err = r.RefByID(7)
+	require.NoError(t, err)
+	require.Equal(t, &ResultSetRef{ID: 1, Property: TypeDefinitionProp}, ref)
+	require.True(t, ref.IsTypeDefinition())
+	require.False(t, ref.IsImplementation())
+
+	require.NoError(t, r.Close())
+}
+
 func setupResultSet(t *testing.T) *ResultSet {
 	r, err := NewResultSet()
 	require.NoError(t, err)
@@ -0,0 +1,654 @@
+package parser
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// SCIP (https://github.com/sourcegraph/scip) field numbers of the messages
+// the transformer reads. Everything else in the index is skipped.
+const (
+	scipIndexMetadata        protowire.Number = 1
+	scipIndexDocuments       protowire.Number = 2
+	scipIndexExternalSymbols protowire.Number = 3
+
+	scipDocumentRelativePath protowire.Number = 1
+	scipDocumentOccurrences  protowire.Number = 2
+	scipDocumentSymbols      protowire.Number = 3
+	scipDocumentLanguage     protowire.Number = 4
+	scipDocumentText         protowire.Number = 5
+
+	scipOccurrenceRange       protowire.Number = 1
+	scipOccurrenceSymbol      protowire.Number = 2
+	scipOccurrenceSymbolRoles protowire.Number = 3
+
+	scipSymbolInformationSymbol                 protowire.Number = 1
+	scipSymbolInformationDocumentation          protowire.Number = 3
+	scipSymbolInformationRelationships          protowire.Number = 4
+	scipSymbolInformationSignatureDocumentation protowire.Number = 7
+
+	scipRelationshipSymbol           protowire.Number = 1
+	scipRelationshipIsImplementation protowire.Number = 3
+	scipRelationshipIsTypeDefinition protowire.Number = 4
+
+	scipSymbolRoleDefinition int32 = 0x1
+
+	// maxSCIPMessageSize limits the size of a single top-level message, such
+	// as a document, that is held in memory while decoding the index.
+	maxSCIPMessageSize = 256 * 1024 * 1024
+)
+
+var (
+	errSCIPMalformed = errors.New("scip: malformed index")
+
+	markdownCodeBlockRegex = regexp.MustCompile("(?s)\\A\\s*```([\\w+#.-]*)\\n(.*?)\\n?```\\s*\\z")
+)
+
+type scipOccurrence struct {
+	Range       []int32
+	Symbol      string
+	SymbolRoles int32
+}
+
+type scipRelationship struct {
+	Symbol           string
+	IsImplementation bool
+	IsTypeDefinition bool
+}
+
+type scipSymbolInformation struct {
+	Symbol            string
+	Documentation     []string
+	SignatureLanguage string
+	SignatureText     string
+	Relationships     []scipRelationship
+}
+
+type scipDocument struct {
+	RelativePath string
+	Language     string
+	Occurrences  []scipOccurrence
+	Symbols      []scipSymbolInformation
+}
+
+// scipIndex converts SCIP documents into the same intermediate structures
+// that are built when parsing LSIF, so that both formats are serialized by
+// Docs.SerializeEntries.
+type scipIndex struct {
+	docs    *Docs
+	symbols map[string]ID
+	nextID  ID
+
+	// implementations and typeDefinitions map a result set to the result
+	// sets it is related to. They are resolved once all documents are read
+	// because definitions may appear in any document.
+	implementations map[ID][]ID
+	typeDefinitions map[ID]ID
+}
+
+// IsSCIP reports whether the buffered reader contains a SCIP protobuf index
+// rather than LSIF JSON lines. An LSIF dump always starts with a JSON object,
+// while a SCIP index starts with a length-delimited protobuf field.
+func IsSCIP(filename string, br *bufio.Reader) bool {
+	if strings.HasSuffix(filename, ".scip") {
+		return true
+	}
+
+	b, err := br.Peek(1)
+	if err != nil {
+		return false
+	}
+
+	num, typ := protowire.DecodeTag(uint64(b[0]))
+	return typ == protowire.BytesType && num >= scipIndexMetadata && num <= scipIndexExternalSymbols
+}
+
+// ParseSCIP reads and processes a SCIP index from the provided reader
+func (d *Docs) ParseSCIP(r io.Reader) error {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+
+	idx := &scipIndex{
+		docs:    d,
+		symbols: make(map[string]ID),
+		nextID:  minID,
+
+		implementations: make(map[ID][]ID),
+		typeDefinitions: make(map[ID]ID),
+	}
+
+	for {
+		num, msg, err := readSCIPMessage(br)
+		if err == io.EOF {
+			return idx.resolveRelationships()
+		}
+		if err != nil {
+			return err
+		}
+
+		switch num {
+		case scipIndexMetadata:
+			// The project root only matters for LSIF, where document URIs
+			// are absolute. SCIP paths are already relative.
+		case scipIndexDocuments:
+			doc, err := parseSCIPDocument(msg)
+			if err != nil {
+				return err
+			}
+			if err := idx.addDocument(doc); err != nil {
+				return err
+			}
+		case scipIndexExternalSymbols:
+			info, err := parseSCIPSymbolInformation(msg)
+			if err != nil {
+				return err
+			}
+			if err := idx.addSymbolInformation("", info); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// readSCIPMessage reads the next top-level field of the index. The index is
+// never loaded into memory in full: only one document at a time is.
+func readSCIPMessage(r *bufio.Reader) (protowire.Number, []byte, error) {
+	for {
+		tag, err := binary.ReadUvarint(r)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		num, typ := protowire.DecodeTag(tag)
+		if num < protowire.MinValidNumber {
+			return 0, nil, errSCIPMalformed
+		}
+
+		if typ != protowire.BytesType {
+			if err := skipSCIPValue(r, typ); err != nil {
+				return 0, nil, err
+			}
+			continue
+		}
+
+		size, err := binary.ReadUvarint(r)
+		if err != nil {
+			return 0, nil, unexpectedEOF(err)
+		}
+		if size > maxSCIPMessageSize {
+			return 0, nil, fmt.Errorf("scip: message of %d bytes exceeds limit of %d bytes", size, maxSCIPMessageSize)
+		}
+
+		msg := make([]byte, size)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			return 0, nil, unexpectedEOF(err)
+		}
+
+		return num, msg, nil
+	}
+}
+
+func skipSCIPValue(r *bufio.Reader, typ protowire.Type) error {
+	var n int
+	switch typ {
+	case protowire.VarintType:
+		_, err := binary.ReadUvarint(r)
+		return unexpectedEOF(err)
+	case protowire.Fixed32Type:
+		n = 4
+	case protowire.Fixed64Type:
+		n = 8
+	default:
+		return errSCIPMalformed
+	}
+
+	_, err := r.Discard(n)
+	return unexpectedEOF(err)
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// forEachSCIPField calls fn for every field in the protobuf message b. The
+// value passed to fn is the raw field value without its tag.
+func forEachSCIPField(b []byte, fn func(num protowire.Number, typ protowire.Type, v []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return errSCIPMalformed
+		}
+		b = b[n:]
+
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return errSCIPMalformed
+		}
+
+		if err := fn(num, typ, b[:n]); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+
+	return nil
+}
+
+func scipBytes(v []byte) ([]byte, error) {
+	b, n := protowire.ConsumeBytes(v)
+	if n < 0 {
+		return nil, errSCIPMalformed
+	}
+
+	return b, nil
+}
+
+func scipString(v []byte) (string, error) {
+	b, err := scipBytes(v)
+	return string(b), err
+}
+
+func scipInt32(v []byte) (int32, error) {
+	x, n := protowire.ConsumeVarint(v)
+	if n < 0 {
+		return 0, errSCIPMalformed
+	}
+
+	return int32(x), nil
+}
+
+func scipBool(v []byte) (bool, error) {
+	x, n := protowire.ConsumeVarint(v)
+	if n < 0 {
+		return false, errSCIPMalformed
+	}
+
+	return protowire.DecodeBool(x), nil
+}
+
+func parseSCIPDocument(msg []byte) (*scipDocument, error) {
+	doc := &scipDocument{}
+
+	err := forEachSCIPField(msg, func(num protowire.Number, _ protowire.Type, v []byte) error {
+		var err error
+
+		switch num {
+		case scipDocumentRelativePath:
+			doc.RelativePath, err = scipString(v)
+		case scipDocumentLanguage:
+			doc.Language, err = scipString(v)
+		case scipDocumentOccurrences:
+			var b []byte
+			if b, err = scipBytes(v); err == nil {
+				var occ scipOccurrence
+				if occ, err = parseSCIPOccurrence(b); err == nil {
+					doc.Occurrences = append(doc.Occurrences, occ)
+				}
+			}
+		case scipDocumentSymbols:
+			var b []byte
+			if b, err = scipBytes(v); err == nil {
+				var info scipSymbolInformation
+				if info, err = parseSCIPSymbolInformation(b); err == nil {
+					doc.Symbols = append(doc.Symbols, info)
+				}
+			}
+		case scipDocumentText:
+			// The source text is not needed to build code navigation data
+		}
+
+		return err
+	})
+
+	return doc, err
+}
+
+func parseSCIPOccurrence(msg []byte) (scipOccurrence, error) {
+	var occ scipOccurrence
+
+	err := forEachSCIPField(msg, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		var err error
+
+		switch num {
+		case scipOccurrenceRange:
+			occ.Range, err = appendSCIPInt32s(occ.Range, typ, v)
+		case scipOccurrenceSymbol:
+			occ.Symbol, err = scipString(v)
+		case scipOccurrenceSymbolRoles:
+			occ.SymbolRoles, err = scipInt32(v)
+		}
+
+		return err
+	})
+
+	return occ, err
+}
+
+// appendSCIPInt32s decodes a repeated int32 field, which can be encoded
+// either packed or as one field per element.
+func appendSCIPInt32s(dst []int32, typ protowire.Type, v []byte) ([]int32, error) {
+	if typ == protowire.VarintType {
+		x, err := scipInt32(v)
+		return append(dst, x), err
+	}
+
+	b, err := scipBytes(v)
+	if err != nil {
+		return nil, err
+	}
+
+	for len(b) > 0 {
+		x, n := protowire.ConsumeVarint(b)
+		if n < 0 {
+			return nil, errSCIPMalformed
+		}
+		dst = append(dst, int32(x))
+		b = b[n:]
+	}
+
+	return dst, nil
+}
+
+func parseSCIPSymbolInformation(msg []byte) (scipSymbolInformation, error) {
+	var info scipSymbolInformation
+
+	err := forEachSCIPField(msg, func(num protowire.Number, _ protowire.Type, v []byte) error {
+		var err error
+
+		switch num {
+		case scipSymbolInformationSymbol:
+			info.Symbol, err = scipString(v)
+		case scipSymbolInformationDocumentation:
+			var s string
+			if s, err = scipString(v); err == nil {
+				info.Documentation = append(info.Documentation, s)
+			}
+		case scipSymbolInformationSignatureDocumentation:
+			var b []byte
+			if b, err = scipBytes(v); err == nil {
+				info.SignatureLanguage, info.SignatureText, err = parseSCIPSignature(b)
+			}
+		case scipSymbolInformationRelationships:
+			var b []byte
+			if b, err = scipBytes(v); err == nil {
+				var rel scipRelationship
+				if rel, err = parseSCIPRelationship(b); err == nil {
+					info.Relationships = append(info.Relationships, rel)
+				}
+			}
+		}
+
+		return err
+	})
+
+	return info, err
+}
+
+// parseSCIPSignature returns the language and text of the Document used as
+// signature documentation of a symbol.
+func parseSCIPSignature(msg []byte) (language string, text string, err error) {
+	err = forEachSCIPField(msg, func(num protowire.Number, _ protowire.Type, v []byte) error {
+		var err error
+
+		switch num {
+		case scipDocumentLanguage:
+			language, err = scipString(v)
+		case scipDocumentText:
+			text, err = scipString(v)
+		}
+
+		return err
+	})
+
+	return language, text, err
+}
+
+func parseSCIPRelationship(msg []byte) (scipRelationship, error) {
+	var rel scipRelationship
+
+	err := forEachSCIPField(msg, func(num protowire.Number, _ protowire.Type, v []byte) error {
+		var err error
+
+		switch num {
+		case scipRelationshipSymbol:
+			rel.Symbol, err = scipString(v)
+		case scipRelationshipIsImplementation:
+			rel.IsImplementation, err = scipBool(v)
+		case scipRelationshipIsTypeDefinition:
+			rel.IsTypeDefinition, err = scipBool(v)
+		}
+
+		return err
+	})
+
+	return rel, err
+}
+
+func (s *scipIndex) newID() (ID, error) {
+	if s.nextID > maxID {
+		return 0, errors.New("scip: too many elements in index")
+	}
+
+	id := s.nextID
+	s.nextID++
+
+	return id, nil
+}
+
+// resultSetID returns the ID shared by all occurrences of a symbol. Local
+// symbols are only unique within a document.
+func (s *scipIndex) resultSetID(path, symbol string) (ID, error) {
+	key := symbol
+	if strings.HasPrefix(symbol, "local ") {
+		key = path + "\x00" + symbol
+	}
+
+	if id, ok := s.symbols[key]; ok {
+		return id, nil
+	}
+
+	id, err := s.newID()
+	if err != nil {
+		return 0, err
+	}
+	s.symbols[key] = id
+
+	return id, nil
+}
+
+func (s *scipIndex) addDocument(doc *scipDocument) error {
+	if err := s.docs.checkDocumentsLimit(); err != nil {
+		return err
+	}
+
+	docID, err := s.newID()
+	if err != nil {
+		return err
+	}
+
+	s.docs.Entries[docID] = doc.RelativePath
+
+	ranges := s.docs.Ranges
+	references := make(map[ID][]Item)
+	var order []ID
+
+	for _, occ := range doc.Occurrences {
+		if occ.Symbol == "" || len(occ.Range) < 3 {
+			continue
+		}
+
+		resultSetID, err := s.resultSetID(doc.RelativePath, occ.Symbol)
+		if err != nil {
+			return err
+		}
+
+		rangeID, err := s.newID()
+		if err != nil {
+			return err
+		}
+
+		rg := Range{Line: occ.Range[0], Character: occ.Range[1], ResultSetID: resultSetID}
+		if err := ranges.Cache.SetEntry(rangeID, &rg); err != nil {
+			return err
+		}
+		s.docs.DocRanges[docID] = append(s.docs.DocRanges[docID], rangeID)
+		if err := s.docs.checkRangesLimit(docID); err != nil {
+			return err
+		}
+
+		item := Item{Line: rg.Line + 1, DocID: docID}
+		if occ.SymbolRoles&scipSymbolRoleDefinition != 0 {
+			ranges.DefRefs[resultSetID] = item
+			continue
+		}
+
+		if _, ok := references[resultSetID]; !ok {
+			order = append(order, resultSetID)
+		}
+		references[resultSetID] = append(references[resultSetID], item)
+	}
+
+	for _, resultSetID := range order {
+		if err := ranges.References.Store(resultSetID, references[resultSetID]); err != nil {
+			return err
+		}
+	}
+
+	for _, info := range doc.Symbols {
+		if err := s.addSymbolInformation(doc.RelativePath, info); err != nil {
+			return err
+		}
+	}
+
+	return s.docs.checkCacheLimit()
+}
+
+func (s *scipIndex) addSymbolInformation(path string, info scipSymbolInformation) error {
+	if info.Symbol == "" {
+		return nil
+	}
+
+	contents := scipHoverContents(info)
+	if len(contents) == 0 && len(info.Relationships) == 0 {
+		return nil
+	}
+
+	resultSetID, err := s.resultSetID(path, info.Symbol)
+	if err != nil {
+		return err
+	}
+
+	if err := s.addRelationships(path, resultSetID, info.Relationships); err != nil {
+		return err
+	}
+
+	if len(contents) == 0 {
+		return nil
+	}
+
+	raw, err := json.Marshal(contents)
+	if err != nil {
+		return err
+	}
+
+	return s.docs.Ranges.ResultSet.Hovers.addContents(resultSetID, raw)
+}
+
+func (s *scipIndex) addRelationships(path string, resultSetID ID, relationships []scipRelationship) error {
+	for _, rel := range relationships {
+		if rel.Symbol == "" || !rel.IsImplementation && !rel.IsTypeDefinition {
+			continue
+		}
+
+		relatedID, err := s.resultSetID(path, rel.Symbol)
+		if err != nil {
+			return err
+		}
+
+		// A symbol that implements another one is listed among the
+		// implementations of the related symbol.
+		if rel.IsImplementation {
+			s.implementations[relatedID] = append(s.implementations[relatedID], resultSetID)
+		}
+		if rel.IsTypeDefinition {
+			s.typeDefinitions[resultSetID] = relatedID
+		}
+	}
+
+	return nil
+}
+
+// resolveRelationships points implementations and type definitions to the
+// definitions of the related symbols. Symbols that are not defined in the
+// index, such as external ones, are skipped.
+func (s *scipIndex) resolveRelationships() error {
+	ranges := s.docs.Ranges
+
+	for resultSetID, typeID := range s.typeDefinitions {
+		if def, ok := ranges.DefRefs[typeID]; ok {
+			ranges.TypeDefRefs[resultSetID] = def
+		}
+	}
+
+	for resultSetID, implIDs := range s.implementations {
+		var items []Item
+		for _, implID := range implIDs {
+			if def, ok := ranges.DefRefs[implID]; ok {
+				items = append(items, def)
+			}
+		}
+
+		if err := ranges.Implementations.Store(resultSetID, items); err != nil {
+			return err
+		}
+	}
+
+	return s.docs.checkCacheLimit()
+}
+
+// scipHoverContents converts the documentation of a symbol into LSIF hover
+// contents: fenced code blocks become { "language": ..., "value": ... }
+// objects that are highlighted, everything else is kept as plain text.
+func scipHoverContents(info scipSymbolInformation) []interface{} {
+	var contents []interface{}
+
+	if info.SignatureText != "" {
+		contents = append(contents, map[string]string{
+			"language": strings.ToLower(info.SignatureLanguage),
+			"value":    info.SignatureText,
+		})
+	}
+
+	for _, doc := range info.Documentation {
+		if doc == "" {
+			continue
+		}
+
+		if m := markdownCodeBlockRegex.FindStringSubmatch(doc); m != nil {
+			if info.SignatureText != "" {
+				// The signature already contains the same code
+				continue
+			}
+			contents = append(contents, map[string]string{"language": m[1], "value": m[2]})
+			continue
+		}
+
+		contents = append(contents, doc)
+	}
+
+	return contents
+}
@@ -0,0 +1,242 @@
+package parser
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/config"
+)
+
+func appendSCIPString(b []byte, num protowire.Number, s string) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendSCIPMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+func scipOccurrenceMessage(symbol string, roles int32, rg ...int32) []byte {
+	var packed []byte
+	for _, v := range rg {
+		packed = protowire.AppendVarint(packed, uint64(v))
+	}
+
+	var b []byte
+	b = appendSCIPMessage(b, scipOccurrenceRange, packed)
+	b = appendSCIPString(b, scipOccurrenceSymbol, symbol)
+	if roles != 0 {
+		b = protowire.AppendTag(b, scipOccurrenceSymbolRoles, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(roles))
+	}
+
+	return b
+}
+
+func scipSymbolMessage(symbol string, docs ...string) []byte {
+	b := appendSCIPString(nil, scipSymbolInformationSymbol, symbol)
+	for _, doc := range docs {
+		b = appendSCIPString(b, scipSymbolInformationDocumentation, doc)
+	}
+
+	return b
+}
+
+func scipRelationshipMessage(symbol string, field protowire.Number) []byte {
+	b := appendSCIPString(nil, scipRelationshipSymbol, symbol)
+	b = protowire.AppendTag(b, field, protowire.VarintType)
+
+	return protowire.AppendVarint(b, 1)
+}
+
+func scipDocumentMessage(path string, occurrences [][]byte, symbols [][]byte) []byte {
+	b := appendSCIPString(nil, scipDocumentRelativePath, path)
+	b = appendSCIPString(b, scipDocumentLanguage, "go")
+	for _, occ := range occurrences {
+		b = appendSCIPMessage(b, scipDocumentOccurrences, occ)
+	}
+	for _, sym := range symbols {
+		b = appendSCIPMessage(b, scipDocumentSymbols, sym)
+	}
+
+	return b
+}
+
+func createSCIPIndex() []byte {
+	const bar = "scip-go gomod example v1 `example/lib`/Bar()."
+
+	var index []byte
+	index = appendSCIPMessage(index, scipIndexMetadata, appendSCIPString(nil, 3, "file:///project"))
+	index = appendSCIPMessage(index, scipIndexDocuments, scipDocumentMessage(
+		"main.go",
+		[][]byte{
+			scipOccurrenceMessage("local 0", scipSymbolRoleDefinition, 2, 1, 2),
+			scipOccurrenceMessage(bar, 0, 3, 5, 8),
+			scipOccurrenceMessage("local 0", 0, 4, 1, 2),
+		},
+		nil,
+	))
+	index = appendSCIPMessage(index, scipIndexDocuments, scipDocumentMessage(
+		"lib/bar.go",
+		[][]byte{
+			scipOccurrenceMessage(bar, scipSymbolRoleDefinition, 6, 5, 6, 8),
+		},
+		[][]byte{
+			scipSymbolMessage(bar, "```go\nfunc Bar()\n```", "Bar does things"),
+		},
+	))
+
+	return index
+}
+
+func TestIsSCIP(t *testing.T) {
+	require.True(t, IsSCIP("index.scip", bufio.NewReader(bytes.NewReader(nil))))
+	require.True(t, IsSCIP("dump", bufio.NewReader(bytes.NewReader(createSCIPIndex()))))
+	require.False(t, IsSCIP("dump.lsif", bufio.NewReader(bytes.NewReader([]byte(`{"id":"1"}`)))))
+}
+
+func serializeSCIP(t *testing.T, index []byte) map[string][]SerializedRange {
+	t.Helper()
+
+	d, err := NewDocs()
+	require.NoError(t, err)
+	defer d.Close()
+
+	require.NoError(t, d.ParseSCIP(bytes.NewReader(index)))
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	require.NoError(t, d.SerializeEntries(zw))
+	require.NoError(t, zw.Close())
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+
+	entries := make(map[string][]SerializedRange)
+	for _, f := range zr.File {
+		if f.Name == FormatFile {
+			continue
+		}
+
+		r, err := f.Open()
+		require.NoError(t, err)
+
+		var ranges []SerializedRange
+		require.NoError(t, json.NewDecoder(r).Decode(&ranges))
+		entries[f.Name] = ranges
+	}
+
+	return entries
+}
+
+func TestParseSCIP(t *testing.T) {
+	entries := serializeSCIP(t, createSCIPIndex())
+	require.Len(t, entries, 2)
+
+	mainRanges := entries["lsif/main.go.json"]
+	require.Len(t, mainRanges, 3)
+
+	require.Equal(t, int32(2), mainRanges[0].StartLine)
+	require.Equal(t, "main.go#L3", mainRanges[0].DefinitionPath)
+	require.Equal(t, []SerializedReference{{Path: "main.go#L5"}}, mainRanges[0].References)
+
+	require.Equal(t, int32(3), mainRanges[1].StartLine)
+	require.Equal(t, int32(5), mainRanges[1].StartChar)
+	require.Equal(t, "lib/bar.go#L7", mainRanges[1].DefinitionPath)
+	require.Equal(t, []SerializedReference{{Path: "main.go#L4"}}, mainRanges[1].References)
+	require.JSONEq(t,
+		`[{"tokens":[[{"class":"kd","value":"func"},{"value":" Bar()"}]],"language":"go"},{"value":"Bar does things"}]`,
+		string(mainRanges[1].Hover),
+	)
+
+	barRanges := entries["lsif/lib/bar.go.json"]
+	require.Len(t, barRanges, 1)
+	require.Equal(t, mainRanges[1].Hover, barRanges[0].Hover)
+}
+
+func TestParseSCIPRelationships(t *testing.T) {
+	const (
+		iface = "scip-go gomod example v1 `example/lib`/Reader#"
+		impl  = "scip-go gomod example v1 `example/lib`/File#"
+		value = "scip-go gomod example v1 `example/lib`/file."
+	)
+
+	implSymbol := appendSCIPString(nil, scipSymbolInformationSymbol, impl)
+	implSymbol = appendSCIPMessage(implSymbol, scipSymbolInformationRelationships,
+		scipRelationshipMessage(iface, scipRelationshipIsImplementation))
+
+	valueSymbol := appendSCIPString(nil, scipSymbolInformationSymbol, value)
+	valueSymbol = appendSCIPMessage(valueSymbol, scipSymbolInformationRelationships,
+		scipRelationshipMessage(impl, scipRelationshipIsTypeDefinition))
+
+	index := appendSCIPMessage(nil, scipIndexDocuments, scipDocumentMessage(
+		"lib/reader.go",
+		[][]byte{
+			scipOccurrenceMessage(iface, scipSymbolRoleDefinition, 1, 5, 11),
+			scipOccurrenceMessage(value, scipSymbolRoleDefinition, 20, 4, 8),
+		},
+		[][]byte{valueSymbol},
+	))
+	index = appendSCIPMessage(index, scipIndexDocuments, scipDocumentMessage(
+		"lib/file.go",
+		[][]byte{
+			scipOccurrenceMessage(impl, scipSymbolRoleDefinition, 9, 5, 9),
+		},
+		[][]byte{implSymbol},
+	))
+
+	entries := serializeSCIP(t, index)
+
+	readerRanges := entries["lsif/lib/reader.go.json"]
+	require.Len(t, readerRanges, 2)
+	require.Equal(t, []SerializedReference{{Path: "lib/file.go#L10"}}, readerRanges[0].Implementations)
+	require.Empty(t, readerRanges[0].TypeDefinitionPath)
+	require.Equal(t, "lib/file.go#L10", readerRanges[1].TypeDefinitionPath)
+	require.Empty(t, readerRanges[1].Implementations)
+}
+
+func TestParseSCIPMalformed(t *testing.T) {
+	d, err := NewDocs()
+	require.NoError(t, err)
+	defer d.Close()
+
+	index := createSCIPIndex()
+
+	require.Equal(t, io.ErrUnexpectedEOF, d.ParseSCIP(bytes.NewReader(index[:len(index)-3])))
+	require.Equal(t, errSCIPMalformed, d.ParseSCIP(bytes.NewReader([]byte{0x12, 0x02, 0x0a, 0x05})))
+}
+
+func TestGenerateFromSCIP(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("index.scip")
+	require.NoError(t, err)
+	_, err = w.Write(createSCIPIndex())
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	parser, err := NewParser(context.Background(), &buf, config.LsifConfig{})
+	require.NoError(t, err)
+	defer parser.Close()
+
+	output, err := io.ReadAll(parser)
+	require.NoError(t, err)
+
+	zr, err := zip.NewReader(bytes.NewReader(output), int64(len(output)))
+	require.NoError(t, err)
+
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	require.ElementsMatch(t, []string{FormatFile, "lsif/main.go.json", "lsif/lib/bar.go.json"}, names)
+}
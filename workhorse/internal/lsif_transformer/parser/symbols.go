@@ -0,0 +1,295 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// symbolKinds maps LSP SymbolKind values to their names
+var symbolKinds = []string{
+	"", "file", "module", "namespace", "package", "class", "method", "property",
+	"field", "constructor", "enum", "interface", "function", "variable",
+	"constant", "string", "number", "boolean", "array", "object", "key", "null",
+	"enum_member", "struct", "event", "operator", "type_parameter",
+}
+
+var tagKey = []byte(`"tag"`)
+
+// Symbols holds the document symbol results of an LSIF dump. The results
+// and the tags of ranges they refer to are stored in a file, like hovers.
+type Symbols struct {
+	File          *os.File
+	Offsets       *cache
+	CurrentOffset int
+	DocResults    map[ID]ID
+}
+
+// Position represents a position in a document
+type Position struct {
+	Line      int32 `json:"line"`
+	Character int32 `json:"character"`
+}
+
+// SymbolRange represents the range of a symbol in a document
+type SymbolRange struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+// RawSymbolTag represents the tag of a range that declares or defines a
+// symbol
+type RawSymbolTag struct {
+	Type      string       `json:"type"`
+	Text      string       `json:"text"`
+	Kind      int          `json:"kind"`
+	FullRange *SymbolRange `json:"fullRange"`
+}
+
+// RawDocumentSymbol represents an entry of a documentSymbolResult. An entry
+// either describes the symbol itself or refers to a range by ID, in which
+// case the symbol is described by the tag of the range.
+type RawDocumentSymbol struct {
+	ID       ID                  `json:"id"`
+	Name     string              `json:"name"`
+	Kind     int                 `json:"kind"`
+	Range    *SymbolRange        `json:"range"`
+	Children []RawDocumentSymbol `json:"children"`
+}
+
+// DocumentSymbolRef represents the edge between a document and its
+// documentSymbolResult
+type DocumentSymbolRef struct {
+	DocID    ID `json:"outV"`
+	ResultID ID `json:"inV"`
+}
+
+// SerializedSymbol represents a serialized document symbol
+type SerializedSymbol struct {
+	Name      string             `json:"name"`
+	Kind      string             `json:"kind"`
+	StartLine int32              `json:"start_line"`
+	StartChar int32              `json:"start_char"`
+	EndLine   int32              `json:"end_line"`
+	EndChar   int32              `json:"end_char"`
+	Children  []SerializedSymbol `json:"children,omitempty"`
+}
+
+// NewSymbols creates a new Symbols instance
+func NewSymbols() (*Symbols, error) {
+	file, err := os.CreateTemp("", "symbols")
+	if err != nil {
+		return nil, err
+	}
+
+	if removeErr := os.Remove(file.Name()); removeErr != nil {
+		return nil, removeErr
+	}
+
+	offsets, err := newCache("symbols-indexes", Offset{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Symbols{
+		File:          file,
+		Offsets:       offsets,
+		CurrentOffset: 0,
+		DocResults:    make(map[ID]ID),
+	}, nil
+}
+
+// Read reads the data
+func (s *Symbols) Read(label string, line []byte) error {
+	switch label {
+	case "range":
+		// Most ranges have no tag, so avoid decoding them a second time
+		if bytes.Contains(line, tagKey) {
+			return s.addRangeTag(line)
+		}
+	case "documentSymbolResult":
+		return s.addResult(line)
+	case "textDocument/documentSymbol":
+		return s.addDocumentRef(line)
+	}
+
+	return nil
+}
+
+// HasSymbols returns true if document symbols were found for the document
+func (s *Symbols) HasSymbols(docID ID) bool {
+	_, ok := s.DocResults[docID]
+	return ok
+}
+
+// Serialize serializes the symbols of a document to the provided writer
+func (s *Symbols) Serialize(w io.Writer, docID ID, ranges *Ranges) error {
+	var rawSymbols []RawDocumentSymbol
+	if raw := s.entry(s.DocResults[docID]); raw != nil {
+		if err := json.Unmarshal(raw, &rawSymbols); err != nil {
+			return err
+		}
+	}
+
+	symbols := s.resolve(rawSymbols, ranges)
+	if symbols == nil {
+		symbols = []SerializedSymbol{}
+	}
+
+	return json.NewEncoder(w).Encode(symbols)
+}
+
+// Close closes the Symbols instance
+func (s *Symbols) Close() error {
+	for _, err := range []error{
+		s.File.Close(),
+		s.Offsets.Close(),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Symbols) addRangeTag(line []byte) error {
+	var rawRange struct {
+		ID  ID              `json:"id"`
+		Tag json.RawMessage `json:"tag"`
+	}
+	if err := json.Unmarshal(line, &rawRange); err != nil {
+		return err
+	}
+
+	// Only definitions and declarations describe symbols
+	var tag RawSymbolTag
+	if err := json.Unmarshal(rawRange.Tag, &tag); err != nil {
+		return err
+	}
+	if tag.Type != "definition" && tag.Type != "declaration" {
+		return nil
+	}
+
+	return s.store(rawRange.ID, rawRange.Tag)
+}
+
+func (s *Symbols) addResult(line []byte) error {
+	var rawResult struct {
+		ID     ID              `json:"id"`
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(line, &rawResult); err != nil {
+		return err
+	}
+
+	return s.store(rawResult.ID, rawResult.Result)
+}
+
+func (s *Symbols) addDocumentRef(line []byte) error {
+	var ref DocumentSymbolRef
+	if err := json.Unmarshal(line, &ref); err != nil {
+		return err
+	}
+
+	s.DocResults[ref.DocID] = ref.ResultID
+
+	return nil
+}
+
+func (s *Symbols) store(id ID, data json.RawMessage) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	n, err := s.File.Write(data)
+	if err != nil {
+		return err
+	}
+
+	offset := Offset{At: int32(s.CurrentOffset), Len: int32(n)}
+	s.CurrentOffset += n
+
+	return s.Offsets.SetEntry(id, &offset)
+}
+
+func (s *Symbols) entry(id ID) json.RawMessage {
+	var offset Offset
+	if err := s.Offsets.Entry(id, &offset); err != nil || offset.Len == 0 {
+		return nil
+	}
+
+	data := make([]byte, offset.Len)
+	if _, err := s.File.ReadAt(data, int64(offset.At)); err != nil {
+		return nil
+	}
+
+	return data
+}
+
+// resolve converts raw symbols into serialized ones. Symbols that refer to
+// ranges without a usable tag are dropped, and their children take their
+// place.
+func (s *Symbols) resolve(rawSymbols []RawDocumentSymbol, ranges *Ranges) []SerializedSymbol {
+	var symbols []SerializedSymbol
+
+	for _, raw := range rawSymbols {
+		children := s.resolve(raw.Children, ranges)
+
+		symbol, ok := s.symbolFor(raw, ranges)
+		if !ok {
+			symbols = append(symbols, children...)
+			continue
+		}
+
+		symbol.Children = children
+		symbols = append(symbols, symbol)
+	}
+
+	return symbols
+}
+
+func (s *Symbols) symbolFor(raw RawDocumentSymbol, ranges *Ranges) (SerializedSymbol, bool) {
+	if raw.ID == 0 {
+		if raw.Name == "" || raw.Range == nil {
+			return SerializedSymbol{}, false
+		}
+
+		return newSerializedSymbol(raw.Name, raw.Kind, *raw.Range), true
+	}
+
+	var tag RawSymbolTag
+	data := s.entry(raw.ID)
+	if data == nil || json.Unmarshal(data, &tag) != nil || tag.Text == "" {
+		return SerializedSymbol{}, false
+	}
+
+	if tag.FullRange != nil {
+		return newSerializedSymbol(tag.Text, tag.Kind, *tag.FullRange), true
+	}
+
+	rg, err := ranges.getRange(raw.ID)
+	if err != nil {
+		return SerializedSymbol{}, false
+	}
+
+	start := Position{Line: rg.Line, Character: rg.Character}
+
+	return newSerializedSymbol(tag.Text, tag.Kind, SymbolRange{Start: start, End: start}), true
+}
+
+func newSerializedSymbol(name string, kind int, rg SymbolRange) SerializedSymbol {
+	var kindName string
+	if kind > 0 && kind < len(symbolKinds) {
+		kindName = symbolKinds[kind]
+	}
+
+	return SerializedSymbol{
+		Name:      name,
+		Kind:      kindName,
+		StartLine: rg.Start.Line,
+		StartChar: rg.Start.Character,
+		EndLine:   rg.End.Line,
+		EndChar:   rg.End.Character,
+	}
+}
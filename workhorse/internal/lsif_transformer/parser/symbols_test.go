@@ -0,0 +1,94 @@
+package parser
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func parseSymbolsDump(t *testing.T, lines ...string) *Docs {
+	t.Helper()
+
+	d, err := NewDocs()
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, d.Close()) })
+
+	require.NoError(t, d.Parse(strings.NewReader(strings.Join(lines, "\n"))))
+
+	return d
+}
+
+func serializeSymbols(t *testing.T, d *Docs, docID ID) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	require.NoError(t, d.Symbols.Serialize(&buf, docID, d.Ranges))
+
+	return buf.String()
+}
+
+func TestSymbolsWithDocumentSymbols(t *testing.T) {
+	d := parseSymbolsDump(t,
+		`{"id":1,"label":"document","uri":"file:///main.go"}`,
+		`{"id":2,"label":"documentSymbolResult","result":[{"name":"Server","kind":23,"range":{"start":{"line":3,"character":0},"end":{"line":9,"character":1}},"children":[{"name":"Addr","kind":8,"range":{"start":{"line":4,"character":1},"end":{"line":4,"character":12}}}]}]}`,
+		`{"id":3,"label":"textDocument/documentSymbol","outV":1,"inV":2}`,
+	)
+
+	require.True(t, d.Symbols.HasSymbols(1))
+	require.JSONEq(t, `[{"name":"Server","kind":"struct","start_line":3,"start_char":0,"end_line":9,"end_char":1,
+		"children":[{"name":"Addr","kind":"field","start_line":4,"start_char":1,"end_line":4,"end_char":12}]}]`,
+		serializeSymbols(t, d, 1))
+}
+
+func TestSymbolsWithRangeBasedDocumentSymbols(t *testing.T) {
+	d := parseSymbolsDump(t,
+		`{"id":1,"label":"document","uri":"file:///main.go"}`,
+		`{"id":2,"label":"range","start":{"line":3,"character":5},"end":{"line":3,"character":11},"tag":{"type":"definition","text":"Server","kind":23,"fullRange":{"start":{"line":3,"character":0},"end":{"line":9,"character":1}}}}`,
+		`{"id":3,"label":"range","start":{"line":11,"character":5},"end":{"line":11,"character":9},"tag":{"type":"definition","text":"main","kind":12}}`,
+		`{"id":4,"label":"range","start":{"line":12,"character":1},"end":{"line":12,"character":4},"tag":{"type":"reference","text":"fmt"}}`,
+		`{"id":5,"label":"range","start":{"line":13,"character":1},"end":{"line":13,"character":4}}`,
+		`{"id":6,"label":"documentSymbolResult","result":[{"id":2},{"id":4,"children":[{"id":3}]},{"id":5}]}`,
+		`{"id":7,"label":"textDocument/documentSymbol","outV":"1","inV":"6"}`,
+	)
+
+	require.JSONEq(t, `[
+		{"name":"Server","kind":"struct","start_line":3,"start_char":0,"end_line":9,"end_char":1},
+		{"name":"main","kind":"function","start_line":11,"start_char":5,"end_line":11,"end_char":5}
+	]`, serializeSymbols(t, d, 1))
+}
+
+func TestSymbolsSerializeEntries(t *testing.T) {
+	d := parseSymbolsDump(t,
+		`{"id":1,"label":"document","uri":"file:///main.go"}`,
+		`{"id":2,"label":"document","uri":"file:///lib.go"}`,
+		`{"id":3,"label":"documentSymbolResult","result":[]}`,
+		`{"id":4,"label":"textDocument/documentSymbol","outV":1,"inV":3}`,
+	)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	require.NoError(t, d.SerializeEntries(zw))
+	require.NoError(t, zw.Close())
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		r, err := f.Open()
+		require.NoError(t, err)
+
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		files[f.Name] = string(data)
+	}
+
+	require.Contains(t, files, "lsif/main.go.json")
+	require.Contains(t, files, "lsif/lib.go.json")
+	require.NotContains(t, files, "symbols/lib.go.json")
+	require.JSONEq(t, `[]`, files["symbols/main.go.json"])
+}
@@ -0,0 +1,43 @@
+package notification
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Memory is a Backend that keeps the values of keys in memory, for
+// single-node installs and tests.
+type Memory struct {
+	*Hub
+
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func NewMemory() *Memory {
+	return &Memory{Hub: NewHub("memory"), values: make(map[string]string)}
+}
+
+// Set changes the value of key and notifies its watchers.
+func (m *Memory) Set(key, value string) {
+	m.mu.Lock()
+	m.values[key] = value
+	m.mu.Unlock()
+
+	m.Notify(key, value)
+}
+
+func (m *Memory) get(_ context.Context, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.values[key], nil
+}
+
+func (m *Memory) WatchKey(ctx context.Context, key, value string, timeout time.Duration) (WatchKeyStatus, error) {
+	return m.Watch(ctx, key, value, timeout, m.get)
+}
+
+// Process returns immediately because Set notifies watchers directly.
+func (m *Memory) Process() {}
@@ -0,0 +1,130 @@
+package notification
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const runnerKey = "runner:build_queue:10"
+
+func TestMemoryWatchKeyInstantReturn(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		value          string
+		watchValue     string
+		expectedStatus WatchKeyStatus
+	}{
+		{
+			desc:           "sees change with key existing and changed",
+			value:          "somethingelse",
+			watchValue:     "something",
+			expectedStatus: WatchKeyStatusAlreadyChanged,
+		},
+		{
+			desc:           "sees change with key non-existing",
+			watchValue:     "something",
+			expectedStatus: WatchKeyStatusAlreadyChanged,
+		},
+		{
+			desc:           "sees timeout with key existing and unchanged",
+			value:          "something",
+			watchValue:     "something",
+			expectedStatus: WatchKeyStatusTimeout,
+		},
+		{
+			desc:           "sees timeout with key non-existing and unchanged",
+			watchValue:     "",
+			expectedStatus: WatchKeyStatusTimeout,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			m := NewMemory()
+			defer m.Shutdown()
+			if tc.value != "" {
+				m.Set(runnerKey, tc.value)
+			}
+
+			val, err := m.WatchKey(context.Background(), runnerKey, tc.watchValue, time.Millisecond)
+
+			require.NoError(t, err, "Expected no error")
+			require.Equal(t, tc.expectedStatus, val, "Expected value")
+			require.Zero(t, m.Watchers(runnerKey))
+		})
+	}
+}
+
+func TestMemoryWatchKeyWhenWatching(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		setValue       string
+		expectedStatus WatchKeyStatus
+	}{
+		{
+			desc:           "sees change",
+			setValue:       "somethingelse",
+			expectedStatus: WatchKeyStatusSeenChange,
+		},
+		{
+			desc:           "sees no change",
+			setValue:       "something",
+			expectedStatus: WatchKeyStatusNoChange,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			m := NewMemory()
+			defer m.Shutdown()
+			m.Set(runnerKey, "something")
+
+			go func() {
+				require.Eventually(t, func() bool { return m.Watchers(runnerKey) == 1 }, time.Second, time.Millisecond)
+				m.Set(runnerKey, tc.setValue)
+			}()
+
+			val, err := m.WatchKey(context.Background(), runnerKey, "something", 10*time.Second)
+
+			require.NoError(t, err, "Expected no error")
+			require.Equal(t, tc.expectedStatus, val, "Expected value")
+		})
+	}
+}
+
+func TestMemoryShutdown(t *testing.T) {
+	m := NewMemory()
+	m.Set(runnerKey, "something")
+
+	go func() {
+		require.Eventually(t, func() bool { return m.Watchers(runnerKey) == 1 }, time.Second, time.Millisecond)
+		m.Shutdown()
+	}()
+
+	val, err := m.WatchKey(context.Background(), runnerKey, "something", 10*time.Second)
+	require.NoError(t, err)
+	require.Equal(t, WatchKeyStatusNoChange, val)
+
+	// Watching a key after the shutdown should result in an immediate response
+	val, err = m.WatchKey(context.Background(), runnerKey, "something", 10*time.Second)
+	require.NoError(t, err)
+	require.Equal(t, WatchKeyStatusNoChange, val)
+}
+
+func TestHubCloseAll(t *testing.T) {
+	m := NewMemory()
+	defer m.Shutdown()
+	m.Set(runnerKey, "something")
+
+	go func() {
+		require.Eventually(t, func() bool { return m.Watchers(runnerKey) == 1 }, time.Second, time.Millisecond)
+		m.CloseAll()
+	}()
+
+	val, err := m.WatchKey(context.Background(), runnerKey, "something", 10*time.Second)
+	require.Error(t, err)
+	require.Equal(t, WatchKeyStatusNoChange, val)
+}
@@ -0,0 +1,216 @@
+// Package notification watches keys for changes that GitLab notifies
+// Workhorse about, such as the build queue of CI runners.
+package notification
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/log"
+)
+
+// WatchKeyStatus is used to tell how WatchKey returned
+type WatchKeyStatus int
+
+const (
+	// WatchKeyStatusTimeout is returned when the watch timeout provided by the caller was exceeded
+	WatchKeyStatusTimeout WatchKeyStatus = iota
+	// WatchKeyStatusAlreadyChanged is returned when the value passed by the caller was never observed
+	WatchKeyStatusAlreadyChanged
+	// WatchKeyStatusSeenChange is returned when we have seen the value passed by the caller get changed
+	WatchKeyStatusSeenChange
+	// WatchKeyStatusNoChange is returned when the function had to return before observing a change.
+	//  Also returned on errors.
+	WatchKeyStatusNoChange
+)
+
+// Backend notifies watchers of changes of the value of keys.
+type Backend interface {
+	// WatchKey waits for the value of key to change from value, for at most
+	// timeout.
+	WatchKey(ctx context.Context, key, value string, timeout time.Duration) (WatchKeyStatus, error)
+	// Process receives notifications until the process exits.
+	Process()
+	// Shutdown ends the current and future watches.
+	Shutdown()
+}
+
+var (
+	watchers = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gitlab_workhorse_notifications_watchers",
+			Help: "The number of keys that is being watched by gitlab-workhorse, by notification backend",
+		},
+		[]string{"backend"},
+	)
+	totalMessages = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_workhorse_notifications_messages_total",
+			Help: "How many notifications gitlab-workhorse has received in total, by notification backend",
+		},
+		[]string{"backend"},
+	)
+)
+
+// Hub fans notifications out to the watchers of keys. Backends receive
+// notifications into it with Notify.
+type Hub struct {
+	mu          sync.Mutex
+	backend     string
+	gauges      []prometheus.Gauge
+	subscribers map[string][]chan string
+	shutdown    chan struct{}
+}
+
+// NewHub returns a hub for backend. Its watchers are also counted in
+// gauges, for backends that have metrics of their own.
+func NewHub(backend string, gauges ...prometheus.Gauge) *Hub {
+	return &Hub{
+		backend:  backend,
+		gauges:   append([]prometheus.Gauge{watchers.WithLabelValues(backend)}, gauges...),
+		shutdown: make(chan struct{}),
+	}
+}
+
+func (h *Hub) addWatchers(n int) {
+	for _, g := range h.gauges {
+		g.Add(float64(n))
+	}
+}
+
+// Subscribe adds a watcher of key. The returned channel receives the new
+// values of key, and is closed by CloseAll.
+func (h *Hub) Subscribe(key string) chan string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	notify := make(chan string, 1)
+	if h.subscribers == nil {
+		h.subscribers = make(map[string][]chan string)
+	}
+	h.subscribers[key] = append(h.subscribers[key], notify)
+	h.addWatchers(1)
+
+	return notify
+}
+
+// Unsubscribe removes a watcher of key. It returns false if the watcher
+// was already removed by CloseAll.
+func (h *Hub) Unsubscribe(key string, notify chan string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	removed := false
+	chans := h.subscribers[key]
+	for i, c := range chans {
+		if notify == c {
+			h.subscribers[key] = append(chans[:i], chans[i+1:]...)
+			h.addWatchers(-1)
+			removed = true
+			break
+		}
+	}
+	if len(h.subscribers[key]) == 0 {
+		delete(h.subscribers, key)
+	}
+
+	return removed
+}
+
+// Watchers returns the number of watchers of key.
+func (h *Hub) Watchers(key string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.subscribers[key])
+}
+
+// Notify sends value to the watchers of key. It returns false if key has
+// no watchers.
+func (h *Hub) Notify(key, value string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	totalMessages.WithLabelValues(h.backend).Inc()
+	chans, ok := h.subscribers[key]
+	for _, c := range chans {
+		select {
+		case c <- value:
+		default:
+		}
+	}
+
+	return ok
+}
+
+// CloseAll ends all watches, for example because notifications may have
+// been missed while reconnecting.
+func (h *Hub) CloseAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, chans := range h.subscribers {
+		for _, c := range chans {
+			close(c)
+			h.addWatchers(-1)
+		}
+	}
+	h.subscribers = nil
+}
+
+// Shutdown ends the current and future watches.
+func (h *Hub) Shutdown() {
+	log.WithFields(log.Fields{"backend": h.backend}).Info("notifications: shutting down")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	select {
+	case <-h.shutdown:
+		// already closed
+	default:
+		close(h.shutdown)
+	}
+}
+
+// Watch implements WatchKey on top of get, which returns the current value
+// of a key, or an empty string if the key does not exist.
+func (h *Hub) Watch(ctx context.Context, key, value string, timeout time.Duration, get func(context.Context, string) (string, error)) (WatchKeyStatus, error) {
+	notify := h.Subscribe(key)
+	defer h.Unsubscribe(key, notify)
+
+	currentValue, err := get(ctx, key)
+	if err != nil {
+		return WatchKeyStatusNoChange, err
+	}
+	if currentValue != value {
+		return WatchKeyStatusAlreadyChanged, nil
+	}
+
+	return h.Wait(key, value, notify, timeout)
+}
+
+// Wait waits for the value of key to change from value, which must be its
+// current value, on the channel that Subscribe returned.
+func (h *Hub) Wait(key, value string, notify <-chan string, timeout time.Duration) (WatchKeyStatus, error) {
+	select {
+	case <-h.shutdown:
+		log.WithFields(log.Fields{"key": key}).Info("stopping watch due to shutdown")
+		return WatchKeyStatusNoChange, nil
+	case currentValue, ok := <-notify:
+		if !ok {
+			return WatchKeyStatusNoChange, errors.New("notifications: connection lost")
+		}
+		if currentValue == value {
+			return WatchKeyStatusNoChange, nil
+		}
+		return WatchKeyStatusSeenChange, nil
+	case <-time.After(timeout):
+		return WatchKeyStatusTimeout, nil
+	}
+}
@@ -0,0 +1,129 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jpillora/backoff"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/config"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/log"
+)
+
+// Postgres is a Backend that reads the values of keys from a PostgreSQL
+// table, and receives their changes with LISTEN. The payload of the
+// notifications is a JSON object with the key and its new value, like the
+// one row_to_json returns for a row of the table.
+type Postgres struct {
+	*Hub
+
+	channel          string
+	query            string
+	pool             *pgxpool.Pool
+	reconnectBackoff backoff.Backoff
+	listening        atomic.Bool
+}
+
+type postgresNotification struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+func NewPostgres(cfg config.PostgresNotificationsConfig) (*Postgres, error) {
+	// The pool connects lazily, so this does not fail if PostgreSQL is down.
+	pool, err := pgxpool.New(context.Background(), cfg.URL) // lint:allow context.Background
+	if err != nil {
+		return nil, fmt.Errorf("notifications: postgres: %v", err)
+	}
+
+	return &Postgres{
+		Hub:     NewHub("postgres"),
+		channel: cfg.Channel,
+		query:   fmt.Sprintf("SELECT value FROM %s WHERE key = $1", pgx.Identifier(strings.Split(cfg.Table, ".")).Sanitize()),
+		pool:    pool,
+		reconnectBackoff: backoff.Backoff{
+			Min:    100 * time.Millisecond,
+			Max:    60 * time.Second,
+			Factor: 2,
+			Jitter: true,
+		},
+	}, nil
+}
+
+func (p *Postgres) Process() {
+	log.Info("notifications: starting postgres listen loop")
+
+	ctx := context.Background() // lint:allow context.Background
+
+	for {
+		err := p.listen(ctx)
+		p.listening.Store(false)
+
+		// Changes may have been missed while the connection was down.
+		p.CloseAll()
+
+		log.WithError(fmt.Errorf("notifications: postgres: %v", err)).Error()
+		time.Sleep(p.reconnectBackoff.Duration())
+	}
+}
+
+func (p *Postgres) listen(ctx context.Context) error {
+	// LISTEN ties the connection to the session, so it must not go back to
+	// the pool.
+	poolConn, err := p.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	conn := poolConn.Hijack()
+	defer conn.Close(ctx)
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{p.channel}.Sanitize()); err != nil {
+		return err
+	}
+
+	p.listening.Store(true)
+	p.reconnectBackoff.Reset()
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		var msg postgresNotification
+		if err := json.Unmarshal([]byte(n.Payload), &msg); err != nil || msg.Key == "" {
+			log.WithFields(log.Fields{"payload": n.Payload}).Error("notifications: postgres: invalid payload")
+			continue
+		}
+
+		p.Notify(msg.Key, msg.Value)
+	}
+}
+
+func (p *Postgres) get(ctx context.Context, key string) (string, error) {
+	var value string
+	err := p.pool.QueryRow(ctx, p.query, key).Scan(&value)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("notifications: postgres: %v", err)
+	}
+
+	return value, nil
+}
+
+func (p *Postgres) WatchKey(ctx context.Context, key, value string, timeout time.Duration) (WatchKeyStatus, error) {
+	if !p.listening.Load() {
+		// Like with Redis, it is OK to fail fast while not connected.
+		return WatchKeyStatusNoChange, errors.New("no postgres connection")
+	}
+
+	return p.Watch(ctx, key, value, timeout, p.get)
+}
@@ -0,0 +1,32 @@
+package notification
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/config"
+)
+
+func TestNewPostgres(t *testing.T) {
+	p, err := NewPostgres(config.PostgresNotificationsConfig{
+		URL:     "postgres://gitlab@127.0.0.1:1/gitlabhq_production",
+		Channel: "workhorse_notifications",
+		Table:   "ci.workhorse_notifications",
+	})
+	require.NoError(t, err)
+	defer p.pool.Close()
+
+	require.Equal(t, `SELECT value FROM "ci"."workhorse_notifications" WHERE key = $1`, p.query)
+
+	val, err := p.WatchKey(context.Background(), runnerKey, "something", time.Second)
+	require.EqualError(t, err, "no postgres connection")
+	require.Equal(t, WatchKeyStatusNoChange, val)
+}
+
+func TestNewPostgresInvalidURL(t *testing.T) {
+	_, err := NewPostgres(config.PostgresNotificationsConfig{URL: "postgres://%zz"})
+	require.Error(t, err)
+}
@@ -0,0 +1,154 @@
+package portforward
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrClientClosed is returned for streams of a client whose websocket has
+// failed.
+var ErrClientClosed = errors.New("port-forward: client closed")
+
+// Client forwards local connections over a websocket to a Server.
+type Client struct {
+	conn *frameConn
+
+	mu      sync.Mutex
+	next    uint32
+	streams map[uint32]*clientStream
+	err     error
+	done    chan struct{}
+}
+
+type clientStream struct {
+	local  net.Conn
+	opened chan error
+}
+
+// NewClient starts reading frames from conn.
+func NewClient(conn *websocket.Conn) *Client {
+	c := &Client{
+		conn:    &frameConn{conn: conn},
+		streams: make(map[uint32]*clientStream),
+		done:    make(chan struct{}),
+	}
+	go c.readLoop()
+
+	return c
+}
+
+// Done is closed when the websocket fails.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the websocket failed.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.err
+}
+
+// Forward connects local to target through the server, and copies data in
+// both directions until either side closes. It closes local.
+func (c *Client) Forward(local net.Conn, target string) error {
+	defer local.Close()
+
+	s := &clientStream{local: local, opened: make(chan error, 1)}
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return ErrClientClosed
+	}
+	c.next++
+	stream := c.next
+	c.streams[stream] = s
+	c.mu.Unlock()
+	defer c.remove(stream)
+
+	if err := c.conn.write(frame{kind: frameOpen, stream: stream, payload: []byte(target)}); err != nil {
+		return fmt.Errorf("port-forward: open %s: %w", target, err)
+	}
+
+	select {
+	case err := <-s.opened:
+		if err != nil {
+			return fmt.Errorf("port-forward: open %s: %w", target, err)
+		}
+	case <-c.done:
+		return ErrClientClosed
+	}
+
+	reason := copyToFrames(c.conn, local, stream)
+	if c.remove(stream) {
+		_ = c.conn.write(frame{kind: frameClose, stream: stream, payload: []byte(reason)})
+	}
+
+	return nil
+}
+
+func (c *Client) readLoop() {
+	var err error
+	defer func() {
+		c.mu.Lock()
+		c.err = err
+		for stream, s := range c.streams {
+			_ = s.local.Close()
+			delete(c.streams, stream)
+		}
+		c.mu.Unlock()
+		close(c.done)
+	}()
+
+	for {
+		var f frame
+		f, err = c.conn.read()
+		if err != nil {
+			return
+		}
+
+		c.mu.Lock()
+		s := c.streams[f.stream]
+		c.mu.Unlock()
+		if s == nil {
+			continue
+		}
+
+		switch f.kind {
+		case frameOpened:
+			s.opened <- nil
+		case frameData:
+			if _, writeErr := s.local.Write(f.payload); writeErr != nil {
+				c.remove(f.stream)
+				_ = c.conn.write(frame{kind: frameClose, stream: f.stream, payload: []byte("write failed")})
+			}
+		case frameClose:
+			if c.remove(f.stream) {
+				select {
+				case s.opened <- fmt.Errorf("closed by server: %s", f.payload):
+				default:
+				}
+			}
+		}
+	}
+}
+
+// remove closes a stream. It returns false if the stream was not open.
+func (c *Client) remove(stream uint32) bool {
+	c.mu.Lock()
+	s, ok := c.streams[stream]
+	delete(c.streams, stream)
+	c.mu.Unlock()
+
+	if ok {
+		_ = s.local.Close()
+	}
+
+	return ok
+}
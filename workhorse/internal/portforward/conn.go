@@ -0,0 +1,35 @@
+package portforward
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// frameConn serializes the frames written to a websocket, which supports
+// only one concurrent writer.
+type frameConn struct {
+	conn *websocket.Conn
+	mu   sync.Mutex
+}
+
+func (c *frameConn) write(f frame) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.conn.WriteMessage(websocket.BinaryMessage, f.marshal())
+}
+
+func (c *frameConn) read() (frame, error) {
+	mt, data, err := c.conn.ReadMessage()
+	if err != nil {
+		return frame{}, err
+	}
+
+	if mt != websocket.BinaryMessage {
+		return frame{}, errors.New("unexpected text message")
+	}
+
+	return parseFrame(data)
+}
@@ -0,0 +1,71 @@
+// Package portforward multiplexes TCP connections over a websocket.
+//
+// Every websocket message is a binary frame with a 1 byte type, a 4 byte big
+// endian stream ID chosen by the client, and a payload:
+//
+//   - open: sent by the client to connect a stream to the target in the
+//     payload, as host:port.
+//   - opened: sent by the server when the stream is connected.
+//   - data: sent by either side with data of a stream.
+//   - close: sent by either side when a stream ends, with an optional reason
+//     as payload.
+package portforward
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Subprotocol is the websocket subprotocol of port forwarding
+const Subprotocol = "v1.port-forward.gitlab.com"
+
+const (
+	frameOpen   byte = 1
+	frameOpened byte = 2
+	frameData   byte = 3
+	frameClose  byte = 4
+)
+
+const (
+	headerSize = 5
+	// MaxPayload is the maximum size of the payload of a frame
+	MaxPayload = 32 * 1024
+	// MaxStreams is the maximum number of concurrent streams of a websocket
+	MaxStreams = 64
+)
+
+type frame struct {
+	kind    byte
+	stream  uint32
+	payload []byte
+}
+
+func (f frame) marshal() []byte {
+	buf := make([]byte, headerSize+len(f.payload))
+	buf[0] = f.kind
+	binary.BigEndian.PutUint32(buf[1:headerSize], f.stream)
+	copy(buf[headerSize:], f.payload)
+
+	return buf
+}
+
+func parseFrame(data []byte) (frame, error) {
+	if len(data) < headerSize {
+		return frame{}, fmt.Errorf("frame too short: %d bytes", len(data))
+	}
+
+	if len(data)-headerSize > MaxPayload {
+		return frame{}, fmt.Errorf("frame payload too large: %d bytes", len(data)-headerSize)
+	}
+
+	f := frame{
+		kind:    data[0],
+		stream:  binary.BigEndian.Uint32(data[1:headerSize]),
+		payload: data[headerSize:],
+	}
+	if f.kind < frameOpen || f.kind > frameClose {
+		return frame{}, fmt.Errorf("unknown frame type %d", f.kind)
+	}
+
+	return f, nil
+}
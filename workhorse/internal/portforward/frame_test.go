@@ -0,0 +1,30 @@
+package portforward
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	f := frame{kind: frameData, stream: 258, payload: []byte("hello")}
+
+	data := f.marshal()
+	require.Equal(t, []byte{frameData, 0, 0, 1, 2, 'h', 'e', 'l', 'l', 'o'}, data)
+
+	parsed, err := parseFrame(data)
+	require.NoError(t, err)
+	require.Equal(t, f, parsed)
+}
+
+func TestParseFrameErrors(t *testing.T) {
+	for desc, data := range map[string][]byte{
+		"too short":     {frameData, 0, 0},
+		"unknown type":  {9, 0, 0, 0, 1},
+		"zero type":     {0, 0, 0, 0, 1},
+		"large payload": make([]byte, headerSize+MaxPayload+1),
+	} {
+		_, err := parseFrame(data)
+		require.Error(t, err, desc)
+	}
+}
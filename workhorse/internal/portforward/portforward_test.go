@@ -0,0 +1,261 @@
+package portforward
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func startEchoServer(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func startClient(t *testing.T, targets []string) *Client {
+	t.Helper()
+
+	client, _ := startClientWithLimits(t, targets, Limits{})
+	return client
+}
+
+// startClientWithLimits also returns the result of Serve.
+func startClientWithLimits(t *testing.T, targets []string, limits Limits) (*Client, chan error) {
+	t.Helper()
+
+	served := make(chan error, 1)
+
+	upgrader := &websocket.Upgrader{Subprotocols: []string{Subprotocol}}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		served <- NewServer(conn, targets, limits).Serve(context.Background())
+	}))
+	t.Cleanup(ts.Close)
+
+	dialer := &websocket.Dialer{Subprotocols: []string{Subprotocol}}
+	conn, resp, err := dialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	t.Cleanup(func() { conn.Close() })
+
+	return NewClient(conn), served
+}
+
+func forward(t *testing.T, client *Client, target string) (net.Conn, chan error) {
+	t.Helper()
+
+	local, remote := net.Pipe()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- client.Forward(remote, target)
+	}()
+
+	return local, errCh
+}
+
+func TestForward(t *testing.T) {
+	echo := startEchoServer(t)
+	client := startClient(t, []string{echo})
+
+	// Several streams share the websocket
+	var conns []net.Conn
+	var errChs []chan error
+	for i := 0; i < 3; i++ {
+		local, errCh := forward(t, client, echo)
+		conns = append(conns, local)
+		errChs = append(errChs, errCh)
+	}
+
+	for i, local := range conns {
+		msg := strings.Repeat("x", i*MaxPayload) + "hello\n"
+		go func() {
+			_, _ = local.Write([]byte(msg))
+		}()
+
+		line, err := bufio.NewReader(local).ReadString('\n')
+		require.NoError(t, err)
+		require.Equal(t, msg, line)
+	}
+
+	for i, local := range conns {
+		require.NoError(t, local.Close())
+		require.NoError(t, <-errChs[i])
+	}
+}
+
+func TestForwardTargetNotAllowed(t *testing.T) {
+	client := startClient(t, []string{"127.0.0.1:1"})
+
+	local, errCh := forward(t, client, startEchoServer(t))
+	defer local.Close()
+
+	err := <-errCh
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "target not allowed")
+}
+
+func TestForwardConnectionFailed(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	target := ln.Addr().String()
+	ln.Close()
+
+	client := startClient(t, []string{target})
+
+	local, errCh := forward(t, client, target)
+	defer local.Close()
+
+	err = <-errCh
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "connection failed")
+}
+
+func TestForwardTargetCloses(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = conn.Write([]byte("bye"))
+		conn.Close()
+	}()
+
+	client := startClient(t, []string{ln.Addr().String()})
+
+	local, errCh := forward(t, client, ln.Addr().String())
+	data, err := io.ReadAll(local)
+	require.NoError(t, err)
+	require.Equal(t, "bye", string(data))
+	require.NoError(t, <-errCh)
+}
+
+func TestServeLimits(t *testing.T) {
+	tests := []struct {
+		desc   string
+		limits Limits
+		send   string
+		reason string
+	}{
+		{desc: "idle", limits: Limits{MaxIdleTime: 50 * time.Millisecond}, reason: "idle for more than 50ms"},
+		{desc: "input", limits: Limits{MaxInputBytes: 10}, send: "more than ten bytes\n", reason: "input limit of 10 bytes exceeded"},
+		{desc: "output", limits: Limits{MaxInputBytes: 100, MaxOutputBytes: 10}, send: "more than ten bytes\n", reason: "output limit of 10 bytes exceeded"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			echo := startEchoServer(t)
+			client, served := startClientWithLimits(t, []string{echo}, tc.limits)
+
+			local, _ := forward(t, client, echo)
+			defer local.Close()
+			if tc.send != "" {
+				go func() {
+					_, _ = local.Write([]byte(tc.send))
+				}()
+			}
+
+			var err error
+			select {
+			case err = <-served:
+			case <-time.After(5 * time.Second):
+				t.Fatal("server did not stop")
+			}
+
+			var limitErr *LimitError
+			require.ErrorAs(t, err, &limitErr)
+			require.Equal(t, tc.reason, limitErr.Reason)
+		})
+	}
+}
+
+func TestForwardSlowTarget(t *testing.T) {
+	// A target that never reads the data sent to it
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	defer func() {
+		select {
+		case conn := <-accepted:
+			conn.Close()
+		default:
+		}
+	}()
+
+	slow := ln.Addr().String()
+	echo := startEchoServer(t)
+	client := startClient(t, []string{slow, echo})
+
+	slowLocal, slowErrCh := forward(t, client, slow)
+	defer slowLocal.Close()
+	go func() {
+		// More than the socket buffers and the stream queue can hold
+		chunk := make([]byte, MaxPayload)
+		for i := 0; i < 4096; i++ {
+			if _, err := slowLocal.Write(chunk); err != nil {
+				return
+			}
+		}
+	}()
+
+	// The slow stream is closed once its queue is full
+	select {
+	case err := <-slowErrCh:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("slow stream was not closed")
+	}
+
+	// Meanwhile, other streams keep working
+	local, errCh := forward(t, client, echo)
+	go func() {
+		_, _ = local.Write([]byte("hello\n"))
+	}()
+
+	line, err := bufio.NewReader(local).ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "hello\n", line)
+
+	require.NoError(t, local.Close())
+	require.NoError(t, <-errCh)
+}
@@ -0,0 +1,341 @@
+package portforward
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// DialTimeout limits how long connecting a stream to its target may take
+var DialTimeout = 10 * time.Second
+
+// streamQueueSize is the number of data frames buffered for a target that
+// does not read them fast enough. The stream is closed when it is exceeded,
+// so that a slow target does not hold up the other streams.
+const streamQueueSize = 16
+
+// Limits stop the port forwarding of a websocket. Zero means no limit.
+type Limits struct {
+	// MaxIdleTime stops forwarding when no data is sent in either direction
+	// for that long
+	MaxIdleTime time.Duration
+	// MaxInputBytes and MaxOutputBytes stop forwarding when more data is
+	// sent to or received from the targets, over all streams
+	MaxInputBytes  int64
+	MaxOutputBytes int64
+}
+
+// LimitError is returned by Serve when a limit was reached. Its reason is
+// meant to be shown to the client.
+type LimitError struct {
+	Reason string
+}
+
+func (e *LimitError) Error() string {
+	return "port-forward: " + e.Reason
+}
+
+// Server connects the streams of a websocket client to TCP targets.
+type Server struct {
+	conn    *frameConn
+	targets []string
+	limits  Limits
+
+	mu sync.Mutex
+	// streams holds nil for streams that are being connected
+	streams map[uint32]*serverStream
+
+	stopCh       chan error
+	lastActivity atomic.Int64
+	inputBytes   atomic.Int64
+	outputBytes  atomic.Int64
+}
+
+type serverStream struct {
+	conn net.Conn
+	// queue holds the data to write to conn, and is closed with the stream
+	queue chan []byte
+}
+
+// NewServer returns a server that allows connections to targets only.
+func NewServer(conn *websocket.Conn, targets []string, limits Limits) *Server {
+	return &Server{
+		conn:    &frameConn{conn: conn},
+		targets: targets,
+		limits:  limits,
+		streams: make(map[uint32]*serverStream),
+		stopCh:  make(chan error, 1),
+	}
+}
+
+// Serve handles frames until the websocket fails, a limit is reached or ctx
+// is done. All streams are closed when it returns.
+func (s *Server) Serve(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer s.closeAll()
+
+	s.lastActivity.Store(time.Now().UnixNano())
+	if s.limits.MaxIdleTime > 0 {
+		go s.idleLoop(ctx)
+	}
+
+	go func() {
+		s.stop(s.readLoop(ctx))
+	}()
+
+	select {
+	case err := <-s.stopCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Server) readLoop(ctx context.Context) error {
+	for {
+		f, err := s.conn.read()
+		if err != nil {
+			return fmt.Errorf("port-forward: read: %w", err)
+		}
+
+		switch f.kind {
+		case frameOpen:
+			s.open(ctx, f.stream, string(f.payload))
+		case frameData:
+			if err := s.data(f.stream, f.payload); err != nil {
+				return err
+			}
+		case frameClose:
+			s.remove(f.stream)
+		default:
+			return fmt.Errorf("port-forward: unexpected frame type %d", f.kind)
+		}
+	}
+}
+
+// stop makes Serve return err, unless it is already returning.
+func (s *Server) stop(err error) {
+	select {
+	case s.stopCh <- err:
+	default:
+	}
+}
+
+func (s *Server) open(ctx context.Context, stream uint32, target string) {
+	if !slices.Contains(s.targets, target) {
+		s.reject(stream, "target not allowed")
+		return
+	}
+
+	s.mu.Lock()
+	_, exists := s.streams[stream]
+	full := len(s.streams) >= MaxStreams
+	if !exists && !full {
+		s.streams[stream] = nil
+	}
+	s.mu.Unlock()
+
+	switch {
+	case exists:
+		s.reject(stream, "stream already open")
+		return
+	case full:
+		s.reject(stream, "too many streams")
+		return
+	}
+
+	go s.connect(ctx, stream, target)
+}
+
+func (s *Server) connect(ctx context.Context, stream uint32, target string) {
+	dialer := &net.Dialer{Timeout: DialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", target)
+	if err != nil {
+		s.remove(stream)
+		s.reject(stream, "connection failed")
+		return
+	}
+
+	st := &serverStream{conn: conn, queue: make(chan []byte, streamQueueSize)}
+
+	s.mu.Lock()
+	_, ok := s.streams[stream]
+	if ok {
+		s.streams[stream] = st
+	}
+	s.mu.Unlock()
+
+	// The client closed the stream while it was being connected
+	if !ok {
+		_ = conn.Close()
+		return
+	}
+
+	go s.writeLoop(stream, st)
+
+	if err := s.conn.write(frame{kind: frameOpened, stream: stream}); err != nil {
+		s.remove(stream)
+		return
+	}
+
+	reason := copyToFrames(s.conn, &outputReader{Reader: conn, server: s}, stream)
+	if s.remove(stream) {
+		_ = s.conn.write(frame{kind: frameClose, stream: stream, payload: []byte(reason)})
+	}
+}
+
+// writeLoop writes the data queued for a stream to its target, so that the
+// frames of other streams are handled while the target is busy.
+func (s *Server) writeLoop(stream uint32, st *serverStream) {
+	for payload := range st.queue {
+		if _, err := st.conn.Write(payload); err != nil {
+			if s.remove(stream) {
+				s.reject(stream, "write failed")
+			}
+			return
+		}
+	}
+}
+
+func (s *Server) data(stream uint32, payload []byte) error {
+	s.touch()
+	if s.limits.MaxInputBytes > 0 && s.inputBytes.Add(int64(len(payload))) > s.limits.MaxInputBytes {
+		return &LimitError{Reason: fmt.Sprintf("input limit of %d bytes exceeded", s.limits.MaxInputBytes)}
+	}
+
+	s.mu.Lock()
+	st := s.streams[stream]
+	queued := false
+	if st != nil {
+		select {
+		case st.queue <- payload:
+			queued = true
+		default:
+		}
+	}
+	s.mu.Unlock()
+
+	if queued {
+		return nil
+	}
+
+	reason := "stream not open"
+	if st != nil {
+		reason = "stream buffer full"
+	}
+
+	// The stream may still be connecting
+	s.remove(stream)
+	s.reject(stream, reason)
+
+	return nil
+}
+
+func (s *Server) reject(stream uint32, reason string) {
+	_ = s.conn.write(frame{kind: frameClose, stream: stream, payload: []byte(reason)})
+}
+
+// remove closes a stream. It returns false if the stream was not open.
+func (s *Server) remove(stream uint32) bool {
+	s.mu.Lock()
+	st, ok := s.streams[stream]
+	delete(s.streams, stream)
+	if st != nil {
+		close(st.queue)
+	}
+	s.mu.Unlock()
+
+	if st != nil {
+		_ = st.conn.Close()
+	}
+
+	return ok
+}
+
+func (s *Server) closeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for stream, st := range s.streams {
+		if st != nil {
+			close(st.queue)
+			_ = st.conn.Close()
+		}
+		delete(s.streams, stream)
+	}
+}
+
+func (s *Server) touch() {
+	s.lastActivity.Store(time.Now().UnixNano())
+}
+
+func (s *Server) idleLoop(ctx context.Context) {
+	ticker := time.NewTicker(max(min(s.limits.MaxIdleTime/4, time.Second), time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if time.Since(time.Unix(0, s.lastActivity.Load())) >= s.limits.MaxIdleTime {
+			s.stop(&LimitError{Reason: fmt.Sprintf("idle for more than %v", s.limits.MaxIdleTime)})
+			return
+		}
+	}
+}
+
+// outputReader accounts for the data a target sends to the client.
+type outputReader struct {
+	io.Reader
+	server *Server
+}
+
+func (r *outputReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n == 0 {
+		return n, err
+	}
+
+	s := r.server
+	s.touch()
+	if s.limits.MaxOutputBytes > 0 && s.outputBytes.Add(int64(n)) > s.limits.MaxOutputBytes {
+		limitErr := &LimitError{Reason: fmt.Sprintf("output limit of %d bytes exceeded", s.limits.MaxOutputBytes)}
+		s.stop(limitErr)
+		return 0, limitErr
+	}
+
+	return n, err
+}
+
+// copyToFrames sends data read from r as data frames of a stream. It
+// returns the reason the stream ended.
+func copyToFrames(conn *frameConn, r io.Reader, stream uint32) string {
+	buf := make([]byte, MaxPayload)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if writeErr := conn.write(frame{kind: frameData, stream: stream, payload: buf[:n]}); writeErr != nil {
+				return ""
+			}
+		}
+
+		if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+			return ""
+		}
+		if err != nil {
+			return "read failed"
+		}
+	}
+}
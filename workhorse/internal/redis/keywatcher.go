@@ -14,20 +14,45 @@ import (
 	"github.com/redis/go-redis/v9"
 
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/log"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/notification"
 )
 
 type KeyWatcher struct {
-	mu               sync.Mutex
-	subscribers      map[string][]chan string
-	shutdown         chan struct{}
+	mu sync.Mutex
+	// hub holds the watchers of keys. Its subscriptions are changed with
+	// kw.mu held, as they are tied to the Redis subscriptions.
+	hub              *notification.Hub
 	reconnectBackoff backoff.Backoff
-	redisConn        *redis.Client // can be nil
+	redisConn        redis.UniversalClient // can be nil
 	conn             *redis.PubSub
+	// shards holds a sharded pubsub per Redis Cluster primary that serves
+	// watched keys, by address, and is nil while not connected.
+	shards map[string]*shard
+	// channelShards holds the shard that each channel is subscribed on
+	channelShards map[string]*shard
+	shardErrors   chan error
+	// maxShards limits the connections of shards. Keys served by other
+	// nodes are not watched beyond it, so that their requests are proxied.
+	maxShards int
+	// masterForKey returns the Redis Cluster primary that serves a key
+	masterForKey func(ctx context.Context, key string) (*redis.Client, error)
 }
 
-func NewKeyWatcher(redisConn *redis.Client) *KeyWatcher {
-	return &KeyWatcher{
-		shutdown: make(chan struct{}),
+// shard is a sharded pubsub connection to a Redis Cluster primary. The
+// channels of all hash slots the node serves are subscribed one by one,
+// because SSUBSCRIBE only accepts channels of a single slot.
+type shard struct {
+	pubsub *redis.PubSub
+	addr   string
+	keys   int
+	// unsubscribing counts the SUNSUBSCRIBE replies we expect per channel,
+	// to tell them apart from the node unsubscribing a slot that moved
+	unsubscribing map[string]int
+}
+
+func NewKeyWatcher(redisConn redis.UniversalClient) *KeyWatcher {
+	kw := &KeyWatcher{
+		hub: notification.NewHub("redis", KeyWatchers),
 		reconnectBackoff: backoff.Backoff{
 			Min:    100 * time.Millisecond,
 			Max:    60 * time.Second,
@@ -35,7 +60,14 @@ func NewKeyWatcher(redisConn *redis.Client) *KeyWatcher {
 			Jitter: true,
 		},
 		redisConn: redisConn,
+		maxShards: defaultMaxShards,
+	}
+
+	if cluster, ok := redisConn.(*redis.ClusterClient); ok {
+		kw.masterForKey = cluster.MasterForKey
 	}
+
+	return kw
 }
 
 var (
@@ -64,6 +96,12 @@ var (
 		},
 		[]string{"action"},
 	)
+	ShardConnections = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "gitlab_workhorse_keywatcher_redis_shard_connections",
+			Help: "Current number of keywatcher Redis Cluster sharded pubsub connections",
+		},
+	)
 	ReceivedBytes = promauto.NewCounter(
 		prometheus.CounterOpts{
 			Name: "gitlab_workhorse_keywatcher_received_bytes_total",
@@ -72,7 +110,15 @@ var (
 	)
 )
 
-const channelPrefix = "workhorse:notifications:"
+const (
+	channelPrefix = "workhorse:notifications:"
+
+	// defaultMaxShards bounds the sharded pubsub connections, of which
+	// there is one per Redis Cluster primary that serves watched keys
+	defaultMaxShards = 64
+)
+
+var errTooManyShards = errors.New("keywatcher: too many sharded pubsub connections")
 
 func countAction(action string) { TotalActions.WithLabelValues(action).Add(1) }
 
@@ -90,15 +136,9 @@ func (kw *KeyWatcher) receivePubSubStream(ctx context.Context, pubsub *redis.Pub
 		kw.conn.Close()
 		kw.conn = nil
 
-		// Reset kw.subscribers because it is tied to Redis server side state of
-		// kw.conn and we just closed that connection.
-		for _, chans := range kw.subscribers {
-			for _, ch := range chans {
-				close(ch)
-				KeyWatchers.Dec()
-			}
-		}
-		kw.subscribers = nil
+		// Reset the watchers because they are tied to Redis server side state
+		// of kw.conn and we just closed that connection.
+		kw.hub.CloseAll()
 	}()
 
 	for {
@@ -108,151 +148,285 @@ func (kw *KeyWatcher) receivePubSubStream(ctx context.Context, pubsub *redis.Pub
 			return nil
 		}
 
-		switch msg := msg.(type) {
-		case *redis.Subscription:
-			RedisSubscriptions.Set(float64(msg.Count))
-		case *redis.Pong:
-			// Ignore.
-		case *redis.Message:
-			TotalMessages.Inc()
-			ReceivedBytes.Add(float64(len(msg.Payload)))
-			if strings.HasPrefix(msg.Channel, channelPrefix) {
-				kw.notifySubscribers(msg.Channel[len(channelPrefix):], string(msg.Payload))
-			}
-		default:
-			log.WithError(fmt.Errorf("keywatcher: unknown: %T", msg)).Error()
+		if err := kw.handleMessage(msg); err != nil {
+			log.WithError(err).Error()
 			return nil
 		}
 	}
 }
 
-func (kw *KeyWatcher) Process() {
-	log.Info("keywatcher: starting process loop")
+// receiveShardedPubSubStreams serves the sharded pubsub connections that
+// addSubscription opens, until one of them fails. The others are then
+// closed too, so that the watchers resubscribe once Process reconnects.
+func (kw *KeyWatcher) receiveShardedPubSubStreams() error {
+	shardErrors := make(chan error, 1)
 
-	ctx := context.Background() // lint:allow context.Background
+	kw.mu.Lock()
+	kw.shards = make(map[string]*shard)
+	kw.channelShards = make(map[string]*shard)
+	kw.shardErrors = shardErrors
+	kw.mu.Unlock()
 
-	for {
-		pubsub := kw.redisConn.Subscribe(ctx, []string{}...)
-		if err := pubsub.Ping(ctx); err != nil {
-			log.WithError(fmt.Errorf("keywatcher: %v", err)).Error()
-			time.Sleep(kw.reconnectBackoff.Duration())
-			continue
+	defer func() {
+		kw.mu.Lock()
+		defer kw.mu.Unlock()
+		for _, s := range kw.shards {
+			s.pubsub.Close()
+			ShardConnections.Dec()
 		}
+		kw.shards = nil
+		kw.channelShards = nil
+		RedisSubscriptions.Set(0)
 
-		kw.reconnectBackoff.Reset()
+		kw.hub.CloseAll()
+	}()
 
-		if err := kw.receivePubSubStream(ctx, pubsub); err != nil {
-			log.WithError(fmt.Errorf("keywatcher: receivePubSubStream: %v", err)).Error()
-		}
-	}
+	err := <-shardErrors
+	log.WithError(fmt.Errorf("keywatcher: sharded pubsub receive: %v", err)).Error()
+	return nil
 }
 
-func (kw *KeyWatcher) Shutdown() {
-	log.Info("keywatcher: shutting down")
+func (kw *KeyWatcher) receiveShard(ctx context.Context, s *shard, shardErrors chan<- error) {
+	var err error
+	for err == nil {
+		var msg interface{}
+		if msg, err = s.pubsub.Receive(ctx); err != nil {
+			break
+		}
+
+		if sub, ok := msg.(*redis.Subscription); ok && sub.Kind == "sunsubscribe" && !kw.expectedUnsubscribe(s, sub.Channel) {
+			// The node unsubscribes the channels of a slot that moved to
+			// another node
+			err = fmt.Errorf("%s unsubscribed by server %s", sub.Channel, s.addr)
+			break
+		}
+
+		err = kw.handleMessage(msg)
+	}
 
 	kw.mu.Lock()
 	defer kw.mu.Unlock()
 
+	if kw.shards[s.addr] != s {
+		// The shard was closed because it has no subscriptions left, or
+		// because all shards were closed.
+		return
+	}
+
 	select {
-	case <-kw.shutdown:
-		// already closed
+	case shardErrors <- err:
 	default:
-		close(kw.shutdown)
 	}
 }
 
-func (kw *KeyWatcher) notifySubscribers(key, value string) {
+// expectedUnsubscribe reports whether we unsubscribed from channel on s
+// ourselves.
+func (kw *KeyWatcher) expectedUnsubscribe(s *shard, channel string) bool {
 	kw.mu.Lock()
 	defer kw.mu.Unlock()
 
-	chanList, ok := kw.subscribers[key]
-	if !ok {
-		countAction("drop-message")
-		return
+	if s.unsubscribing[channel] == 0 {
+		return false
 	}
 
-	countAction("deliver-message")
-	for _, c := range chanList {
-		select {
-		case c <- value:
-		default:
+	if s.unsubscribing[channel]--; s.unsubscribing[channel] == 0 {
+		delete(s.unsubscribing, channel)
+	}
+	return true
+}
+
+func (kw *KeyWatcher) handleMessage(msg interface{}) error {
+	switch msg := msg.(type) {
+	case *redis.Subscription:
+		// The count of sharded subscriptions is per shard, so subscribe and
+		// unsubscribe track those instead.
+		if msg.Kind == "subscribe" || msg.Kind == "unsubscribe" {
+			RedisSubscriptions.Set(float64(msg.Count))
+		}
+	case *redis.Pong:
+		// Ignore.
+	case *redis.Message:
+		TotalMessages.Inc()
+		ReceivedBytes.Add(float64(len(msg.Payload)))
+		if strings.HasPrefix(msg.Channel, channelPrefix) {
+			if kw.hub.Notify(msg.Channel[len(channelPrefix):], string(msg.Payload)) {
+				countAction("deliver-message")
+			} else {
+				countAction("drop-message")
+			}
 		}
+	default:
+		return fmt.Errorf("keywatcher: unknown: %T", msg)
 	}
+
+	return nil
 }
 
-func (kw *KeyWatcher) addSubscription(ctx context.Context, key string, notify chan string) error {
+func (kw *KeyWatcher) Process() {
+	log.Info("keywatcher: starting process loop")
+
+	ctx := context.Background() // lint:allow context.Background
+
+	for {
+		receive, err := kw.connect(ctx)
+		if err != nil {
+			log.WithError(fmt.Errorf("keywatcher: %v", err)).Error()
+			time.Sleep(kw.reconnectBackoff.Duration())
+			continue
+		}
+
+		kw.reconnectBackoff.Reset()
+
+		if err := receive(); err != nil {
+			log.WithError(fmt.Errorf("keywatcher: receive: %v", err)).Error()
+		}
+	}
+}
+
+func (kw *KeyWatcher) connect(ctx context.Context) (func() error, error) {
+	if cluster, ok := kw.redisConn.(*redis.ClusterClient); ok {
+		// Sharded pubsub connections are opened per hash slot when keys are
+		// watched, so only check that the cluster is reachable.
+		if err := cluster.Ping(ctx).Err(); err != nil {
+			return nil, err
+		}
+
+		return kw.receiveShardedPubSubStreams, nil
+	}
+
+	pubsub := kw.redisConn.Subscribe(ctx, []string{}...)
+	if err := pubsub.Ping(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	return func() error { return kw.receivePubSubStream(ctx, pubsub) }, nil
+}
+
+func (kw *KeyWatcher) Shutdown() {
+	kw.hub.Shutdown()
+}
+
+func (kw *KeyWatcher) addSubscription(ctx context.Context, key string) (chan string, error) {
 	kw.mu.Lock()
 	defer kw.mu.Unlock()
 
-	if kw.conn == nil {
+	if kw.conn == nil && kw.shards == nil {
 		// This can happen because CI long polling is disabled in this Workhorse
 		// process. It can also be that we are waiting for the pubsub connection
 		// to be established. Either way it is OK to fail fast.
-		return errors.New("no redis connection")
+		return nil, errors.New("no redis connection")
 	}
 
-	if len(kw.subscribers[key]) == 0 {
+	if kw.hub.Watchers(key) == 0 {
 		countAction("create-subscription")
-		if err := kw.conn.Subscribe(ctx, channelPrefix+key); err != nil {
-			return err
+		if err := kw.subscribe(ctx, key); err != nil {
+			return nil, err
 		}
 	}
 
-	if kw.subscribers == nil {
-		kw.subscribers = make(map[string][]chan string)
-	}
-	kw.subscribers[key] = append(kw.subscribers[key], notify)
-	KeyWatchers.Inc()
-
-	return nil
+	return kw.hub.Subscribe(key), nil
 }
 
 func (kw *KeyWatcher) delSubscription(ctx context.Context, key string, notify chan string) {
 	kw.mu.Lock()
 	defer kw.mu.Unlock()
 
-	chans, ok := kw.subscribers[key]
-	if !ok {
+	if !kw.hub.Unsubscribe(key, notify) {
 		// This can happen if the pubsub connection dropped while we were
 		// waiting.
 		return
 	}
 
-	for i, c := range chans {
-		if notify == c {
-			kw.subscribers[key] = append(chans[:i], chans[i+1:]...)
-			KeyWatchers.Dec()
-			break
+	if kw.hub.Watchers(key) == 0 {
+		countAction("delete-subscription")
+		kw.unsubscribe(ctx, key)
+	}
+}
+
+// subscribe must be called with kw.mu held.
+func (kw *KeyWatcher) subscribe(ctx context.Context, key string) error {
+	if kw.shards == nil {
+		return kw.conn.Subscribe(ctx, channelPrefix+key)
+	}
+
+	channel := channelPrefix + key
+	node, err := kw.masterForKey(ctx, channel)
+	if err != nil {
+		return err
+	}
+
+	addr := node.Options().Addr
+	s, ok := kw.shards[addr]
+	if !ok && len(kw.shards) >= kw.maxShards {
+		countAction("shard-limit")
+		return errTooManyShards
+	} else if !ok {
+		countAction("create-shard")
+		s = &shard{pubsub: node.SSubscribe(ctx), addr: addr, unsubscribing: make(map[string]int)}
+		if err := kw.ssubscribe(ctx, s, channel); err != nil {
+			s.pubsub.Close()
+			return err
 		}
+
+		kw.shards[addr] = s
+		ShardConnections.Inc()
+		// The shard outlives the request that opened it.
+		go kw.receiveShard(context.WithoutCancel(ctx), s, kw.shardErrors)
+	} else if err := kw.ssubscribe(ctx, s, channel); err != nil {
+		return err
 	}
-	if len(kw.subscribers[key]) == 0 {
-		delete(kw.subscribers, key)
-		countAction("delete-subscription")
+
+	kw.channelShards[channel] = s
+	s.keys++
+	RedisSubscriptions.Inc()
+	return nil
+}
+
+// ssubscribe subscribes to channel on s. When it fails, for example
+// because the slot of channel moved, the cluster topology is reloaded so
+// that the next watch finds the right node.
+func (kw *KeyWatcher) ssubscribe(ctx context.Context, s *shard, channel string) error {
+	err := s.pubsub.SSubscribe(ctx, channel)
+	if cluster, ok := kw.redisConn.(*redis.ClusterClient); ok && err != nil {
+		cluster.ReloadState(ctx)
+	}
+	return err
+}
+
+// unsubscribe must be called with kw.mu held.
+func (kw *KeyWatcher) unsubscribe(ctx context.Context, key string) {
+	if kw.shards == nil {
 		if kw.conn != nil {
 			kw.conn.Unsubscribe(ctx, channelPrefix+key)
 		}
+		return
 	}
-}
 
-// WatchKeyStatus is used to tell how WatchKey returned
-type WatchKeyStatus int
+	channel := channelPrefix + key
+	s, ok := kw.channelShards[channel]
+	if !ok {
+		return
+	}
+	delete(kw.channelShards, channel)
 
-const (
-	// WatchKeyStatusTimeout is returned when the watch timeout provided by the caller was exceeded
-	WatchKeyStatusTimeout WatchKeyStatus = iota
-	// WatchKeyStatusAlreadyChanged is returned when the value passed by the caller was never observed
-	WatchKeyStatusAlreadyChanged
-	// WatchKeyStatusSeenChange is returned when we have seen the value passed by the caller get changed
-	WatchKeyStatusSeenChange
-	// WatchKeyStatusNoChange is returned when the function had to return before observing a change.
-	//  Also returned on errors.
-	WatchKeyStatusNoChange
-)
+	RedisSubscriptions.Dec()
+	if s.keys--; s.keys > 0 {
+		s.unsubscribing[channel]++
+		s.pubsub.SUnsubscribe(ctx, channel)
+		return
+	}
+
+	countAction("delete-shard")
+	delete(kw.shards, s.addr)
+	ShardConnections.Dec()
+	s.pubsub.Close()
+}
 
-func (kw *KeyWatcher) WatchKey(ctx context.Context, key, value string, timeout time.Duration) (WatchKeyStatus, error) {
-	notify := make(chan string, 1)
-	if err := kw.addSubscription(ctx, key, notify); err != nil {
-		return WatchKeyStatusNoChange, err
+func (kw *KeyWatcher) WatchKey(ctx context.Context, key, value string, timeout time.Duration) (notification.WatchKeyStatus, error) {
+	notify, err := kw.addSubscription(ctx, key)
+	if err != nil {
+		return notification.WatchKeyStatusNoChange, err
 	}
 	defer kw.delSubscription(ctx, key, notify)
 
@@ -260,25 +434,11 @@ func (kw *KeyWatcher) WatchKey(ctx context.Context, key, value string, timeout t
 	if errors.Is(err, redis.Nil) {
 		currentValue = ""
 	} else if err != nil {
-		return WatchKeyStatusNoChange, fmt.Errorf("keywatcher: redis GET: %v", err)
+		return notification.WatchKeyStatusNoChange, fmt.Errorf("keywatcher: redis GET: %v", err)
 	}
 	if currentValue != value {
-		return WatchKeyStatusAlreadyChanged, nil
+		return notification.WatchKeyStatusAlreadyChanged, nil
 	}
 
-	select {
-	case <-kw.shutdown:
-		log.WithFields(log.Fields{"key": key}).Info("stopping watch due to shutdown")
-		return WatchKeyStatusNoChange, nil
-	case currentValue := <-notify:
-		if currentValue == "" {
-			return WatchKeyStatusNoChange, fmt.Errorf("keywatcher: redis GET failed")
-		}
-		if currentValue == value {
-			return WatchKeyStatusNoChange, nil
-		}
-		return WatchKeyStatusSeenChange, nil
-	case <-time.After(timeout):
-		return WatchKeyStatusTimeout, nil
-	}
+	return kw.hub.Wait(key, value, notify, timeout)
 }
@@ -2,6 +2,7 @@ package redis
 
 import (
 	"context"
+	"errors"
 	"os"
 	"sync"
 	"testing"
@@ -12,6 +13,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/config"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/notification"
 )
 
 var ctx = context.Background()
@@ -20,7 +22,7 @@ const (
 	runnerKey = "runner:build_queue:10"
 )
 
-func initRdb(t *testing.T) *redis.Client {
+func initRdb(t *testing.T) redis.UniversalClient {
 	buf, err := os.ReadFile("../../config.toml")
 	require.NoError(t, err)
 	cfg, err := config.LoadConfig(string(buf))
@@ -34,9 +36,7 @@ func initRdb(t *testing.T) *redis.Client {
 }
 
 func countSubscribers(kw *KeyWatcher, key string) int {
-	kw.mu.Lock()
-	defer kw.mu.Unlock()
-	return len(kw.subscribers[key])
+	return kw.hub.Watchers(key)
 }
 
 // Forces a run of the `Process` loop against a mock PubSubConn.
@@ -75,7 +75,7 @@ type keyChangeTestCase struct {
 	isKeyMissing   bool
 	watchValue     string
 	processedValue string
-	expectedStatus WatchKeyStatus
+	expectedStatus notification.WatchKeyStatus
 	timeout        time.Duration
 }
 
@@ -83,12 +83,12 @@ func TestKeyChangesInstantReturn(t *testing.T) {
 	rdb := initRdb(t)
 
 	testCases := []keyChangeTestCase{
-		// WatchKeyStatusAlreadyChanged
+		// notification.WatchKeyStatusAlreadyChanged
 		{
 			desc:           "sees change with key existing and changed",
 			returnValue:    "somethingelse",
 			watchValue:     "something",
-			expectedStatus: WatchKeyStatusAlreadyChanged,
+			expectedStatus: notification.WatchKeyStatusAlreadyChanged,
 			timeout:        time.Second,
 		},
 		{
@@ -96,22 +96,22 @@ func TestKeyChangesInstantReturn(t *testing.T) {
 			isKeyMissing:   true,
 			watchValue:     "something",
 			processedValue: "somethingelse",
-			expectedStatus: WatchKeyStatusAlreadyChanged,
+			expectedStatus: notification.WatchKeyStatusAlreadyChanged,
 			timeout:        time.Second,
 		},
-		// WatchKeyStatusTimeout
+		// notification.WatchKeyStatusTimeout
 		{
 			desc:           "sees timeout with key existing and unchanged",
 			returnValue:    "something",
 			watchValue:     "something",
-			expectedStatus: WatchKeyStatusTimeout,
+			expectedStatus: notification.WatchKeyStatusTimeout,
 			timeout:        time.Millisecond,
 		},
 		{
 			desc:           "sees timeout with key non-existing and unchanged",
 			isKeyMissing:   true,
 			watchValue:     "",
-			expectedStatus: WatchKeyStatusTimeout,
+			expectedStatus: notification.WatchKeyStatusTimeout,
 			timeout:        time.Millisecond,
 		},
 	}
@@ -142,28 +142,28 @@ func TestKeyChangesWhenWatching(t *testing.T) {
 	rdb := initRdb(t)
 
 	testCases := []keyChangeTestCase{
-		// WatchKeyStatusSeenChange
+		// notification.WatchKeyStatusSeenChange
 		{
 			desc:           "sees change with key existing",
 			returnValue:    "something",
 			watchValue:     "something",
 			processedValue: "somethingelse",
-			expectedStatus: WatchKeyStatusSeenChange,
+			expectedStatus: notification.WatchKeyStatusSeenChange,
 		},
 		{
 			desc:           "sees change with key non-existing, when watching empty value",
 			isKeyMissing:   true,
 			watchValue:     "",
 			processedValue: "something",
-			expectedStatus: WatchKeyStatusSeenChange,
+			expectedStatus: notification.WatchKeyStatusSeenChange,
 		},
-		// WatchKeyStatusNoChange
+		// notification.WatchKeyStatusNoChange
 		{
 			desc:           "sees no change with key existing",
 			returnValue:    "something",
 			watchValue:     "something",
 			processedValue: "something",
-			expectedStatus: WatchKeyStatusNoChange,
+			expectedStatus: notification.WatchKeyStatusNoChange,
 		},
 	}
 
@@ -204,14 +204,14 @@ func TestKeyChangesParallel(t *testing.T) {
 			returnValue:    "something",
 			watchValue:     "something",
 			processedValue: "somethingelse",
-			expectedStatus: WatchKeyStatusSeenChange,
+			expectedStatus: notification.WatchKeyStatusSeenChange,
 		},
 		{
 			desc:           "massively parallel, sees change with key existing, watching missing keys",
 			isKeyMissing:   true,
 			watchValue:     "",
 			processedValue: "somethingelse",
-			expectedStatus: WatchKeyStatusSeenChange,
+			expectedStatus: notification.WatchKeyStatusSeenChange,
 		},
 	}
 
@@ -265,7 +265,7 @@ func TestShutdown(t *testing.T) {
 		val, err := kw.WatchKey(ctx, runnerKey, "something", 10*time.Second)
 
 		require.NoError(t, err, "Expected no error")
-		require.Equal(t, WatchKeyStatusNoChange, val, "Expected value not to change")
+		require.Equal(t, notification.WatchKeyStatusNoChange, val, "Expected value not to change")
 	}()
 
 	go func() {
@@ -280,7 +280,7 @@ func TestShutdown(t *testing.T) {
 	require.Eventually(t, func() bool { return countSubscribers(kw, runnerKey) == 0 }, 10*time.Second, time.Millisecond)
 
 	// Adding a key after the shutdown should result in an immediate response
-	var val WatchKeyStatus
+	var val notification.WatchKeyStatus
 	var err error
 	done := make(chan struct{})
 	go func() {
@@ -291,8 +291,123 @@ func TestShutdown(t *testing.T) {
 	select {
 	case <-done:
 		require.NoError(t, err, "Expected no error")
-		require.Equal(t, WatchKeyStatusNoChange, val, "Expected value not to change")
+		require.Equal(t, notification.WatchKeyStatusNoChange, val, "Expected value not to change")
 	case <-time.After(100 * time.Millisecond):
 		t.Fatal("timeout waiting for WatchKey")
 	}
 }
+
+func TestWatchKeyClusterNotConnected(t *testing.T) {
+	rdb := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{"127.0.0.1:0"}})
+	defer rdb.Close()
+
+	kw := NewKeyWatcher(rdb)
+	defer kw.Shutdown()
+
+	val, err := kw.WatchKey(ctx, runnerKey, "something", time.Second)
+	require.EqualError(t, err, "no redis connection")
+	require.Equal(t, notification.WatchKeyStatusNoChange, val)
+}
+
+func TestWatchKeyTooManyShards(t *testing.T) {
+	rdb := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{"127.0.0.1:0"}})
+	defer rdb.Close()
+
+	kw := NewKeyWatcher(rdb)
+	defer kw.Shutdown()
+
+	// Pretend to be connected, with the only shard allowed in use by
+	// another node
+	node := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"})
+	defer node.Close()
+	kw.masterForKey = func(context.Context, string) (*redis.Client, error) { return node, nil }
+	kw.shards = map[string]*shard{"127.0.0.1:2": {addr: "127.0.0.1:2", keys: 1}}
+	kw.maxShards = 1
+
+	val, err := kw.WatchKey(ctx, runnerKey, "something", time.Second)
+	require.ErrorIs(t, err, errTooManyShards)
+	require.Equal(t, notification.WatchKeyStatusNoChange, val)
+	require.Zero(t, countSubscribers(kw, runnerKey))
+}
+
+func TestKeyChangesSharded(t *testing.T) {
+	buf, err := os.ReadFile("../../config.toml")
+	require.NoError(t, err)
+	cfg, err := config.LoadConfig(string(buf))
+	require.NoError(t, err)
+	if len(cfg.Redis.Cluster) == 0 {
+		t.Skip("no Redis Cluster configured")
+	}
+
+	rdb, err := Configure(cfg.Redis)
+	require.NoError(t, err)
+	defer rdb.Close()
+
+	kw := NewKeyWatcher(rdb)
+	defer kw.Shutdown()
+
+	errC := make(chan error)
+	go func() { errC <- kw.receiveShardedPubSubStreams() }()
+
+	// The keys are in different hash slots, which may be served by the
+	// same node
+	otherKey := "runner:build_queue:{other}"
+
+	for _, key := range []string{runnerKey, otherKey} {
+		rdb.Set(ctx, key, "something", 0)
+		defer rdb.Del(ctx, key)
+	}
+
+	require.Eventually(t, func() bool {
+		kw.mu.Lock()
+		defer kw.mu.Unlock()
+		return kw.shards != nil
+	}, time.Second, time.Millisecond)
+
+	wg := &sync.WaitGroup{}
+	for _, key := range []string{runnerKey, otherKey} {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			val, err := kw.WatchKey(ctx, key, "something", 10*time.Second)
+
+			assert.NoError(t, err, "Expected no error")
+			assert.Equal(t, notification.WatchKeyStatusSeenChange, val, "Expected value to change")
+		}(key)
+	}
+
+	require.Eventually(t, func() bool {
+		return countSubscribers(kw, runnerKey) == 1 && countSubscribers(kw, otherKey) == 1
+	}, time.Second, time.Millisecond)
+
+	kw.mu.Lock()
+	require.Len(t, kw.channelShards, 2)
+	require.NotEmpty(t, kw.shards)
+	require.LessOrEqual(t, len(kw.shards), 2)
+	kw.mu.Unlock()
+
+	for _, key := range []string{runnerKey, otherKey} {
+		rdb.SPublish(ctx, channelPrefix+key, "somethingelse")
+	}
+	wg.Wait()
+
+	require.Eventually(t, func() bool {
+		kw.mu.Lock()
+		defer kw.mu.Unlock()
+		return len(kw.shards) == 0
+	}, time.Second, time.Millisecond)
+
+	kw.mu.Lock()
+	kw.shardErrors <- errors.New("closed")
+	kw.mu.Unlock()
+	require.NoError(t, <-errC)
+}
+
+func TestShardExpectedUnsubscribe(t *testing.T) {
+	kw := NewKeyWatcher(nil)
+	s := &shard{unsubscribing: map[string]int{channelPrefix + runnerKey: 1}}
+
+	require.True(t, kw.expectedUnsubscribe(s, channelPrefix+runnerKey))
+	require.False(t, kw.expectedUnsubscribe(s, channelPrefix+runnerKey), "a moved slot unsubscribes the channel again")
+	require.Empty(t, s.unsubscribing)
+}
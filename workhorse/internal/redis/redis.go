@@ -135,22 +135,27 @@ func (s sentinelInstrumentationHook) ProcessPipelineHook(next redis.ProcessPipel
 	}
 }
 
-// Configure redis-connection
-func Configure(cfg *config.RedisConfig) (*redis.Client, error) {
+// Configure redis-connection. The returned client is a *redis.ClusterClient
+// when Redis Cluster nodes are configured, and a *redis.Client otherwise.
+func Configure(cfg *config.RedisConfig) (redis.UniversalClient, error) {
 	if cfg == nil {
 		return nil, nil
 	}
 
-	var rdb *redis.Client
-	var err error
+	if len(cfg.Cluster) > 0 {
+		return configureCluster(cfg), nil
+	}
 
 	if len(cfg.Sentinel) > 0 {
-		rdb = configureSentinel(cfg)
-	} else {
-		rdb, err = configureRedis(cfg)
+		return configureSentinel(cfg), nil
+	}
+
+	rdb, err := configureRedis(cfg)
+	if err != nil {
+		return nil, err
 	}
 
-	return rdb, err
+	return rdb, nil
 }
 
 func configureRedis(cfg *config.RedisConfig) (*redis.Client, error) {
@@ -203,6 +208,67 @@ func configureSentinel(cfg *config.RedisConfig) *redis.Client {
 	return client
 }
 
+func configureCluster(cfg *config.RedisConfig) *redis.ClusterClient {
+	options := clusterOptions(cfg)
+
+	var tlsConfig *tls.Config
+	if options.TLS {
+		// The server name is taken from the address of each node when dialing.
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	return redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs:    options.Nodes,
+		Username: options.Username,
+		Password: options.Password,
+
+		// PoolSize and MaxIdleConns apply to each node of the cluster.
+		PoolSize:        getOrDefault(cfg.MaxActive, defaultMaxActive),
+		MaxIdleConns:    getOrDefault(cfg.MaxIdle, defaultMaxIdle),
+		ConnMaxIdleTime: defaultIdleTimeout,
+
+		ReadTimeout:  defaultReadTimeout,
+		WriteTimeout: defaultWriteTimeout,
+
+		TLSConfig: tlsConfig,
+		Dialer:    createDialer([]string{}, tlsConfig),
+	})
+}
+
+// ClusterOptions contains grouped, related values
+type ClusterOptions struct {
+	Username string
+	Password string
+	Nodes    []string
+	TLS      bool
+}
+
+// clusterOptions extracts the credentials from the URLs and the addresses in
+// <host>:<port> format. Password takes priority over the first password-in-url,
+// and TLS is used when any of the URLs has the rediss scheme.
+func clusterOptions(cfg *config.RedisConfig) ClusterOptions {
+	options := ClusterOptions{
+		Password: cfg.Password,
+		Nodes:    make([]string, len(cfg.Cluster)),
+	}
+
+	for i := range cfg.Cluster {
+		nodeDetails := cfg.Cluster[i]
+		options.Nodes[i] = fmt.Sprintf("%s:%s", nodeDetails.Hostname(), nodeDetails.Port())
+
+		if nodeDetails.Scheme == "rediss" {
+			options.TLS = true
+		}
+
+		if pw, exist := nodeDetails.User.Password(); exist && len(options.Password) == 0 {
+			options.Password = pw
+			options.Username = nodeDetails.User.Username()
+		}
+	}
+
+	return options
+}
+
 // sentinelOptions extracts the sentinel username and password from the URLs
 // and addresses in <host>:<port> format.
 // the order of priority for the passwords is: SentinelPassword -> first password-in-url
@@ -0,0 +1,36 @@
+package staticpages
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/log"
+)
+
+// MaintenancePage serves the maintenance.html page with a 503 status. A Geo
+// secondary serves it for requests it would proxy to an unreachable primary.
+// Without the page, it serves the 503 error page.
+func (s *Static) MaintenancePage() http.Handler {
+	maintenancePage := filepath.Join(s.DocumentRoot, "maintenance.html")
+	unavailable := s.ErrorPagesUnless(false, ErrorFormatHTML, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+	}))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cleanURL := filepath.Clean(maintenancePage)
+		data, err := os.ReadFile(cleanURL)
+		if err != nil {
+			unavailable.ServeHTTP(w, r)
+			return
+		}
+
+		setNoCacheHeaders(w.Header())
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if _, err := w.Write(data); err != nil {
+			// The client went away, which is not an error of Workhorse
+			log.WithContextFields(r.Context(), log.Fields{"path": cleanURL}).WithError(err).Info("failed to write the maintenance page")
+		}
+	})
+}
@@ -0,0 +1,58 @@
+package staticpages
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/testhelper"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIfNoMaintenancePageExist(t *testing.T) {
+	dir := t.TempDir()
+
+	w := httptest.NewRecorder()
+
+	st := &Static{DocumentRoot: dir}
+	st.MaintenancePage().ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	w.Flush()
+
+	require.Equal(t, 503, w.Code)
+	testhelper.RequireResponseBody(t, w, "Service Unavailable\n")
+}
+
+func TestIfNoMaintenancePageExistWithErrorPage(t *testing.T) {
+	dir := t.TempDir()
+
+	errorPage := "ERROR"
+	os.WriteFile(filepath.Join(dir, "503.html"), []byte(errorPage), 0600)
+
+	w := httptest.NewRecorder()
+
+	st := &Static{DocumentRoot: dir}
+	st.MaintenancePage().ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	w.Flush()
+
+	require.Equal(t, 503, w.Code)
+	testhelper.RequireResponseBody(t, w, errorPage)
+}
+
+func TestIfMaintenancePageExist(t *testing.T) {
+	dir := t.TempDir()
+
+	maintenancePage := "MAINTENANCE"
+	os.WriteFile(filepath.Join(dir, "maintenance.html"), []byte(maintenancePage), 0600)
+
+	w := httptest.NewRecorder()
+
+	st := &Static{DocumentRoot: dir}
+	st.MaintenancePage().ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	w.Flush()
+
+	require.Equal(t, 503, w.Code)
+	require.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	testhelper.RequireResponseBody(t, w, maintenancePage)
+}
@@ -157,6 +157,10 @@ func setupWithTmpPath(t *testing.T, filename string, includeFormat bool, format
 }
 
 func testUploadArtifacts(t *testing.T, contentType, url string, body io.Reader) *httptest.ResponseRecorder {
+	return testUploadArtifactsWithConfig(t, config.NewDefaultConfig(), contentType, url, body)
+}
+
+func testUploadArtifactsWithConfig(t *testing.T, cfg *config.Config, contentType, url string, body io.Reader) *httptest.ResponseRecorder {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -171,7 +175,7 @@ func testUploadArtifacts(t *testing.T, contentType, url string, body io.Reader)
 	apiClient := api.NewAPI(parsedURL, "123", roundTripper)
 	proxyClient := proxy.NewProxy(parsedURL, "123", roundTripper)
 
-	Artifacts(apiClient, proxyClient, &DefaultPreparer{}, config.NewDefaultConfig()).ServeHTTP(response, httpRequest)
+	Artifacts(apiClient, proxyClient, &DefaultPreparer{}, cfg).ServeHTTP(response, httpRequest)
 	return response
 }
 
@@ -304,6 +308,27 @@ func TestLsifFileProcessing(t *testing.T) {
 	testhelper.RequireResponseHeader(t, response, MetadataHeaderKey, MetadataHeaderPresent)
 }
 
+func TestLsifFileProcessingLimitExceeded(t *testing.T) {
+	tempPath := t.TempDir()
+
+	s := setupWithTmpPath(t, "file", true, "zip", &api.Response{TempPath: tempPath, ProcessLsif: true}, nil)
+
+	file, err := os.Open("../../testdata/lsif/valid.lsif.zip")
+	require.NoError(t, err)
+
+	_, err = io.Copy(s.fileWriter, file)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	require.NoError(t, s.writer.Close())
+
+	cfg := config.NewDefaultConfig()
+	cfg.LsifConfig.MaxInputBytes = 10
+
+	response := testUploadArtifactsWithConfig(t, cfg, s.writer.FormDataContentType(), s.url, s.buffer)
+	require.Equal(t, http.StatusRequestEntityTooLarge, response.Code)
+	require.Equal(t, "code intelligence index exceeds the max_input_bytes limit of 10\n", response.Body.String())
+}
+
 func TestInvalidLsifFileProcessing(t *testing.T) {
 	tempPath := t.TempDir()
 
@@ -40,6 +40,7 @@ var zipSubcommandsErrorsCounter = promauto.NewCounterVec(
 type artifactsUploadProcessor struct {
 	format      string
 	processLSIF bool
+	lsifConfig  config.LsifConfig
 	tempDir     string
 
 	SavedFileTracker
@@ -52,6 +53,7 @@ func Artifacts(myAPI *api.API, h http.Handler, p Preparer, cfg *config.Config) h
 		mg := &artifactsUploadProcessor{
 			format:           format,
 			processLSIF:      a.ProcessLsif,
+			lsifConfig:       cfg.LsifConfig,
 			tempDir:          a.TempPath,
 			SavedFileTracker: SavedFileTracker{Request: r},
 		}
@@ -176,7 +178,7 @@ func (a *artifactsUploadProcessor) Name() string { return "artifacts" }
 
 func (a *artifactsUploadProcessor) TransformContents(ctx context.Context, filename string, r io.Reader) (io.ReadCloser, error) {
 	if a.processLSIF {
-		return parser.NewParser(ctx, r)
+		return parser.NewParser(ctx, r, a.lsifConfig)
 	}
 
 	return a.SavedFileTracker.TransformContents(ctx, filename, r)
@@ -2,6 +2,7 @@
 package upload
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -24,12 +25,40 @@ func RequestBody(rails PreAuthorizer, h http.Handler, p Preparer) http.Handler {
 			return
 		}
 
-		fh, err := destination.Upload(r.Context(), r.Body, r.ContentLength, "upload", opts)
+		var reader io.Reader = r.Body
+		lfs := newLfsReader(r.Body, a, opts)
+		if lfs != nil {
+			if r.ContentLength > lfs.size {
+				rejectLfsUpload(w, r, "size", lfs.sizeError())
+				return
+			}
+			reader = lfs
+		}
+
+		// Cancelling ctx removes the uploaded file, which happens right
+		// away when the upload is rejected
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		fh, err := destination.Upload(ctx, reader, r.ContentLength, "upload", opts)
 		if err != nil {
+			if lfs != nil && lfs.exceeded() {
+				rejectLfsUpload(w, r, "size", lfs.sizeError())
+				return
+			}
+
 			fail.Request(w, r, fmt.Errorf("RequestBody: upload failed: %v", err))
 			return
 		}
 
+		if lfs != nil {
+			if reason, err := lfs.verify(fh); err != nil {
+				cancel()
+				rejectLfsUpload(w, r, reason, err)
+				return
+			}
+		}
+
 		data := url.Values{}
 		fields, err := fh.GitLabFinalizeFields("file")
 		if err != nil {
@@ -2,6 +2,8 @@ package upload
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,6 +12,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
@@ -77,6 +80,61 @@ func TestRequestBodyErrors(t *testing.T) {
 	}
 }
 
+func TestRequestBodyLfs(t *testing.T) {
+	testhelper.ConfigureSecret()
+
+	sum := sha256.Sum256([]byte(fileContent))
+	oid := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		desc           string
+		oid            string
+		size           int64
+		hideLength     bool
+		expectedStatus int
+	}{
+		{desc: "valid", oid: oid, size: int64(fileLen), expectedStatus: http.StatusOK},
+		{desc: "valid without length", oid: oid, size: int64(fileLen), hideLength: true, expectedStatus: http.StatusOK},
+		{desc: "oid mismatch", oid: strings.Repeat("0", 64), size: int64(fileLen), expectedStatus: http.StatusBadRequest},
+		{desc: "too large", oid: oid, size: int64(fileLen) - 1, expectedStatus: http.StatusBadRequest},
+		{desc: "too large without length", oid: oid, size: int64(fileLen) - 1, hideLength: true, expectedStatus: http.StatusBadRequest},
+		{desc: "too small", oid: oid, size: int64(fileLen) + 1, hideLength: true, expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			tempPath := t.TempDir()
+			auth := &rails{response: &api.Response{LfsOid: tc.oid, LfsSize: tc.size}}
+			preparer := &alwaysLocalPreparer{tempPath: tempPath}
+
+			proxy := echoProxy(t, fileLen)
+			if tc.expectedStatus != http.StatusOK {
+				proxy = http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+					assert.Fail(t, "request proxied upstream")
+				})
+			}
+
+			var body io.Reader = strings.NewReader(fileContent)
+			if tc.hideLength {
+				body = io.MultiReader(body)
+			}
+
+			resp := testUpload(context.Background(), auth, preparer, proxy, body)
+			defer resp.Body.Close()
+			require.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedStatus != http.StatusOK {
+				require.Equal(t, "SHA256 or size mismatch\n", string(testhelper.ReadAll(t, resp.Body)))
+				require.Eventually(t, func() bool {
+					entries, err := os.ReadDir(tempPath)
+					require.NoError(t, err)
+					return len(entries) == 0
+				}, time.Second, 10*time.Millisecond, "rejected upload is removed")
+			}
+		})
+	}
+}
+
 func testNoProxyInvocation(t *testing.T, expectedStatus int, auth PreAuthorizer, preparer Preparer) {
 	proxy := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
 		assert.Fail(t, "request proxied upstream")
@@ -149,6 +207,7 @@ func echoProxy(t *testing.T, expectedBodyLength int) http.Handler {
 
 type rails struct {
 	unauthorized bool
+	response     *api.Response
 }
 
 func (r *rails) PreAuthorizeHandler(next api.HandleFunc, _ string) http.Handler {
@@ -158,17 +217,28 @@ func (r *rails) PreAuthorizeHandler(next api.HandleFunc, _ string) http.Handler
 		})
 	}
 
+	response := r.response
+	if response == nil {
+		response = &api.Response{TempPath: os.TempDir()}
+	}
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		next(w, r, &api.Response{TempPath: os.TempDir()})
+		next(w, r, response)
 	})
 }
 
 type alwaysLocalPreparer struct {
 	prepareError error
+	tempPath     string
 }
 
 func (a *alwaysLocalPreparer) Prepare(_ *api.Response) (*destination.UploadOpts, error) {
-	opts, err := destination.GetOpts(&api.Response{TempPath: os.TempDir()})
+	tempPath := a.tempPath
+	if tempPath == "" {
+		tempPath = os.TempDir()
+	}
+
+	opts, err := destination.GetOpts(&api.Response{TempPath: tempPath})
 	if err != nil {
 		return nil, err
 	}
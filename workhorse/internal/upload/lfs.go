@@ -0,0 +1,90 @@
+package upload
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/helper/fail"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/upload/destination"
+)
+
+// lfsMismatchMessage matches the response of Rails to invalid LFS uploads
+const lfsMismatchMessage = "SHA256 or size mismatch"
+
+var lfsUploadsRejected = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "gitlab_workhorse_lfs_uploads_rejected",
+		Help: "How many LFS uploads have been rejected by gitlab-workhorse because their content does not match the object ID or size, partitioned by reason.",
+	},
+	[]string{"reason"},
+)
+
+// lfsReader verifies the size of an LFS object while it is being read, and
+// fails as soon as more bytes than the declared size have been read.
+type lfsReader struct {
+	io.Reader
+	oid  string
+	size int64
+	n    int64
+}
+
+// newLfsReader returns nil if a is not the authorization of an LFS upload.
+// It makes sure that the SHA256 of the upload is calculated.
+func newLfsReader(r io.Reader, a *api.Response, opts *destination.UploadOpts) *lfsReader {
+	if a.LfsOid == "" {
+		return nil
+	}
+
+	if len(opts.UploadHashFunctions) > 0 && !slices.Contains(opts.UploadHashFunctions, "sha256") {
+		opts.UploadHashFunctions = append(slices.Clone(opts.UploadHashFunctions), "sha256")
+	}
+
+	return &lfsReader{Reader: r, oid: a.LfsOid, size: a.LfsSize}
+}
+
+func (r *lfsReader) Read(p []byte) (int, error) {
+	if r.exceeded() {
+		return 0, r.sizeError()
+	}
+
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	if r.exceeded() {
+		return 0, r.sizeError()
+	}
+
+	return n, err
+}
+
+func (r *lfsReader) exceeded() bool {
+	return r.n > r.size
+}
+
+func (r *lfsReader) sizeError() error {
+	return fmt.Errorf("LFS object %s is larger than %d bytes", r.oid, r.size)
+}
+
+// verify checks the stored object against its declared object ID and size.
+// It returns the reason of a mismatch for metrics.
+func (r *lfsReader) verify(fh *destination.FileHandler) (string, error) {
+	if fh.Size != r.size {
+		return "size", fmt.Errorf("LFS object %s has %d bytes instead of %d", r.oid, fh.Size, r.size)
+	}
+
+	if fh.SHA256() != r.oid {
+		return "oid", fmt.Errorf("LFS object %s has SHA256 %s", r.oid, fh.SHA256())
+	}
+
+	return "", nil
+}
+
+func rejectLfsUpload(w http.ResponseWriter, r *http.Request, reason string, err error) {
+	lfsUploadsRejected.WithLabelValues(reason).Inc()
+	fail.Request(w, r, fmt.Errorf("RequestBody: %v", err), fail.WithStatus(http.StatusBadRequest), fail.WithBody(lfsMismatchMessage))
+}
@@ -38,3 +38,14 @@ func FixedPreAuthMultipart(myAPI *api.API, h http.Handler, p Preparer, cfg *conf
 		interceptMultipartFiles(w, r, h, s, fa, p, cfg)
 	})
 }
+
+// FixedPreAuthImageMultipart behaves like FixedPreAuthMultipart but in
+// addition validates and re-encodes uploaded images, such as avatars,
+// when image upload processing is enabled in the configuration.
+func FixedPreAuthImageMultipart(myAPI *api.API, h http.Handler, p Preparer, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := &SavedFileTracker{Request: r, ImageUpload: &cfg.ImageUploadConfig}
+		fa := &apiAuthorizer{myAPI}
+		interceptMultipartFiles(w, r, h, s, fa, p, cfg)
+	})
+}
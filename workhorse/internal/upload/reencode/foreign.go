@@ -0,0 +1,270 @@
+package reencode
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// foreignSignatures are byte sequences that identify file formats which
+// browsers, interpreters or archive tools would happily parse out of an
+// otherwise valid image.
+var foreignSignatures = [][]byte{
+	[]byte("PK\x03\x04"),
+	[]byte("%PDF-"),
+	[]byte("<?php"),
+	[]byte("<script"),
+	[]byte("<html"),
+	[]byte("<svg"),
+	[]byte("\x7fELF"),
+}
+
+// hasForeignData detects polyglot files: images that carry another file
+// format either appended after the end of the image data or embedded in
+// metadata segments. Pixel data is not inspected, as compressed data can
+// contain any byte sequence.
+func hasForeignData(buf []byte, format string) bool {
+	var (
+		end      int
+		metadata [][]byte
+		ok       bool
+	)
+
+	switch format {
+	case "png":
+		end, metadata, ok = pngLayout(buf)
+	case "jpeg":
+		end, metadata, ok = jpegLayout(buf)
+	case "gif":
+		end, metadata, ok = gifLayout(buf)
+	case "webp":
+		end, metadata, ok = webpLayout(buf)
+	default:
+		return false
+	}
+
+	if !ok {
+		return true
+	}
+
+	for _, m := range metadata {
+		lower := bytes.ToLower(m)
+		for _, sig := range foreignSignatures {
+			if bytes.Contains(lower, bytes.ToLower(sig)) {
+				return true
+			}
+		}
+	}
+
+	// Some encoders pad files with zero bytes; anything else after the end
+	// of the image is not part of the image.
+	return len(bytes.Trim(buf[end:], "\x00")) > 0
+}
+
+// pngLayout returns the end of the IEND chunk and the data of ancillary
+// chunks, such as text chunks.
+func pngLayout(buf []byte) (int, [][]byte, bool) {
+	var metadata [][]byte
+
+	for pos := 8; pos+12 <= len(buf); {
+		n := uint64(binary.BigEndian.Uint32(buf[pos:]))
+		if uint64(pos)+12+n > uint64(len(buf)) {
+			return 0, nil, false
+		}
+
+		typ, data := buf[pos+4:pos+8], buf[pos+8:pos+8+int(n)]
+		pos += 12 + int(n)
+
+		if string(typ) == "IEND" {
+			return pos, metadata, true
+		}
+
+		// Ancillary chunks have a lowercase first letter
+		if typ[0]&0x20 != 0 {
+			metadata = append(metadata, data)
+		}
+	}
+
+	return 0, nil, false
+}
+
+// jpegLayout returns the end of the EOI marker and the data of application
+// and comment segments.
+func jpegLayout(buf []byte) (int, [][]byte, bool) {
+	if len(buf) < 2 || buf[0] != 0xff || buf[1] != 0xd8 {
+		return 0, nil, false
+	}
+
+	var metadata [][]byte
+
+	for pos := 2; pos+2 <= len(buf); {
+		if buf[pos] != 0xff {
+			return 0, nil, false
+		}
+
+		marker := buf[pos+1]
+		switch {
+		case marker == 0xff:
+			// Fill byte
+			pos++
+			continue
+		case marker == 0xd9:
+			return pos + 2, metadata, true
+		case marker == 0x01 || marker >= 0xd0 && marker <= 0xd7:
+			// Markers without a segment
+			pos += 2
+			continue
+		}
+
+		if pos+4 > len(buf) {
+			return 0, nil, false
+		}
+		n := int(binary.BigEndian.Uint16(buf[pos+2:]))
+		if n < 2 || pos+2+n > len(buf) {
+			return 0, nil, false
+		}
+
+		if marker >= 0xe0 && marker <= 0xef || marker == 0xfe {
+			metadata = append(metadata, buf[pos+4:pos+2+n])
+		}
+		pos += 2 + n
+
+		if marker == 0xda {
+			// Skip the entropy-coded data of the scan, in which 0xff is
+			// followed by a stuffed zero or a restart marker
+			for pos+1 < len(buf) && (buf[pos] != 0xff || buf[pos+1] == 0x00 || buf[pos+1] >= 0xd0 && buf[pos+1] <= 0xd7) {
+				pos++
+			}
+		}
+	}
+
+	return 0, nil, false
+}
+
+// gifLayout returns the end of the trailer and the data of comment and
+// application extensions.
+func gifLayout(buf []byte) (int, [][]byte, bool) {
+	end, metadata, _, ok := gifBlocks(buf)
+	return end, metadata, ok
+}
+
+// gifFrames returns the number of frames of a GIF image without decoding
+// them.
+func gifFrames(buf []byte) (int, bool) {
+	_, _, frames, ok := gifBlocks(buf)
+	return frames, ok
+}
+
+// gifBlocks returns the layout of a GIF image like gifLayout, and the
+// number of frames in it.
+func gifBlocks(buf []byte) (int, [][]byte, int, bool) {
+	if len(buf) < 13 {
+		return 0, nil, 0, false
+	}
+
+	pos := 13
+	if buf[10]&0x80 != 0 {
+		pos += 3 << (buf[10]&7 + 1)
+	}
+
+	var metadata [][]byte
+	frames := 0
+
+	for pos < len(buf) {
+		switch buf[pos] {
+		case 0x3b:
+			return pos + 1, metadata, frames, true
+		case 0x21:
+			if pos+2 > len(buf) {
+				return 0, nil, 0, false
+			}
+			label := buf[pos+1]
+			keep := label == 0xfe || label == 0xff
+
+			data, next, ok := gifSubBlocks(buf, pos+2, keep)
+			if !ok {
+				return 0, nil, 0, false
+			}
+			if keep {
+				metadata = append(metadata, data)
+			}
+			pos = next
+		case 0x2c:
+			if pos+10 > len(buf) {
+				return 0, nil, 0, false
+			}
+			flags := buf[pos+9]
+			pos += 10
+			frames++
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&7 + 1)
+			}
+
+			// Skip the LZW minimum code size and the image data
+			_, next, ok := gifSubBlocks(buf, pos+1, false)
+			if !ok {
+				return 0, nil, 0, false
+			}
+			pos = next
+		default:
+			return 0, nil, 0, false
+		}
+	}
+
+	return 0, nil, 0, false
+}
+
+// gifSubBlocks returns the data of the sub-blocks starting at pos if keep is
+// set, and the position after the block terminator.
+func gifSubBlocks(buf []byte, pos int, keep bool) ([]byte, int, bool) {
+	var data []byte
+
+	for pos < len(buf) {
+		n := int(buf[pos])
+		pos++
+		if n == 0 {
+			return data, pos, true
+		}
+		if pos+n > len(buf) {
+			break
+		}
+
+		if keep {
+			data = append(data, buf[pos:pos+n]...)
+		}
+		pos += n
+	}
+
+	return nil, 0, false
+}
+
+// webpLayout returns the end of the RIFF container and the data of EXIF and
+// XMP chunks.
+func webpLayout(buf []byte) (int, [][]byte, bool) {
+	if len(buf) < 12 || string(buf[:4]) != "RIFF" || string(buf[8:12]) != "WEBP" {
+		return 0, nil, false
+	}
+
+	end := uint64(binary.LittleEndian.Uint32(buf[4:])) + 8
+	if end > uint64(len(buf)) {
+		return 0, nil, false
+	}
+
+	var metadata [][]byte
+
+	for pos := uint64(12); pos+8 <= end; {
+		fourCC := string(buf[pos : pos+4])
+		n := uint64(binary.LittleEndian.Uint32(buf[pos+4:]))
+		if pos+8+n > end {
+			return 0, nil, false
+		}
+
+		if fourCC == "EXIF" || fourCC == "XMP " {
+			metadata = append(metadata, buf[pos+8:pos+8+n])
+		}
+
+		// Chunks are padded to an even size
+		pos += 8 + n + n&1
+	}
+
+	return int(end), metadata, true
+}
@@ -0,0 +1,272 @@
+// Package reencode validates uploaded images and re-encodes them to a
+// canonical format so that downstream image parsers only ever see data
+// produced by a well-known encoder.
+package reencode
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"gitlab.com/gitlab-org/labkit/log"
+	_ "golang.org/x/image/bmp"  // register BMP decoder
+	_ "golang.org/x/image/tiff" // register TIFF decoder
+	_ "golang.org/x/image/webp" // register WebP decoder
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/config"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/utils/svg"
+)
+
+const (
+	svgPolicyAllow    = "allow"
+	svgPolicySanitize = "sanitize"
+	svgPolicyReject   = "reject"
+)
+
+var (
+	// ErrImageTooLarge is returned when the file size, the dimensions or the
+	// decoded size of an image exceed the configured limits.
+	ErrImageTooLarge = errors.New("image exceeds the allowed size limits")
+	// ErrInvalidImage is returned when an image cannot be decoded, or when its
+	// contents do not match the type implied by its filename.
+	ErrInvalidImage = errors.New("file is not a valid image")
+	// ErrPolyglotImage is returned when an image also contains data that
+	// would be interpreted as another file format.
+	ErrPolyglotImage = errors.New("image contains data of another file format")
+	// ErrSVGNotAllowed is returned when SVG uploads are rejected by policy,
+	// or when an SVG image cannot be sanitized.
+	ErrSVGNotAllowed = errors.New("SVG image is not allowed")
+)
+
+var imageUploadsProcessed = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "gitlab_workhorse_image_upload_processed_total",
+		Help: "Amount of image uploads processed by gitlab-workhorse before they are sent to Rails. Partitioned by format and result.",
+	},
+	[]string{"format", "result"},
+)
+
+// outputFormats are the formats images are re-encoded to. WebP images are
+// converted to PNG, as there is no WebP encoder.
+var outputFormats = map[string]imaging.Format{
+	"png":  imaging.PNG,
+	"jpeg": imaging.JPEG,
+	"gif":  imaging.GIF,
+	"bmp":  imaging.BMP,
+	"tiff": imaging.TIFF,
+	"webp": imaging.PNG,
+}
+
+var rasterExtensions = map[string]string{
+	".png":  "png",
+	".jpg":  "jpeg",
+	".jpeg": "jpeg",
+	".gif":  "gif",
+	".bmp":  "bmp",
+	".tif":  "tiff",
+	".tiff": "tiff",
+	".webp": "webp",
+}
+
+// Handles returns true if the filename refers to an image format that
+// Process knows how to validate.
+func Handles(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if ext == ".svg" {
+		return true
+	}
+
+	_, ok := rasterExtensions[ext]
+	return ok
+}
+
+// processedImage is the re-encoded copy of an image, which must be stored
+// under filename.
+type processedImage struct {
+	io.Reader
+	filename string
+}
+
+func (*processedImage) Close() error { return nil }
+
+// Filename returns the filename of the image, which has another extension
+// than the uploaded file when the image was converted to another format.
+func (p *processedImage) Filename() string { return p.filename }
+
+// Process reads an image from r, validates it against the limits in cfg and
+// returns a re-encoded copy of it. Raster images keep their format, except
+// WebP images, which are converted to PNG and get a .png extension. SVG
+// images are handled according to cfg.SVGPolicy.
+func Process(ctx context.Context, r io.Reader, filename string, cfg config.ImageUploadConfig) (io.ReadCloser, error) {
+	buf, err := io.ReadAll(io.LimitReader(r, int64(cfg.MaxFilesize)+1))
+	if err != nil {
+		return nil, err
+	}
+
+	ext := strings.ToLower(filepath.Ext(filename))
+	format := rasterExtensions[ext]
+	if ext == ".svg" {
+		format = "svg"
+	}
+
+	out, err := process(buf, format, cfg)
+	result := "ok"
+	if err != nil {
+		result = "rejected"
+
+		log.WithContextFields(ctx, log.Fields{
+			"filename": filename,
+			"format":   format,
+			"error":    err.Error(),
+		}).Info("rejecting image upload")
+	}
+	imageUploadsProcessed.WithLabelValues(format, result).Inc()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if format == "webp" {
+		filename = strings.TrimSuffix(filename, filepath.Ext(filename)) + ".png"
+	}
+
+	return &processedImage{Reader: bytes.NewReader(out), filename: filename}, nil
+}
+
+func process(buf []byte, format string, cfg config.ImageUploadConfig) ([]byte, error) {
+	if uint64(len(buf)) > cfg.MaxFilesize {
+		return nil, ErrImageTooLarge
+	}
+
+	if format == "svg" {
+		return processSVG(buf, cfg.SVGPolicy)
+	}
+
+	if svg.Is(buf) {
+		return nil, ErrPolyglotImage
+	}
+
+	imgCfg, detected, err := image.DecodeConfig(bytes.NewReader(buf))
+	if err != nil || detected != format {
+		return nil, ErrInvalidImage
+	}
+
+	if err := checkDimensions(imgCfg, cfg); err != nil {
+		return nil, err
+	}
+
+	if hasForeignData(buf, format) {
+		return nil, ErrPolyglotImage
+	}
+
+	if format == "gif" {
+		if frames, ok := gifFrames(buf); !ok {
+			return nil, ErrInvalidImage
+		} else if frames > 1 {
+			return processAnimatedGIF(buf, imgCfg, frames, cfg)
+		}
+	}
+
+	img, err := imaging.Decode(bytes.NewReader(buf), imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+
+	var out bytes.Buffer
+	if err := imaging.Encode(&out, img, outputFormats[format], imaging.JPEGQuality(90)); err != nil {
+		return nil, fmt.Errorf("re-encode image: %w", err)
+	}
+
+	return out.Bytes(), nil
+}
+
+// processAnimatedGIF re-encodes all frames of an animated GIF image, as
+// imaging only keeps the first one.
+func processAnimatedGIF(buf []byte, imgCfg image.Config, frames int, cfg config.ImageUploadConfig) ([]byte, error) {
+	// Every frame is decoded to a paletted image of at most the size of the
+	// first one
+	decodedBytes := uint64(imgCfg.Width) * uint64(imgCfg.Height) * uint64(frames)
+	if decodedBytes > cfg.MaxDecodedBytes {
+		return nil, ErrImageTooLarge
+	}
+
+	g, err := gif.DecodeAll(bytes.NewReader(buf))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+
+	var out bytes.Buffer
+	if err := gif.EncodeAll(&out, g); err != nil {
+		return nil, fmt.Errorf("re-encode image: %w", err)
+	}
+
+	return out.Bytes(), nil
+}
+
+func processSVG(buf []byte, policy string) ([]byte, error) {
+	switch policy {
+	case svgPolicyAllow:
+		if !svg.Is(buf) {
+			return nil, ErrInvalidImage
+		}
+		return buf, nil
+	case svgPolicySanitize:
+		out, err := svg.Sanitize(buf)
+		if errors.Is(err, svg.ErrNotSVG) {
+			return nil, ErrInvalidImage
+		} else if err != nil {
+			return nil, ErrSVGNotAllowed
+		}
+		return out, nil
+	default:
+		return nil, ErrSVGNotAllowed
+	}
+}
+
+func checkDimensions(imgCfg image.Config, cfg config.ImageUploadConfig) error {
+	if imgCfg.Width <= 0 || imgCfg.Height <= 0 {
+		return ErrInvalidImage
+	}
+
+	if uint32(imgCfg.Width) > cfg.MaxWidth || uint32(imgCfg.Height) > cfg.MaxHeight {
+		return ErrImageTooLarge
+	}
+
+	decodedBytes := uint64(imgCfg.Width) * uint64(imgCfg.Height) * bytesPerPixel(imgCfg.ColorModel)
+	if decodedBytes > cfg.MaxDecodedBytes {
+		return ErrImageTooLarge
+	}
+
+	return nil
+}
+
+// bytesPerPixel returns the in-memory size of a single decoded pixel for
+// the given color model. Unknown models are assumed to be 16 bits per channel.
+func bytesPerPixel(m color.Model) uint64 {
+	if _, ok := m.(color.Palette); ok {
+		return 1
+	}
+
+	switch m {
+	case color.GrayModel, color.AlphaModel:
+		return 1
+	case color.Gray16Model, color.Alpha16Model:
+		return 2
+	case color.YCbCrModel:
+		return 3
+	case color.RGBAModel, color.NRGBAModel, color.CMYKModel:
+		return 4
+	default:
+		return 8
+	}
+}
@@ -0,0 +1,282 @@
+package reencode
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/image/bmp"
+
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/config"
+)
+
+func testImage(t *testing.T, width, height int) image.Image {
+	t.Helper()
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, img, nil))
+	return buf.Bytes()
+}
+
+func encodeGIF(t *testing.T, img image.Image) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	require.NoError(t, gif.Encode(&buf, img, nil))
+	return buf.Bytes()
+}
+
+func encodeAnimatedGIF(t *testing.T, width, height, frames int) []byte {
+	t.Helper()
+
+	g := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, width, height), palette.Plan9)
+		frame.SetColorIndex(i%width, 0, uint8(i))
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 10)
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, gif.EncodeAll(&buf, g))
+	return buf.Bytes()
+}
+
+func encodeBMP(t *testing.T, img image.Image) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	require.NoError(t, bmp.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+// withPNGChunk inserts a chunk right after the IHDR chunk of a PNG image.
+func withPNGChunk(data []byte, typ string, payload []byte) []byte {
+	const ihdrEnd = 8 + 12 + 13
+
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	chunk = append(chunk, typ...)
+	chunk = append(chunk, payload...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+	out := append([]byte{}, data[:ihdrEnd]...)
+	out = append(out, chunk...)
+	return append(out, data[ihdrEnd:]...)
+}
+
+func processBytes(t *testing.T, data []byte, filename string, cfg config.ImageUploadConfig) ([]byte, error) {
+	t.Helper()
+
+	out, _, err := processFile(t, data, filename, cfg)
+	return out, err
+}
+
+func processFile(t *testing.T, data []byte, filename string, cfg config.ImageUploadConfig) ([]byte, string, error) {
+	t.Helper()
+
+	rc, err := Process(context.Background(), bytes.NewReader(data), filename, cfg)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rc.Close()
+
+	out, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	return out, rc.(interface{ Filename() string }).Filename(), nil
+}
+
+func TestHandles(t *testing.T) {
+	require.True(t, Handles("avatar.png"))
+	require.True(t, Handles("AVATAR.JPG"))
+	require.True(t, Handles("logo.svg"))
+	require.False(t, Handles("notes.txt"))
+	require.False(t, Handles("favicon.ico"))
+}
+
+func TestProcessReencodes(t *testing.T) {
+	img := testImage(t, 16, 8)
+
+	tests := []struct {
+		desc             string
+		data             []byte
+		filename         string
+		format           string
+		expectedFilename string
+	}{
+		{desc: "PNG stays PNG", data: encodePNG(t, img), filename: "avatar.png", format: "png", expectedFilename: "avatar.png"},
+		{desc: "JPEG stays JPEG", data: encodeJPEG(t, img), filename: "avatar.jpeg", format: "jpeg", expectedFilename: "avatar.jpeg"},
+		{desc: "GIF stays GIF", data: encodeGIF(t, img), filename: "avatar.gif", format: "gif", expectedFilename: "avatar.gif"},
+		{desc: "BMP stays BMP", data: encodeBMP(t, img), filename: "avatar.bmp", format: "bmp", expectedFilename: "avatar.bmp"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			out, filename, err := processFile(t, tc.data, tc.filename, config.DefaultImageUploadConfig)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedFilename, filename)
+
+			cfg, format, err := image.DecodeConfig(bytes.NewReader(out))
+			require.NoError(t, err)
+			require.Equal(t, tc.format, format)
+			require.Equal(t, 16, cfg.Width)
+			require.Equal(t, 8, cfg.Height)
+		})
+	}
+}
+
+func TestProcessConvertsWebP(t *testing.T) {
+	data, err := os.ReadFile("testdata/image.webp")
+	require.NoError(t, err)
+
+	out, filename, err := processFile(t, data, "avatar.WEBP", config.DefaultImageUploadConfig)
+	require.NoError(t, err)
+	require.Equal(t, "avatar.png", filename)
+
+	_, format, err := image.DecodeConfig(bytes.NewReader(out))
+	require.NoError(t, err)
+	require.Equal(t, "png", format)
+}
+
+func TestProcessIgnoresSignaturesInPixelData(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	img.Set(0, 0, color.NRGBA{R: 'P', G: 'K', B: 3, A: 4})
+
+	var buf bytes.Buffer
+	enc := png.Encoder{CompressionLevel: png.NoCompression}
+	require.NoError(t, enc.Encode(&buf, img))
+	require.Contains(t, buf.String(), "PK\x03\x04")
+
+	_, err := processBytes(t, buf.Bytes(), "a.png", config.DefaultImageUploadConfig)
+	require.NoError(t, err)
+}
+
+func TestProcessRejects(t *testing.T) {
+	pngData := encodePNG(t, testImage(t, 64, 64))
+
+	tiny := config.DefaultImageUploadConfig
+	tiny.MaxWidth = 32
+
+	smallDecoded := config.DefaultImageUploadConfig
+	smallDecoded.MaxDecodedBytes = 64 * 64 * 3
+
+	smallFile := config.DefaultImageUploadConfig
+	smallFile.MaxFilesize = 10
+
+	tests := []struct {
+		desc        string
+		data        []byte
+		filename    string
+		cfg         config.ImageUploadConfig
+		expectedErr error
+	}{
+		{desc: "width limit", data: pngData, filename: "a.png", cfg: tiny, expectedErr: ErrImageTooLarge},
+		{desc: "decoded size limit", data: pngData, filename: "a.png", cfg: smallDecoded, expectedErr: ErrImageTooLarge},
+		{desc: "file size limit", data: pngData, filename: "a.png", cfg: smallFile, expectedErr: ErrImageTooLarge},
+		{desc: "extension mismatch", data: pngData, filename: "a.jpg", cfg: config.DefaultImageUploadConfig, expectedErr: ErrInvalidImage},
+		{desc: "not an image", data: []byte("hello world"), filename: "a.png", cfg: config.DefaultImageUploadConfig, expectedErr: ErrInvalidImage},
+		{desc: "appended zip", data: append(append([]byte{}, pngData...), []byte("PK\x03\x04payload")...), filename: "a.png", cfg: config.DefaultImageUploadConfig, expectedErr: ErrPolyglotImage},
+		{desc: "trailing data", data: append(append([]byte{}, pngData...), []byte("trailer")...), filename: "a.png", cfg: config.DefaultImageUploadConfig, expectedErr: ErrPolyglotImage},
+		{desc: "zip in text chunk", data: withPNGChunk(pngData, "tEXt", []byte("Comment\x00PK\x03\x04payload")), filename: "a.png", cfg: config.DefaultImageUploadConfig, expectedErr: ErrPolyglotImage},
+		{desc: "script in text chunk", data: withPNGChunk(pngData, "tEXt", []byte("Comment\x00<SCRIPT>alert(1)</script>")), filename: "a.png", cfg: config.DefaultImageUploadConfig, expectedErr: ErrPolyglotImage},
+		{desc: "trailing data in GIF", data: append(encodeGIF(t, testImage(t, 8, 8)), []byte("%PDF-1.4")...), filename: "a.gif", cfg: config.DefaultImageUploadConfig, expectedErr: ErrPolyglotImage},
+		{desc: "trailing data in JPEG", data: append(encodeJPEG(t, testImage(t, 8, 8)), []byte("<?php")...), filename: "a.jpg", cfg: config.DefaultImageUploadConfig, expectedErr: ErrPolyglotImage},
+		{desc: "SVG disguised as PNG", data: []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`), filename: "a.png", cfg: config.DefaultImageUploadConfig, expectedErr: ErrPolyglotImage},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := processBytes(t, tc.data, tc.filename, tc.cfg)
+			require.Equal(t, tc.expectedErr, err)
+		})
+	}
+}
+
+func TestProcessAnimatedGIF(t *testing.T) {
+	out, err := processBytes(t, encodeAnimatedGIF(t, 8, 8, 3), "a.gif", config.DefaultImageUploadConfig)
+	require.NoError(t, err)
+
+	g, err := gif.DecodeAll(bytes.NewReader(out))
+	require.NoError(t, err)
+	require.Len(t, g.Image, 3, "all frames are kept")
+	require.Equal(t, []int{10, 10, 10}, g.Delay)
+}
+
+func TestProcessAnimatedGIFTooManyFrames(t *testing.T) {
+	cfg := config.DefaultImageUploadConfig
+	cfg.MaxDecodedBytes = 8 * 8 * 2
+
+	_, err := processBytes(t, encodeAnimatedGIF(t, 8, 8, 3), "a.gif", cfg)
+	require.Equal(t, ErrImageTooLarge, err)
+}
+
+func TestProcessDecompressionBomb(t *testing.T) {
+	data, err := os.ReadFile("../exif/testdata/takes_lot_of_memory_to_decode.tiff")
+	require.NoError(t, err)
+
+	_, err = processBytes(t, data, "bomb.tiff", config.DefaultImageUploadConfig)
+	require.Equal(t, ErrImageTooLarge, err)
+}
+
+func TestProcessSVG(t *testing.T) {
+	data := []byte(`<svg xmlns="http://www.w3.org/2000/svg" onload="alert(1)"><script>alert(2)</script><rect width="10" height="10"/></svg>`)
+
+	tests := []struct {
+		policy      string
+		expectedErr error
+		contains    string
+		excludes    string
+	}{
+		{policy: "allow", contains: "<script>"},
+		{policy: "sanitize", contains: "<rect", excludes: "alert"},
+		{policy: "reject", expectedErr: ErrSVGNotAllowed},
+		{policy: "unknown", expectedErr: ErrSVGNotAllowed},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.policy, func(t *testing.T) {
+			cfg := config.DefaultImageUploadConfig
+			cfg.SVGPolicy = tc.policy
+
+			out, err := processBytes(t, data, "logo.svg", cfg)
+			require.Equal(t, tc.expectedErr, err)
+			if tc.contains != "" {
+				require.Contains(t, string(out), tc.contains)
+			}
+			if tc.excludes != "" {
+				require.NotContains(t, string(out), tc.excludes)
+			}
+		})
+	}
+}
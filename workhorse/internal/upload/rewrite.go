@@ -130,6 +130,12 @@ func parseAndNormalizeContentDisposition(header textproto.MIMEHeader) (string, s
 	return params["name"], params["filename"]
 }
 
+// renamer is implemented by transformed file contents that must be stored
+// under another filename, e.g. because they were converted to another format.
+type renamer interface {
+	Filename() string
+}
+
 func (rew *rewriter) handleFilePart(r *http.Request, name string, p *multipart.Part, cfg *config.Config) error {
 	if rew.filter.Count() >= maxFilesAllowed {
 		return ErrTooManyFilesUploaded
@@ -163,6 +169,10 @@ func (rew *rewriter) handleFilePart(r *http.Request, name string, p *multipart.P
 		}
 	}()
 
+	if rf, ok := inputReader.(renamer); ok {
+		filename = rf.Filename()
+	}
+
 	fh, err := destination.Upload(ctx, inputReader, -1, filename, opts)
 	if err != nil {
 		switch err {
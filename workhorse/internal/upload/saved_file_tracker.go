@@ -11,12 +11,16 @@ import (
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/secret"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/upload/destination"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/upload/exif"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/upload/reencode"
 )
 
 // SavedFileTracker tracks saved files.
 type SavedFileTracker struct {
 	Request         *http.Request
 	rewrittenFields map[string]string
+	// ImageUpload, when enabled, makes TransformContents validate and
+	// re-encode images instead of only removing their EXIF metadata.
+	ImageUpload *config.ImageUploadConfig
 }
 
 // Track adds the localPath of the saved file to the tracker with the provided fieldName.
@@ -72,7 +76,11 @@ func (s *SavedFileTracker) Name() string { return "accelerate" }
 // TransformContents is a method that implements the destination.FileHandler interface.
 // It transforms the contents of the file if it is an image based on its filename and returns a ReadCloser.
 // If the file is not an image, it returns the original reader wrapped in a NopCloser.
-func (*SavedFileTracker) TransformContents(ctx context.Context, filename string, r io.Reader) (io.ReadCloser, error) {
+func (s *SavedFileTracker) TransformContents(ctx context.Context, filename string, r io.Reader) (io.ReadCloser, error) {
+	if s.ImageUpload != nil && s.ImageUpload.Enabled && reencode.Handles(filename) {
+		return reencode.Process(ctx, r, filename, *s.ImageUpload)
+	}
+
 	if imageType := exif.FileTypeFromSuffix(filename); imageType != exif.TypeUnknown {
 		return handleExifUpload(ctx, r, filename, imageType)
 	}
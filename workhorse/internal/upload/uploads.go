@@ -21,8 +21,10 @@ import (
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/config"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/helper/fail"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/lsif_transformer/parser"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/upload/destination"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/upload/exif"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/upload/reencode"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/zipartifacts"
 )
 
@@ -78,6 +80,8 @@ func interceptMultipartFiles(w http.ResponseWriter, r *http.Request, h http.Hand
 		case exif.ErrRemovingExif:
 			fail.Request(w, r, err, fail.WithStatus(http.StatusUnprocessableEntity),
 				fail.WithBody("Failed to process image"))
+		case reencode.ErrImageTooLarge, reencode.ErrInvalidImage, reencode.ErrPolyglotImage, reencode.ErrSVGNotAllowed:
+			fail.Request(w, r, err, fail.WithStatus(http.StatusUnprocessableEntity), fail.WithBody(err.Error()))
 		default:
 			if errors.Is(err, context.DeadlineExceeded) {
 				fail.Request(w, r, err, fail.WithStatus(http.StatusGatewayTimeout), fail.WithBody("deadline exceeded"))
@@ -87,6 +91,8 @@ func interceptMultipartFiles(w http.ResponseWriter, r *http.Request, h http.Hand
 			switch t := err.(type) {
 			case textproto.ProtocolError:
 				fail.Request(w, r, err, fail.WithStatus(http.StatusBadRequest))
+			case *parser.LimitError:
+				fail.Request(w, r, err, fail.WithStatus(http.StatusRequestEntityTooLarge), fail.WithBody(t.Error()))
 			case *api.PreAuthorizeFixedPathError:
 				fail.Request(w, r, err, fail.WithStatus(t.StatusCode), fail.WithBody(t.Status))
 			default:
@@ -5,6 +5,8 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"image"
+	"image/png"
 	"io"
 	"mime/multipart"
 	"net/http"
@@ -576,7 +578,65 @@ func TestUploadHandlerRemovingExifCorruptedFile(t *testing.T) {
 	})
 }
 
+func TestUploadHandlerReencodingImages(t *testing.T) {
+	cfg := config.DefaultImageUploadConfig
+	cfg.Enabled = true
+
+	var pngBuffer bytes.Buffer
+	require.NoError(t, png.Encode(&pngBuffer, image.NewGray(image.Rect(0, 0, 4, 4))))
+	content := pngBuffer.Bytes()
+
+	runUploadTestWithFilter(t, &testFormProcessor{SavedFileTracker{ImageUpload: &cfg}}, content, "avatar.png", 200, func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseMultipartForm(100000)
+		assert.NoError(t, err)
+
+		output, err := os.ReadFile(r.FormValue("file.path"))
+		assert.NoError(t, err)
+
+		imgCfg, format, err := image.DecodeConfig(bytes.NewReader(output))
+		assert.NoError(t, err)
+		assert.Equal(t, "png", format)
+		assert.Equal(t, 4, imgCfg.Width)
+
+		w.WriteHeader(200)
+		fmt.Fprint(w, "RESPONSE")
+	})
+
+	polyglot := append(append([]byte{}, content...), []byte("PK\x03\x04")...)
+	runUploadTestWithFilter(t, &testFormProcessor{SavedFileTracker{ImageUpload: &cfg}}, polyglot, "avatar.png", 422, func(_ http.ResponseWriter, _ *http.Request) {
+		t.Error("request should not reach the backend")
+	})
+}
+
+func TestUploadHandlerRenamingConvertedImages(t *testing.T) {
+	cfg := config.DefaultImageUploadConfig
+	cfg.Enabled = true
+
+	content, err := os.ReadFile("reencode/testdata/image.webp")
+	require.NoError(t, err)
+
+	runUploadTestWithFilter(t, &testFormProcessor{SavedFileTracker{ImageUpload: &cfg}}, content, "avatar.webp", 200, func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseMultipartForm(100000)
+		assert.NoError(t, err)
+		assert.Equal(t, "avatar.png", r.FormValue("file.name"))
+
+		output, err := os.ReadFile(r.FormValue("file.path"))
+		assert.NoError(t, err)
+
+		_, format, err := image.DecodeConfig(bytes.NewReader(output))
+		assert.NoError(t, err)
+		assert.Equal(t, "png", format)
+
+		w.WriteHeader(200)
+		fmt.Fprint(w, "RESPONSE")
+	})
+}
+
 func runUploadTest(t *testing.T, image []byte, filename string, httpCode int, tsHandler func(http.ResponseWriter, *http.Request)) {
+	runUploadTestWithFilter(t, &testFormProcessor{}, image, filename, httpCode, tsHandler)
+}
+
+func runUploadTestWithFilter(t *testing.T, filter MultipartFormProcessor, image []byte, filename string, httpCode int, tsHandler func(http.ResponseWriter, *http.Request)) {
 	var buffer bytes.Buffer
 
 	writer := multipart.NewWriter(&buffer)
@@ -605,7 +665,7 @@ func runUploadTest(t *testing.T, image []byte, filename string, httpCode int, ts
 
 	handler := newProxy(ts.URL)
 
-	testInterceptMultipartFiles(t, response, httpRequest, handler, &testFormProcessor{})
+	testInterceptMultipartFiles(t, response, httpRequest, handler, filter)
 	require.Equal(t, httpCode, response.Code)
 }
 
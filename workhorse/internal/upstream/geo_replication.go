@@ -0,0 +1,94 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+
+	apipkg "gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/helper"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/helper/ttlcache"
+)
+
+const geoReplicationCacheMaxEntries = 10000
+
+var geoReplicationCacheTTL = time.Minute
+
+// geoReplicationCache remembers, per request path, whether Rails answered
+// that the data downloaded from the path is replicated to this Geo
+// secondary.
+type geoReplicationCache struct {
+	entries *ttlcache.Cache[bool]
+	fetch   func(ctx context.Context, path string) (bool, error)
+
+	// notFoundUntil is the Unix time in nanoseconds until which the
+	// replication status endpoint is not called, as the GitLab version does
+	// not provide it
+	notFoundUntil atomic.Int64
+}
+
+func newGeoReplicationCache(fetch func(ctx context.Context, path string) (bool, error)) *geoReplicationCache {
+	return &geoReplicationCache{
+		entries: ttlcache.New[bool](geoReplicationCacheTTL, geoReplicationCacheMaxEntries),
+		fetch:   fetch,
+	}
+}
+
+// replicated reports whether the data downloaded from path can be served
+// locally. Errors count as not replicated so that the primary serves the
+// request, and are cached like answers.
+func (c *geoReplicationCache) replicated(ctx context.Context, path string) bool {
+	if replicated, ok := c.entries.Get(path); ok {
+		return replicated
+	}
+
+	if time.Now().UnixNano() < c.notFoundUntil.Load() {
+		return false
+	}
+
+	replicated, err := c.fetch(ctx, path)
+	if errors.Is(err, apipkg.ErrGeoReplicationStatusNotFound) {
+		// Logged once per TTL rather than once per path
+		until := time.Now().Add(geoReplicationCacheTTL).UnixNano()
+		if old := c.notFoundUntil.Load(); c.notFoundUntil.CompareAndSwap(old, until) {
+			log.WithContextFields(ctx, log.Fields{"path": path}).WithError(err).Info("Geo: replication status is not available, proxying requests to primary")
+		}
+		return false
+	} else if err != nil {
+		log.WithContextFields(ctx, log.Fields{"path": path}).WithError(err).Info("Geo: unable to get replication status, proxying request to primary")
+		replicated = false
+	}
+
+	c.entries.Put(path, replicated)
+
+	return replicated
+}
+
+// geoLocalIfReplicated serves downloads with local when their data is
+// replicated to this Geo secondary, and proxies them to the primary
+// otherwise. kind labels the served bytes in metrics.
+func (u *upstream) geoLocalIfReplicated(kind string, local http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		location, handler := "local", local
+
+		// Serve what we can while the primary is unreachable
+		if !u.geoProxyUnreachable.Load() && !u.geoReplication.replicated(r.Context(), r.URL.Path) {
+			u.mu.RLock()
+			proxy := u.geoProxyHandler
+			u.mu.RUnlock()
+
+			// The primary may have gone away since the route matched
+			if proxy != nil {
+				location, handler = "proxied", proxy
+			}
+		}
+
+		cw := helper.NewCountingResponseWriter(w)
+		handler.ServeHTTP(cw, r)
+		geoServedBytes.WithLabelValues(kind, location).Add(float64(cw.Count()))
+	})
+}
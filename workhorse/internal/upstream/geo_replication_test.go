@@ -0,0 +1,55 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	apipkg "gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
+)
+
+func TestGeoReplicationCache(t *testing.T) {
+	calls := 0
+	replicated := true
+	var err error
+	c := newGeoReplicationCache(func(_ context.Context, path string) (bool, error) {
+		require.Equal(t, "/group/project/-/archive/main/project-main.zip", path)
+		calls++
+		return replicated, err
+	})
+
+	const path = "/group/project/-/archive/main/project-main.zip"
+	require.True(t, c.replicated(context.Background(), path))
+	replicated = false
+	require.True(t, c.replicated(context.Background(), path), "cached")
+	require.Equal(t, 1, calls)
+
+	c.entries.Delete(path)
+	require.False(t, c.replicated(context.Background(), path), "refetched")
+	require.Equal(t, 2, calls)
+
+	c.entries.Delete(path)
+	err = errors.New("rails is down")
+	replicated = true
+	require.False(t, c.replicated(context.Background(), path), "errors count as not replicated")
+	require.False(t, c.replicated(context.Background(), path), "errors are cached")
+	require.Equal(t, 3, calls)
+}
+
+func TestGeoReplicationCacheEndpointNotFound(t *testing.T) {
+	calls := 0
+	c := newGeoReplicationCache(func(context.Context, string) (bool, error) {
+		calls++
+		return true, apipkg.ErrGeoReplicationStatusNotFound
+	})
+
+	require.False(t, c.replicated(context.Background(), "/a"))
+	require.False(t, c.replicated(context.Background(), "/b"))
+	require.Equal(t, 1, calls, "the missing endpoint is not called for other paths")
+
+	c.notFoundUntil.Store(0)
+	require.False(t, c.replicated(context.Background(), "/b"))
+	require.Equal(t, 2, calls, "the endpoint is called again after the TTL")
+}
@@ -26,6 +26,25 @@ var (
 		[]string{"code", "method", "route"},
 	)
 
+	geoServedBytes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "geo",
+			Name:      "served_bytes_total",
+			Help:      "How many bytes of replicated downloads a Geo secondary served, by kind of download and whether it served them locally or proxied them to the primary.",
+		},
+		[]string{"kind", "location"},
+	)
+
+	geoPrimaryUnreachable = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "geo",
+			Name:      "primary_unreachable",
+			Help:      "Whether the last health checks of the Geo primary failed. While it is 1, a Geo secondary serves the maintenance page instead of proxying requests.",
+		},
+	)
+
 	buildHandler = metrics.NewHandlerFactory(metrics.WithNamespace(namespace), metrics.WithLabels("route"))
 )
 
@@ -18,8 +18,11 @@ import (
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/config"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/dependencyproxy"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/git"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/git/audit"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/helper"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/imageresizer"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/joblog"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/lfs"
 	proxypkg "gitlab.com/gitlab-org/gitlab/workhorse/internal/proxy"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/queueing"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/secret"
@@ -195,6 +198,8 @@ func buildProxy(backend *url.URL, version string, rt http.RoundTripper, cfg conf
 		git.SendBlob,
 		git.SendDiff,
 		git.SendPatch,
+		git.SendDiffJSON,
+		git.SendDiffHTML,
 		git.SendSnapshot,
 		artifacts.SendEntry,
 		sendurl.SendURL,
@@ -232,11 +237,30 @@ func configureRoutes(u *upstream) {
 	mimeMultipartUploader := upload.Multipart(api, signingProxy, preparer, &u.Config)
 
 	tempfileMultipartProxy := upload.FixedPreAuthMultipart(api, proxy, preparer, &u.Config)
+	avatarMultipartProxy := upload.FixedPreAuthImageMultipart(api, proxy, preparer, &u.Config)
 	ciAPIProxyQueue := queueing.QueueRequests("ci_api_job_requests", tempfileMultipartProxy, u.APILimit, u.APIQueueLimit, u.APIQueueTimeout, prometheus.DefaultRegisterer)
 	ciAPILongPolling := builds.RegisterHandler(ciAPIProxyQueue, u.watchKeyHandler, u.APICILongPollingDuration)
 
 	dependencyProxyInjector.SetUploadHandler(requestBodyUploader)
 
+	uploadPackCache, err := git.NewUploadPackCache(u.UploadPackCacheConfig)
+	if err != nil {
+		log.WithError(err).Error("git-upload-pack response caching is disabled")
+	}
+	u.uploadPackCache = uploadPackCache
+
+	gitAuditor, err := audit.New(u.GitAuditConfig, api)
+	if err != nil {
+		log.WithError(err).Error("git audit events are sent to Rails without spooling")
+		gitAuditor, _ = audit.New(config.GitAuditConfig{}, api)
+	}
+	u.gitAuditor = gitAuditor
+
+	lfsDownload := proxy
+	if u.LfsConfig.DownloadAcceleration {
+		lfsDownload = senddata.SendData(sendfile.SendFile(lfs.Download(api)), sendurl.SendURL)
+	}
+
 	// Serve static files or forward the requests
 	defaultUpstream := static.ServeExisting(
 		u.URLPrefix,
@@ -249,10 +273,13 @@ func configureRoutes(u *upstream) {
 	u.Routes = []routeEntry{
 		// Git Clone
 		u.route("GET", gitProjectPattern+`info/refs\z`, git.GetInfoRefsHandler(api)),
-		u.route("POST", gitProjectPattern+`git-upload-pack\z`, contentEncodingHandler(git.UploadPack(api)), withMatcher(isContentType("application/x-git-upload-pack-request"))),
-		u.route("POST", gitProjectPattern+`git-receive-pack\z`, contentEncodingHandler(git.ReceivePack(api)), withMatcher(isContentType("application/x-git-receive-pack-request"))),
+		u.route("POST", gitProjectPattern+`git-upload-pack\z`, contentEncodingHandler(git.UploadPack(api, uploadPackCache, u.UploadPackPolicyConfig, gitAuditor)), withMatcher(isContentType("application/x-git-upload-pack-request"))),
+		u.route("POST", gitProjectPattern+`git-receive-pack\z`, contentEncodingHandler(git.ReceivePack(api, gitAuditor)), withMatcher(isContentType("application/x-git-receive-pack-request"))),
 		u.route("PUT", gitProjectPattern+`gitlab-lfs/objects/([0-9a-f]{64})/([0-9]+)\z`, requestBodyUploader, withMatcher(isContentType("application/octet-stream"))),
-		u.route("POST", gitProjectPattern+`ssh-upload-pack\z`, git.SSHUploadPack(api)),
+		u.route("GET", gitProjectPattern+`gitlab-lfs/objects/([0-9a-f]{64})\z`, lfsDownload),
+		u.route("POST", gitProjectPattern+`info/lfs/objects/batch\z`, lfs.BatchCache(proxy, u.LfsConfig)),
+		u.route("POST", gitProjectPattern+`ssh-upload-pack\z`, git.SSHUploadPack(api, gitAuditor)),
+		u.route("POST", gitProjectPattern+`ssh-receive-pack\z`, git.SSHReceivePack(api, gitAuditor)),
 
 		// CI Artifacts
 		u.route("POST", apiPattern+`v4/jobs/[0-9]+/artifacts\z`, contentEncodingHandler(upload.Artifacts(api, signingProxy, preparer, &u.Config))),
@@ -267,6 +294,9 @@ func configureRoutes(u *upstream) {
 		// Proxy Job Services
 		u.wsRoute(projectPattern+`-/jobs/[0-9]+/proxy.ws\z`, channel.Handler(api)),
 
+		// Job log event stream
+		u.route("GET", projectPattern+`-/jobs/[0-9]+/trace\.sse\z`, joblog.Handler(api, proxy, u.watchKeyHandler)),
+
 		// Long poll and limit capacity given to jobs/request and builds/register.json
 		u.route("", apiPattern+`v4/jobs/request\z`, ciAPILongPolling),
 
@@ -320,8 +350,8 @@ func configureRoutes(u *upstream) {
 		u.route("POST", apiProjectPattern+`/wikis/attachments\z`, tempfileMultipartProxy),
 		u.route("POST", apiGroupPattern+`/wikis/attachments\z`, tempfileMultipartProxy),
 		u.route("POST", apiPattern+`graphql\z`, tempfileMultipartProxy),
-		u.route("POST", apiTopicPattern, tempfileMultipartProxy),
-		u.route("PUT", apiTopicPattern, tempfileMultipartProxy),
+		u.route("POST", apiTopicPattern, avatarMultipartProxy),
+		u.route("PUT", apiTopicPattern, avatarMultipartProxy),
 		u.route("POST", apiPattern+`v4/groups/import`, mimeMultipartUploader),
 		u.route("POST", apiPattern+`v4/projects/import`, mimeMultipartUploader),
 		u.route("POST", apiPattern+`v4/projects/import-relation`, mimeMultipartUploader),
@@ -347,17 +377,17 @@ func configureRoutes(u *upstream) {
 		u.route("POST", apiProjectPattern+`/uploads\z`, mimeMultipartUploader),
 
 		// Project Avatar
-		u.route("POST", apiPattern+`v4/projects\z`, tempfileMultipartProxy),
-		u.route("PUT", apiProjectPattern+`\z`, tempfileMultipartProxy),
+		u.route("POST", apiPattern+`v4/projects\z`, avatarMultipartProxy),
+		u.route("PUT", apiProjectPattern+`\z`, avatarMultipartProxy),
 
 		// Group Avatar
-		u.route("POST", apiPattern+`v4/groups\z`, tempfileMultipartProxy),
-		u.route("PUT", apiPattern+`v4/groups/[^/]+\z`, tempfileMultipartProxy),
+		u.route("POST", apiPattern+`v4/groups\z`, avatarMultipartProxy),
+		u.route("PUT", apiPattern+`v4/groups/[^/]+\z`, avatarMultipartProxy),
 
 		// User Avatar
-		u.route("PUT", apiPattern+`v4/user/avatar\z`, tempfileMultipartProxy),
-		u.route("POST", apiPattern+`v4/users\z`, tempfileMultipartProxy),
-		u.route("PUT", apiPattern+`v4/users/[0-9]+\z`, tempfileMultipartProxy),
+		u.route("PUT", apiPattern+`v4/user/avatar\z`, avatarMultipartProxy),
+		u.route("POST", apiPattern+`v4/users\z`, avatarMultipartProxy),
+		u.route("PUT", apiPattern+`v4/users/[0-9]+\z`, avatarMultipartProxy),
 
 		// Explicitly proxy API requests
 		u.route("", apiPattern, proxy),
@@ -391,6 +421,9 @@ func configureRoutes(u *upstream) {
 		u.route("", "", defaultUpstream),
 	}
 
+	// Served instead of proxying requests while the Geo primary is unreachable
+	u.geoProxyUnavailableRoute = u.route("", "", static.MaintenancePage())
+
 	// Routes which should actually be served locally by a Geo Proxy. If none
 	// matches, then then proxy the request.
 	u.geoLocalRoutes = []routeEntry{
@@ -402,10 +435,17 @@ func configureRoutes(u *upstream) {
 		// proxy/redirect pulls as well, when the secondary is not up-to-date.
 		//
 		u.route("GET", geoGitProjectPattern+`info/refs\z`, git.GetInfoRefsHandler(api)),
-		u.route("POST", geoGitProjectPattern+`git-upload-pack\z`, contentEncodingHandler(git.UploadPack(api)), withMatcher(isContentType("application/x-git-upload-pack-request"))),
-		u.route("GET", geoGitProjectPattern+`gitlab-lfs/objects/([0-9a-f]{64})\z`, defaultUpstream),
+		u.route("POST", geoGitProjectPattern+`git-upload-pack\z`, contentEncodingHandler(git.UploadPack(api, uploadPackCache, u.UploadPackPolicyConfig, gitAuditor)), withMatcher(isContentType("application/x-git-upload-pack-request"))),
+		u.route("GET", geoGitProjectPattern+`gitlab-lfs/objects/([0-9a-f]{64})\z`, u.geoLocalIfReplicated("lfs", lfsDownload)),
 		u.route("POST", geoGitProjectPattern+`info/lfs/objects/batch\z`, defaultUpstream),
 
+		// Downloads which are served locally when Rails says their data is
+		// replicated to this Geo secondary, and proxied otherwise
+		u.route("GET", projectPattern+`-/archive/`, u.geoLocalIfReplicated("archive", defaultUpstream)),
+		u.route("GET", apiProjectPattern+`/repository/archive`, u.geoLocalIfReplicated("archive", defaultUpstream)),
+		u.route("GET", projectPattern+`-/jobs/[0-9]+/artifacts/(download|raw/|file/)`, u.geoLocalIfReplicated("artifacts", defaultUpstream)),
+		u.route("GET", apiProjectPattern+`/jobs/[0-9]+/artifacts(/|\z)`, u.geoLocalIfReplicated("artifacts", defaultUpstream)),
+
 		// Serve health checks from this Geo secondary
 		u.route("", "^/-/(readiness|liveness)$", static.DeployPage(probeUpstream)),
 		u.route("", "^/-/health$", static.DeployPage(healthUpstream)),
@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/testhelper"
 )
 
@@ -115,3 +118,27 @@ func TestLfsBatchSecondaryGitSSHPullWithGeoProxy(t *testing.T) {
 
 	runTestCasesWithGeoProxyEnabledPost(t, testCases)
 }
+
+func TestReplicatedDownloadsWithGeoProxy(t *testing.T) {
+	testCases := []testCase{
+		{"replicated LFS object", "/group/project.git/gitlab-lfs/objects/37446575700829a11278ad3a550f244f45d5ae4fe1552778fa4f041f9eaeecf6", "Local Rails server received request to path /group/project.git/gitlab-lfs/objects/37446575700829a11278ad3a550f244f45d5ae4fe1552778fa4f041f9eaeecf6"},
+		{"not replicated LFS object", "/group/not-replicated.git/gitlab-lfs/objects/37446575700829a11278ad3a550f244f45d5ae4fe1552778fa4f041f9eaeecf6", "Geo primary received request to path /group/not-replicated.git/gitlab-lfs/objects/37446575700829a11278ad3a550f244f45d5ae4fe1552778fa4f041f9eaeecf6"},
+		{"replicated archive", "/group/project/-/archive/main/project-main.zip", "Local Rails server received request to path /group/project/-/archive/main/project-main.zip"},
+		{"not replicated archive", "/group/not-replicated/-/archive/main/not-replicated-main.zip", "Geo primary received request to path /group/not-replicated/-/archive/main/not-replicated-main.zip"},
+		{"replicated API archive", "/api/v4/projects/group%2Fproject/repository/archive.zip", "Local Rails server received request to path /api/v4/projects/group/project/repository/archive.zip"},
+		{"replicated artifacts", "/group/project/-/jobs/1/artifacts/download", "Local Rails server received request to path /group/project/-/jobs/1/artifacts/download"},
+		{"not replicated artifacts", "/group/not-replicated/-/jobs/1/artifacts/raw/file.txt", "Geo primary received request to path /group/not-replicated/-/jobs/1/artifacts/raw/file.txt"},
+		{"replicated API artifacts", "/api/v4/projects/1/jobs/1/artifacts", "Local Rails server received request to path /api/v4/projects/1/jobs/1/artifacts"},
+		{"job page is forwarded", "/group/project/-/jobs/1", "Geo primary received request to path /group/project/-/jobs/1"},
+	}
+
+	geoServedBytes.Reset()
+
+	runTestCasesWithGeoProxyEnabled(t, testCases)
+
+	for _, kind := range []string{"lfs", "archive", "artifacts"} {
+		for _, location := range []string{"local", "proxied"} {
+			require.Positive(t, testutil.ToFloat64(geoServedBytes.WithLabelValues(kind, location)), kind+" "+location)
+		}
+	}
+}
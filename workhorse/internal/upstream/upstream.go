@@ -6,9 +6,12 @@ The upstream package provides functionality for routing requests and interacting
 package upstream
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"net/http"
@@ -19,12 +22,16 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"gitlab.com/gitlab-org/labkit/correlation"
+	"gitlab.com/gitlab-org/labkit/log"
 
 	apipkg "gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/builds"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/config"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/git"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/git/audit"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/helper"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/helper/nginx"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/notification"
 	proxypkg "gitlab.com/gitlab-org/gitlab/workhorse/internal/proxy"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/rejectmethods"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/upload"
@@ -39,6 +46,9 @@ var (
 		upload.RewrittenFieldsHeader,
 	}
 	geoProxyAPIPollingInterval = 10 * time.Second
+	// geoProxyUnreachableThreshold is how many health checks of the primary
+	// must fail in a row before it counts as unreachable
+	geoProxyUnreachableThreshold = 3
 )
 
 type upstream struct {
@@ -53,17 +63,36 @@ type upstream struct {
 	geoLocalRoutes        []routeEntry
 	geoProxyCableRoute    routeEntry
 	geoProxyRoute         routeEntry
+	geoProxyHandler       http.Handler
+	geoReplication        *geoReplicationCache
 	geoProxyPollSleep     func(time.Duration)
 	geoPollerDone         chan struct{}
 	accessLogger          *logrus.Logger
 	enableGeoProxyFeature bool
 	mu                    sync.RWMutex
 	watchKeyHandler       builds.WatchKeyHandler
+
+	// Health checks of the Geo primary. While it is unreachable,
+	// geoProxyUnavailableRoute serves the requests for it.
+	geoProxyRoundTripper     http.RoundTripper
+	geoProxyUnreachable      atomic.Bool
+	geoProxyFailedChecks     int
+	geoProxyUnavailableRoute routeEntry
+
+	gitAuditor      *audit.Auditor
+	uploadPackCache *git.UploadPackCache
 }
 
 // NewUpstream creates a new HTTP handler for handling upstream requests based on the provided configuration.
-func NewUpstream(cfg config.Config, accessLogger *logrus.Logger, watchKeyHandler builds.WatchKeyHandler) http.Handler {
-	return newUpstream(cfg, accessLogger, configureRoutes, watchKeyHandler)
+// The returned closer releases the resources of the handler once it no longer serves requests.
+func NewUpstream(cfg config.Config, accessLogger *logrus.Logger, watchKeyHandler builds.WatchKeyHandler) (http.Handler, io.Closer) {
+	var up *upstream
+	handler := newUpstream(cfg, accessLogger, func(u *upstream) {
+		up = u
+		configureRoutes(u)
+	}, watchKeyHandler)
+
+	return handler, up
 }
 
 func newUpstream(cfg config.Config, accessLogger *logrus.Logger, routesCallback func(*upstream), watchKeyHandler builds.WatchKeyHandler) http.Handler {
@@ -96,10 +125,12 @@ func newUpstream(cfg config.Config, accessLogger *logrus.Logger, routesCallback
 		up.Version,
 		up.RoundTripper,
 	)
+	up.geoReplication = newGeoReplicationCache(up.APIClient.GetGeoReplicationStatus)
 
 	routesCallback(&up)
 
 	go up.pollGeoProxyAPI()
+	go up.pollGeoProxyHealth()
 
 	var correlationOpts []correlation.InboundHandlerOption
 	if cfg.PropagateCorrelationID {
@@ -118,6 +149,18 @@ func newUpstream(cfg config.Config, accessLogger *logrus.Logger, routesCallback
 	return handler
 }
 
+// Close flushes the git audit events that are waiting for delivery to the
+// spool, and stops the background work of the git-upload-pack cache.
+func (u *upstream) Close() error {
+	u.uploadPackCache.Close()
+
+	if u.gitAuditor == nil {
+		return nil
+	}
+
+	return u.gitAuditor.Close()
+}
+
 func (u *upstream) configureURLPrefix() {
 	relativeURLRoot := u.Backend.Path
 	if !strings.HasSuffix(relativeURLRoot, "/") {
@@ -202,6 +245,10 @@ func (u *upstream) findGeoProxyRoute(cleanedPath string, r *http.Request) *route
 		return &u.geoProxyCableRoute
 	}
 
+	if u.geoProxyUnreachable.Load() && u.geoProxyUnavailableRoute.handler != nil {
+		return &u.geoProxyUnavailableRoute
+	}
+
 	return &u.geoProxyRoute
 }
 
@@ -215,25 +262,34 @@ func (u *upstream) pollGeoProxyAPI() {
 			break
 		}
 
-		u.callGeoProxyAPI()
-		u.geoProxyPollSleep(geoProxyAPIPollingInterval)
+		lastUpdate, poll := u.callGeoProxyAPI()
+		u.waitForGeoProxyUpdate(lastUpdate, poll)
 	}
 }
 
-// Calls /api/v4/geo/proxy and sets up routes
-func (u *upstream) callGeoProxyAPI() {
+// Calls /api/v4/geo/proxy and sets up routes. Returns the value to watch for
+// pushed updates, and whether to poll the API again after the polling
+// interval.
+func (u *upstream) callGeoProxyAPI() (string, bool) {
 	geoProxyData, err := u.APIClient.GetGeoProxyData()
 	if err != nil {
 		// Unable to determine Geo Proxy URL. Fallback on cached value.
-		return
+		return "", true
 	}
 
 	if !geoProxyData.GeoEnabled {
+		if u.geoProxyBackend != nil && u.geoProxyBackend.String() != "" {
+			u.updateGeoProxyFieldsFromData(&apipkg.GeoProxyData{GeoProxyURL: &url.URL{}})
+		}
+
 		// When Geo is not enabled, we don't need to proxy, as it unnecessarily polls the
-		// API, whereas a restart is necessary to enable Geo in the first place; at which
-		// point we get fresh data from the API.
-		u.enableGeoProxyFeature = false
-		return
+		// API. Rails can still push an update when Geo gets enabled; without
+		// pushed updates a restart is necessary to enable Geo in the first place, at
+		// which point we get fresh data from the API.
+		if !u.canWatchGeoProxyUpdates(geoProxyData.GeoProxyLastUpdate) {
+			u.enableGeoProxyFeature = false
+		}
+		return geoProxyData.GeoProxyLastUpdate, false
 	}
 
 	hasProxyDataChanged := false
@@ -250,6 +306,147 @@ func (u *upstream) callGeoProxyAPI() {
 	if hasProxyDataChanged {
 		u.updateGeoProxyFieldsFromData(geoProxyData)
 	}
+
+	return geoProxyData.GeoProxyLastUpdate, true
+}
+
+func (u *upstream) canWatchGeoProxyUpdates(lastUpdate string) bool {
+	return u.watchKeyHandler != nil && u.GeoProxyConfig.WatchKey != "" && lastUpdate != ""
+}
+
+func (u *upstream) geoProxyPollingInterval() time.Duration {
+	if u.GeoProxyConfig.PollingInterval.Duration > 0 {
+		return u.GeoProxyConfig.PollingInterval.Duration
+	}
+
+	return geoProxyAPIPollingInterval
+}
+
+// waitForGeoProxyUpdate returns when Rails pushes a change of the Geo proxy
+// data, or when the polling interval passed and poll is set. Without pushed
+// updates, it falls back to polling.
+//
+// If the watched value differs from the one the API just returned, for
+// example because the API answers with stale data, calling the API again
+// right away would likely return the same value. The polling interval is
+// then kept between the calls.
+func (u *upstream) waitForGeoProxyUpdate(lastUpdate string, poll bool) {
+	interval := u.geoProxyPollingInterval()
+
+	if !u.canWatchGeoProxyUpdates(lastUpdate) {
+		u.geoProxyPollSleep(interval)
+		return
+	}
+
+	for {
+		status, err := u.watchKeyHandler(context.Background(), u.GeoProxyConfig.WatchKey, lastUpdate, interval) // lint:allow context.Background
+		if err != nil {
+			log.WithError(err).Error("Geo: watching for proxy data updates failed, polling instead")
+			u.geoProxyPollSleep(interval)
+			return
+		}
+
+		switch status {
+		case notification.WatchKeyStatusAlreadyChanged:
+			u.geoProxyPollSleep(interval)
+			return
+		case notification.WatchKeyStatusSeenChange:
+			return
+		case notification.WatchKeyStatusTimeout:
+			if poll {
+				return
+			}
+		default:
+			// Workhorse is shutting down
+			u.geoProxyPollSleep(interval)
+			return
+		}
+	}
+}
+
+func (u *upstream) pollGeoProxyHealth() {
+	interval := u.GeoProxyConfig.HealthCheckInterval.Duration
+	if interval <= 0 {
+		return
+	}
+
+	for {
+		select {
+		case <-u.geoPollerDone:
+			return
+		case <-time.After(interval):
+		}
+
+		u.checkGeoProxyHealth(interval)
+	}
+}
+
+// checkGeoProxyHealth requests the health check path of the primary. After
+// geoProxyUnreachableThreshold failures in a row, requests for the primary
+// get the maintenance page until a check succeeds again.
+func (u *upstream) checkGeoProxyHealth(timeout time.Duration) {
+	u.mu.RLock()
+	backend, rt := u.geoProxyBackend, u.geoProxyRoundTripper
+	u.mu.RUnlock()
+
+	if backend.String() == "" || rt == nil {
+		u.geoProxyFailedChecks = 0
+		u.setGeoProxyUnreachable(backend, false)
+		return
+	}
+
+	if err := checkHealth(rt, backend.JoinPath(u.GeoProxyConfig.HealthCheckPath), timeout); err != nil {
+		u.geoProxyFailedChecks++
+		log.WithError(err).WithFields(log.Fields{"geo_proxy_url": backend.String(), "failed_checks": u.geoProxyFailedChecks}).Error("Geo: primary health check failed")
+
+		if u.geoProxyFailedChecks >= geoProxyUnreachableThreshold {
+			u.setGeoProxyUnreachable(backend, true)
+		}
+		return
+	}
+
+	u.geoProxyFailedChecks = 0
+	u.setGeoProxyUnreachable(backend, false)
+}
+
+func (u *upstream) setGeoProxyUnreachable(backend *url.URL, unreachable bool) {
+	if u.geoProxyUnreachable.Swap(unreachable) == unreachable {
+		return
+	}
+
+	logEntry := log.WithFields(log.Fields{"geo_proxy_url": backend.String()})
+	if unreachable {
+		geoPrimaryUnreachable.Set(1)
+		logEntry.Error("Geo: primary is unreachable, serving the maintenance page instead of proxying")
+	} else {
+		geoPrimaryUnreachable.Set(0)
+		logEntry.Info("Geo: primary is reachable again")
+	}
+}
+
+func checkHealth(rt http.RoundTripper, healthURL *url.URL, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout) // lint:allow context.Background
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", healthURL.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	// Any answer but a gateway error means the primary is up, even if the
+	// health check is not allowed from this secondary
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return fmt.Errorf("health check: received HTTP status code: %v", resp.StatusCode)
+	}
+
+	return nil
 }
 
 func (u *upstream) updateGeoProxyFieldsFromData(geoProxyData *apipkg.GeoProxyData) {
@@ -260,6 +457,8 @@ func (u *upstream) updateGeoProxyFieldsFromData(geoProxyData *apipkg.GeoProxyDat
 	u.geoProxyExtraData = geoProxyData.GeoProxyExtraData
 
 	if u.geoProxyBackend.String() == "" {
+		u.geoProxyHandler = nil
+		u.geoProxyRoundTripper = nil
 		return
 	}
 
@@ -277,6 +476,8 @@ func (u *upstream) updateGeoProxyFieldsFromData(geoProxyData *apipkg.GeoProxyDat
 	)
 	u.geoProxyCableRoute = u.wsRoute(`^/-/cable\z`, geoProxyUpstream)
 	u.geoProxyRoute = u.route("", "", geoProxyUpstream, withGeoProxy())
+	u.geoProxyHandler = instrumentGeoProxyRoute(geoProxyUpstream, "", "")
+	u.geoProxyRoundTripper = geoProxyRoundTripper
 }
 
 func httpError(w http.ResponseWriter, r *http.Request, error string, code int) {
@@ -1,11 +1,16 @@
 package upstream
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -16,12 +21,14 @@ import (
 	apipkg "gitlab.com/gitlab-org/gitlab/workhorse/internal/api"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/config"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/helper"
+	"gitlab.com/gitlab-org/gitlab/workhorse/internal/notification"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/testhelper"
 	"gitlab.com/gitlab-org/gitlab/workhorse/internal/upstream/roundtripper"
 )
 
 const (
 	geoProxyEndpoint             = "/api/v4/geo/proxy"
+	geoReplicationStatusEndpoint = "/api/v4/geo/proxy/replication_status"
 	testDocumentRoot             = "testdata/public"
 	geoProxyDisabledResponseBody = `{"geo_enabled":false}`
 )
@@ -168,6 +175,183 @@ func TestGeoProxyFeatureEnabledOnGeoSecondarySite(t *testing.T) {
 	runTestCasesWithGeoProxyEnabled(t, testCases)
 }
 
+func TestGeoProxyPushedUpdates(t *testing.T) {
+	remoteServer := startRemoteServer(t)
+
+	geoProxyEndpointEnabledResponseBody := fmt.Sprintf(`{"geo_enabled":true,"geo_proxy_url":"%v","geo_proxy_last_update":"1"}`, remoteServer.URL)
+	geoProxyEndpointResponseBody := geoProxyEndpointEnabledResponseBody
+	var mu sync.Mutex
+	railsServer := startRailsServer(t, &geoProxyEndpointResponseBody)
+	railsServer.Config.Handler = lockedHandler(&mu, railsServer.Config.Handler)
+
+	backend := notification.NewMemory()
+	defer backend.Shutdown()
+	backend.Set("geo_proxy", "1")
+
+	cfg := newUpstreamConfig(railsServer.URL)
+	cfg.GeoProxyConfig = config.GeoProxyConfig{
+		PollingInterval: config.TomlDuration{Duration: time.Hour},
+		WatchKey:        "geo_proxy",
+	}
+	testDone := make(chan struct{})
+	defer close(testDone)
+	ws := httptest.NewServer(newUpstream(*cfg, logrus.StandardLogger(), func(u *upstream) {
+		u.enableGeoProxyFeature = true
+		// Updates must arrive without polling
+		u.geoProxyPollSleep = func(time.Duration) { <-testDone }
+		configureRoutes(u)
+	}, backend.WatchKey))
+	defer ws.Close()
+
+	requireResponse := func(expected string) {
+		require.Eventually(t, func() bool {
+			resp, err := http.Get(ws.URL + "/anything")
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			return string(body) == expected
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+
+	requireResponse("Geo primary received request to path /anything")
+
+	mu.Lock()
+	geoProxyEndpointResponseBody = `{"geo_enabled":false,"geo_proxy_last_update":"2"}`
+	mu.Unlock()
+	backend.Set("geo_proxy", "2")
+
+	requireResponse("Local Rails server received request to path /anything")
+
+	mu.Lock()
+	geoProxyEndpointResponseBody = strings.Replace(geoProxyEndpointEnabledResponseBody, `"1"`, `"3"`, 1)
+	mu.Unlock()
+	backend.Set("geo_proxy", "3")
+
+	requireResponse("Geo primary received request to path /anything")
+}
+
+func TestGeoProxyPollsWhenWatchedValueAlreadyChanged(t *testing.T) {
+	remoteServer := startRemoteServer(t)
+
+	response := fmt.Sprintf(`{"geo_enabled":true,"geo_proxy_url":"%v","geo_proxy_last_update":"1"}`, remoteServer.URL)
+	railsServer := startRailsServer(t, &response)
+	var apiCalls atomic.Int32
+	railsHandler := railsServer.Config.Handler
+	railsServer.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == geoProxyEndpoint {
+			apiCalls.Add(1)
+		}
+		railsHandler.ServeHTTP(w, r)
+	})
+
+	// The API keeps returning a value that is older than the watched key
+	watch := func(context.Context, string, string, time.Duration) (notification.WatchKeyStatus, error) {
+		return notification.WatchKeyStatusAlreadyChanged, nil
+	}
+
+	cfg := newUpstreamConfig(railsServer.URL)
+	cfg.GeoProxyConfig = config.GeoProxyConfig{WatchKey: "geo_proxy"}
+	sleeps := make(chan time.Duration)
+	ws := httptest.NewServer(newUpstream(*cfg, logrus.StandardLogger(), func(u *upstream) {
+		u.enableGeoProxyFeature = true
+		// Block the poller after the first sleep
+		u.geoProxyPollSleep = func(d time.Duration) { sleeps <- d }
+		configureRoutes(u)
+	}, watch))
+	defer ws.Close()
+
+	select {
+	case d := <-sleeps:
+		require.Equal(t, geoProxyAPIPollingInterval, d)
+		require.Equal(t, int32(1), apiCalls.Load())
+	case <-time.After(5 * time.Second):
+		t.Fatalf("called the Geo proxy API %d times without waiting", apiCalls.Load())
+	}
+}
+
+func TestGeoProxyServesMaintenancePageWhenPrimaryUnreachable(t *testing.T) {
+	testhelper.SetupStaticFileHelper(t, "/maintenance.html", "Read-only maintenance", testDocumentRoot)
+	t.Cleanup(func() { os.Remove(filepath.Join(testDocumentRoot, "maintenance.html")) })
+
+	var healthStatus atomic.Int64
+	healthStatus.Store(http.StatusBadGateway)
+	remoteServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/-/health" {
+			w.WriteHeader(int(healthStatus.Load()))
+			return
+		}
+
+		fmt.Fprint(w, "Geo primary received request to path "+r.URL.Path)
+	}))
+	defer remoteServer.Close()
+
+	geoProxyEndpointResponseBody := fmt.Sprintf(`{"geo_enabled":true,"geo_proxy_url":"%v"}`, remoteServer.URL)
+	railsServer := startRailsServer(t, &geoProxyEndpointResponseBody)
+
+	var up *upstream
+	cfg := newUpstreamConfig(railsServer.URL)
+	cfg.GeoProxyConfig.HealthCheckPath = "/-/health"
+	ws := httptest.NewServer(newUpstream(*cfg, logrus.StandardLogger(), func(u *upstream) {
+		// The test calls the API and checks health instead of the pollers
+		u.enableGeoProxyFeature = false
+		up = u
+		configureRoutes(u)
+	}, nil))
+	defer ws.Close()
+
+	up.callGeoProxyAPI()
+
+	get := func(path string) (int, string) {
+		resp, err := http.Get(ws.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		return resp.StatusCode, string(body)
+	}
+
+	for i := 0; i < geoProxyUnreachableThreshold; i++ {
+		code, body := get("/anything")
+		require.Equal(t, 200, code, "primary is reachable until enough health checks failed")
+		require.Equal(t, "Geo primary received request to path /anything", body)
+
+		up.checkGeoProxyHealth(time.Second)
+	}
+
+	code, body := get("/anything")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, "Read-only maintenance", body)
+
+	code, body = get("/group/not-replicated/-/archive/main/not-replicated-main.zip")
+	require.Equal(t, 200, code)
+	require.Equal(t, "Local Rails server received request to path /group/not-replicated/-/archive/main/not-replicated-main.zip", body, "downloads are served locally")
+
+	code, body = get("/-/health")
+	require.Equal(t, 200, code)
+	require.Equal(t, "Local Rails server received request to path /-/health", body)
+
+	healthStatus.Store(http.StatusNotFound)
+	up.checkGeoProxyHealth(time.Second)
+
+	code, body = get("/anything")
+	require.Equal(t, 200, code)
+	require.Equal(t, "Geo primary received request to path /anything", body)
+}
+
+func lockedHandler(mu *sync.Mutex, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 // This test can be removed when the environment variable `GEO_SECONDARY_PROXY` is removed
 func TestGeoProxyFeatureDisabledOnNonGeoSecondarySite(t *testing.T) {
 	response := geoProxyDisabledResponseBody
@@ -460,10 +644,15 @@ func startRailsServer(t *testing.T, geoProxyEndpointResponseBody *string) *httpt
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var body string
 
-		if r.URL.Path == geoProxyEndpoint {
+		switch r.URL.Path {
+		case geoProxyEndpoint:
 			w.Header().Set("Content-Type", "application/vnd.gitlab-workhorse+json")
 			body = *geoProxyEndpointResponseBody
-		} else {
+		case geoReplicationStatusEndpoint:
+			// Data is replicated unless its project is called not-replicated
+			w.Header().Set("Content-Type", "application/vnd.gitlab-workhorse+json")
+			body = fmt.Sprintf(`{"replicated":%t}`, !strings.Contains(r.URL.Query().Get("path"), "/not-replicated"))
+		default:
 			body = "Local Rails server received request to path " + r.URL.Path
 		}
 
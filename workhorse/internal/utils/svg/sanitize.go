@@ -0,0 +1,188 @@
+package svg
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrNotSVG is returned by Sanitize when the input is not an SVG image.
+var ErrNotSVG = errors.New("svg: not an SVG image")
+
+// forbiddenElements lists elements that are dropped, including their
+// children, when sanitizing an SVG document.
+var forbiddenElements = map[string]bool{
+	"script":        true,
+	"foreignobject": true,
+	"iframe":        true,
+	"embed":         true,
+	"object":        true,
+	"handler":       true,
+	"listener":      true,
+	"animate":       true,
+	"set":           true,
+	"animatemotion": true,
+	"animatecolor":  true,
+}
+
+// Sanitize strips scripts, event handlers, external references, comments
+// and document type declarations from an SVG image. The result is a
+// re-serialized SVG document that only contains passive content.
+func Sanitize(buf []byte) ([]byte, error) {
+	if !Is(buf) {
+		return nil, ErrNotSVG
+	}
+
+	dec := xml.NewDecoder(bytes.NewReader(buf))
+	dec.Strict = true
+
+	var out bytes.Buffer
+	enc := xml.NewEncoder(&out)
+
+	skipDepth := 0
+	// style holds the start element of a style element until its end, and
+	// styleText the style sheet inside it
+	var style *xml.StartElement
+	var styleText strings.Builder
+	for tokens := 1; ; tokens++ {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("svg: parse: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			// Style sheets don't contain elements
+			if skipDepth > 0 || style != nil || forbiddenElements[strings.ToLower(t.Name.Local)] {
+				skipDepth++
+				continue
+			}
+			el := sanitizeStartElement(t)
+			if strings.EqualFold(t.Name.Local, "style") {
+				style = &el
+				styleText.Reset()
+				continue
+			}
+			err = enc.EncodeToken(el)
+		case xml.EndElement:
+			if skipDepth > 0 {
+				skipDepth--
+				continue
+			}
+			if style != nil {
+				err = encodeStyle(enc, *style, styleText.String())
+				style = nil
+				break
+			}
+			err = enc.EncodeToken(xml.EndElement{Name: flattenName(t.Name)})
+		case xml.CharData:
+			switch {
+			case skipDepth > 0:
+			case style != nil:
+				// Checked as a whole at the end of the element, as CDATA
+				// sections and comments can split it anywhere
+				styleText.Write(t)
+			default:
+				err = enc.EncodeToken(t)
+			}
+		case xml.ProcInst:
+			if t.Target == "xml" && tokens == 1 {
+				err = enc.EncodeToken(t)
+			}
+		case xml.Directive:
+			// DOCTYPE declarations are never needed for rendering, but
+			// entity definitions inside them are a common attack vector.
+			// Comments are dropped silently.
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("svg: encode: %w", err)
+		}
+	}
+
+	if err := enc.Flush(); err != nil {
+		return nil, fmt.Errorf("svg: encode: %w", err)
+	}
+
+	return out.Bytes(), nil
+}
+
+// encodeStyle writes a style element with the given style sheet. Style
+// sheets with external references are dropped as a whole, as removing
+// single rules could change the meaning of the rest.
+func encodeStyle(enc *xml.Encoder, el xml.StartElement, text string) error {
+	if hasExternalStyleReference(text) {
+		return nil
+	}
+
+	for _, tok := range []xml.Token{el, xml.CharData(text), el.End()} {
+		if err := enc.EncodeToken(tok); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func sanitizeStartElement(t xml.StartElement) xml.StartElement {
+	el := xml.StartElement{Name: flattenName(t.Name)}
+
+	for _, attr := range t.Attr {
+		local := strings.ToLower(attr.Name.Local)
+		if strings.HasPrefix(local, "on") {
+			continue
+		}
+		if (local == "href" || local == "src") && !isLocalReference(attr.Value) {
+			continue
+		}
+		if local == "style" && hasExternalStyleReference(attr.Value) {
+			continue
+		}
+
+		el.Attr = append(el.Attr, xml.Attr{Name: flattenName(attr.Name), Value: attr.Value})
+	}
+
+	return el
+}
+
+// flattenName keeps namespace prefixes as part of the local name so that
+// the encoder writes them back verbatim instead of inventing new prefixes.
+func flattenName(name xml.Name) xml.Name {
+	if name.Space == "" {
+		return name
+	}
+
+	return xml.Name{Local: name.Space + ":" + name.Local}
+}
+
+func isLocalReference(value string) bool {
+	v := strings.TrimSpace(value)
+
+	return strings.HasPrefix(v, "#") ||
+		strings.HasPrefix(strings.ToLower(v), "data:image/png") ||
+		strings.HasPrefix(strings.ToLower(v), "data:image/jpeg") ||
+		strings.HasPrefix(strings.ToLower(v), "data:image/gif")
+}
+
+func hasExternalStyleReference(value string) bool {
+	v := strings.ToLower(value)
+
+	// CSS escapes could spell any of the checks below
+	if strings.Contains(v, "expression(") || strings.Contains(v, "@import") || strings.Contains(v, "\\") {
+		return true
+	}
+
+	for _, ref := range strings.Split(v, "url(")[1:] {
+		if !strings.HasPrefix(strings.TrimLeft(ref, " \t\r\n\f'\""), "#") {
+			return true
+		}
+	}
+
+	return false
+}
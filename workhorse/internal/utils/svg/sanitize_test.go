@@ -0,0 +1,102 @@
+package svg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitize(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "Passive SVG",
+			input:    `<svg width="100" height="100"><rect width="10" height="10"></rect></svg>`,
+			expected: `<svg width="100" height="100"><rect width="10" height="10"></rect></svg>`,
+		},
+		{
+			name:     "Script element",
+			input:    `<svg><script>alert(1)</script><circle r="4"/></svg>`,
+			expected: `<svg><circle r="4"></circle></svg>`,
+		},
+		{
+			name:     "Event handler attribute",
+			input:    `<svg onload="alert(1)"><g onclick="alert(2)"></g></svg>`,
+			expected: `<svg><g></g></svg>`,
+		},
+		{
+			name:     "External and local references",
+			input:    `<svg xmlns:xlink="http://www.w3.org/1999/xlink"><use xlink:href="https://example.com/x.svg#a"/><use xlink:href="#b"/></svg>`,
+			expected: `<svg xmlns:xlink="http://www.w3.org/1999/xlink"><use></use><use xlink:href="#b"></use></svg>`,
+		},
+		{
+			name:     "Foreign object",
+			input:    `<svg><foreignObject><body><iframe src="x"></iframe></body></foreignObject></svg>`,
+			expected: `<svg></svg>`,
+		},
+		{
+			name:     "Local style sheet",
+			input:    `<svg><style>.a { fill: url(#g) }</style></svg>`,
+			expected: `<svg><style>.a { fill: url(#g) }</style></svg>`,
+		},
+		{
+			name:     "Style sheet with import",
+			input:    `<svg><style>@import url(https://example.com/x.css);</style><g></g></svg>`,
+			expected: `<svg><g></g></svg>`,
+		},
+		{
+			name:     "Style sheet with external URL",
+			input:    `<svg><style><![CDATA[.a { fill: url(#g) } .b { background: url( 'javascript:alert(1)') }]]></style></svg>`,
+			expected: `<svg></svg>`,
+		},
+		{
+			name:     "Style sheet with escapes",
+			input:    `<svg><style>@\69mport "https://example.com/x.css";</style></svg>`,
+			expected: `<svg></svg>`,
+		},
+		{
+			name:     "Style sheet with import split by CDATA",
+			input:    `<svg><style>@im<![CDATA[port]]> url(https://example.com/x.css);</style></svg>`,
+			expected: `<svg></svg>`,
+		},
+		{
+			name:     "Style sheet with import split by a comment",
+			input:    `<svg><style>@im<!-- -->port url(https://example.com/x.css);</style></svg>`,
+			expected: `<svg></svg>`,
+		},
+		{
+			name:     "Style attribute with external URL",
+			input:    `<svg><g style="fill: url(#g); stroke: url(https://example.com/x)"></g></svg>`,
+			expected: `<svg><g></g></svg>`,
+		},
+		{
+			name:     "Elements in style sheet",
+			input:    `<svg><style><script>alert(1)</script></style></svg>`,
+			expected: `<svg><style></style></svg>`,
+		},
+		{
+			name:     "Comments and XML declaration",
+			input:    `<?xml version="1.0"?><!-- hi --><svg></svg>`,
+			expected: `<?xml version="1.0"?><svg></svg>`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Sanitize([]byte(tc.input))
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, string(got))
+		})
+	}
+}
+
+func TestSanitizeInvalid(t *testing.T) {
+	_, err := Sanitize([]byte(`<html></html>`))
+	require.Equal(t, ErrNotSVG, err)
+
+	_, err = Sanitize([]byte(`<svg><g></svg>`))
+	require.Error(t, err)
+}